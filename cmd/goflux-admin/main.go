@@ -273,6 +273,7 @@ func printUsage() {
 	fmt.Println()
 	fmt.Println("Examples:")
 	fmt.Println("  goflux-admin create --user alice --permissions upload,download")
+	fmt.Println("  goflux-admin create --user ops --permissions '*'")
 	fmt.Println("  goflux-admin list")
 	fmt.Println("  goflux-admin list --revoked")
 	fmt.Println("  goflux-admin revoke tok_abc123def456")
@@ -281,6 +282,7 @@ func printUsage() {
 	fmt.Println("  create:")
 	fmt.Println("    --user <username>           User name (required)")
 	fmt.Println("    --permissions <perms>       Comma-separated permissions (default: upload,download,list)")
+	fmt.Println("                                of upload, download, list, delete, move, copy, mkdir or *")
 	fmt.Println("    --days <days>               Days until expiration (default: 365)")
 	fmt.Println("    --file <path>               Tokens file path (default: tokens.json)")
 	fmt.Println()
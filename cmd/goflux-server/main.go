@@ -4,8 +4,11 @@ import (
 	"flag"
 	"fmt"
 	"log"
+	"path/filepath"
+	"strings"
 
 	"github.com/0xRepo-Source/goflux/pkg/auth"
+	"github.com/0xRepo-Source/goflux/pkg/checksum"
 	"github.com/0xRepo-Source/goflux/pkg/config"
 	"github.com/0xRepo-Source/goflux/pkg/server"
 	"github.com/0xRepo-Source/goflux/pkg/storage"
@@ -28,7 +31,15 @@ func main() {
 	}
 
 	// Create storage backend
-	store, err := storage.NewLocal(cfg.Server.StorageDir)
+	var store storage.Storage
+	switch cfg.Server.Storage {
+	case "", "local":
+		store, err = storage.NewLocal(cfg.Server.StorageDir)
+	case "cas":
+		store, err = storage.NewCAS(cfg.Server.StorageDir)
+	default:
+		log.Fatalf("Unknown storage backend: %s (use \"local\" or \"cas\")", cfg.Server.Storage)
+	}
 	if err != nil {
 		log.Fatalf("Failed to create storage: %v", err)
 	}
@@ -39,6 +50,21 @@ func main() {
 		log.Fatalf("Failed to create server: %v", err)
 	}
 
+	// Restrict chunk checksums to the configured algorithms
+	if len(cfg.Server.Checksums) > 0 {
+		var algs []checksum.Algorithm
+		for _, name := range cfg.Server.Checksums {
+			alg, err := checksum.Parse(name)
+			if err != nil {
+				log.Fatalf("Invalid checksums config: %v", err)
+			}
+			algs = append(algs, alg)
+		}
+		srv.SetChecksums(algs)
+	}
+
+	srv.SetLimits(cfg.Server.MaxChunkSize, cfg.Server.MaxFileSize)
+
 	// Enable authentication if token file provided
 	if cfg.Server.TokensFile != "" {
 		tokenStore, err := auth.NewTokenStore(cfg.Server.TokensFile)
@@ -49,11 +75,83 @@ func main() {
 		fmt.Printf("Loaded authentication from: %s\n", cfg.Server.TokensFile)
 	}
 
-	fmt.Printf("Starting goflux-server on %s\n", cfg.Server.Address)
+	// Accept client certificates if a client CA is configured
+	if cfg.Server.ClientCAFile != "" {
+		if cfg.Server.ClientCertsFile == "" {
+			log.Fatalf("client_certs must be set when client_ca is configured")
+		}
+		certStore, err := auth.NewCertStore(cfg.Server.ClientCertsFile)
+		if err != nil {
+			log.Fatalf("Failed to load client certificate mappings: %v", err)
+		}
+		if err := srv.EnableCertAuth(cfg.Server.ClientCAFile, certStore); err != nil {
+			log.Fatalf("Failed to enable client certificate auth: %v", err)
+		}
+		fmt.Printf("Loaded client certificate mappings from: %s\n", cfg.Server.ClientCertsFile)
+	}
+
+	// Serve HTTPS if a certificate is configured
+	if cfg.Server.TLSCertFile != "" || cfg.Server.TLSKeyFile != "" {
+		if cfg.Server.TLSCertFile == "" || cfg.Server.TLSKeyFile == "" {
+			log.Fatalf("Both tls_cert and tls_key must be set to enable TLS")
+		}
+		if err := srv.EnableTLS(cfg.Server.TLSCertFile, cfg.Server.TLSKeyFile); err != nil {
+			log.Fatalf("Failed to enable TLS: %v", err)
+		}
+		fmt.Printf("Loaded TLS certificate from: %s\n", cfg.Server.TLSCertFile)
+	}
+
+	listeners := cfg.Server.ServerListeners()
+
+	// Serve storage over SFTP if an ssh listener is configured
+	if hasTransport(listeners, "ssh") {
+		if cfg.Server.SSHKeys == "" {
+			log.Fatalf("ssh_keys must be set to serve ssh")
+		}
+		keyStore, err := auth.NewKeyStore(cfg.Server.SSHKeys)
+		if err != nil {
+			log.Fatalf("Failed to load ssh keys: %v", err)
+		}
+		hostKey := cfg.Server.SSHHostKey
+		if hostKey == "" {
+			hostKey = filepath.Join(cfg.Server.MetaDir, "ssh_host_ed25519_key")
+		}
+		if err := srv.EnableSSH(hostKey, keyStore); err != nil {
+			log.Fatalf("Failed to enable SSH: %v", err)
+		}
+		fmt.Printf("Loaded ssh keys from: %s\n", cfg.Server.SSHKeys)
+	}
+
+	for _, l := range listeners {
+		if err := srv.Listen(l.Transport, l.Address); err != nil {
+			log.Fatalf("Invalid listener: %v", err)
+		}
+	}
+
+	fmt.Printf("Starting goflux-server on %s\n", describeListeners(listeners))
 	fmt.Printf("Storage directory: %s\n", cfg.Server.StorageDir)
 	fmt.Printf("Configuration file: %s\n", *configFile)
 
-	if err := srv.Start(cfg.Server.Address, cfg.Server.WebUIDir); err != nil {
+	if err := srv.Serve(cfg.Server.WebUIDir); err != nil {
 		log.Fatalf("Server failed: %v", err)
 	}
 }
+
+// hasTransport reports whether any listener uses transport
+func hasTransport(listeners []config.ListenerConfig, transport string) bool {
+	for _, l := range listeners {
+		if l.Transport == transport {
+			return true
+		}
+	}
+	return false
+}
+
+// describeListeners lists listeners as "transport address" pairs
+func describeListeners(listeners []config.ListenerConfig) string {
+	var parts []string
+	for _, l := range listeners {
+		parts = append(parts, l.Transport+" "+l.Address)
+	}
+	return strings.Join(parts, ", ")
+}
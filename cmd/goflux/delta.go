@@ -0,0 +1,93 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+
+	"github.com/0xRepo-Source/goflux/pkg/checksum"
+	"github.com/0xRepo-Source/goflux/pkg/chunk"
+	"github.com/0xRepo-Source/goflux/pkg/delta"
+	"github.com/0xRepo-Source/goflux/pkg/proto"
+	"github.com/0xRepo-Source/goflux/pkg/resume"
+	"github.com/0xRepo-Source/goflux/pkg/transport"
+)
+
+// doPutDelta uploads a file as a delta against the version on the server:
+// the server signs the blocks of its copy, and only the data it doesn't
+// have is sent. Files the server doesn't have yet, servers without delta
+// uploads, users without download permission, and remote files that
+// change during the upload fall back to doPut. If quiet is set only
+// warnings are printed.
+func doPutDelta(client transport.Client, splitter chunk.Splitter, alg checksum.Algorithm, uploads *resume.UploadIndex, localPath, remotePath string, parallel int, quiet bool) error {
+	sig, err := client.Signature(remotePath, 0)
+	switch {
+	case errors.Is(err, transport.ErrDeltaUnsupported):
+		fmt.Printf("⚠️  Server does not support delta uploads; sending the whole file\n")
+		return doPut(client, splitter, alg, uploads, localPath, remotePath, "", parallel, quiet)
+	case isForbidden(err):
+		fmt.Printf("⚠️  Delta uploads need download permission; sending the whole file\n")
+		return doPut(client, splitter, alg, uploads, localPath, remotePath, "", parallel, quiet)
+	case errors.Is(err, fs.ErrNotExist):
+		return doPut(client, splitter, alg, uploads, localPath, remotePath, "", parallel, quiet)
+	case err != nil:
+		return fmt.Errorf("failed to get signature of %s: %w", remotePath, err)
+	}
+
+	file, err := os.Open(localPath)
+	if err != nil {
+		return fmt.Errorf("failed to open file: %w", err)
+	}
+	defer file.Close()
+	stat, err := file.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to stat file: %w", err)
+	}
+	fileSize := stat.Size()
+	if err := checkCapabilities(client, splitter, alg, fileSize); err != nil {
+		return err
+	}
+
+	fileHash, err := fileSHA256(localPath)
+	if err != nil {
+		return fmt.Errorf("failed to hash file: %w", err)
+	}
+	if fileHash == sig.FileHash && fileSize == sig.Size {
+		notef(quiet, "✓ Upload complete: %s → %s (already on server, nothing sent)\n", localPath, remotePath)
+		return nil
+	}
+
+	notef(quiet, "Uploading %s as a delta (%d bytes, %d blocks of %d bytes on server)...\n", localPath, fileSize, len(sig.Blocks), sig.BlockSize)
+
+	// The delta is computed while it is sent, and again from the start of
+	// the file if the upload is retried
+	diff := func(w io.Writer) error {
+		_, err := delta.Diff(sig, io.NewSectionReader(file, 0, fileSize), w)
+		return err
+	}
+	result, err := client.PutDelta(remotePath, sig, fileSize, fileHash, diff)
+	if errors.Is(err, transport.ErrRemoteChanged) {
+		// A retry of a delta the server applied finds it changed, to the
+		// version that was sent
+		if info, err := client.Stat(remotePath); err == nil && info.Hash == fileHash && info.Size == fileSize {
+			notef(quiet, "✓ Upload complete: %s → %s (%d bytes)\n", localPath, remotePath, fileSize)
+			return nil
+		}
+		notef(quiet, "%s changed on the server; sending the whole file\n", remotePath)
+		return doPut(client, splitter, alg, uploads, localPath, remotePath, "", parallel, quiet)
+	}
+	if err != nil {
+		return fmt.Errorf("delta upload failed: %w", err)
+	}
+
+	notef(quiet, "✓ Upload complete: %s → %s (%d bytes, %d sent, %d reused from the server copy)\n", localPath, remotePath, result.Size, result.LiteralBytes, result.CopiedBytes)
+	return nil
+}
+
+// deltaSupported reports whether the server takes delta uploads
+func deltaSupported(client transport.Client) bool {
+	caps, err := client.Capabilities()
+	return err == nil && caps.Has(proto.FeatureDelta)
+}
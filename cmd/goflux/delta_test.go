@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/0xRepo-Source/goflux/pkg/auth"
+	"github.com/0xRepo-Source/goflux/pkg/checksum"
+	"github.com/0xRepo-Source/goflux/pkg/chunk"
+	"github.com/0xRepo-Source/goflux/pkg/proto"
+	"github.com/0xRepo-Source/goflux/pkg/resume"
+	"github.com/0xRepo-Source/goflux/pkg/server"
+	"github.com/0xRepo-Source/goflux/pkg/transport"
+)
+
+// putDelta uploads localPath to remotePath with doPutDelta
+func (ts *treeServer) putDelta(t *testing.T, localPath, remotePath string) {
+	t.Helper()
+	uploads, _ := resume.OpenUploadIndex("")
+	if err := doPutDelta(ts.client, chunk.New(1024), checksum.SHA256, uploads, localPath, remotePath, 2, true); err != nil {
+		t.Fatalf("doPutDelta() error = %v", err)
+	}
+}
+
+func TestPutDeltaWithoutDownloadPermission(t *testing.T) {
+	hash := sha256.Sum256([]byte("upload-token"))
+	tokensFile := filepath.Join(t.TempDir(), "tokens.json")
+	data, _ := json.Marshal(auth.TokenStoreFile{Tokens: []auth.Token{{
+		ID:          "t1",
+		TokenHash:   hex.EncodeToString(hash[:]),
+		User:        "uploader",
+		Permissions: []string{"upload"},
+		ExpiresAt:   time.Now().Add(time.Hour),
+	}}})
+	if err := os.WriteFile(tokensFile, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	tokens, err := auth.NewTokenStore(tokensFile)
+	if err != nil {
+		t.Fatalf("NewTokenStore() error = %v", err)
+	}
+
+	ts := newTreeServer(t, func(s *server.Server) { s.EnableAuth(tokens) })
+	client := transport.NewHTTPClient(ts.srv.URL)
+	client.SetAuthToken("upload-token")
+	ts.client = client
+
+	if err := ts.store.Put("/a.txt", []byte("old content")); err != nil {
+		t.Fatal(err)
+	}
+	local := filepath.Join(t.TempDir(), "a.txt")
+	if err := os.WriteFile(local, []byte("new content"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// The signature is refused, and the whole file is sent instead
+	ts.putDelta(t, local, "/a.txt")
+	if got, err := ts.store.Get("/a.txt"); err != nil || string(got) != "new content" {
+		t.Errorf("stored %q, %v; want the new content", got, err)
+	}
+	if n := ts.count("POST /delta"); n != 0 {
+		t.Errorf("sent %d deltas, want 0", n)
+	}
+}
+
+// lostResponseClient sends every delta twice, as a client does that retries
+// a delta whose response was lost, and returns the second answer
+type lostResponseClient struct {
+	transport.Client
+}
+
+func (c *lostResponseClient) PutDelta(path string, sig *proto.Signature, size int64, fileHash string, diff func(w io.Writer) error) (*proto.DeltaResult, error) {
+	if _, err := c.Client.PutDelta(path, sig, size, fileHash, diff); err != nil {
+		return nil, err
+	}
+	return c.Client.PutDelta(path, sig, size, fileHash, diff)
+}
+
+func TestPutDeltaAppliedBeforeRetry(t *testing.T) {
+	ts := newTreeServer(t, nil)
+	ts.client = &lostResponseClient{ts.client}
+
+	old := bytes.Repeat([]byte("0123456789"), 1000)
+	if err := ts.store.Put("/a.bin", old); err != nil {
+		t.Fatal(err)
+	}
+	local := filepath.Join(t.TempDir(), "a.bin")
+	if err := os.WriteFile(local, append([]byte("new "), old...), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// The retry finds the file changed to what it sends, so nothing more is
+	// uploaded
+	ts.putDelta(t, local, "/a.bin")
+	if got, err := ts.store.Get("/a.bin"); err != nil || !bytes.Equal(got, append([]byte("new "), old...)) {
+		t.Errorf("stored %d bytes, %v; want the new version", len(got), err)
+	}
+	if n := ts.count("POST /upload/create"); n != 0 {
+		t.Errorf("started %d uploads, want 0", n)
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/0xRepo-Source/goflux/pkg/proto"
+	"github.com/0xRepo-Source/goflux/pkg/transport"
+)
+
+func doRemove(client transport.Client, remotePath string, recursive bool) error {
+	if err := client.Delete(remotePath, recursive); err != nil {
+		if errors.Is(err, transport.ErrNotEmpty) {
+			return fmt.Errorf("%s is not empty (use rm -r to delete it with its contents)", remotePath)
+		}
+		return err
+	}
+	fmt.Printf("✓ Deleted %s\n", remotePath)
+	return nil
+}
+
+func doMove(client transport.Client, src, dst string) error {
+	dst = remoteTarget(src, dst)
+	if err := client.Move(src, dst); err != nil {
+		return err
+	}
+	fmt.Printf("✓ Moved %s → %s\n", src, dst)
+	return nil
+}
+
+func doCopy(client transport.Client, src, dst string) error {
+	dst = remoteTarget(src, dst)
+	if err := client.Copy(src, dst); err != nil {
+		return err
+	}
+	fmt.Printf("✓ Copied %s → %s\n", src, dst)
+	return nil
+}
+
+func doMkdir(client transport.Client, remotePath string) error {
+	if err := client.Mkdir(remotePath); err != nil {
+		return err
+	}
+	fmt.Printf("✓ Created %s\n", remotePath)
+	return nil
+}
+
+func doStat(client transport.Client, remotePath string) error {
+	info, err := client.Stat(remotePath)
+	if err != nil {
+		return err
+	}
+
+	kind := "file"
+	if info.IsDir {
+		kind = "directory"
+	}
+	fmt.Printf("Path:     %s\n", remotePath)
+	fmt.Printf("Type:     %s\n", kind)
+	if !info.IsDir {
+		fmt.Printf("Size:     %d bytes\n", info.Size)
+	}
+	fmt.Printf("Mode:     %s\n", fileMode(info))
+	fmt.Printf("Modified: %s\n", formatTime(info.ModTime, time.RFC3339))
+	if info.Hash != "" {
+		fmt.Printf("SHA-256:  %s\n", info.Hash)
+	}
+	return nil
+}
+
+// doListDetails lists a directory page by page, with a line of details
+// per entry if long is set
+func doListDetails(client transport.Client, remotePath string, long bool, opts transport.ListOptions) error {
+	fmt.Printf("Files in %s:\n", remotePath)
+	for {
+		listing, err := client.ListDetails(remotePath, opts)
+		if err != nil {
+			return err
+		}
+		for _, e := range listing.Entries {
+			name := e.Name
+			if e.IsDir {
+				name += "/"
+			}
+			if !long {
+				fmt.Printf("  %s\n", name)
+				continue
+			}
+			size := "-"
+			if !e.IsDir {
+				size = strconv.FormatInt(e.Size, 10)
+			}
+			fmt.Printf("  %s %12s  %-16s  %s\n", fileMode(&e), size, formatTime(e.ModTime, "2006-01-02 15:04"), name)
+		}
+		if listing.NextOffset == 0 {
+			return nil
+		}
+		opts.Offset = listing.NextOffset
+	}
+}
+
+// fileMode formats the mode of a remote file as ls does
+func fileMode(info *proto.FileInfo) string {
+	mode := fs.FileMode(info.Mode).Perm()
+	if info.IsDir {
+		mode |= fs.ModeDir
+	}
+	return mode.String()
+}
+
+// formatTime formats t in local time, or "-" if it isn't known
+func formatTime(t time.Time, layout string) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return t.Local().Format(layout)
+}
+
+// remoteTarget returns where src ends up when moved or copied to dst: a
+// dst ending in a slash names the directory to put it in, as with cp.
+func remoteTarget(src, dst string) string {
+	if strings.HasSuffix(dst, "/") {
+		return path.Join(dst, path.Base(src))
+	}
+	return dst
+}
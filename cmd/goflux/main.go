@@ -3,14 +3,21 @@ package main
 import (
 	"crypto/sha256"
 	"encoding/hex"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
 	"log"
 	"os"
+	"strings"
+	"sync"
+	"time"
 
+	"github.com/0xRepo-Source/goflux/pkg/checksum"
 	"github.com/0xRepo-Source/goflux/pkg/chunk"
 	"github.com/0xRepo-Source/goflux/pkg/config"
+	"github.com/0xRepo-Source/goflux/pkg/proto"
+	"github.com/0xRepo-Source/goflux/pkg/resume"
 	"github.com/0xRepo-Source/goflux/pkg/transport"
 	"github.com/schollz/progressbar/v3"
 )
@@ -19,6 +26,8 @@ func main() {
 	// Simple flags - config file only
 	configFile := flag.String("config", "goflux.json", "path to configuration file")
 	version := flag.Bool("version", false, "print version")
+	parallel := flag.Int("parallel", 0, "number of chunks to transfer concurrently (overrides config)")
+	uploadID := flag.String("upload-id", "", "resume the upload with this ID (put only)")
 	flag.Parse()
 
 	if *version {
@@ -44,39 +53,162 @@ func main() {
 		authToken = os.Getenv("GOFLUX_TOKEN")
 	}
 
-	client := transport.NewHTTPClient(cfg.Client.ServerURL)
-	if authToken != "" {
-		client.SetAuthToken(authToken)
+	// The URL scheme selects the transport
+	client, err := transport.Dial(cfg.Client.ServerURL, transport.Options{
+		Token: authToken,
+		Retry: retryPolicy(cfg.Client),
+		TLS: transport.TLSOptions{
+			CAFile:             cfg.Client.CAFile,
+			InsecureSkipVerify: cfg.Client.InsecureSkipVerify,
+			CertFile:           cfg.Client.CertFile,
+			KeyFile:            cfg.Client.KeyFile,
+		},
+		SSH: transport.SSHOptions{
+			KeyFile:               cfg.Client.SSHKey,
+			KnownHostsFile:        cfg.Client.SSHKnownHosts,
+			InsecureIgnoreHostKey: cfg.Client.SSHInsecureIgnoreHostKey,
+		},
+	})
+	if err != nil {
+		log.Fatalf("Failed to connect: %v", err)
 	}
+	defer client.Close()
 
 	chunker := chunk.New(cfg.Client.ChunkSize)
+	splitter, err := newSplitter(cfg.Client)
+	if err != nil {
+		log.Fatalf("Invalid chunking config: %v", err)
+	}
+	alg, err := checksum.Parse(cfg.Client.Checksum)
+	if err != nil {
+		log.Fatalf("Invalid checksum config: %v", err)
+	}
+
+	parallelism := cfg.Client.Parallel
+	if *parallel > 0 {
+		parallelism = *parallel
+	}
 
 	command := args[0]
 	switch command {
 	case "put":
-		if len(args) < 3 {
-			fmt.Println("Usage: goflux put <local-file> <remote-path>")
+		putFlags := flag.NewFlagSet("put", flag.ExitOnError)
+		recursive := putFlags.Bool("r", false, "upload a directory tree")
+		var tree treeOptions
+		tree.register(putFlags)
+		putFlags.BoolVar(&tree.delta, "delta", false, "send only the changes to files that exist on the server")
+		putFlags.Parse(args[1:])
+		if putFlags.NArg() < 2 {
+			fmt.Println("Usage: goflux put [-delta] [-r [-include glob] [-exclude glob] [-jobs n]] <local-path> <remote-path>")
 			os.Exit(1)
 		}
-		if err := doPut(client, chunker, args[1], args[2]); err != nil {
+		uploads := openUploadIndex()
+		if *recursive {
+			err = doPutTree(client, splitter, alg, uploads, putFlags.Arg(0), putTreeTarget(putFlags.Arg(0), putFlags.Arg(1)), &tree, parallelism)
+		} else if tree.delta && *uploadID == "" {
+			err = doPutDelta(client, splitter, alg, uploads, putFlags.Arg(0), putFlags.Arg(1), parallelism, false)
+		} else {
+			err = doPut(client, splitter, alg, uploads, putFlags.Arg(0), putFlags.Arg(1), *uploadID, parallelism, false)
+		}
+		if err != nil {
 			log.Fatalf("Upload failed: %v", err)
 		}
 	case "get":
-		if len(args) < 3 {
-			fmt.Println("Usage: goflux get <remote-path> <local-file>")
+		getFlags := flag.NewFlagSet("get", flag.ExitOnError)
+		recursive := getFlags.Bool("r", false, "download a directory tree")
+		var tree treeOptions
+		tree.register(getFlags)
+		getFlags.Parse(args[1:])
+		if getFlags.NArg() < 2 {
+			fmt.Println("Usage: goflux get [-r [-include glob] [-exclude glob] [-jobs n]] <remote-path> <local-path>")
 			os.Exit(1)
 		}
-		if err := doGet(client, args[1], args[2]); err != nil {
+		if *recursive {
+			err = doGetTree(client, getFlags.Arg(0), getTreeTarget(getFlags.Arg(0), getFlags.Arg(1)), &tree, int64(chunker.Size), parallelism)
+		} else {
+			err = doGet(client, getFlags.Arg(0), getFlags.Arg(1), int64(chunker.Size), parallelism, false)
+		}
+		if err != nil {
 			log.Fatalf("Download failed: %v", err)
 		}
+	case "sync":
+		syncFlags := flag.NewFlagSet("sync", flag.ExitOnError)
+		var tree treeOptions
+		tree.register(syncFlags)
+		syncFlags.BoolVar(&tree.delete, "delete", false, "delete files at the destination that aren't in the source")
+		syncFlags.BoolVar(&tree.dryRun, "dry-run", false, "print what would change without changing anything")
+		syncFlags.BoolVar(&tree.delta, "delta", false, "upload only the changes to files that exist on the server")
+		syncFlags.Parse(args[1:])
+		if syncFlags.NArg() < 2 {
+			fmt.Println("Usage: goflux sync [-delete] [-dry-run] [-delta] [-include glob] [-exclude glob] [-jobs n] <src> <dst>")
+			fmt.Println("One of src and dst is a remote path with a leading colon, e.g. goflux sync ./site :/www")
+			os.Exit(1)
+		}
+		uploads := openUploadIndex()
+		if err := doSync(client, splitter, alg, uploads, syncFlags.Arg(0), syncFlags.Arg(1), &tree, int64(chunker.Size), parallelism); err != nil {
+			log.Fatalf("Sync failed: %v", err)
+		}
 	case "ls":
+		lsFlags := flag.NewFlagSet("ls", flag.ExitOnError)
+		long := lsFlags.Bool("l", false, "show type, size, mode and modification time")
+		sortBy := lsFlags.String("sort", "", "sort by name, size or time")
+		reverse := lsFlags.Bool("r", false, "reverse the sort order")
+		lsFlags.Parse(args[1:])
 		path := "/"
-		if len(args) > 1 {
-			path = args[1]
+		if lsFlags.NArg() > 0 {
+			path = lsFlags.Arg(0)
+		}
+		if *long || *sortBy != "" || *reverse {
+			err = doListDetails(client, path, *long, transport.ListOptions{Sort: *sortBy, Reverse: *reverse})
+		} else {
+			err = doList(client, path)
 		}
-		if err := doList(client, path); err != nil {
+		if err != nil {
 			log.Fatalf("List failed: %v", err)
 		}
+	case "stat":
+		if len(args) < 2 {
+			fmt.Println("Usage: goflux stat <remote-path>")
+			os.Exit(1)
+		}
+		if err := doStat(client, args[1]); err != nil {
+			log.Fatalf("Stat failed: %v", err)
+		}
+	case "rm":
+		rmFlags := flag.NewFlagSet("rm", flag.ExitOnError)
+		recursive := rmFlags.Bool("r", false, "delete directories and their contents")
+		rmFlags.Parse(args[1:])
+		if rmFlags.NArg() < 1 {
+			fmt.Println("Usage: goflux rm [-r] <remote-path>")
+			os.Exit(1)
+		}
+		if err := doRemove(client, rmFlags.Arg(0), *recursive); err != nil {
+			log.Fatalf("Delete failed: %v", err)
+		}
+	case "mv":
+		if len(args) < 3 {
+			fmt.Println("Usage: goflux mv <remote-src> <remote-dst>")
+			os.Exit(1)
+		}
+		if err := doMove(client, args[1], args[2]); err != nil {
+			log.Fatalf("Move failed: %v", err)
+		}
+	case "cp":
+		if len(args) < 3 {
+			fmt.Println("Usage: goflux cp <remote-src> <remote-dst>")
+			os.Exit(1)
+		}
+		if err := doCopy(client, args[1], args[2]); err != nil {
+			log.Fatalf("Copy failed: %v", err)
+		}
+	case "mkdir":
+		if len(args) < 2 {
+			fmt.Println("Usage: goflux mkdir <remote-path>")
+			os.Exit(1)
+		}
+		if err := doMkdir(client, args[1]); err != nil {
+			log.Fatalf("Mkdir failed: %v", err)
+		}
 	default:
 		fmt.Printf("Unknown command: %s\n", command)
 		printUsage()
@@ -84,7 +216,91 @@ func main() {
 	}
 }
 
-func doPut(client *transport.HTTPClient, chunker *chunk.Chunker, localPath, remotePath string) error {
+// retryPolicy builds the transport retry policy from the client config
+func retryPolicy(cfg config.ClientConfig) transport.RetryPolicy {
+	policy := transport.DefaultRetryPolicy()
+	if cfg.Retries < 0 {
+		policy.MaxAttempts = 1
+	} else if cfg.Retries > 0 {
+		policy.MaxAttempts = cfg.Retries + 1
+	}
+	if cfg.RetryBudget > 0 {
+		policy.MaxElapsed = time.Duration(cfg.RetryBudget) * time.Second
+	}
+	return policy
+}
+
+// newSplitter returns the chunk splitter selected by the client config. For
+// content-defined chunking chunk_size is the average chunk size.
+func newSplitter(cfg config.ClientConfig) (chunk.Splitter, error) {
+	fixed := chunk.New(cfg.ChunkSize)
+	switch cfg.Chunking {
+	case "", "fixed":
+		return fixed, nil
+	case "cdc":
+		min, max := cfg.MinChunkSize, cfg.MaxChunkSize
+		if min <= 0 {
+			min = fixed.Size / 4
+		}
+		if max <= 0 {
+			max = fixed.Size * 4
+		}
+		return chunk.NewFastCDC(min, fixed.Size, max)
+	default:
+		return nil, fmt.Errorf("unknown chunking %q (use \"fixed\" or \"cdc\")", cfg.Chunking)
+	}
+}
+
+// checkCapabilities negotiates with the server and fails if it is known not
+// to accept an upload of fileSize bytes split by splitter with alg
+// checksums. Servers that predate negotiation are assumed to.
+func checkCapabilities(client transport.Client, splitter chunk.Splitter, alg checksum.Algorithm, fileSize int64) error {
+	caps, err := client.Capabilities()
+	if err != nil {
+		return fmt.Errorf("failed to query server capabilities: %w", err)
+	}
+	if caps.Version == 0 {
+		return nil
+	}
+	if !caps.AcceptsChecksum(string(alg)) {
+		return fmt.Errorf("server does not accept %s checksums (accepts %v); change \"checksum\" in the config", alg, caps.Checksums)
+	}
+	if caps.MaxChunkSize > 0 && int64(splitter.MaxSize()) > caps.MaxChunkSize {
+		return fmt.Errorf("chunks of up to %d bytes exceed the server limit of %d; lower \"chunk_size\" in the config", splitter.MaxSize(), caps.MaxChunkSize)
+	}
+	if caps.MaxFileSize > 0 && fileSize > caps.MaxFileSize {
+		return fmt.Errorf("file of %d bytes exceeds the server limit of %d", fileSize, caps.MaxFileSize)
+	}
+	return nil
+}
+
+// sameChecksum reports whether an upload's checksum algorithm, as reported
+// by the server, is alg
+func sameChecksum(name string, alg checksum.Algorithm) bool {
+	got, err := checksum.Parse(name)
+	return err == nil && got == alg
+}
+
+// openUploadIndex opens the record of unfinished uploads kept in the user's
+// cache directory, falling back to one that is not persisted
+func openUploadIndex() *resume.UploadIndex {
+	path, err := resume.DefaultUploadIndexPath()
+	if err == nil {
+		var uploads *resume.UploadIndex
+		if uploads, err = resume.OpenUploadIndex(path); err == nil {
+			return uploads
+		}
+	}
+	fmt.Printf("⚠️  Interrupted uploads cannot be resumed automatically: %v\n", err)
+	uploads, _ := resume.OpenUploadIndex("")
+	return uploads
+}
+
+// doPut uploads a file, sending up to parallel chunks at once. It resumes
+// the server upload uploadID if given, or else the one recorded in uploads
+// for the same file and destination. If quiet is set only warnings are
+// printed.
+func doPut(client transport.Client, splitter chunk.Splitter, alg checksum.Algorithm, uploads *resume.UploadIndex, localPath, remotePath, uploadID string, parallel int, quiet bool) error {
 	// Open file for streaming
 	file, err := os.Open(localPath)
 	if err != nil {
@@ -99,50 +315,118 @@ func doPut(client *transport.HTTPClient, chunker *chunk.Chunker, localPath, remo
 	}
 	fileSize := stat.Size()
 
-	// Calculate number of chunks
-	numChunks := int(fileSize / int64(chunker.Size))
-	if fileSize%int64(chunker.Size) != 0 {
-		numChunks++
+	// Fail early on what the server is known not to accept
+	if err := checkCapabilities(client, splitter, alg, fileSize); err != nil {
+		return err
 	}
 
-	fmt.Printf("Uploading %s (%d bytes, %d chunks)...\n", localPath, fileSize, numChunks)
+	// Split and hash the whole file up front so the server can verify the
+	// result and skip content it already holds
+	fileHash, chunks, err := scanFile(file, splitter, alg)
+	if err != nil {
+		return fmt.Errorf("failed to hash file: %w", err)
+	}
+	if len(chunks) == 0 {
+		// An empty file is sent as one empty chunk, so there is an upload
+		// for the server to store
+		chunks = []chunk.Chunk{{Checksum: alg.Sum(nil)}}
+	}
+	numChunks := len(chunks)
 
-	// Query server for existing upload session
-	status, err := client.QueryUploadStatus(remotePath)
+	// Fixed-size chunks are declared so the server can locate them by
+	// position; content-defined ones have varying lengths
+	chunkSize := 0
+	if fixed, ok := splitter.(*chunk.Chunker); ok {
+		chunkSize = fixed.Size
+	}
+
+	notef(quiet, "Uploading %s (%d bytes, %d chunks)...\n", localPath, fileSize, numChunks)
 
 	// Track which chunks to upload
-	chunksToUpload := make(map[int]bool)
-	var totalToUpload int
+	var chunksToUpload []int
 
-	if err != nil {
-		fmt.Printf("⚠️  Could not query upload status: %v (starting fresh upload)\n", err)
-		// Upload all chunks
-		for i := 0; i < numChunks; i++ {
-			chunksToUpload[i] = true
+	explicit := uploadID != ""
+	if !explicit {
+		uploadID = uploads.Lookup(client.URL(), remotePath, fileHash)
+	}
+	if uploadID != "" {
+		status, err := client.QueryUploadStatusByID(uploadID)
+		switch {
+		case err == nil && status.Exists && !status.Completed && status.Path == remotePath && status.TotalChunks == numChunks && sameChecksum(status.Checksum, alg):
+			alreadyUploaded := numChunks - len(status.MissingChunks)
+			notef(quiet, "🔄 Resuming upload %s: %d/%d chunks already uploaded\n", uploadID, alreadyUploaded, numChunks)
+			chunksToUpload = status.MissingChunks
+		case explicit && err != nil:
+			return fmt.Errorf("failed to query upload %s: %w", uploadID, err)
+		case explicit:
+			return fmt.Errorf("upload %s cannot be resumed as %s → %s", uploadID, localPath, remotePath)
+		default:
+			// The recorded upload finished or was discarded
+			uploadID = ""
 		}
-		totalToUpload = numChunks
-	} else if status.Exists && !status.Completed {
-		alreadyUploaded := numChunks - len(status.MissingChunks)
-		fmt.Printf("🔄 Resuming upload: %d/%d chunks already uploaded\n", alreadyUploaded, numChunks)
+	}
 
-		// Only upload missing chunks
-		for _, chunkID := range status.MissingChunks {
-			chunksToUpload[chunkID] = true
+	if uploadID == "" {
+		uploadID, err = client.CreateUpload(remotePath, numChunks, chunkSize, fileHash, alg)
+		if errors.Is(err, transport.ErrUploadIDsUnsupported) {
+			// Servers without upload IDs only verify SHA-256 checksums
+			if alg != checksum.SHA256 {
+				return fmt.Errorf("server does not support %s checksums; set \"checksum\": \"sha256\"", alg)
+			}
+			uploadID = ""
+			chunksToUpload = legacyChunksToUpload(client, remotePath, numChunks)
+		} else if err != nil {
+			return fmt.Errorf("failed to create upload: %w", err)
+		} else {
+			notef(quiet, "Upload ID: %s\n", uploadID)
+			if err := uploads.Remember(client.URL(), remotePath, fileHash, uploadID); err != nil {
+				fmt.Printf("⚠️  Could not record upload ID: %v\n", err)
+			}
+			for i := 0; i < numChunks; i++ {
+				chunksToUpload = append(chunksToUpload, i)
+			}
 		}
-		totalToUpload = len(status.MissingChunks)
-	} else {
-		// Fresh upload - upload all chunks
-		for i := 0; i < numChunks; i++ {
-			chunksToUpload[i] = true
+	}
+
+	// Let the server skip chunks, or the whole file, it already holds
+	if uploadID != "" && len(chunksToUpload) > 0 {
+		manifest := proto.Manifest{Size: fileSize, FileHash: fileHash}
+		for _, c := range chunks {
+			manifest.ChunkHashes = append(manifest.ChunkHashes, c.Checksum)
+			manifest.ChunkOffsets = append(manifest.ChunkOffsets, c.Offset)
+		}
+		result, err := client.SendManifest(uploadID, manifest)
+		switch {
+		case errors.Is(err, transport.ErrManifestUnsupported):
+		case err != nil:
+			if errors.Is(err, transport.ErrFileHashMismatch) {
+				uploads.Forget(client.URL(), remotePath, fileHash)
+			}
+			return fmt.Errorf("manifest check failed: %w", err)
+		case result.Complete:
+			if err := uploads.Forget(client.URL(), remotePath, fileHash); err != nil {
+				fmt.Printf("⚠️  Could not update upload index: %v\n", err)
+			}
+			if result.Linked {
+				notef(quiet, "✓ Upload complete: %s → %s (already on server, nothing sent)\n", localPath, remotePath)
+			} else {
+				notef(quiet, "✓ Upload complete: %s → %s (all chunks already on server)\n", localPath, remotePath)
+			}
+			return nil
+		default:
+			if result.ReusedChunks > 0 {
+				notef(quiet, "♻️  %d chunks already on server\n", result.ReusedChunks)
+			}
+			chunksToUpload = result.MissingChunks
 		}
-		totalToUpload = numChunks
 	}
 
 	// Create progress bar
-	bar := progressbar.NewOptions(totalToUpload,
+	bar := progressbar.NewOptions(len(chunksToUpload),
 		progressbar.OptionEnableColorCodes(true),
 		progressbar.OptionShowBytes(true),
 		progressbar.OptionSetWidth(40),
+		progressbar.OptionSetVisibility(!quiet),
 		progressbar.OptionSetDescription("[cyan]Uploading...[reset]"),
 		progressbar.OptionSetTheme(progressbar.Theme{
 			Saucer:        "[green]=[reset]",
@@ -153,84 +437,389 @@ func doPut(client *transport.HTTPClient, chunker *chunk.Chunker, localPath, remo
 		}),
 	)
 
-	// Stream and upload chunks
-	buffer := make([]byte, chunker.Size)
-	chunkID := 0
+	// Upload chunks with a pool of workers, each reading its chunks
+	// straight from the file at their offsets
+	reader := chunk.NewReaderAt(file, chunks, alg)
+	buffers := sync.Pool{New: func() any { return make([]byte, splitter.MaxSize()) }}
+	send := func(chunkID int) error {
+		buffer := buffers.Get().([]byte)
+		defer buffers.Put(buffer)
+		err := uploadChunkAt(client, reader, buffer, uploadID, remotePath, chunkID, fileHash)
+		if err != nil && !errors.Is(err, transport.ErrChunksMissing) {
+			return err
+		}
+		// Chunks the server lost are resent by confirmUpload
+		_ = bar.Add(1)
+		return nil
+	}
+	err = runParallel(chunksToUpload, parallel, send)
+	if err == nil && uploadID != "" {
+		err = confirmUpload(client, uploadID, numChunks, parallel, send, quiet)
+	}
+
+	if err != nil {
+		bar.Close()
+		if errors.Is(err, transport.ErrFileHashMismatch) {
+			uploads.Forget(client.URL(), remotePath, fileHash)
+			return fmt.Errorf("server rejected the upload, was %s modified while uploading? %w", localPath, err)
+		}
+		return err
+	}
+
+	_ = bar.Finish()
+	if err := uploads.Forget(client.URL(), remotePath, fileHash); err != nil {
+		fmt.Printf("⚠️  Could not update upload index: %v\n", err)
+	}
+	notef(quiet, "\n✓ Upload complete: %s → %s\n", localPath, remotePath)
+	return nil
+}
 
-	for {
-		// Read chunk from file
-		n, err := file.Read(buffer)
-		if err == io.EOF {
-			break
+// confirmAttempts is how often confirmUpload sends chunks again before it
+// gives up on an upload the server doesn't store
+const confirmAttempts = 3
+
+// confirmUpload makes sure the server stored an upload whose chunks were
+// all accepted. Storing the file can fail, or find chunks the server has
+// to be sent again; a stored upload's session is gone. Missing chunks are
+// sent again with send, and if none are missing a chunk is resent to make
+// the server store the file again.
+func confirmUpload(client transport.Client, uploadID string, numChunks, parallel int, send func(int) error, quiet bool) error {
+	for attempt := 0; ; attempt++ {
+		status, err := client.QueryUploadStatusByID(uploadID)
+		if errors.Is(err, transport.ErrUploadNotFound) || err == nil && !status.Exists {
+			return nil
 		}
 		if err != nil {
-			bar.Close()
-			return fmt.Errorf("failed to read chunk: %w", err)
-		}
-
-		// Only upload if needed
-		if chunksToUpload[chunkID] {
-			// Calculate checksum for this chunk
-			chunkData := buffer[:n]
-			hash := sha256.Sum256(chunkData)
-			checksum := hex.EncodeToString(hash[:])
-
-			// Upload chunk
-			uploadData := transport.ChunkData{
-				Path:     remotePath,
-				ChunkID:  chunkID,
-				Data:     chunkData,
-				Checksum: checksum,
-				Total:    numChunks,
-			}
+			return fmt.Errorf("failed to confirm upload %s: %w", uploadID, err)
+		}
+		if attempt == confirmAttempts {
+			return fmt.Errorf("server did not store upload %s; resume it with --upload-id %s", uploadID, uploadID)
+		}
 
-			if err := client.UploadChunk(uploadData); err != nil {
-				bar.Close()
-				return fmt.Errorf("failed to upload chunk %d: %w", chunkID, err)
-			}
-			_ = bar.Add(1)
+		resend := status.MissingChunks
+		if len(resend) == 0 {
+			resend = []int{numChunks - 1}
+		}
+		notef(quiet, "\n🔄 Server did not store the file yet; sending %d chunks again\n", len(resend))
+		if err := runParallel(resend, parallel, send); err != nil {
+			return err
+		}
+	}
+}
+
+// legacyChunksToUpload lists the chunks to send to a server without upload
+// IDs, resuming its upload session for remotePath if there is one
+func legacyChunksToUpload(client transport.Client, remotePath string, numChunks int) []int {
+	// Query server for existing upload session
+	status, err := client.QueryUploadStatus(remotePath)
+
+	var chunksToUpload []int
+
+	if err != nil {
+		fmt.Printf("⚠️  Could not query upload status: %v (starting fresh upload)\n", err)
+		// Upload all chunks
+		for i := 0; i < numChunks; i++ {
+			chunksToUpload = append(chunksToUpload, i)
 		}
+	} else if status.Exists && !status.Completed {
+		alreadyUploaded := numChunks - len(status.MissingChunks)
+		fmt.Printf("🔄 Resuming upload: %d/%d chunks already uploaded\n", alreadyUploaded, numChunks)
 
-		chunkID++
+		// Only upload missing chunks
+		chunksToUpload = status.MissingChunks
+	} else {
+		// Fresh upload - upload all chunks
+		for i := 0; i < numChunks; i++ {
+			chunksToUpload = append(chunksToUpload, i)
+		}
 	}
 
-	_ = bar.Finish()
-	fmt.Printf("\n✓ Upload complete: %s → %s\n", localPath, remotePath)
+	return chunksToUpload
+}
+
+// uploadChunkAt reads one chunk from the file into buffer and uploads it
+func uploadChunkAt(client transport.Client, reader *chunk.ReaderAt, buffer []byte, uploadID, remotePath string, chunkID int, fileHash string) error {
+	c, err := reader.ReadChunk(chunkID, buffer)
+	if err != nil {
+		return err
+	}
+
+	// Servers without upload IDs expect untagged SHA-256 checksums
+	sum := c.Checksum
+	if uploadID == "" {
+		sum = strings.TrimPrefix(sum, string(checksum.SHA256)+":")
+	}
+
+	uploadData := proto.ChunkData{
+		UploadID: uploadID,
+		Path:     remotePath,
+		ChunkID:  chunkID,
+		Data:     c.Data,
+		Checksum: sum,
+		Total:    reader.Len(),
+		FileHash: fileHash,
+		Offset:   c.Offset,
+	}
+
+	if err := client.UploadChunk(uploadData); err != nil {
+		return fmt.Errorf("failed to upload chunk %d: %w", chunkID, err)
+	}
 	return nil
 }
 
-func doGet(client *transport.HTTPClient, remotePath, localPath string) error {
-	fmt.Printf("Downloading %s...\n", remotePath)
+// scanFile splits a file into chunks and returns the SHA-256 of the file
+// and the layout of its chunks, checksummed with alg. It rewinds the file.
+func scanFile(file *os.File, splitter chunk.Splitter, alg checksum.Algorithm) (string, []chunk.Chunk, error) {
+	hasher := sha256.New()
+	chunks, err := chunk.Scan(io.TeeReader(file, hasher), splitter, alg)
+	if err != nil {
+		return "", nil, err
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return "", nil, err
+	}
+	return hex.EncodeToString(hasher.Sum(nil)), chunks, nil
+}
+
+// runParallel calls fn for every id using up to parallel goroutines. It
+// stops handing out ids after the first error and returns that error.
+func runParallel(ids []int, parallel int, fn func(id int) error) error {
+	if parallel < 1 {
+		parallel = 1
+	}
 
-	// Create indeterminate progress bar (we don't know size beforehand)
-	bar := progressbar.NewOptions(-1,
-		progressbar.OptionSetDescription("[cyan]Downloading...[reset]"),
-		progressbar.OptionSetWidth(40),
-		progressbar.OptionSpinnerType(14),
-		progressbar.OptionShowCount(),
-		progressbar.OptionShowIts(),
-		progressbar.OptionEnableColorCodes(true),
+	jobs := make(chan int)
+	stop := make(chan struct{})
+	var (
+		wg       sync.WaitGroup
+		errOnce  sync.Once
+		firstErr error
 	)
-	_ = bar.RenderBlank()
 
-	data, err := client.Download(remotePath)
+	for w := 0; w < parallel; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for id := range jobs {
+				if err := fn(id); err != nil {
+					errOnce.Do(func() {
+						firstErr = err
+						close(stop)
+					})
+					return
+				}
+			}
+		}()
+	}
+
+dispatch:
+	for _, id := range ids {
+		select {
+		case jobs <- id:
+		case <-stop:
+			break dispatch
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	return firstErr
+}
+
+// doGet downloads a file into localPath+".part", fetching up to parallel
+// blocks at once, and renames it into place when complete. Progress is
+// tracked in localPath+".part.json" so an interrupted download resumes
+// where it stopped, as long as the remote file is unchanged. Servers that
+// can't send ranges of the file are downloaded from in one request instead,
+// which starts over every time. The file is checked against the server's
+// SHA-256 of it, if the server reports one. If quiet is set only warnings
+// are printed.
+func doGet(client transport.Client, remotePath, localPath string, blockSize int64, parallel int, quiet bool) error {
+	info, err := client.StatDownload(remotePath)
+	if err != nil {
+		return err
+	}
+	if !info.Ranges {
+		return getWhole(client, remotePath, localPath, info, quiet)
+	}
+
+	partPath := localPath + ".part"
+	statePath := partPath + ".json"
+
+	// Resume a previous download of the same version of the file, as long
+	// as the blocks it has written are still there
+	session, err := resume.LoadDownloadSession(statePath)
+	if err == nil && (session.RemotePath != remotePath || session.ETag != info.ETag || session.Size != info.Size) {
+		fmt.Printf("⚠️  %s changed on the server, restarting download\n", remotePath)
+		session = nil
+	} else if err == nil {
+		if part, err := os.Stat(partPath); err != nil || part.Size() < session.DoneSize() {
+			fmt.Printf("⚠️  %s is missing or incomplete, restarting download\n", partPath)
+			session = nil
+		}
+	} else if !os.IsNotExist(err) {
+		fmt.Printf("⚠️  Ignoring download state: %v\n", err)
+	}
+
+	resuming := session != nil
+	if !resuming {
+		os.Remove(partPath)
+		session, err = resume.NewDownloadSession(statePath, remotePath, info.ETag, info.Size, blockSize)
+		if err != nil {
+			return err
+		}
+	}
+
+	file, err := os.OpenFile(partPath, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", partPath, err)
+	}
+	defer file.Close()
+	if err := file.Truncate(info.Size); err != nil {
+		return fmt.Errorf("failed to size %s: %w", partPath, err)
+	}
+
+	missing := session.MissingBlocks()
+	var remaining int64
+	for _, block := range missing {
+		_, length := session.Block(block)
+		remaining += length
+	}
+
+	if resuming {
+		notef(quiet, "🔄 Resuming download of %s: %d/%d bytes already downloaded\n", remotePath, info.Size-remaining, info.Size)
+	} else {
+		notef(quiet, "Downloading %s (%d bytes)...\n", remotePath, info.Size)
+	}
+
+	bar := downloadBar(remaining, quiet)
+	err = runParallel(missing, parallel, func(block int) error {
+		offset, length := session.Block(block)
+		if err := client.DownloadRange(remotePath, offset, length, info.ETag, file); err != nil {
+			return fmt.Errorf("failed to download bytes %d-%d: %w", offset, offset+length-1, err)
+		}
+		_ = bar.Add64(length)
+		return session.MarkBlockDone(block)
+	})
 	if err != nil {
 		bar.Close()
+		if errors.Is(err, transport.ErrRemoteChanged) {
+			// The partial data belongs to an older version; start over next time
+			session.Delete()
+			os.Remove(partPath)
+			return fmt.Errorf("%s changed on the server during download, run the command again: %w", remotePath, err)
+		}
 		return err
 	}
+	_ = bar.Finish()
 
+	if err := checkDownload(partPath, remotePath, info.Hash); err != nil {
+		// Whatever went wrong is in the part file; start over next time
+		file.Close()
+		session.Delete()
+		os.Remove(partPath)
+		return err
+	}
+
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("failed to write file: %w", err)
+	}
+	if err := os.Rename(partPath, localPath); err != nil {
+		return fmt.Errorf("failed to move %s into place: %w", partPath, err)
+	}
+	if err := session.Delete(); err != nil {
+		fmt.Printf("Warning: %v\n", err)
+	}
+
+	notef(quiet, "\n✓ Download complete: %s → %s (%d bytes)\n", remotePath, localPath, info.Size)
+	return nil
+}
+
+// getWhole downloads a file from a server that can't send ranges of it in
+// a single request into localPath+".part", and renames it into place when
+// complete.
+func getWhole(client transport.Client, remotePath, localPath string, info *transport.RemoteFile, quiet bool) error {
+	partPath := localPath + ".part"
+	// Progress of an earlier ranged download doesn't apply to this one
+	os.Remove(partPath + ".json")
+
+	file, err := os.Create(partPath)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", partPath, err)
+	}
+	defer file.Close()
+
+	notef(quiet, "Downloading %s (the server can't resume downloads)...\n", remotePath)
+	bar := downloadBar(info.Size, quiet)
+	n, err := client.Download(remotePath, io.MultiWriter(file, bar))
+	if err == nil && info.Size >= 0 && n != info.Size {
+		err = fmt.Errorf("got %d of %d bytes", n, info.Size)
+	}
+	if err == nil {
+		err = checkDownload(partPath, remotePath, info.Hash)
+	}
+	if err != nil {
+		bar.Close()
+		file.Close()
+		os.Remove(partPath)
+		return fmt.Errorf("download of %s failed: %w", remotePath, err)
+	}
 	_ = bar.Finish()
-	fmt.Printf("\n")
 
-	if err := os.WriteFile(localPath, data, 0644); err != nil {
+	if err := file.Close(); err != nil {
 		return fmt.Errorf("failed to write file: %w", err)
 	}
+	if err := os.Rename(partPath, localPath); err != nil {
+		return fmt.Errorf("failed to move %s into place: %w", partPath, err)
+	}
+
+	notef(quiet, "\n✓ Download complete: %s → %s (%d bytes)\n", remotePath, localPath, n)
+	return nil
+}
 
-	fmt.Printf("✓ Download complete: %s → %s (%d bytes)\n", remotePath, localPath, len(data))
+// checkDownload compares a downloaded file with the SHA-256 the server
+// reported for it, if any
+func checkDownload(partPath, remotePath, hash string) error {
+	if hash == "" {
+		return nil
+	}
+	sum, err := fileSHA256(partPath)
+	if err != nil {
+		return fmt.Errorf("failed to check %s: %w", partPath, err)
+	}
+	if sum != hash {
+		return fmt.Errorf("downloaded %s does not match the server's SHA-256 %s, run the command again", remotePath, hash)
+	}
 	return nil
 }
 
-func doList(client *transport.HTTPClient, path string) error {
+// downloadBar returns the progress bar of a download of total bytes, or of
+// unknown length if total is -1
+func downloadBar(total int64, quiet bool) *progressbar.ProgressBar {
+	return progressbar.NewOptions64(total,
+		progressbar.OptionEnableColorCodes(true),
+		progressbar.OptionShowBytes(true),
+		progressbar.OptionSetWidth(40),
+		progressbar.OptionSetVisibility(!quiet),
+		progressbar.OptionSetDescription("[cyan]Downloading...[reset]"),
+		progressbar.OptionSetTheme(progressbar.Theme{
+			Saucer:        "[green]=[reset]",
+			SaucerHead:    "[green]>[reset]",
+			SaucerPadding: " ",
+			BarStart:      "[",
+			BarEnd:        "]",
+		}),
+	)
+}
+
+// notef prints a progress message unless quiet is set
+func notef(quiet bool, format string, args ...any) {
+	if !quiet {
+		fmt.Printf(format, args...)
+	}
+}
+
+func doList(client transport.Client, path string) error {
 	files, err := client.List(path)
 	if err != nil {
 		return err
@@ -249,16 +838,49 @@ func printUsage() {
 	fmt.Println("  goflux [--config <file>] <command> [args...]")
 	fmt.Println("\nCommands:")
 	fmt.Println("  put <local-file> <remote-path>   Upload a file")
+	fmt.Println("  put -delta <local-file> <remote-path>")
+	fmt.Println("                                   Upload only what changed from the file on the server")
+	fmt.Println("  put -r <local-dir> <remote-dir>  Upload a directory tree")
 	fmt.Println("  get <remote-path> <local-file>   Download a file")
-	fmt.Println("  ls [path]                        List files (default: /)")
+	fmt.Println("  get -r <remote-dir> <local-dir>  Download a directory tree")
+	fmt.Println("  sync [-delete] [-dry-run] <src> <dst>")
+	fmt.Println("                                   Mirror a local directory to a remote one or back;")
+	fmt.Println("                                   the remote side has a leading colon (:/path)")
+	fmt.Println("  ls [-l] [-sort name|size|time] [-r] [path]")
+	fmt.Println("                                   List files (default: /), -l with details")
+	fmt.Println("  stat <remote-path>               Show size, mode, time and hash of a file")
+	fmt.Println("  rm [-r] <remote-path>            Delete a file, or a directory with -r")
+	fmt.Println("  mv <remote-src> <remote-dst>     Move or rename a file or directory")
+	fmt.Println("  cp <remote-src> <remote-dst>     Copy a file or directory")
+	fmt.Println("  mkdir <remote-path>              Create a directory and its parents")
 	fmt.Println("\nFlags:")
 	fmt.Println("  --config <file>   Configuration file (default: goflux.json)")
+	fmt.Println("  --parallel <n>    Chunks to transfer concurrently (default: from config)")
+	fmt.Println("  --upload-id <id>  Resume the upload with this ID (put)")
 	fmt.Println("  --version         Print version")
+	fmt.Println("\nTree transfers (put -r, get -r, sync; before the paths):")
+	fmt.Println("  -include <glob>   Only transfer files whose path or name matches (repeatable)")
+	fmt.Println("  -exclude <glob>   Skip files and directories that match (repeatable)")
+	fmt.Println("  -jobs <n>         Files to transfer concurrently (default: 4)")
+	fmt.Println("  -delete           Delete destination files missing from the source (sync)")
+	fmt.Println("  -dry-run          Print the changes without making them (sync)")
+	fmt.Println("  -delta            Upload only what changed in files the server has (put, sync)")
+	fmt.Println("  Files already at the destination with the same size and content are skipped")
 	fmt.Println("\nConfiguration:")
 	fmt.Println("  Edit goflux.json to set server URL, token, and chunk size")
 	fmt.Println("  Use GOFLUX_TOKEN environment variable for authentication")
+	fmt.Println("  The server_url scheme selects the transport:")
+	fmt.Println("    http://host:port, https://host:port   HTTP(S)")
+	fmt.Println("    quic://host:port                      HTTP/3 over QUIC")
+	fmt.Println("    unix:///path/to/socket                HTTP over a unix socket")
+	fmt.Println("    ssh://user@host:port                  SFTP")
 	fmt.Println("\nExamples:")
 	fmt.Println("  goflux ls")
+	fmt.Println("  goflux ls -l -sort time -r /uploads")
 	fmt.Println("  goflux put file.txt /uploads/file.txt")
+	fmt.Println("  goflux put -delta dump.sql /backup/dump.sql")
+	fmt.Println("  goflux put -r -exclude '*.tmp' ./photos /backup/")
+	fmt.Println("  goflux sync -delete -dry-run ./site :/www")
+	fmt.Println("  goflux mv /uploads/file.txt /archive/")
 	fmt.Println("  goflux --config prod.json get /data/file.zip ./file.zip")
 }
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/0xRepo-Source/goflux/pkg/proto"
+	"github.com/0xRepo-Source/goflux/pkg/resume"
+	"github.com/0xRepo-Source/goflux/pkg/transport"
+)
+
+func TestGetResumes(t *testing.T) {
+	content := make([]byte, 10000)
+	rand.New(rand.NewSource(1)).Read(content)
+	sum := sha256.Sum256(content)
+	const etag = `"2-a"`
+
+	var mu sync.Mutex
+	var ranges []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "GET" {
+			mu.Lock()
+			ranges = append(ranges, r.Header.Get("Range"))
+			mu.Unlock()
+		}
+		w.Header().Set("ETag", etag)
+		w.Header().Set(proto.HeaderFileHash, hex.EncodeToString(sum[:]))
+		http.ServeContent(w, r, "a.bin", time.Time{}, bytes.NewReader(content))
+	}))
+	defer srv.Close()
+
+	tests := []struct {
+		name     string
+		etag     string // ETag of the interrupted download, if any
+		done     int    // blocks it completed
+		part     int    // length of the part file left, -1 for none, 0 for all
+		state    string // state file content instead of a session
+		requests int    // ranges the download should fetch
+	}{
+		{"fresh", "", 0, 0, "", 10},
+		{"after a partial write", etag, 6, 0, "", 4},
+		{"remote changed", `"1-a"`, 6, 0, "", 10},
+		{"corrupt state", "", 0, 0, `{"etag": "\"2-a\"", "size": 10000, "block_siz`, 10},
+		{"part deleted", etag, 6, -1, "", 10},
+		{"part truncated", etag, 6, 5500, "", 10},
+		{"part truncated after the done blocks", etag, 6, 6000, "", 4},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			local := filepath.Join(t.TempDir(), "a.bin")
+			partPath := local + ".part"
+			statePath := partPath + ".json"
+
+			// The part file holds the completed blocks of the interrupted
+			// download; the rest of it, and all of it for another version of
+			// the file, is garbage that must not survive
+			part := bytes.Repeat([]byte{0xff}, len(content))
+			if tt.etag != "" {
+				d, err := resume.NewDownloadSession(statePath, "/a.bin", tt.etag, int64(len(content)), 1000)
+				if err != nil {
+					t.Fatalf("NewDownloadSession() error = %v", err)
+				}
+				for i := 0; i < tt.done; i++ {
+					if err := d.MarkBlockDone(i); err != nil {
+						t.Fatal(err)
+					}
+				}
+				if tt.etag == etag {
+					copy(part, content[:tt.done*1000])
+				}
+			}
+			if tt.state != "" {
+				if err := os.WriteFile(statePath, []byte(tt.state), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if tt.part > 0 {
+				part = part[:tt.part]
+			}
+			if (tt.etag != "" || tt.state != "") && tt.part >= 0 {
+				if err := os.WriteFile(partPath, part, 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			ranges = nil
+			if err := doGet(transport.NewHTTPClient(srv.URL), "/a.bin", local, 1000, 3, true); err != nil {
+				t.Fatalf("doGet() error = %v", err)
+			}
+
+			got, err := os.ReadFile(local)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, content) {
+				t.Error("downloaded file differs from the remote one")
+			}
+			if len(ranges) != tt.requests {
+				t.Errorf("fetched %d ranges, want %d: %s", len(ranges), tt.requests, strings.Join(ranges, ", "))
+			}
+			for _, path := range []string{partPath, statePath} {
+				if _, err := os.Stat(path); !os.IsNotExist(err) {
+					t.Errorf("%s left behind after the download", filepath.Base(path))
+				}
+			}
+		})
+	}
+}
+
+func TestGetChecksHash(t *testing.T) {
+	content := bytes.Repeat([]byte("goflux"), 1000)
+	sum := sha256.Sum256(content)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", `"1-a"`)
+		w.Header().Set(proto.HeaderFileHash, hex.EncodeToString(sum[:]))
+		http.ServeContent(w, r, "a.bin", time.Time{}, bytes.NewReader(content))
+	}))
+	defer srv.Close()
+
+	// An interrupted download whose part file was overwritten since: the
+	// blocks marked done no longer hold what was downloaded
+	local := filepath.Join(t.TempDir(), "a.bin")
+	partPath := local + ".part"
+	d, err := resume.NewDownloadSession(partPath+".json", "/a.bin", `"1-a"`, int64(len(content)), 1000)
+	if err != nil {
+		t.Fatalf("NewDownloadSession() error = %v", err)
+	}
+	if err := d.MarkBlockDone(0); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(partPath, make([]byte, len(content)), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	client := transport.NewHTTPClient(srv.URL)
+	if err := doGet(client, "/a.bin", local, 1000, 3, true); err == nil {
+		t.Fatal("doGet() accepted a file that doesn't match the server's hash")
+	}
+	if _, err := os.Stat(local); !os.IsNotExist(err) {
+		t.Error("corrupt download moved into place")
+	}
+
+	// The next run starts over
+	if err := doGet(client, "/a.bin", local, 1000, 3, true); err != nil {
+		t.Fatalf("doGet() after a corrupt download error = %v", err)
+	}
+	if got, _ := os.ReadFile(local); !bytes.Equal(got, content) {
+		t.Error("downloaded file differs from the remote one")
+	}
+}
+
+func TestGetWithoutRanges(t *testing.T) {
+	// A server from before ranged downloads: no ETag or Accept-Ranges, and
+	// bodies too long to buffer are sent without a length
+	var gets int
+	var content []byte
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "GET" {
+			gets++
+		}
+		w.Write(content)
+	}))
+	defer srv.Close()
+
+	for _, size := range []int{100, 100000} {
+		content = bytes.Repeat([]byte("x"), size)
+		local := filepath.Join(t.TempDir(), "a.bin")
+
+		// Leftovers of a ranged download don't get in the way
+		if err := os.WriteFile(local+".part", []byte("stale"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := resume.NewDownloadSession(local+".part.json", "/a.bin", `"1-a"`, int64(size), 1000); err != nil {
+			t.Fatal(err)
+		}
+
+		for run := 0; run < 2; run++ {
+			gets = 0
+			if err := doGet(transport.NewHTTPClient(srv.URL), "/a.bin", local, 1000, 3, true); err != nil {
+				t.Fatalf("%d bytes, run %d: doGet() error = %v", size, run, err)
+			}
+			if got, _ := os.ReadFile(local); !bytes.Equal(got, content) {
+				t.Errorf("%d bytes, run %d: downloaded file differs from the remote one", size, run)
+			}
+			if gets != 1 {
+				t.Errorf("%d bytes, run %d: %d requests, want 1", size, run, gets)
+			}
+		}
+		for _, path := range []string{local + ".part", local + ".part.json"} {
+			if _, err := os.Stat(path); !os.IsNotExist(err) {
+				t.Errorf("%s left behind after the download", filepath.Base(path))
+			}
+		}
+	}
+}
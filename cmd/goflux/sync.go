@@ -0,0 +1,170 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/0xRepo-Source/goflux/pkg/checksum"
+	"github.com/0xRepo-Source/goflux/pkg/chunk"
+	"github.com/0xRepo-Source/goflux/pkg/resume"
+	"github.com/0xRepo-Source/goflux/pkg/transport"
+)
+
+// treePlan is what a tree transfer changes at its destination.
+type treePlan struct {
+	extra    []treeEntry // destination entries missing from the source, children first; only with delete
+	mkdirs   []treeEntry // source directories missing at the destination, parents first
+	transfer []treeEntry // source files missing or different at the destination
+	touch    []treeEntry // files with the same content whose mode or time differ
+	dirs     []treeEntry // source directories whose mode and time to set, children first
+	upToDate int         // files already the same
+}
+
+// planTree compares the entries of the source tree with those of the
+// destination. localPath locates an entry in whichever tree is local;
+// upload tells which that is. Comparing files may mean hashing local
+// ones, so up to opts.jobs are compared at once.
+func planTree(src, dst []treeEntry, opts *treeOptions, localPath func(rel string) string, upload bool) (*treePlan, error) {
+	srcBy := make(map[string]treeEntry, len(src))
+	for _, e := range src {
+		srcBy[e.rel] = e
+	}
+	dstBy := make(map[string]treeEntry, len(dst))
+	for _, e := range dst {
+		dstBy[e.rel] = e
+	}
+
+	plan := &treePlan{}
+	if opts.delete {
+		for i := len(dst) - 1; i >= 0; i-- {
+			e := dst[i]
+			if s, ok := srcBy[e.rel]; e.rel == "" || ok && s.isDir == e.isDir || partialDownload(e, srcBy, upload) {
+				continue
+			}
+			plan.extra = append(plan.extra, e)
+		}
+	}
+
+	dirs, files := opts.treeSelect(src)
+	for _, d := range dirs {
+		if e, ok := dstBy[d.rel]; !ok || !e.isDir {
+			plan.mkdirs = append(plan.mkdirs, d)
+		}
+	}
+
+	const (
+		differs = iota
+		attrsDiffer
+		same
+	)
+	states := make([]int, len(files))
+	ids := make([]int, len(files))
+	for i := range ids {
+		ids[i] = i
+	}
+	err := runParallel(ids, opts.jobs, func(i int) error {
+		f := files[i]
+		d, ok := dstBy[f.rel]
+		if !ok || d.isDir {
+			return nil
+		}
+		local, remote := f, d
+		if !upload {
+			local, remote = d, f
+		}
+		equal, err := sameFile(localPath(f.rel), local.size, local.modTime, remote)
+		if err != nil {
+			return fmt.Errorf("failed to compare %s: %w", f.rel, err)
+		}
+		switch {
+		case !equal:
+		case d.mode != f.mode || d.modTime.Unix() != f.modTime.Unix():
+			states[i] = attrsDiffer
+		default:
+			states[i] = same
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	for i, f := range files {
+		switch states[i] {
+		case differs:
+			plan.transfer = append(plan.transfer, f)
+		case attrsDiffer:
+			plan.touch = append(plan.touch, f)
+		default:
+			plan.upToDate++
+		}
+	}
+
+	// Changing the contents of directories changes their modification
+	// times, so after any change they're all set again
+	changed := len(plan.extra)+len(plan.mkdirs)+len(plan.transfer) > 0
+	for i := len(dirs) - 1; i >= 0; i-- {
+		d := dirs[i]
+		e, ok := dstBy[d.rel]
+		if changed || !ok || e.mode != d.mode || e.modTime.Unix() != d.modTime.Unix() {
+			plan.dirs = append(plan.dirs, d)
+		}
+	}
+	return plan, nil
+}
+
+// partialDownload reports whether a local destination entry holds the
+// progress of downloading a source file, which a later run resumes
+func partialDownload(e treeEntry, srcBy map[string]treeEntry, upload bool) bool {
+	if upload || e.isDir {
+		return false
+	}
+	for _, suffix := range []string{".part", ".part.json"} {
+		if s, ok := srcBy[strings.TrimSuffix(e.rel, suffix)]; ok && strings.HasSuffix(e.rel, suffix) && !s.isDir {
+			return true
+		}
+	}
+	return false
+}
+
+// print lists the changes of a dry run
+func (p *treePlan) print(verb string) {
+	for _, e := range p.extra {
+		fmt.Printf("delete    %s\n", e.display())
+	}
+	for _, d := range p.mkdirs {
+		fmt.Printf("mkdir     %s\n", d.display())
+	}
+	for _, f := range p.transfer {
+		fmt.Printf("%-9s %s (%d bytes)\n", verb, f.display(), f.size)
+	}
+	for _, f := range p.touch {
+		fmt.Printf("attrs     %s\n", f.display())
+	}
+	fmt.Printf("Dry run: %d files to %s, %d to delete, %d up to date; nothing was changed\n", len(p.transfer), verb, len(p.extra), p.upToDate+len(p.touch))
+}
+
+// doSync makes dst a mirror of src. One of them is a remote path, written
+// with a leading colon, and the other a local directory.
+func doSync(client transport.Client, splitter chunk.Splitter, alg checksum.Algorithm, uploads *resume.UploadIndex, src, dst string, opts *treeOptions, blockSize int64, parallel int) error {
+	remoteSrc, ok := remoteArg(src)
+	remoteDst, dstOK := remoteArg(dst)
+	switch {
+	case ok == dstOK:
+		return errors.New("one of source and destination must be a remote path, written with a leading colon (:/path)")
+	case dstOK:
+		return doPutTree(client, splitter, alg, uploads, src, remoteDst, opts, parallel)
+	default:
+		return doGetTree(client, remoteSrc, dst, opts, blockSize, parallel)
+	}
+}
+
+// remoteArg returns the remote path named by a sync argument with a
+// leading colon, and whether it had one
+func remoteArg(arg string) (string, bool) {
+	if !strings.HasPrefix(arg, ":") {
+		return "", false
+	}
+	return path.Clean("/" + strings.TrimPrefix(arg, ":")), true
+}
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+var planTime = time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+func dirEntry(rel string) treeEntry {
+	return treeEntry{rel: rel, isDir: true, mode: 0755, modTime: planTime}
+}
+
+func fileEntry(rel string, size int64) treeEntry {
+	return treeEntry{rel: rel, size: size, mode: 0644, modTime: planTime}
+}
+
+// rels returns the paths of entries
+func rels(entries []treeEntry) []string {
+	names := []string{}
+	for _, e := range entries {
+		names = append(names, e.rel)
+	}
+	return names
+}
+
+func TestPlanTree(t *testing.T) {
+	older := fileEntry("a", 1)
+	older.modTime = planTime.Add(-time.Hour)
+	private := fileEntry("a", 1)
+	private.mode = 0600
+
+	tests := []struct {
+		name     string
+		src, dst []treeEntry
+		opts     treeOptions
+		upload   bool
+		extra    []string
+		mkdirs   []string
+		transfer []string
+		touch    []string
+		upToDate int
+	}{
+		{
+			name:     "new and changed files",
+			src:      []treeEntry{dirEntry(""), dirEntry("d"), fileEntry("d/a", 1), fileEntry("b", 2)},
+			dst:      []treeEntry{dirEntry(""), fileEntry("b", 3)},
+			mkdirs:   []string{"d"},
+			transfer: []string{"d/a", "b"},
+		},
+		{
+			name:     "same content",
+			src:      []treeEntry{dirEntry(""), fileEntry("a", 1), fileEntry("b", 2)},
+			dst:      []treeEntry{dirEntry(""), fileEntry("a", 1), fileEntry("b", 2)},
+			upToDate: 2,
+		},
+		{
+			name:     "older copy",
+			src:      []treeEntry{dirEntry(""), fileEntry("a", 1)},
+			dst:      []treeEntry{dirEntry(""), older},
+			transfer: []string{"a"},
+		},
+		{
+			name:  "mode differs",
+			src:   []treeEntry{dirEntry(""), fileEntry("a", 1)},
+			dst:   []treeEntry{dirEntry(""), private},
+			touch: []string{"a"},
+		},
+		{
+			name:     "delete children first",
+			src:      []treeEntry{dirEntry(""), fileEntry("keep", 1)},
+			dst:      []treeEntry{dirEntry(""), dirEntry("old"), fileEntry("old/x", 1), fileEntry("keep", 1), fileEntry("y", 1)},
+			opts:     treeOptions{delete: true},
+			extra:    []string{"y", "old/x", "old"},
+			upToDate: 1,
+		},
+		{
+			name: "no delete without the flag",
+			src:  []treeEntry{dirEntry("")},
+			dst:  []treeEntry{dirEntry(""), dirEntry("old"), fileEntry("old/x", 1)},
+		},
+		{
+			name:     "directory replaced by a file",
+			src:      []treeEntry{dirEntry(""), fileEntry("p", 1)},
+			dst:      []treeEntry{dirEntry(""), dirEntry("p"), fileEntry("p/q", 1)},
+			opts:     treeOptions{delete: true},
+			extra:    []string{"p/q", "p"},
+			transfer: []string{"p"},
+		},
+		{
+			name:     "file replaced by a directory",
+			src:      []treeEntry{dirEntry(""), dirEntry("p"), fileEntry("p/q", 1)},
+			dst:      []treeEntry{dirEntry(""), fileEntry("p", 1)},
+			opts:     treeOptions{delete: true},
+			extra:    []string{"p"},
+			mkdirs:   []string{"p"},
+			transfer: []string{"p/q"},
+		},
+		{
+			name:     "type change without delete",
+			src:      []treeEntry{dirEntry(""), dirEntry("p"), fileEntry("p/q", 1), fileEntry("r", 1)},
+			dst:      []treeEntry{dirEntry(""), fileEntry("p", 1), dirEntry("r")},
+			mkdirs:   []string{"p"},
+			transfer: []string{"p/q", "r"},
+		},
+		{
+			name:     "partial downloads kept",
+			src:      []treeEntry{dirEntry(""), fileEntry("big", 10)},
+			dst:      []treeEntry{dirEntry(""), fileEntry("big.part", 10), fileEntry("big.part.json", 1), fileEntry("other.part", 1), fileEntry("big.json", 1)},
+			opts:     treeOptions{delete: true},
+			extra:    []string{"big.json", "other.part"},
+			transfer: []string{"big"},
+		},
+		{
+			name:     "partial names deleted on upload",
+			src:      []treeEntry{dirEntry(""), fileEntry("big", 10)},
+			dst:      []treeEntry{dirEntry(""), fileEntry("big.part", 10), fileEntry("big.part.json", 1)},
+			opts:     treeOptions{delete: true},
+			upload:   true,
+			extra:    []string{"big.part.json", "big.part"},
+			transfer: []string{"big"},
+		},
+		{
+			name:  "partial name of a directory deleted",
+			src:   []treeEntry{dirEntry(""), dirEntry("big")},
+			dst:   []treeEntry{dirEntry(""), dirEntry("big"), fileEntry("big.part", 1)},
+			opts:  treeOptions{delete: true},
+			extra: []string{"big.part"},
+		},
+		{
+			name:     "include selects directories",
+			src:      []treeEntry{dirEntry(""), dirEntry("docs"), fileEntry("docs/a.txt", 1), dirEntry("img"), dirEntry("new"), fileEntry("new/b.txt", 1)},
+			dst:      []treeEntry{dirEntry(""), dirEntry("docs"), fileEntry("docs/a.txt", 1), fileEntry("docs/old.txt", 1), dirEntry("gone")},
+			opts:     treeOptions{include: patternList{"*.txt"}, delete: true},
+			extra:    []string{"gone", "docs/old.txt"},
+			mkdirs:   []string{"new"},
+			transfer: []string{"new/b.txt"},
+			upToDate: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			plan, err := planTree(tt.src, tt.dst, &tt.opts, func(rel string) string { return rel }, tt.upload)
+			if err != nil {
+				t.Fatalf("planTree() error = %v", err)
+			}
+			check := func(what string, got []treeEntry, want []string) {
+				if want == nil {
+					want = []string{}
+				}
+				if !slices.Equal(rels(got), want) {
+					t.Errorf("%s = %v, want %v", what, rels(got), want)
+				}
+			}
+			check("extra", plan.extra, tt.extra)
+			check("mkdirs", plan.mkdirs, tt.mkdirs)
+			check("transfer", plan.transfer, tt.transfer)
+			check("touch", plan.touch, tt.touch)
+			if plan.upToDate != tt.upToDate {
+				t.Errorf("upToDate = %d, want %d", plan.upToDate, tt.upToDate)
+			}
+		})
+	}
+}
+
+func TestPlanTreeSetsDirectoriesAfterChanges(t *testing.T) {
+	src := []treeEntry{dirEntry(""), dirEntry("d"), dirEntry("d/e"), fileEntry("d/e/f", 1)}
+
+	plan, err := planTree(src, src, &treeOptions{}, func(rel string) string { return rel }, true)
+	if err != nil {
+		t.Fatalf("planTree() error = %v", err)
+	}
+	if len(plan.dirs) != 0 {
+		t.Errorf("unchanged tree sets directories %v", rels(plan.dirs))
+	}
+
+	// Any change sets them all again, children first
+	plan, err = planTree(src, src[:3], &treeOptions{}, func(rel string) string { return rel }, true)
+	if err != nil {
+		t.Fatalf("planTree() error = %v", err)
+	}
+	if want := []string{"d/e", "d", ""}; !slices.Equal(rels(plan.dirs), want) {
+		t.Errorf("dirs = %v, want %v", rels(plan.dirs), want)
+	}
+}
+
+func TestPlanTreeComparesHashes(t *testing.T) {
+	dir := t.TempDir()
+	content := []byte("hello")
+	if err := os.WriteFile(filepath.Join(dir, "a"), content, 0644); err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256(content)
+
+	local := []treeEntry{dirEntry(""), fileEntry("a", 5)}
+	remote := fileEntry("a", 5)
+	remote.modTime = planTime.Add(time.Hour)
+	localPath := func(rel string) string { return filepath.Join(dir, rel) }
+
+	// A remote hash decides even when the times differ
+	remote.hash = hex.EncodeToString(sum[:])
+	plan, err := planTree(local, []treeEntry{dirEntry(""), remote}, &treeOptions{}, localPath, true)
+	if err != nil {
+		t.Fatalf("planTree() error = %v", err)
+	}
+	if !slices.Equal(rels(plan.touch), []string{"a"}) || len(plan.transfer) != 0 {
+		t.Errorf("same hash: touch %v, transfer %v; want only the time set", rels(plan.touch), rels(plan.transfer))
+	}
+
+	remote.hash = hex.EncodeToString(make([]byte, 32))
+	plan, err = planTree(local, []treeEntry{dirEntry(""), remote}, &treeOptions{}, localPath, true)
+	if err != nil {
+		t.Fatalf("planTree() error = %v", err)
+	}
+	if !slices.Equal(rels(plan.transfer), []string{"a"}) {
+		t.Errorf("different hash: transfer %v, want [a]", rels(plan.transfer))
+	}
+}
+
+func TestSyncDeleteSparesExcluded(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"a.txt", "keep.log", "sub/b.txt", "sub/c.log", "cache/x.txt"} {
+		p := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte("x"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Downloading a remote tree that only has a.txt, skipping logs and the
+	// cache directory: what the filters hide locally is never deleted
+	opts := &treeOptions{exclude: patternList{"*.log", "cache"}, delete: true}
+	dst, err := localTree(dir, opts)
+	if err != nil {
+		t.Fatalf("localTree() error = %v", err)
+	}
+	src := []treeEntry{dirEntry(""), fileEntry("a.txt", 1)}
+	plan, err := planTree(src, dst, opts, func(rel string) string { return filepath.Join(dir, rel) }, false)
+	if err != nil {
+		t.Fatalf("planTree() error = %v", err)
+	}
+	if want := []string{"sub/b.txt", "sub"}; !slices.Equal(rels(plan.extra), want) {
+		t.Errorf("extra = %v, want %v", rels(plan.extra), want)
+	}
+}
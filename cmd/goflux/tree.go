@@ -0,0 +1,571 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/0xRepo-Source/goflux/pkg/checksum"
+	"github.com/0xRepo-Source/goflux/pkg/chunk"
+	"github.com/0xRepo-Source/goflux/pkg/proto"
+	"github.com/0xRepo-Source/goflux/pkg/resume"
+	"github.com/0xRepo-Source/goflux/pkg/transport"
+)
+
+// treeOptions selects the files of a recursive put, get or sync and how
+// many of them move at once.
+type treeOptions struct {
+	include patternList
+	exclude patternList
+	jobs    int
+	delete  bool // delete destination files missing from the source
+	dryRun  bool // print the plan without changing anything
+	delta   bool // upload changed files as deltas against their remote versions
+}
+
+// register adds the tree flags to a command's flag set
+func (o *treeOptions) register(flags *flag.FlagSet) {
+	flags.Var(&o.include, "include", "with -r, only transfer files matching this glob (repeatable)")
+	flags.Var(&o.exclude, "exclude", "with -r, skip files and directories matching this glob (repeatable)")
+	flags.IntVar(&o.jobs, "jobs", 4, "with -r, number of files to transfer concurrently")
+}
+
+// patternList collects the values of a repeatable glob flag. A value may
+// hold several patterns separated by commas.
+type patternList []string
+
+func (p *patternList) String() string {
+	return strings.Join(*p, ",")
+}
+
+func (p *patternList) Set(value string) error {
+	for _, pattern := range strings.Split(value, ",") {
+		pattern = strings.TrimSpace(pattern)
+		if pattern == "" {
+			continue
+		}
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid pattern %q: %w", pattern, err)
+		}
+		*p = append(*p, pattern)
+	}
+	return nil
+}
+
+// matches reports whether a pattern matches rel, a slash-separated path
+// relative to the tree root, or its last element
+func (p patternList) matches(rel string) bool {
+	for _, pattern := range p {
+		if ok, _ := path.Match(pattern, rel); ok {
+			return true
+		}
+		if ok, _ := path.Match(pattern, path.Base(rel)); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// skipDir reports whether the directory rel and everything below it are
+// left out
+func (o *treeOptions) skipDir(rel string) bool {
+	return rel != "" && o.exclude.matches(rel)
+}
+
+// wantFile reports whether the file rel is transferred
+func (o *treeOptions) wantFile(rel string) bool {
+	if o.exclude.matches(rel) {
+		return false
+	}
+	return len(o.include) == 0 || o.include.matches(rel)
+}
+
+// treeEntry is a file or directory of a tree transfer
+type treeEntry struct {
+	rel     string // slash-separated path below the tree root, "" for the root
+	isDir   bool
+	size    int64
+	mode    fs.FileMode
+	modTime time.Time
+	hash    string // SHA-256 of a remote file, if the server knows it
+}
+
+// display names the entry for the user, directories with a trailing slash
+func (e treeEntry) display() string {
+	switch {
+	case e.rel == "":
+		return "./"
+	case e.isDir:
+		return e.rel + "/"
+	}
+	return e.rel
+}
+
+// treeSelect splits entries into directories and files. With include
+// patterns only the directories leading to a selected file are kept, so
+// a filtered transfer doesn't create a skeleton of empty directories.
+func (o *treeOptions) treeSelect(entries []treeEntry) (dirs, files []treeEntry) {
+	needed := map[string]bool{"": true}
+	for _, e := range entries {
+		if e.isDir {
+			continue
+		}
+		files = append(files, e)
+		for dir := path.Dir(e.rel); dir != "."; dir = path.Dir(dir) {
+			needed[dir] = true
+		}
+	}
+	for _, e := range entries {
+		if e.isDir && (len(o.include) == 0 || needed[e.rel]) {
+			dirs = append(dirs, e)
+		}
+	}
+	return dirs, files
+}
+
+// localTree lists the directories and selected files below root, parents
+// before children. Symlinks and special files are skipped.
+func localTree(root string, opts *treeOptions) ([]treeEntry, error) {
+	var entries []treeEntry
+	err := filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if rel == "." {
+			rel = ""
+		}
+
+		if d.IsDir() && opts.skipDir(rel) {
+			return filepath.SkipDir
+		}
+		if !d.IsDir() && !d.Type().IsRegular() {
+			fmt.Printf("⚠️  Skipping %s: not a regular file\n", p)
+			return nil
+		}
+		if !d.IsDir() && !opts.wantFile(rel) {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		entries = append(entries, treeEntry{
+			rel:     rel,
+			isDir:   d.IsDir(),
+			size:    info.Size(),
+			mode:    info.Mode().Perm(),
+			modTime: info.ModTime(),
+		})
+		return nil
+	})
+	return entries, err
+}
+
+// remoteTree lists the directories and selected files below a remote
+// directory, parents before children.
+func remoteTree(client transport.Client, root string, opts *treeOptions) ([]treeEntry, error) {
+	info, err := client.Stat(root)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir {
+		return nil, fmt.Errorf("%s is not a directory", root)
+	}
+	entries := []treeEntry{remoteEntry("", *info)}
+
+	var walk func(rel string) error
+	walk = func(rel string) error {
+		infos, err := listAll(client, path.Join(root, rel))
+		if err != nil {
+			return err
+		}
+		for _, info := range infos {
+			if !validName(info.Name) {
+				return fmt.Errorf("%s lists an invalid name %q", path.Join(root, rel), info.Name)
+			}
+			childRel := path.Join(rel, info.Name)
+			if info.IsDir {
+				if opts.skipDir(childRel) {
+					continue
+				}
+				entries = append(entries, remoteEntry(childRel, info))
+				if err := walk(childRel); err != nil {
+					return err
+				}
+			} else if opts.wantFile(childRel) {
+				entries = append(entries, remoteEntry(childRel, info))
+			}
+		}
+		return nil
+	}
+	return entries, walk("")
+}
+
+// validName reports whether a name from a remote listing names a single
+// entry of its directory. Anything else could place a download outside
+// the target directory.
+func validName(name string) bool {
+	return name != "" && name != "." && name != ".." && !strings.ContainsAny(name, `/\`)
+}
+
+// remoteEntry converts a remote file description to a tree entry
+func remoteEntry(rel string, info proto.FileInfo) treeEntry {
+	return treeEntry{
+		rel:     rel,
+		isDir:   info.IsDir,
+		size:    info.Size,
+		mode:    fs.FileMode(info.Mode).Perm(),
+		modTime: info.ModTime,
+		hash:    info.Hash,
+	}
+}
+
+// listAll returns every entry of a remote directory, reading the listing
+// page by page
+func listAll(client transport.Client, dir string) ([]proto.FileInfo, error) {
+	var infos []proto.FileInfo
+	opts := transport.ListOptions{}
+	for {
+		listing, err := client.ListDetails(dir, opts)
+		if err != nil {
+			return nil, err
+		}
+		infos = append(infos, listing.Entries...)
+		if listing.NextOffset == 0 {
+			return infos, nil
+		}
+		opts.Offset = listing.NextOffset
+	}
+}
+
+// isForbidden reports whether the server refused a request for lack of a
+// permission
+func isForbidden(err error) bool {
+	var perr *proto.Error
+	return errors.As(err, &perr) && perr.Code == proto.CodeForbidden
+}
+
+// sameFile reports whether the local file at localPath holds what a remote
+// file describes: the sizes are equal and so are the SHA-256 hashes, or the
+// modification times to the second where the hash isn't known.
+func sameFile(localPath string, size int64, modTime time.Time, remote treeEntry) (bool, error) {
+	if remote.isDir || remote.size != size {
+		return false, nil
+	}
+	if remote.hash == "" {
+		return remote.modTime.Unix() == modTime.Unix(), nil
+	}
+	sum, err := fileSHA256(localPath)
+	if err != nil {
+		return false, err
+	}
+	return sum == remote.hash, nil
+}
+
+// fileSHA256 returns the hex SHA-256 of a local file
+func fileSHA256(name string) (string, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	hasher := sha256.New()
+	if _, err := io.Copy(hasher, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hasher.Sum(nil)), nil
+}
+
+// treeProgress counts the files of a tree transfer as workers finish them
+type treeProgress struct {
+	total   int
+	done    atomic.Int64
+	sent    atomic.Int64
+	bytes   atomic.Int64
+	failed  atomic.Int64
+	deleted atomic.Int64
+}
+
+// run transfers files with up to jobs at once, reporting each as it
+// finishes. A failed file doesn't stop the others.
+func (p *treeProgress) run(files []treeEntry, jobs int, transfer func(f treeEntry) error) {
+	ids := make([]int, len(files))
+	for i := range ids {
+		ids[i] = i
+	}
+	runParallel(ids, jobs, func(i int) error {
+		f := files[i]
+		err := transfer(f)
+		n := p.done.Add(1)
+		if err != nil {
+			p.fail(f.rel, err)
+			return nil
+		}
+		p.sent.Add(1)
+		p.bytes.Add(f.size)
+		fmt.Printf("[%d/%d] ✓ %s (%d bytes)\n", n, p.total, f.rel, f.size)
+		return nil
+	})
+}
+
+// fail reports a file that couldn't be transferred or changed
+func (p *treeProgress) fail(rel string, err error) {
+	p.failed.Add(1)
+	fmt.Printf("✗ %s: %v\n", rel, err)
+}
+
+// remove reports the deletion of an extraneous entry. Directories that
+// still hold excluded files are kept.
+func (p *treeProgress) remove(e treeEntry, err error) {
+	switch {
+	case err == nil || errors.Is(err, fs.ErrNotExist):
+		p.deleted.Add(1)
+		fmt.Printf("- %s\n", e.display())
+	case e.isDir:
+		fmt.Printf("⚠️  Keeping %s: %v\n", e.display(), err)
+	default:
+		p.fail(e.rel, err)
+	}
+}
+
+// finish prints a summary and fails if any file did
+func (p *treeProgress) finish(verb string, plan *treePlan) error {
+	summary := fmt.Sprintf("✓ %s %d files (%d bytes), %d already up to date", verb, p.sent.Load(), p.bytes.Load(), plan.upToDate+len(plan.touch))
+	if deleted := p.deleted.Load(); deleted > 0 {
+		summary += fmt.Sprintf(", %d deleted", deleted)
+	}
+	fmt.Println(summary)
+	if failed := p.failed.Load(); failed > 0 {
+		return fmt.Errorf("%d files failed, run the command again to retry them", failed)
+	}
+	return nil
+}
+
+// attrWarning warns once that the server can't keep modes and times
+type attrWarning struct{ once sync.Once }
+
+func (w *attrWarning) check(err error) error {
+	if errors.Is(err, transport.ErrSetAttrUnsupported) {
+		w.once.Do(func() {
+			fmt.Println("⚠️  Server cannot set modes and modification times; they are not preserved")
+		})
+		return nil
+	}
+	return err
+}
+
+// doPutTree uploads the directory tree at localDir to remoteDir, up to
+// opts.jobs files at once, and copies the modes and modification times of
+// its files and directories. Files the server already holds with the same
+// size and content are skipped, so an interrupted transfer resumes with
+// the files it hadn't finished. With opts.delete, remote files that aren't
+// in the local tree are deleted.
+func doPutTree(client transport.Client, splitter chunk.Splitter, alg checksum.Algorithm, uploads *resume.UploadIndex, localDir, remoteDir string, opts *treeOptions, parallel int) error {
+	if info, err := os.Stat(localDir); err != nil {
+		return err
+	} else if !info.IsDir() {
+		return fmt.Errorf("%s is not a directory", localDir)
+	}
+	src, err := localTree(localDir, opts)
+	if err != nil {
+		return err
+	}
+
+	dst, err := remoteTree(client, remoteDir, opts)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		dst = nil
+	case errors.Is(err, transport.ErrStatUnsupported) || isForbidden(err):
+		if opts.delete || opts.dryRun {
+			return fmt.Errorf("cannot list %s: %w", remoteDir, err)
+		}
+		fmt.Printf("⚠️  Cannot list files on the server (%v); uploading every file\n", err)
+		dst = nil
+	case err != nil:
+		return err
+	}
+
+	localPath := func(rel string) string { return filepath.Join(localDir, filepath.FromSlash(rel)) }
+	remotePath := func(rel string) string { return path.Join(remoteDir, rel) }
+	plan, err := planTree(src, dst, opts, localPath, true)
+	if err != nil {
+		return err
+	}
+	if opts.dryRun {
+		plan.print("upload")
+		return nil
+	}
+
+	fmt.Printf("Uploading %s → %s (%d files to send, %d up to date)...\n", localDir, remoteDir, len(plan.transfer), plan.upToDate+len(plan.touch))
+	useDelta := opts.delta
+	if useDelta && !deltaSupported(client) {
+		fmt.Printf("⚠️  Server does not support delta uploads; sending whole files\n")
+		useDelta = false
+	}
+	progress := &treeProgress{total: len(plan.transfer)}
+	var warning attrWarning
+
+	for _, e := range plan.extra {
+		progress.remove(e, client.Delete(remotePath(e.rel), false))
+	}
+
+	// Make the directories first so empty ones are kept too. Uploads
+	// create the directories of files anyway, so that's all that's lost
+	// without them.
+	for _, d := range plan.mkdirs {
+		if err := client.Mkdir(remotePath(d.rel)); errors.Is(err, transport.ErrFilesUnsupported) || isForbidden(err) {
+			fmt.Printf("⚠️  Cannot create directories (%v); empty directories are not uploaded\n", err)
+			break
+		} else if err != nil {
+			return fmt.Errorf("failed to create %s: %w", remotePath(d.rel), err)
+		}
+	}
+
+	progress.run(plan.transfer, opts.jobs, func(f treeEntry) error {
+		var err error
+		if useDelta {
+			err = doPutDelta(client, splitter, alg, uploads, localPath(f.rel), remotePath(f.rel), parallel, true)
+		} else {
+			err = doPut(client, splitter, alg, uploads, localPath(f.rel), remotePath(f.rel), "", parallel, true)
+		}
+		if err != nil {
+			return err
+		}
+		return warning.check(client.SetAttr(remotePath(f.rel), f.mode, f.modTime))
+	})
+	for _, f := range plan.touch {
+		if err := warning.check(client.SetAttr(remotePath(f.rel), f.mode, f.modTime)); err != nil {
+			progress.fail(f.rel, err)
+		}
+	}
+
+	for _, d := range plan.dirs {
+		if remotePath(d.rel) == "/" {
+			continue
+		}
+		// Empty directories are missing if they couldn't be made
+		err := warning.check(client.SetAttr(remotePath(d.rel), d.mode, d.modTime))
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			fmt.Printf("⚠️  Could not set attributes of %s: %v\n", remotePath(d.rel), err)
+		}
+	}
+	return progress.finish("Uploaded", plan)
+}
+
+// doGetTree downloads the remote directory tree at remoteDir to localDir,
+// up to opts.jobs files at once, and copies the modes and modification
+// times of its files and directories. Local files with the same size and
+// content as the remote ones are skipped, and partly downloaded files
+// resume, so an interrupted transfer picks up where it stopped. With
+// opts.delete, local files that aren't in the remote tree are deleted.
+func doGetTree(client transport.Client, remoteDir, localDir string, opts *treeOptions, blockSize int64, parallel int) error {
+	src, err := remoteTree(client, remoteDir, opts)
+	if err != nil {
+		return err
+	}
+
+	var dst []treeEntry
+	if info, err := os.Stat(localDir); err == nil {
+		if !info.IsDir() {
+			return fmt.Errorf("%s is not a directory", localDir)
+		}
+		if dst, err = localTree(localDir, opts); err != nil {
+			return err
+		}
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+
+	localPath := func(rel string) string { return filepath.Join(localDir, filepath.FromSlash(rel)) }
+	plan, err := planTree(src, dst, opts, localPath, false)
+	if err != nil {
+		return err
+	}
+	if opts.dryRun {
+		plan.print("download")
+		return nil
+	}
+
+	fmt.Printf("Downloading %s → %s (%d files to fetch, %d up to date)...\n", remoteDir, localDir, len(plan.transfer), plan.upToDate+len(plan.touch))
+	progress := &treeProgress{total: len(plan.transfer)}
+
+	for _, e := range plan.extra {
+		progress.remove(e, os.Remove(localPath(e.rel)))
+	}
+	for _, d := range plan.mkdirs {
+		if err := os.MkdirAll(localPath(d.rel), 0755); err != nil {
+			return err
+		}
+	}
+
+	progress.run(plan.transfer, opts.jobs, func(f treeEntry) error {
+		if err := doGet(client, path.Join(remoteDir, f.rel), localPath(f.rel), blockSize, parallel, true); err != nil {
+			return err
+		}
+		return setLocalAttr(localPath(f.rel), f)
+	})
+	for _, f := range plan.touch {
+		if err := setLocalAttr(localPath(f.rel), f); err != nil {
+			progress.fail(f.rel, err)
+		}
+	}
+
+	for _, d := range plan.dirs {
+		if err := setLocalAttr(localPath(d.rel), d); err != nil {
+			fmt.Printf("⚠️  Could not set attributes of %s: %v\n", localPath(d.rel), err)
+		}
+	}
+	return progress.finish("Downloaded", plan)
+}
+
+// putTreeTarget returns the remote directory put -r uploads localDir to.
+// As with cp, a destination ending in a slash names the directory to put
+// it in.
+func putTreeTarget(localDir, remoteDir string) string {
+	abs, err := filepath.Abs(localDir)
+	if err != nil {
+		return remoteDir
+	}
+	return remoteTarget(filepath.ToSlash(abs), remoteDir)
+}
+
+// getTreeTarget returns the local directory get -r downloads remoteDir
+// to, with a destination ending in a separator naming the directory to
+// put it in.
+func getTreeTarget(remoteDir, localDir string) string {
+	if strings.HasSuffix(localDir, "/") || strings.HasSuffix(localDir, string(filepath.Separator)) {
+		return filepath.Join(localDir, path.Base(path.Clean("/"+remoteDir)))
+	}
+	return localDir
+}
+
+// setLocalAttr gives a local file the mode and modification time of the
+// remote entry it was downloaded from
+func setLocalAttr(name string, e treeEntry) error {
+	if e.mode != 0 {
+		if err := os.Chmod(name, e.mode); err != nil {
+			return err
+		}
+	}
+	if !e.modTime.IsZero() {
+		return os.Chtimes(name, e.modTime, e.modTime)
+	}
+	return nil
+}
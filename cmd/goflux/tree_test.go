@@ -0,0 +1,348 @@
+package main
+
+import (
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/0xRepo-Source/goflux/pkg/checksum"
+	"github.com/0xRepo-Source/goflux/pkg/chunk"
+	"github.com/0xRepo-Source/goflux/pkg/proto"
+	"github.com/0xRepo-Source/goflux/pkg/resume"
+	"github.com/0xRepo-Source/goflux/pkg/server"
+	"github.com/0xRepo-Source/goflux/pkg/storage"
+	"github.com/0xRepo-Source/goflux/pkg/transport"
+)
+
+// testListener serves a goflux server's handler with httptest and counts
+// the requests it answers by route
+type testListener struct {
+	srv   *httptest.Server
+	ready chan struct{}
+	done  chan struct{}
+
+	mu       sync.Mutex
+	requests map[string]int
+}
+
+func (l *testListener) Serve(handler http.Handler) error {
+	l.srv.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		l.mu.Lock()
+		l.requests[r.Method+" "+r.URL.Path]++
+		l.mu.Unlock()
+		handler.ServeHTTP(w, r)
+	})
+	l.srv.Start()
+	close(l.ready)
+	<-l.done
+	return nil
+}
+
+func (l *testListener) String() string { return "test" }
+
+// count returns how many requests were made for a method and route since
+// the last call, and starts counting again
+func (l *testListener) count(route string) int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	n := l.requests[route]
+	l.requests = make(map[string]int)
+	return n
+}
+
+// treeServer is a goflux server storing files in a temporary directory
+type treeServer struct {
+	*testListener
+	root   string // directory of the store
+	store  *storage.Local
+	client transport.Client
+}
+
+// newTreeServer starts a server; configure, if not nil, is called on it
+// before it serves
+func newTreeServer(t *testing.T, configure func(*server.Server)) *treeServer {
+	t.Helper()
+	root := t.TempDir()
+	store, err := storage.NewLocal(root)
+	if err != nil {
+		t.Fatalf("NewLocal() error = %v", err)
+	}
+	s, err := server.New(store, t.TempDir())
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	if configure != nil {
+		configure(s)
+	}
+
+	l := &testListener{
+		srv:      httptest.NewUnstartedServer(nil),
+		ready:    make(chan struct{}),
+		done:     make(chan struct{}),
+		requests: make(map[string]int),
+	}
+	s.AddListener(l)
+	go s.Serve("")
+	<-l.ready
+	t.Cleanup(func() {
+		l.srv.Close()
+		close(l.done)
+	})
+	return &treeServer{testListener: l, root: root, store: store, client: transport.NewHTTPClient(l.srv.URL)}
+}
+
+func (ts *treeServer) putTree(t *testing.T, localDir, remoteDir string, opts *treeOptions) {
+	t.Helper()
+	uploads, _ := resume.OpenUploadIndex("")
+	if err := doPutTree(ts.client, chunk.New(1024), checksum.SHA256, uploads, localDir, remoteDir, opts, 2); err != nil {
+		t.Fatalf("doPutTree() error = %v", err)
+	}
+}
+
+func (ts *treeServer) getTree(t *testing.T, remoteDir, localDir string, opts *treeOptions) {
+	t.Helper()
+	if err := doGetTree(ts.client, remoteDir, localDir, opts, 1024, 2); err != nil {
+		t.Fatalf("doGetTree() error = %v", err)
+	}
+}
+
+// writeTree creates files under dir from a map of slash-separated paths to
+// contents; paths ending in a slash are directories
+func writeTree(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		p := filepath.Join(dir, filepath.FromSlash(name))
+		if strings.HasSuffix(name, "/") {
+			if err := os.MkdirAll(p, 0755); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestTreeEmptyFiles(t *testing.T) {
+	ts := newTreeServer(t, nil)
+	src := t.TempDir()
+	writeTree(t, src, map[string]string{"a.txt": "hello", "empty": "", "sub/empty": ""})
+
+	ts.putTree(t, src, "/tree", &treeOptions{})
+	for _, name := range []string{"/tree/empty", "/tree/sub/empty"} {
+		if info, err := ts.store.Stat(name); err != nil || info.Size != 0 {
+			t.Errorf("Stat(%s) = %+v, %v; want an empty file", name, info, err)
+		}
+	}
+	ts.count("")
+
+	// Nothing is left to send, so a second run converges
+	ts.putTree(t, src, "/tree", &treeOptions{})
+	if n := ts.count("POST /upload/create"); n != 0 {
+		t.Errorf("second put -r started %d uploads, want 0", n)
+	}
+
+	dst := filepath.Join(t.TempDir(), "tree")
+	ts.getTree(t, "/tree", dst, &treeOptions{})
+	for _, name := range []string{"empty", "sub/empty"} {
+		if info, err := os.Stat(filepath.Join(dst, filepath.FromSlash(name))); err != nil || info.Size() != 0 {
+			t.Errorf("downloaded %s = %v, %v; want an empty file", name, info, err)
+		}
+	}
+}
+
+// listingClient is a client whose server answers stat and listings from a
+// fixed table; other calls are not implemented
+type listingClient struct {
+	transport.Client
+	listings map[string][]proto.FileInfo
+}
+
+func (c *listingClient) Stat(path string) (*proto.FileInfo, error) {
+	return &proto.FileInfo{Name: path, IsDir: true}, nil
+}
+
+func (c *listingClient) ListDetails(path string, opts transport.ListOptions) (*proto.Listing, error) {
+	return &proto.Listing{Path: path, Entries: c.listings[path], Total: len(c.listings[path])}, nil
+}
+
+func TestRemoteTreeRejectsInvalidNames(t *testing.T) {
+	for _, name := range []string{"", ".", "..", "../x", "a/b", `..\x`} {
+		for _, isDir := range []bool{false, true} {
+			client := &listingClient{listings: map[string][]proto.FileInfo{
+				"/tree":     {{Name: "d", IsDir: true}},
+				"/tree/d":   {{Name: name, IsDir: isDir}},
+				"/tree/d/x": nil,
+			}}
+			if _, err := remoteTree(client, "/tree", &treeOptions{}); err == nil {
+				t.Errorf("remoteTree() accepted name %q (dir %v)", name, isDir)
+			}
+		}
+	}
+
+	client := &listingClient{listings: map[string][]proto.FileInfo{
+		"/tree":   {{Name: "d", IsDir: true}, {Name: "a..b"}},
+		"/tree/d": {{Name: ".hidden"}},
+	}}
+	entries, err := remoteTree(client, "/tree", &treeOptions{})
+	if err != nil {
+		t.Fatalf("remoteTree() error = %v", err)
+	}
+	if want := []string{"", "d", "d/.hidden", "a..b"}; !slices.Equal(rels(entries), want) {
+		t.Errorf("remoteTree() = %v, want %v", rels(entries), want)
+	}
+}
+
+// listFiles returns the slash-separated paths of the regular files below dir
+func listFiles(t *testing.T, dir string) []string {
+	t.Helper()
+	var names []string
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(dir, p)
+		names = append(names, filepath.ToSlash(rel))
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	slices.Sort(names)
+	return names
+}
+
+func TestTreeSelection(t *testing.T) {
+	ts := newTreeServer(t, nil)
+	src := t.TempDir()
+	writeTree(t, src, map[string]string{
+		"a.txt": "a", "b.log": "b", "sub/c.txt": "c", "sub/d.log": "d", "cache/e.txt": "e",
+	})
+
+	ts.putTree(t, src, "/sel", &treeOptions{exclude: patternList{"*.log", "cache"}})
+	if got, want := listFiles(t, filepath.Join(ts.root, "sel")), []string{"a.txt", "sub/c.txt"}; !slices.Equal(got, want) {
+		t.Errorf("put -r stored %v, want %v", got, want)
+	}
+
+	ts.putTree(t, src, "/all", &treeOptions{})
+	dst := filepath.Join(t.TempDir(), "all")
+	ts.getTree(t, "/all", dst, &treeOptions{include: patternList{"*.log"}, exclude: patternList{"sub"}})
+	if got, want := listFiles(t, dst), []string{"b.log"}; !slices.Equal(got, want) {
+		t.Errorf("get -r fetched %v, want %v", got, want)
+	}
+}
+
+// rewrite replaces the content of a file with content of the same size and
+// keeps its modification time, so only a content comparison notices
+func rewrite(t *testing.T, name, content string) {
+	t.Helper()
+	info, err := os.Stat(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(name, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(name, info.ModTime(), info.ModTime()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTreeSkipsUpToDate(t *testing.T) {
+	ts := newTreeServer(t, nil)
+	src := t.TempDir()
+	writeTree(t, src, map[string]string{"a.txt": "hello", "sub/b.txt": "world"})
+
+	ts.putTree(t, src, "/tree", &treeOptions{})
+	if n := ts.count("POST /upload/create"); n != 2 {
+		t.Errorf("first put -r started %d uploads, want 2", n)
+	}
+	ts.putTree(t, src, "/tree", &treeOptions{})
+	if n := ts.count("POST /upload/create"); n != 0 {
+		t.Errorf("put -r of an unchanged tree started %d uploads, want 0", n)
+	}
+	rewrite(t, filepath.Join(src, "a.txt"), "jello")
+	ts.putTree(t, src, "/tree", &treeOptions{})
+	if n := ts.count("POST /upload/create"); n != 1 {
+		t.Errorf("put -r after a change started %d uploads, want 1", n)
+	}
+
+	dst := filepath.Join(t.TempDir(), "tree")
+	ts.getTree(t, "/tree", dst, &treeOptions{})
+	if n := ts.count("GET /download"); n == 0 {
+		t.Error("first get -r downloaded nothing")
+	}
+	ts.getTree(t, "/tree", dst, &treeOptions{})
+	if n := ts.count("GET /download"); n != 0 {
+		t.Errorf("get -r of an unchanged tree made %d downloads, want 0", n)
+	}
+	rewrite(t, filepath.Join(dst, "sub", "b.txt"), "wormd")
+	ts.getTree(t, "/tree", dst, &treeOptions{})
+	if n := ts.count("GET /download"); n == 0 {
+		t.Error("get -r after a change downloaded nothing")
+	}
+	for name, want := range map[string]string{"a.txt": "jello", "sub/b.txt": "world"} {
+		if got, err := os.ReadFile(filepath.Join(dst, filepath.FromSlash(name))); err != nil || string(got) != want {
+			t.Errorf("%s = %q, %v; want %q", name, got, err, want)
+		}
+	}
+}
+
+func TestTreeRestoresAttributes(t *testing.T) {
+	ts := newTreeServer(t, nil)
+	src := t.TempDir()
+	writeTree(t, src, map[string]string{"private": "secret", "sub/script": "#!/bin/sh"})
+	fileTime := time.Date(2021, 3, 4, 5, 6, 7, 0, time.UTC)
+	dirTime := time.Date(2022, 1, 2, 3, 4, 5, 0, time.UTC)
+	attrs := map[string]struct {
+		mode    fs.FileMode
+		modTime time.Time
+	}{
+		"private":    {0600, fileTime},
+		"sub/script": {0755, fileTime},
+		"sub":        {0750, dirTime},
+	}
+	for name, a := range attrs {
+		p := filepath.Join(src, filepath.FromSlash(name))
+		if err := os.Chmod(p, a.mode); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(p, a.modTime, a.modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ts.putTree(t, src, "/tree", &treeOptions{})
+	for name, a := range attrs {
+		info, err := ts.store.Stat("/tree/" + name)
+		if err != nil {
+			t.Fatalf("Stat(%s) error = %v", name, err)
+		}
+		if info.Mode.Perm() != a.mode || !info.ModTime.Equal(a.modTime) {
+			t.Errorf("put -r stored %s with %v %v, want %v %v", name, info.Mode.Perm(), info.ModTime, a.mode, a.modTime)
+		}
+	}
+
+	dst := filepath.Join(t.TempDir(), "tree")
+	ts.getTree(t, "/tree", dst, &treeOptions{})
+	for name, a := range attrs {
+		info, err := os.Stat(filepath.Join(dst, filepath.FromSlash(name)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Mode().Perm() != a.mode || !info.ModTime().Equal(a.modTime) {
+			t.Errorf("get -r restored %s with %v %v, want %v %v", name, info.Mode().Perm(), info.ModTime(), a.mode, a.modTime)
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package auth
+
+import (
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+)
+
+// CertMapping maps a client certificate identity to a goflux user. A
+// certificate matches when every non-empty field matches it.
+type CertMapping struct {
+	Subject     string   `json:"subject,omitempty"` // full subject DN, e.g. "CN=ci-runner,O=Example"
+	SAN         string   `json:"san,omitempty"`     // DNS, email, IP or URI subject alternative name
+	User        string   `json:"user"`
+	Permissions []string `json:"permissions"`
+}
+
+// CertStoreFile represents the JSON file format
+type CertStoreFile struct {
+	Certs []CertMapping `json:"certs"`
+}
+
+// CertStore holds client certificate mappings with thread-safe access
+type CertStore struct {
+	mu       sync.RWMutex
+	mappings []CertMapping
+	filename string
+}
+
+// NewCertStore creates a new certificate store
+func NewCertStore(filename string) (*CertStore, error) {
+	cs := &CertStore{filename: filename}
+
+	if err := cs.Load(); err != nil {
+		return nil, err
+	}
+
+	return cs, nil
+}
+
+// Load reads certificate mappings from file
+func (cs *CertStore) Load() error {
+	data, err := os.ReadFile(cs.filename)
+	if err != nil {
+		return fmt.Errorf("error reading client certs file: %w", err)
+	}
+
+	var storeFile CertStoreFile
+	if err := json.Unmarshal(data, &storeFile); err != nil {
+		return fmt.Errorf("error parsing client certs file: %w", err)
+	}
+
+	for i, m := range storeFile.Certs {
+		if m.User == "" {
+			return fmt.Errorf("client cert mapping %d has no user", i)
+		}
+		if m.Subject == "" && m.SAN == "" {
+			return fmt.Errorf("client cert mapping for %s needs a subject or san", m.User)
+		}
+	}
+
+	cs.mu.Lock()
+	cs.mappings = storeFile.Certs
+	cs.mu.Unlock()
+
+	return nil
+}
+
+// Validate maps a verified client certificate to its user and permissions
+func (cs *CertStore) Validate(cert *x509.Certificate) (string, []string, error) {
+	cs.mu.RLock()
+	defer cs.mu.RUnlock()
+
+	for _, m := range cs.mappings {
+		if m.Subject != "" && m.Subject != cert.Subject.String() {
+			continue
+		}
+		if m.SAN != "" && !hasSAN(cert, m.SAN) {
+			continue
+		}
+		return m.User, m.Permissions, nil
+	}
+
+	return "", nil, fmt.Errorf("no user mapped to client certificate %s", cert.Subject)
+}
+
+// hasSAN reports whether the certificate lists name as a subject
+// alternative name of any type
+func hasSAN(cert *x509.Certificate, name string) bool {
+	for _, dns := range cert.DNSNames {
+		if dns == name {
+			return true
+		}
+	}
+	for _, email := range cert.EmailAddresses {
+		if email == name {
+			return true
+		}
+	}
+	for _, ip := range cert.IPAddresses {
+		if ip.String() == name {
+			return true
+		}
+	}
+	for _, uri := range cert.URIs {
+		if uri.String() == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,207 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/json"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newCert returns a self-signed client certificate made from template
+func newCert(t *testing.T, template x509.Certificate) *x509.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template.SerialNumber = big.NewInt(1)
+	template.NotBefore = time.Now().Add(-time.Hour)
+	template.NotAfter = time.Now().Add(time.Hour)
+	template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+// writeCertStore writes mappings to a cert store file and loads it
+func writeCertStore(t *testing.T, mappings []CertMapping) (*CertStore, error) {
+	t.Helper()
+	filename := filepath.Join(t.TempDir(), "client-certs.json")
+	data, _ := json.Marshal(CertStoreFile{Certs: mappings})
+	if err := os.WriteFile(filename, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return NewCertStore(filename)
+}
+
+func TestCertStoreValidate(t *testing.T) {
+	cs, err := writeCertStore(t, []CertMapping{
+		{Subject: "CN=ci-runner,O=Example", User: "ci", Permissions: []string{"upload"}},
+		{SAN: "backup.example.com", User: "backup", Permissions: []string{"download", "list"}},
+		{SAN: "ops@example.com", User: "ops", Permissions: []string{"*"}},
+		{SAN: "10.0.0.5", User: "nas"},
+		{SAN: "spiffe://example.com/agent", User: "agent"},
+		{Subject: "CN=both", SAN: "both.example.com", User: "both"},
+	})
+	if err != nil {
+		t.Fatalf("NewCertStore() error = %v", err)
+	}
+	spiffe, _ := url.Parse("spiffe://example.com/agent")
+
+	tests := []struct {
+		name        string
+		cert        x509.Certificate
+		user        string // empty if the certificate is not mapped
+		permissions []string
+	}{
+		{"subject", x509.Certificate{Subject: pkix.Name{CommonName: "ci-runner", Organization: []string{"Example"}}}, "ci", []string{"upload"}},
+		{"other subject", x509.Certificate{Subject: pkix.Name{CommonName: "ci-runner", Organization: []string{"Other"}}}, "", nil},
+		{"subject prefix", x509.Certificate{Subject: pkix.Name{CommonName: "ci-runner"}}, "", nil},
+		{"dns san", x509.Certificate{Subject: pkix.Name{CommonName: "x"}, DNSNames: []string{"www.example.com", "backup.example.com"}}, "backup", []string{"download", "list"}},
+		{"email san", x509.Certificate{EmailAddresses: []string{"ops@example.com"}}, "ops", []string{"*"}},
+		{"ip san", x509.Certificate{IPAddresses: []net.IP{net.ParseIP("10.0.0.5")}}, "nas", nil},
+		{"uri san", x509.Certificate{URIs: []*url.URL{spiffe}}, "agent", nil},
+		{"san in the common name", x509.Certificate{Subject: pkix.Name{CommonName: "backup.example.com"}}, "", nil},
+		{"subject and san", x509.Certificate{Subject: pkix.Name{CommonName: "both"}, DNSNames: []string{"both.example.com"}}, "both", nil},
+		{"subject without its san", x509.Certificate{Subject: pkix.Name{CommonName: "both"}}, "", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			user, permissions, err := cs.Validate(newCert(t, tt.cert))
+			if tt.user == "" {
+				if err == nil {
+					t.Errorf("Validate() = %s, want unmapped", user)
+				}
+				return
+			}
+			if err != nil || user != tt.user || !slices.Equal(permissions, tt.permissions) {
+				t.Errorf("Validate() = %s %v, %v; want %s %v", user, permissions, err, tt.user, tt.permissions)
+			}
+		})
+	}
+}
+
+func TestCertStoreRejectsInvalidMappings(t *testing.T) {
+	tests := []struct {
+		name    string
+		mapping CertMapping
+		wantErr string
+	}{
+		{"no user", CertMapping{Subject: "CN=ci"}, "no user"},
+		{"no identity", CertMapping{User: "ci"}, "needs a subject or san"},
+	}
+	for _, tt := range tests {
+		if _, err := writeCertStore(t, []CertMapping{tt.mapping}); err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("%s: NewCertStore() error = %v, want one mentioning %q", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+func TestMiddlewareCertAndToken(t *testing.T) {
+	// A token store with one token for alice
+	hash := sha256.Sum256([]byte("alice-token"))
+	tokens := filepath.Join(t.TempDir(), "tokens.json")
+	data, _ := json.Marshal(TokenStoreFile{Tokens: []Token{{
+		ID:          "t1",
+		TokenHash:   hex.EncodeToString(hash[:]),
+		User:        "alice",
+		Permissions: []string{"upload", "delete"},
+		ExpiresAt:   time.Now().Add(time.Hour),
+	}}})
+	if err := os.WriteFile(tokens, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	store, err := NewTokenStore(tokens)
+	if err != nil {
+		t.Fatalf("NewTokenStore() error = %v", err)
+	}
+	certs, err := writeCertStore(t, []CertMapping{
+		{Subject: "CN=ci-runner", User: "ci", Permissions: []string{"upload"}},
+	})
+	if err != nil {
+		t.Fatalf("NewCertStore() error = %v", err)
+	}
+
+	mapped := newCert(t, x509.Certificate{Subject: pkix.Name{CommonName: "ci-runner"}})
+	unmapped := newCert(t, x509.Certificate{Subject: pkix.Name{CommonName: "stranger"}})
+
+	both := NewMiddleware(store)
+	both.SetCertStore(certs)
+	certOnly := NewMiddleware(nil)
+	certOnly.SetCertStore(certs)
+
+	tests := []struct {
+		name       string
+		middleware *Middleware
+		cert       *x509.Certificate
+		token      string
+		permission string
+		status     int
+		user       string
+	}{
+		{"mapped cert", both, mapped, "", "upload", http.StatusOK, "ci"},
+		{"mapped cert wins over token", both, mapped, "alice-token", "upload", http.StatusOK, "ci"},
+		{"mapped cert lacks permission", both, mapped, "alice-token", "delete", http.StatusForbidden, ""},
+		{"unmapped cert falls back to token", both, unmapped, "alice-token", "delete", http.StatusOK, "alice"},
+		{"unmapped cert without token", both, unmapped, "", "upload", http.StatusUnauthorized, ""},
+		{"token only", both, nil, "alice-token", "upload", http.StatusOK, "alice"},
+		{"token lacks permission", both, nil, "alice-token", "move", http.StatusForbidden, ""},
+		{"invalid token", both, nil, "mallory-token", "upload", http.StatusUnauthorized, ""},
+		{"cert auth disabled", NewMiddleware(store), mapped, "", "upload", http.StatusUnauthorized, ""},
+		{"cert only", certOnly, mapped, "", "upload", http.StatusOK, "ci"},
+		{"cert only without cert", certOnly, nil, "alice-token", "upload", http.StatusUnauthorized, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var user string
+			handler := tt.middleware.RequireAuth(tt.permission, func(w http.ResponseWriter, r *http.Request) {
+				user = r.Header.Get("X-Authenticated-User")
+			})
+
+			r := httptest.NewRequest("GET", "/list", nil)
+			if tt.cert != nil {
+				r.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{tt.cert}}}
+			}
+			if tt.token != "" {
+				r.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			w := httptest.NewRecorder()
+			handler(w, r)
+
+			if w.Code != tt.status || user != tt.user {
+				t.Errorf("RequireAuth() = %d as %q, want %d as %q", w.Code, user, tt.status, tt.user)
+			}
+		})
+	}
+
+	// A certificate the TLS layer didn't verify is ignored
+	r := httptest.NewRequest("GET", "/list", nil)
+	r.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{mapped}}
+	w := httptest.NewRecorder()
+	both.RequireAuth("upload", func(http.ResponseWriter, *http.Request) {})(w, r)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("RequireAuth() with an unverified certificate = %d, want 401", w.Code)
+	}
+}
@@ -4,48 +4,59 @@ import (
 	"fmt"
 	"net/http"
 	"strings"
+
+	"github.com/0xRepo-Source/goflux/pkg/proto"
 )
 
 // Middleware provides authentication middleware for HTTP handlers
 type Middleware struct {
-	store *TokenStore
+	store *TokenStore // nil if token auth disabled
+	certs *CertStore  // nil if client certificate auth disabled
 }
 
-// NewMiddleware creates a new auth middleware
+// NewMiddleware creates a new auth middleware. store may be nil when only
+// client certificates are accepted.
 func NewMiddleware(store *TokenStore) *Middleware {
 	return &Middleware{store: store}
 }
 
-// RequireAuth wraps a handler to require authentication
-func (m *Middleware) RequireAuth(requiredPermission string, next http.HandlerFunc) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		// Extract token from Authorization header
-		authHeader := r.Header.Get("Authorization")
-		if authHeader == "" {
-			http.Error(w, "Authorization header required", http.StatusUnauthorized)
-			return
-		}
-
-		// Expected format: "Bearer <token>"
-		parts := strings.SplitN(authHeader, " ", 2)
-		if len(parts) != 2 || parts[0] != "Bearer" {
-			http.Error(w, "Invalid authorization header format. Use: Bearer <token>", http.StatusUnauthorized)
-			return
-		}
+// SetCertStore enables authentication with verified TLS client
+// certificates, mapped to users through certs
+func (m *Middleware) SetCertStore(certs *CertStore) {
+	m.certs = certs
+}
 
-		token := parts[1]
+// RequireAuth wraps a handler to require authentication. A verified client
+// certificate that maps to a user is accepted; otherwise a bearer token is
+// required.
+func (m *Middleware) RequireAuth(requiredPermission string, next http.HandlerFunc) http.HandlerFunc {
+	if requiredPermission == "" {
+		return m.RequireAll(nil, next)
+	}
+	return m.RequireAll([]string{requiredPermission}, next)
+}
 
-		// Validate token
-		user, permissions, err := m.store.Validate(token)
-		if err != nil {
-			http.Error(w, fmt.Sprintf("Authentication failed: %v", err), http.StatusUnauthorized)
-			return
+// RequireAll is RequireAuth for handlers that need every one of several
+// permissions.
+func (m *Middleware) RequireAll(requiredPermissions []string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, permissions, ok := m.authenticateCert(r)
+		if !ok {
+			var status int
+			var err error
+			user, permissions, status, err = m.authenticateToken(r)
+			if err != nil {
+				proto.WriteError(w, status, proto.CodeForStatus(status), err.Error())
+				return
+			}
 		}
 
-		// Check permission
-		if requiredPermission != "" && !HasPermission(permissions, requiredPermission) {
-			http.Error(w, fmt.Sprintf("Permission denied. Required: %s", requiredPermission), http.StatusForbidden)
-			return
+		// Check permissions
+		for _, required := range requiredPermissions {
+			if !HasPermission(permissions, required) {
+				proto.WriteError(w, http.StatusForbidden, proto.CodeForbidden, fmt.Sprintf("Permission denied. Required: %s", required))
+				return
+			}
 		}
 
 		// Set user in request context (optional, for logging)
@@ -56,11 +67,58 @@ func (m *Middleware) RequireAuth(requiredPermission string, next http.HandlerFun
 	}
 }
 
+// authenticateCert maps the request's verified client certificate, if any,
+// to a user
+func (m *Middleware) authenticateCert(r *http.Request) (string, []string, bool) {
+	if m.certs == nil || r.TLS == nil || len(r.TLS.VerifiedChains) == 0 {
+		return "", nil, false
+	}
+
+	user, permissions, err := m.certs.Validate(r.TLS.VerifiedChains[0][0])
+	if err != nil {
+		return "", nil, false
+	}
+	return user, permissions, true
+}
+
+// authenticateToken validates the request's bearer token. On failure it
+// returns the HTTP status to answer with.
+func (m *Middleware) authenticateToken(r *http.Request) (string, []string, int, error) {
+	if m.store == nil {
+		return "", nil, http.StatusUnauthorized, fmt.Errorf("Client certificate required")
+	}
+
+	// Extract token from Authorization header
+	authHeader := r.Header.Get("Authorization")
+	if authHeader == "" {
+		return "", nil, http.StatusUnauthorized, fmt.Errorf("Authorization header required")
+	}
+
+	// Expected format: "Bearer <token>"
+	parts := strings.SplitN(authHeader, " ", 2)
+	if len(parts) != 2 || parts[0] != "Bearer" {
+		return "", nil, http.StatusUnauthorized, fmt.Errorf("Invalid authorization header format. Use: Bearer <token>")
+	}
+
+	// Validate token
+	user, permissions, err := m.store.Validate(parts[1])
+	if err != nil {
+		return "", nil, http.StatusUnauthorized, fmt.Errorf("Authentication failed: %v", err)
+	}
+	return user, permissions, http.StatusOK, nil
+}
+
 // OptionalAuth wraps a handler to optionally accept authentication
 func (m *Middleware) OptionalAuth(next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		if user, _, ok := m.authenticateCert(r); ok {
+			r.Header.Set("X-Authenticated-User", user)
+			next(w, r)
+			return
+		}
+
 		authHeader := r.Header.Get("Authorization")
-		if authHeader != "" {
+		if authHeader != "" && m.store != nil {
 			parts := strings.SplitN(authHeader, " ", 2)
 			if len(parts) == 2 && parts[0] == "Bearer" {
 				user, _, err := m.store.Validate(parts[1])
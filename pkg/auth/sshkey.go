@@ -0,0 +1,135 @@
+package auth
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// KeyMapping maps SSH public keys to a goflux user. The keys are given
+// inline, in authorized_keys format, or as the path of an existing
+// authorized_keys file; every key found authenticates as User.
+type KeyMapping struct {
+	Key            string   `json:"key,omitempty"`             // one authorized_keys line, e.g. "ssh-ed25519 AAAA... ops@host"
+	AuthorizedKeys string   `json:"authorized_keys,omitempty"` // path of an authorized_keys file
+	User           string   `json:"user"`
+	Permissions    []string `json:"permissions"`
+}
+
+// KeyStoreFile represents the JSON file format
+type KeyStoreFile struct {
+	Keys []KeyMapping `json:"keys"`
+}
+
+// keyUser is the user and permissions a key authenticates as
+type keyUser struct {
+	user        string
+	permissions []string
+}
+
+// KeyStore holds SSH public key mappings with thread-safe access
+type KeyStore struct {
+	mu       sync.RWMutex
+	keys     map[string]keyUser // keyed by the key's wire encoding
+	filename string
+}
+
+// NewKeyStore creates a new SSH key store
+func NewKeyStore(filename string) (*KeyStore, error) {
+	ks := &KeyStore{filename: filename}
+
+	if err := ks.Load(); err != nil {
+		return nil, err
+	}
+
+	return ks, nil
+}
+
+// Load reads key mappings from file, along with the authorized_keys files
+// they refer to
+func (ks *KeyStore) Load() error {
+	data, err := os.ReadFile(ks.filename)
+	if err != nil {
+		return fmt.Errorf("error reading ssh keys file: %w", err)
+	}
+
+	var storeFile KeyStoreFile
+	if err := json.Unmarshal(data, &storeFile); err != nil {
+		return fmt.Errorf("error parsing ssh keys file: %w", err)
+	}
+
+	keys := make(map[string]keyUser)
+	for i, m := range storeFile.Keys {
+		if m.User == "" {
+			return fmt.Errorf("ssh key mapping %d has no user", i)
+		}
+
+		var authorized []byte
+		switch {
+		case m.Key != "" && m.AuthorizedKeys != "":
+			return fmt.Errorf("ssh key mapping for %s sets both key and authorized_keys", m.User)
+		case m.Key != "":
+			authorized = []byte(m.Key)
+		case m.AuthorizedKeys != "":
+			if authorized, err = os.ReadFile(m.AuthorizedKeys); err != nil {
+				return fmt.Errorf("ssh key mapping for %s: %w", m.User, err)
+			}
+		default:
+			return fmt.Errorf("ssh key mapping for %s needs a key or authorized_keys", m.User)
+		}
+
+		parsed, err := parseAuthorizedKeys(authorized)
+		if err != nil {
+			return fmt.Errorf("ssh key mapping for %s: %w", m.User, err)
+		}
+		if len(parsed) == 0 {
+			return fmt.Errorf("ssh key mapping for %s has no keys", m.User)
+		}
+		for _, key := range parsed {
+			if prev, ok := keys[string(key.Marshal())]; ok && prev.user != m.User {
+				return fmt.Errorf("ssh key %s is mapped to both %s and %s", ssh.FingerprintSHA256(key), prev.user, m.User)
+			}
+			keys[string(key.Marshal())] = keyUser{user: m.User, permissions: m.Permissions}
+		}
+	}
+
+	ks.mu.Lock()
+	ks.keys = keys
+	ks.mu.Unlock()
+
+	return nil
+}
+
+// Validate maps an SSH public key to its user and permissions
+func (ks *KeyStore) Validate(key ssh.PublicKey) (string, []string, error) {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+
+	u, ok := ks.keys[string(key.Marshal())]
+	if !ok {
+		return "", nil, fmt.Errorf("no user mapped to ssh key %s", ssh.FingerprintSHA256(key))
+	}
+	return u.user, u.permissions, nil
+}
+
+// parseAuthorizedKeys parses every key in authorized_keys format, skipping
+// blank lines and comments. Options such as from= are not enforced.
+func parseAuthorizedKeys(data []byte) ([]ssh.PublicKey, error) {
+	var keys []ssh.PublicKey
+	for i, line := range bytes.Split(data, []byte("\n")) {
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 || line[0] == '#' {
+			continue
+		}
+		key, _, _, _, err := ssh.ParseAuthorizedKey(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", i+1, err)
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
@@ -0,0 +1,172 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func newSSHKey(t *testing.T) ssh.PublicKey {
+	t.Helper()
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := ssh.NewPublicKey(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+// authorizedKey returns key as an authorized_keys line without a newline
+func authorizedKey(key ssh.PublicKey) string {
+	return strings.TrimSpace(string(ssh.MarshalAuthorizedKey(key)))
+}
+
+// writeKeyStore writes mappings to a key store file and loads it
+func writeKeyStore(t *testing.T, mappings []KeyMapping) (*KeyStore, error) {
+	t.Helper()
+	filename := filepath.Join(t.TempDir(), "ssh-keys.json")
+	data, _ := json.Marshal(KeyStoreFile{Keys: mappings})
+	if err := os.WriteFile(filename, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return NewKeyStore(filename)
+}
+
+func TestKeyStoreMapsKeys(t *testing.T) {
+	inline, fromFile, other, unmapped := newSSHKey(t), newSSHKey(t), newSSHKey(t), newSSHKey(t)
+
+	// authorized_keys with comments, blank lines, options and trailing
+	// comments on the keys
+	authorizedKeys := filepath.Join(t.TempDir(), "authorized_keys")
+	content := "# deploy keys\n\n" +
+		authorizedKey(fromFile) + " ci@build\n" +
+		"   # indented comment\n" +
+		`from="10.0.0.0/8" ` + authorizedKey(other) + "\n"
+	if err := os.WriteFile(authorizedKeys, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	ks, err := writeKeyStore(t, []KeyMapping{
+		{Key: authorizedKey(inline) + " ops@laptop", User: "ops", Permissions: []string{"upload", "download"}},
+		{AuthorizedKeys: authorizedKeys, User: "ci", Permissions: []string{"upload"}},
+	})
+	if err != nil {
+		t.Fatalf("NewKeyStore() error = %v", err)
+	}
+
+	tests := []struct {
+		name        string
+		key         ssh.PublicKey
+		user        string
+		permissions []string
+	}{
+		{"inline", inline, "ops", []string{"upload", "download"}},
+		{"authorized_keys", fromFile, "ci", []string{"upload"}},
+		{"authorized_keys with options", other, "ci", []string{"upload"}},
+	}
+	for _, tt := range tests {
+		user, permissions, err := ks.Validate(tt.key)
+		if err != nil || user != tt.user || !slices.Equal(permissions, tt.permissions) {
+			t.Errorf("Validate(%s) = %s %v, %v; want %s %v", tt.name, user, permissions, err, tt.user, tt.permissions)
+		}
+	}
+	if user, _, err := ks.Validate(unmapped); err == nil {
+		t.Errorf("Validate() of an unmapped key = %s", user)
+	}
+}
+
+func TestKeyStoreRejectsInvalidMappings(t *testing.T) {
+	key := newSSHKey(t)
+	dir := t.TempDir()
+	write := func(name, content string) string {
+		p := filepath.Join(dir, name)
+		if err := os.WriteFile(p, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+		return p
+	}
+	malformed := write("malformed", authorizedKey(key)+"\nssh-ed25519 not-base64\n")
+	comments := write("comments", "# nothing but comments\n\n")
+	same := write("same", authorizedKey(key)+"\n")
+
+	tests := []struct {
+		name     string
+		mappings []KeyMapping
+		wantErr  string
+	}{
+		{"malformed line", []KeyMapping{{AuthorizedKeys: malformed, User: "ops"}}, "line 2"},
+		{"malformed inline key", []KeyMapping{{Key: "ssh-ed25519", User: "ops"}}, "line 1"},
+		{"only comments", []KeyMapping{{AuthorizedKeys: comments, User: "ops"}}, "no keys"},
+		{"missing file", []KeyMapping{{AuthorizedKeys: filepath.Join(dir, "missing"), User: "ops"}}, "ops"},
+		{"no user", []KeyMapping{{Key: authorizedKey(key)}}, "no user"},
+		{"no key", []KeyMapping{{User: "ops"}}, "needs a key"},
+		{"key and file", []KeyMapping{{Key: authorizedKey(key), AuthorizedKeys: same, User: "ops"}}, "both"},
+		{"key of two users", []KeyMapping{
+			{Key: authorizedKey(key), User: "ops"},
+			{AuthorizedKeys: same, User: "ci"},
+		}, "mapped to both ops and ci"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := writeKeyStore(t, tt.mappings)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("NewKeyStore() error = %v, want one mentioning %q", err, tt.wantErr)
+			}
+		})
+	}
+
+	// Listing a key twice for the same user is harmless
+	ks, err := writeKeyStore(t, []KeyMapping{
+		{Key: authorizedKey(key), User: "ops", Permissions: []string{"list"}},
+		{AuthorizedKeys: same, User: "ops", Permissions: []string{"list"}},
+	})
+	if err != nil {
+		t.Fatalf("NewKeyStore() with a repeated key error = %v", err)
+	}
+	if user, _, err := ks.Validate(key); err != nil || user != "ops" {
+		t.Errorf("Validate() = %s, %v; want ops", user, err)
+	}
+}
+
+func TestKeyStoreReload(t *testing.T) {
+	first, second := newSSHKey(t), newSSHKey(t)
+	ks, err := writeKeyStore(t, []KeyMapping{{Key: authorizedKey(first), User: "ops"}})
+	if err != nil {
+		t.Fatalf("NewKeyStore() error = %v", err)
+	}
+
+	data, _ := json.Marshal(KeyStoreFile{Keys: []KeyMapping{{Key: authorizedKey(second), User: "ops"}}})
+	if err := os.WriteFile(ks.filename, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ks.Load(); err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if _, _, err := ks.Validate(first); err == nil {
+		t.Error("removed key still validates after Load()")
+	}
+	if _, _, err := ks.Validate(second); err != nil {
+		t.Errorf("added key doesn't validate after Load(): %v", err)
+	}
+
+	// A broken file keeps the keys loaded before
+	if err := os.WriteFile(ks.filename, []byte("{"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ks.Load(); err == nil {
+		t.Error("Load() accepted a broken file")
+	}
+	if _, _, err := ks.Validate(second); err != nil {
+		t.Errorf("failed Load() dropped the loaded keys: %v", err)
+	}
+}
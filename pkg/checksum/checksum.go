@@ -0,0 +1,135 @@
+// Package checksum computes and verifies chunk checksums. A checksum is
+// written as "<algorithm>:<hex digest>", so both ends of a transfer know
+// which algorithm produced it. A bare 64-character hex digest is a SHA-256
+// checksum from a client that predates algorithm tags.
+package checksum
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"strings"
+
+	"github.com/zeebo/blake3"
+	"github.com/zeebo/xxh3"
+)
+
+// Algorithm names a checksum algorithm.
+type Algorithm string
+
+const (
+	SHA256 Algorithm = "sha256" // cryptographic; the default
+	BLAKE3 Algorithm = "blake3" // cryptographic and faster than SHA-256
+	XXH3   Algorithm = "xxh3"   // 64-bit non-cryptographic, for trusted networks
+	CRC32C Algorithm = "crc32c" // 32-bit non-cryptographic, for trusted networks
+)
+
+// Algorithms lists every supported algorithm.
+var Algorithms = []Algorithm{SHA256, BLAKE3, XXH3, CRC32C}
+
+// ErrUnknownAlgorithm is returned for checksums tagged with an algorithm
+// this version does not implement.
+var ErrUnknownAlgorithm = errors.New("unknown checksum algorithm")
+
+// ErrMismatch is returned when data does not match its checksum.
+var ErrMismatch = errors.New("checksum mismatch")
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// Parse returns the algorithm with the given name. An empty name means
+// SHA-256.
+func Parse(name string) (Algorithm, error) {
+	if name == "" {
+		return SHA256, nil
+	}
+	alg := Algorithm(strings.ToLower(name))
+	for _, a := range Algorithms {
+		if a == alg {
+			return a, nil
+		}
+	}
+	return "", fmt.Errorf("%w %q", ErrUnknownAlgorithm, name)
+}
+
+// Cryptographic reports whether finding two inputs with the same checksum
+// is infeasible. Only cryptographic checksums are safe to trust across
+// users, for example to deduplicate stored data.
+func (a Algorithm) Cryptographic() bool {
+	return a == SHA256 || a == BLAKE3
+}
+
+// New returns a hash computing the algorithm's digest.
+func (a Algorithm) New() hash.Hash {
+	switch a {
+	case BLAKE3:
+		return blake3.New()
+	case XXH3:
+		return xxh3.New()
+	case CRC32C:
+		return crc32.New(castagnoli)
+	default:
+		return sha256.New()
+	}
+}
+
+// Sum returns the tagged checksum of data.
+func (a Algorithm) Sum(data []byte) string {
+	h := a.New()
+	h.Write(data)
+	return a.Format(h.Sum(nil))
+}
+
+// Format tags a digest produced by the algorithm's hash.
+func (a Algorithm) Format(digest []byte) string {
+	return string(a) + ":" + hex.EncodeToString(digest)
+}
+
+// Split returns the algorithm and lowercase hex digest of a checksum.
+func Split(sum string) (Algorithm, string, error) {
+	name, digest, tagged := strings.Cut(sum, ":")
+	if !tagged {
+		if len(sum) != 2*sha256.Size || !isHex(sum) {
+			return "", "", fmt.Errorf("malformed checksum %q: no algorithm tag", sum)
+		}
+		return SHA256, strings.ToLower(sum), nil
+	}
+
+	alg, err := Parse(name)
+	if err != nil || name == "" {
+		return "", "", fmt.Errorf("%w %q", ErrUnknownAlgorithm, name)
+	}
+	if len(digest) != 2*alg.New().Size() || !isHex(digest) {
+		return "", "", fmt.Errorf("malformed %s checksum %q", alg, digest)
+	}
+	return alg, strings.ToLower(digest), nil
+}
+
+// Normalize returns the tagged, lowercase form of a checksum, so checksums
+// can be compared as strings.
+func Normalize(sum string) (string, error) {
+	alg, digest, err := Split(sum)
+	if err != nil {
+		return "", err
+	}
+	return string(alg) + ":" + digest, nil
+}
+
+// Verify checks data against a checksum.
+func Verify(data []byte, sum string) error {
+	alg, digest, err := Split(sum)
+	if err != nil {
+		return err
+	}
+	if got := alg.Sum(data); got != string(alg)+":"+digest {
+		return fmt.Errorf("%w: got %s, want %s", ErrMismatch, got, sum)
+	}
+	return nil
+}
+
+func isHex(s string) bool {
+	_, err := hex.DecodeString(s)
+	return err == nil
+}
@@ -0,0 +1,57 @@
+package checksum
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestSumVerify(t *testing.T) {
+	data := []byte("goflux checksum test")
+	for _, alg := range Algorithms {
+		sum := alg.Sum(data)
+		if !strings.HasPrefix(sum, string(alg)+":") {
+			t.Errorf("%s.Sum() = %q, want %s: prefix", alg, sum, alg)
+		}
+		if err := Verify(data, sum); err != nil {
+			t.Errorf("Verify(%s) error = %v", alg, err)
+		}
+		if err := Verify([]byte("tampered"), sum); !errors.Is(err, ErrMismatch) {
+			t.Errorf("Verify(%s) on other data error = %v, want ErrMismatch", alg, err)
+		}
+	}
+}
+
+func TestSplit(t *testing.T) {
+	legacy := strings.TrimPrefix(SHA256.Sum([]byte("x")), "sha256:")
+
+	tests := []struct {
+		sum     string
+		alg     Algorithm
+		wantErr error
+	}{
+		{legacy, SHA256, nil},
+		{strings.ToUpper(legacy), SHA256, nil},
+		{"xxh3:0123456789abcdef", XXH3, nil},
+		{"crc32c:0a1b2c3d", CRC32C, nil},
+		{"md5:d41d8cd98f00b204e9800998ecf8427e", "", ErrUnknownAlgorithm},
+		{":" + legacy, "", ErrUnknownAlgorithm},
+		{"crc32c:0a1b", "", nil},
+		{"00000000000000000000000000000abc", "", nil},
+		{"", "", nil},
+	}
+	for _, tt := range tests {
+		alg, _, err := Split(tt.sum)
+		if tt.alg != "" {
+			if err != nil || alg != tt.alg {
+				t.Errorf("Split(%q) = %s, %v, want %s", tt.sum, alg, err, tt.alg)
+			}
+			continue
+		}
+		if err == nil {
+			t.Errorf("Split(%q) succeeded, want error", tt.sum)
+		} else if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+			t.Errorf("Split(%q) error = %v, want %v", tt.sum, err, tt.wantErr)
+		}
+	}
+}
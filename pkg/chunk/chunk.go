@@ -1,12 +1,24 @@
 package chunk
 
 import (
-	"crypto/sha256"
-	"encoding/hex"
+	"bytes"
 	"fmt"
+
+	"github.com/0xRepo-Source/goflux/pkg/checksum"
 )
 
-// Chunker is responsible for splitting data into resume-able chunks.
+// Splitter decides where chunks end.
+type Splitter interface {
+	// Cut returns the length of the chunk starting at data[0]. data must
+	// hold at least MaxSize bytes, or everything left of the input.
+	Cut(data []byte) int
+
+	// MaxSize is the largest chunk Cut returns.
+	MaxSize() int
+}
+
+// Chunker is responsible for splitting data into resume-able chunks of a
+// fixed size.
 type Chunker struct {
 	Size int
 }
@@ -14,8 +26,10 @@ type Chunker struct {
 // Chunk represents a single chunk of data.
 type Chunk struct {
 	ID       int
+	Offset   int64 // position of the chunk in the original data
+	Size     int   // chunk length in bytes
 	Data     []byte
-	Checksum string
+	Checksum string // tagged, see package checksum
 }
 
 func New(size int) *Chunker {
@@ -25,66 +39,43 @@ func New(size int) *Chunker {
 	return &Chunker{Size: size}
 }
 
-// Split splits data into chunks.
-func (c *Chunker) Split(data []byte) []Chunk {
-	var chunks []Chunk
-	totalSize := len(data)
+// Cut implements Splitter.
+func (c *Chunker) Cut(data []byte) int {
+	return min(c.Size, len(data))
+}
 
-	for i := 0; i < totalSize; i += c.Size {
-		end := i + c.Size
-		if end > totalSize {
-			end = totalSize
-		}
+// MaxSize implements Splitter.
+func (c *Chunker) MaxSize() int {
+	return c.Size
+}
 
-		chunkData := data[i:end]
-		hash := sha256.Sum256(chunkData)
+// Split splits data into chunks.
+func (c *Chunker) Split(data []byte) []Chunk {
+	return Split(c, data)
+}
 
-		chunks = append(chunks, Chunk{
-			ID:       len(chunks),
-			Data:     chunkData,
-			Checksum: hex.EncodeToString(hash[:]),
-		})
+// Split splits data into chunks at the boundaries chosen by s, with SHA-256
+// checksums. The chunks' Data slices alias data.
+func Split(s Splitter, data []byte) []Chunk {
+	chunks, _ := Scan(bytes.NewReader(data), s, checksum.SHA256)
+	for i := range chunks {
+		c := &chunks[i]
+		c.Data = data[c.Offset : c.Offset+int64(c.Size)]
 	}
-
 	return chunks
 }
 
-// Reassemble combines chunks back into original data.
+// Reassemble combines chunks back into original data, verifying each
+// chunk against its checksum.
 func (c *Chunker) Reassemble(chunks []Chunk) ([]byte, error) {
 	var result []byte
 	for i, chunk := range chunks {
 		if chunk.ID != i {
 			return nil, fmt.Errorf("chunk %d missing or out of order", i)
 		}
-
-		// Verify checksum
-		hash := sha256.Sum256(chunk.Data)
-		expectedChecksum := hex.EncodeToString(hash[:])
-
-		if len(chunk.Checksum) == 64 && chunk.Checksum != expectedChecksum {
-			// Check if this looks like a fallback hash (starts with lots of zeros)
-			// Fallback hashes are padded, so they'll have many leading zeros
-			isFallbackHash := true
-			nonZeroCount := 0
-			for j := 0; j < len(chunk.Checksum); j++ {
-				if chunk.Checksum[j] != '0' {
-					nonZeroCount++
-				}
-			}
-			// Real SHA-256 hashes typically have good distribution
-			// Fallback hashes will have mostly zeros (padded)
-			if nonZeroCount > 16 { // More than 16 non-zero chars suggests real hash
-				isFallbackHash = false
-			}
-
-			if !isFallbackHash {
-				// This looks like a real SHA-256, so enforce validation
-				return nil, fmt.Errorf("chunk %d checksum mismatch", i)
-			}
-			// If it looks like a fallback hash, allow it with a warning
-			fmt.Printf("Warning: chunk %d using fallback checksum (non-HTTPS upload)\n", i)
+		if err := checksum.Verify(chunk.Data, chunk.Checksum); err != nil {
+			return nil, fmt.Errorf("chunk %d: %w", i, err)
 		}
-
 		result = append(result, chunk.Data...)
 	}
 	return result, nil
@@ -2,7 +2,11 @@ package chunk
 
 import (
 	"bytes"
+	"errors"
+	"math/rand"
 	"testing"
+
+	"github.com/0xRepo-Source/goflux/pkg/checksum"
 )
 
 func TestChunkerNew(t *testing.T) {
@@ -85,10 +89,28 @@ func TestChunkerReassembleChecksumMismatch(t *testing.T) {
 		chunks[0].Checksum = validChecksum // Keep old checksum (now invalid)
 	}
 
-	// Should fail reassembly because this is a real SHA-256 mismatch
+	// Should fail reassembly because the data no longer matches
 	_, err := chunker.Reassemble(chunks)
-	if err == nil {
-		t.Error("Reassemble() expected error for corrupted chunk with real SHA-256 mismatch, got nil")
+	if !errors.Is(err, checksum.ErrMismatch) {
+		t.Errorf("Reassemble() error = %v, want checksum mismatch", err)
+	}
+}
+
+func TestChunkerReassembleRejectsUnverifiableChecksums(t *testing.T) {
+	chunker := New(10)
+	data := []byte("Test data for checksum verification")
+
+	// Neither a mostly-zero digest nor an unknown algorithm may slip through
+	for _, sum := range []string{
+		"00000000000000000000000000000000000000000000000000000000deadbeef",
+		"md5:d41d8cd98f00b204e9800998ecf8427e",
+		"",
+	} {
+		chunks := chunker.Split(data)
+		chunks[0].Checksum = sum
+		if _, err := chunker.Reassemble(chunks); err == nil {
+			t.Errorf("Reassemble() with checksum %q succeeded, want error", sum)
+		}
 	}
 }
 
@@ -132,3 +154,67 @@ func TestChunkerLargeData(t *testing.T) {
 		t.Error("Reassemble() produced different data")
 	}
 }
+
+func TestFastCDCBounds(t *testing.T) {
+	cdc, err := NewFastCDC(2048, 8192, 32768)
+	if err != nil {
+		t.Fatalf("NewFastCDC() error = %v", err)
+	}
+
+	data := make([]byte, 1<<20)
+	rand.New(rand.NewSource(1)).Read(data)
+
+	chunks := cdc.Split(data)
+	var offset int64
+	for i, c := range chunks {
+		if c.Offset != offset {
+			t.Fatalf("chunk %d offset = %d, want %d", i, c.Offset, offset)
+		}
+		if len(c.Data) > cdc.Max || (len(c.Data) < cdc.Min && i != len(chunks)-1) {
+			t.Errorf("chunk %d has %d bytes, want %d..%d", i, len(c.Data), cdc.Min, cdc.Max)
+		}
+		offset += int64(len(c.Data))
+	}
+	if offset != int64(len(data)) {
+		t.Errorf("chunks cover %d bytes, want %d", offset, len(data))
+	}
+
+	avg := len(data) / len(chunks)
+	if avg < cdc.Avg/2 || avg > cdc.Avg*2 {
+		t.Errorf("average chunk size = %d, want about %d", avg, cdc.Avg)
+	}
+}
+
+func TestFastCDCInsertionKeepsChunks(t *testing.T) {
+	cdc, err := NewFastCDC(2048, 8192, 32768)
+	if err != nil {
+		t.Fatalf("NewFastCDC() error = %v", err)
+	}
+
+	data := make([]byte, 1<<20)
+	rand.New(rand.NewSource(2)).Read(data)
+	shifted := append([]byte{0x42}, data...)
+
+	before := make(map[string]bool)
+	for _, c := range cdc.Split(data) {
+		before[c.Checksum] = true
+	}
+	after := cdc.Split(shifted)
+	shared := 0
+	for _, c := range after {
+		if before[c.Checksum] {
+			shared++
+		}
+	}
+
+	// Only the chunk containing the inserted byte should change
+	if shared < len(after)-2 {
+		t.Errorf("%d of %d chunks unchanged after a 1-byte insert, want all but one", shared, len(after))
+	}
+}
+
+func TestNewFastCDCInvalid(t *testing.T) {
+	if _, err := NewFastCDC(8192, 4096, 16384); err == nil {
+		t.Error("NewFastCDC(min > avg) expected error, got nil")
+	}
+}
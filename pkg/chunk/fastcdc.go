@@ -0,0 +1,109 @@
+package chunk
+
+import (
+	"fmt"
+	"math/bits"
+)
+
+// FastCDC is a content-defined Splitter. It places chunk boundaries where a
+// rolling hash of the data matches a pattern, so inserting or removing bytes
+// only changes the chunks around the edit and the rest keep their hashes.
+// Chunks are between Min and Max bytes and average about Avg.
+//
+// Boundaries depend only on the data and the three sizes: files chunked
+// with the same sizes, by any version of goflux, share their unchanged
+// chunks.
+type FastCDC struct {
+	Min int
+	Avg int
+	Max int
+
+	maskS uint64 // stricter mask used before Avg
+	maskL uint64 // looser mask used after Avg
+}
+
+// NewFastCDC creates a content-defined chunker. The sizes must satisfy
+// 0 < min <= avg <= max.
+func NewFastCDC(min, avg, max int) (*FastCDC, error) {
+	if min <= 0 || min > avg || avg > max {
+		return nil, fmt.Errorf("invalid chunk sizes: need 0 < min (%d) <= avg (%d) <= max (%d)", min, avg, max)
+	}
+
+	// Normalized chunking: cutting is harder before the average size and
+	// easier after it, which keeps chunk sizes close to the average
+	b := bits.Len(uint(avg)) - 1
+	return &FastCDC{
+		Min:   min,
+		Avg:   avg,
+		Max:   max,
+		maskS: topBits(b + 1),
+		maskL: topBits(b - 1),
+	}, nil
+}
+
+// Cut implements Splitter.
+func (f *FastCDC) Cut(data []byte) int {
+	n := len(data)
+	if n <= f.Min {
+		return n
+	}
+	if n > f.Max {
+		n = f.Max
+	}
+	normal := min(f.Avg, n)
+
+	// The hash shifts left once per byte, so its top bits depend on the
+	// last 64 bytes; the masks test those bits
+	var fp uint64
+	i := f.Min
+	for ; i < normal; i++ {
+		fp = fp<<1 + gear[data[i]]
+		if fp&f.maskS == 0 {
+			return i + 1
+		}
+	}
+	for ; i < n; i++ {
+		fp = fp<<1 + gear[data[i]]
+		if fp&f.maskL == 0 {
+			return i + 1
+		}
+	}
+	return n
+}
+
+// MaxSize implements Splitter.
+func (f *FastCDC) MaxSize() int {
+	return f.Max
+}
+
+// Split splits data into content-defined chunks.
+func (f *FastCDC) Split(data []byte) []Chunk {
+	return Split(f, data)
+}
+
+// topBits returns a mask of the n most significant bits.
+func topBits(n int) uint64 {
+	if n <= 0 {
+		return 0
+	}
+	if n >= 64 {
+		return ^uint64(0)
+	}
+	return ^uint64(0) << (64 - n)
+}
+
+// gear maps each byte to a random 64-bit value. It is generated from a
+// fixed seed and must never change, or chunk boundaries would move.
+var gear = func() [256]uint64 {
+	var table [256]uint64
+	state := uint64(0x676f666c7578) // "goflux"
+	for i := range table {
+		// splitmix64
+		state += 0x9e3779b97f4a7c15
+		z := state
+		z = (z ^ z>>30) * 0xbf58476d1ce4e5b9
+		z = (z ^ z>>27) * 0x94d049bb133111eb
+		table[i] = z ^ z>>31
+	}
+	return table
+}()
@@ -0,0 +1,129 @@
+package chunk
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/0xRepo-Source/goflux/pkg/checksum"
+)
+
+// Reader splits a stream into chunks without holding more than two
+// maximum-size chunks of it in memory.
+type Reader struct {
+	r      io.Reader
+	s      Splitter
+	alg    checksum.Algorithm
+	buf    []byte
+	start  int // first unconsumed byte in buf
+	end    int // end of buffered data in buf
+	eof    bool
+	id     int
+	offset int64
+}
+
+// NewReader returns a Reader that splits r at the boundaries chosen by s
+// and checksums chunks with alg.
+func NewReader(r io.Reader, s Splitter, alg checksum.Algorithm) *Reader {
+	return &Reader{
+		r:   r,
+		s:   s,
+		alg: alg,
+		buf: make([]byte, 2*s.MaxSize()),
+	}
+}
+
+// Next returns the next chunk of the stream, or io.EOF after the last one.
+// The chunk's Data is only valid until the next call to Next.
+func (cr *Reader) Next() (Chunk, error) {
+	// Keep at least MaxSize bytes buffered so every cut sees a full window
+	if !cr.eof && cr.end-cr.start < cr.s.MaxSize() {
+		cr.end = copy(cr.buf, cr.buf[cr.start:cr.end])
+		cr.start = 0
+		n, err := io.ReadFull(cr.r, cr.buf[cr.end:])
+		cr.end += n
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			cr.eof = true
+		} else if err != nil {
+			return Chunk{}, err
+		}
+	}
+	if cr.start == cr.end {
+		return Chunk{}, io.EOF
+	}
+
+	size := cr.s.Cut(cr.buf[cr.start:cr.end])
+	data := cr.buf[cr.start : cr.start+size]
+	chunk := Chunk{
+		ID:       cr.id,
+		Offset:   cr.offset,
+		Size:     size,
+		Data:     data,
+		Checksum: cr.alg.Sum(data),
+	}
+	cr.id++
+	cr.offset += int64(size)
+	cr.start += size
+	return chunk, nil
+}
+
+// Scan reads r to the end and returns the layout of its chunks: their IDs,
+// offsets, sizes and checksums, without their data.
+func Scan(r io.Reader, s Splitter, alg checksum.Algorithm) ([]Chunk, error) {
+	cr := NewReader(r, s, alg)
+	var chunks []Chunk
+	for {
+		c, err := cr.Next()
+		if err == io.EOF {
+			return chunks, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		c.Data = nil
+		chunks = append(chunks, c)
+	}
+}
+
+// ReaderAt reads individual chunks of a file by ID, given the layout
+// returned by Scan, so chunks can be sent or re-sent in any order.
+type ReaderAt struct {
+	r      io.ReaderAt
+	chunks []Chunk
+	alg    checksum.Algorithm
+}
+
+// NewReaderAt returns a ReaderAt over r with the given chunk layout that
+// checksums chunks with alg.
+func NewReaderAt(r io.ReaderAt, chunks []Chunk, alg checksum.Algorithm) *ReaderAt {
+	return &ReaderAt{r: r, chunks: chunks, alg: alg}
+}
+
+// Len returns the number of chunks.
+func (ra *ReaderAt) Len() int {
+	return len(ra.chunks)
+}
+
+// ReadChunk reads chunk id into buf, which must hold the chunk's Size
+// bytes. The returned chunk's Data aliases buf and its Checksum is computed
+// from the data read, so callers can compare it with the layout's to detect
+// a file that changed.
+func (ra *ReaderAt) ReadChunk(id int, buf []byte) (Chunk, error) {
+	if id < 0 || id >= len(ra.chunks) {
+		return Chunk{}, fmt.Errorf("chunk %d out of range (%d chunks)", id, len(ra.chunks))
+	}
+	c := ra.chunks[id]
+	if len(buf) < c.Size {
+		return Chunk{}, fmt.Errorf("buffer too small for chunk %d: %d < %d bytes", id, len(buf), c.Size)
+	}
+
+	n, err := ra.r.ReadAt(buf[:c.Size], c.Offset)
+	if n < c.Size {
+		if err == nil || err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return Chunk{}, fmt.Errorf("failed to read chunk %d: %w", id, err)
+	}
+	c.Data = buf[:c.Size]
+	c.Checksum = ra.alg.Sum(c.Data)
+	return c, nil
+}
@@ -0,0 +1,84 @@
+package chunk
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"math/rand"
+	"testing"
+	"testing/iotest"
+
+	"github.com/0xRepo-Source/goflux/pkg/checksum"
+)
+
+func TestReaderMatchesSplit(t *testing.T) {
+	cdc, err := NewFastCDC(512, 2048, 8192)
+	if err != nil {
+		t.Fatalf("NewFastCDC() error = %v", err)
+	}
+	data := make([]byte, 100000)
+	rand.New(rand.NewSource(2)).Read(data)
+
+	for _, s := range []Splitter{New(3000), cdc} {
+		want := Split(s, data)
+
+		// Short reads must not move chunk boundaries
+		cr := NewReader(iotest.HalfReader(bytes.NewReader(data)), s, checksum.SHA256)
+		for i := 0; ; i++ {
+			c, err := cr.Next()
+			if err == io.EOF {
+				if i != len(want) {
+					t.Errorf("Reader produced %d chunks, want %d", i, len(want))
+				}
+				break
+			}
+			if err != nil {
+				t.Fatalf("Next() error = %v", err)
+			}
+			if i >= len(want) {
+				t.Fatalf("Reader produced more than %d chunks", len(want))
+			}
+			w := want[i]
+			if c.ID != i || c.Offset != w.Offset || c.Size != w.Size || c.Checksum != w.Checksum || !bytes.Equal(c.Data, w.Data) {
+				t.Fatalf("chunk %d = {ID %d, Offset %d, Size %d}, want {ID %d, Offset %d, Size %d}", i, c.ID, c.Offset, c.Size, i, w.Offset, w.Size)
+			}
+		}
+	}
+}
+
+func TestReaderAtReadChunk(t *testing.T) {
+	data := []byte("Hello, World! This is a test of random access.")
+	s := New(10)
+	chunks, err := Scan(bytes.NewReader(data), s, checksum.BLAKE3)
+	if err != nil {
+		t.Fatalf("Scan() error = %v", err)
+	}
+	for _, c := range chunks {
+		if c.Data != nil {
+			t.Fatalf("Scan() chunk %d has data", c.ID)
+		}
+	}
+
+	ra := NewReaderAt(bytes.NewReader(data), chunks, checksum.BLAKE3)
+	buf := make([]byte, s.MaxSize())
+	for _, id := range []int{4, 0, 2} {
+		c, err := ra.ReadChunk(id, buf)
+		if err != nil {
+			t.Fatalf("ReadChunk(%d) error = %v", id, err)
+		}
+		want := data[c.Offset : c.Offset+int64(c.Size)]
+		if !bytes.Equal(c.Data, want) || c.Checksum != chunks[id].Checksum {
+			t.Errorf("ReadChunk(%d) = %q, want %q", id, c.Data, want)
+		}
+	}
+
+	if _, err := ra.ReadChunk(len(chunks), buf); err == nil {
+		t.Error("ReadChunk() past the last chunk succeeded")
+	}
+
+	// A file that shrank since it was scanned cannot fill its last chunk
+	short := NewReaderAt(bytes.NewReader(data[:len(data)-1]), chunks, checksum.BLAKE3)
+	if _, err := short.ReadChunk(len(chunks)-1, buf); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("ReadChunk() on truncated file error = %v, want io.ErrUnexpectedEOF", err)
+	}
+}
@@ -11,11 +11,57 @@ import (
 type ServerConfig struct {
 	Address     string `json:"address"`     // Listen address (e.g., "0.0.0.0:80")
 	StorageDir  string `json:"storage_dir"` // Storage directory path
+	Storage     string `json:"storage"`     // Storage backend: "local" (default) or "cas"
 	WebUIDir    string `json:"webui_dir"`   // Web UI directory (empty to disable)
 	MetaDir     string `json:"meta_dir"`    // Metadata directory for resume
 	TokensFile  string `json:"tokens_file"` // Path to tokens file (empty to disable auth)
 	TLSCertFile string `json:"tls_cert"`    // TLS certificate file (empty for HTTP)
 	TLSKeyFile  string `json:"tls_key"`     // TLS key file (empty for HTTP)
+
+	Checksums    []string `json:"checksums"`      // Chunk checksum algorithms clients may use (empty for sha256, blake3)
+	MaxChunkSize int64    `json:"max_chunk_size"` // Largest accepted chunk in bytes (0 for no limit)
+	MaxFileSize  int64    `json:"max_file_size"`  // Largest accepted file in bytes (0 for no limit)
+
+	ClientCAFile    string `json:"client_ca"`    // CA bundle for client certificates (empty to disable)
+	ClientCertsFile string `json:"client_certs"` // Client certificate to user mappings
+
+	SSHAddress string `json:"ssh_address"`  // SFTP listen address (empty to disable)
+	SSHHostKey string `json:"ssh_host_key"` // SSH host key, created if missing
+	SSHKeys    string `json:"ssh_keys"`     // SSH public key to user mappings
+
+	QUICAddress string `json:"quic_address"` // HTTP/3 UDP listen address (empty to disable, requires TLS)
+
+	// Transports to serve; when empty they follow from address,
+	// quic_address and ssh_address
+	Listeners []ListenerConfig `json:"listeners,omitempty"`
+}
+
+// ListenerConfig is a transport the server listens on
+type ListenerConfig struct {
+	Transport string `json:"transport"` // "http", "https", "unix", "quic" or "ssh"
+	Address   string `json:"address"`   // host:port, or the socket path for unix
+}
+
+// ServerListeners returns the transports the server should listen on: the
+// configured listeners, or else HTTP (HTTPS with a certificate) on address
+// plus QUIC and SFTP if their addresses are set.
+func (c ServerConfig) ServerListeners() []ListenerConfig {
+	if len(c.Listeners) > 0 {
+		return c.Listeners
+	}
+
+	transport := "http"
+	if c.TLSCertFile != "" {
+		transport = "https"
+	}
+	listeners := []ListenerConfig{{Transport: transport, Address: c.Address}}
+	if c.QUICAddress != "" {
+		listeners = append(listeners, ListenerConfig{Transport: "quic", Address: c.QUICAddress})
+	}
+	if c.SSHAddress != "" {
+		listeners = append(listeners, ListenerConfig{Transport: "ssh", Address: c.SSHAddress})
+	}
+	return listeners
 }
 
 // ClientConfig holds client configuration
@@ -23,6 +69,29 @@ type ClientConfig struct {
 	ServerURL string `json:"server_url"` // Server URL (e.g., "http://95.145.216.175")
 	ChunkSize int    `json:"chunk_size"` // Chunk size in bytes
 	Token     string `json:"token"`      // Authentication token (optional)
+	Parallel  int    `json:"parallel"`   // Chunks uploaded concurrently
+
+	// Content-defined chunking keeps unchanged regions of an edited file in
+	// identical chunks, so re-uploads only send what changed
+	Chunking     string `json:"chunking"`       // "fixed" (default) or "cdc"
+	MinChunkSize int    `json:"min_chunk_size"` // Smallest cdc chunk (0 for chunk_size/4)
+	MaxChunkSize int    `json:"max_chunk_size"` // Largest cdc chunk (0 for chunk_size*4)
+
+	Checksum string `json:"checksum"` // Chunk checksum algorithm: sha256 (default), blake3, xxh3 or crc32c
+
+	// Retry budget for transient failures (network errors, 5xx, 429)
+	Retries     int `json:"retries"`      // Retries per request (0 for default, -1 to disable)
+	RetryBudget int `json:"retry_budget"` // Seconds to keep retrying one request (0 for default)
+
+	CAFile             string `json:"ca_file"`              // Extra CA bundle for verifying the server (optional)
+	InsecureSkipVerify bool   `json:"insecure_skip_verify"` // Skip server certificate verification (testing only)
+	CertFile           string `json:"cert_file"`            // Client certificate for mutual TLS (optional)
+	KeyFile            string `json:"key_file"`             // Client certificate key for mutual TLS (optional)
+
+	// Used when server_url is ssh://user@host:port
+	SSHKey                   string `json:"ssh_key"`                      // Private key (empty for the SSH agent and ~/.ssh defaults)
+	SSHKnownHosts            string `json:"ssh_known_hosts"`              // Trusted host keys (empty for ~/.ssh/known_hosts)
+	SSHInsecureIgnoreHostKey bool   `json:"ssh_insecure_ignore_host_key"` // Skip host key verification (testing only)
 }
 
 // Config holds both server and client configuration
@@ -50,6 +119,7 @@ func DefaultClientConfig() ClientConfig {
 		ServerURL: "http://localhost",
 		ChunkSize: 1024 * 1024, // 1MB
 		Token:     "",
+		Parallel:  4,
 	}
 }
 
@@ -0,0 +1,350 @@
+// Package delta sends a new version of a file as changes to an old one, as
+// rsync does. The holder of the old version signs it: every block of it
+// gets a weak rolling checksum and a strong one. The holder of the new
+// version finds those blocks anywhere in it, at any offset, and writes a
+// delta of references to them and the literal data in between, which
+// rebuilds the new version from the old.
+//
+// A delta is a sequence of operations, each a byte followed by uvarints:
+//
+//	'C' block count    copy count whole blocks of the old version, starting at block
+//	'L' length data    append length bytes of literal data
+//	'E'                end of the delta
+package delta
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+
+	"github.com/0xRepo-Source/goflux/pkg/proto"
+)
+
+// Limits of block sizes and literal operations.
+const (
+	MinBlockSize = 1 << 10
+	MaxBlockSize = 1 << 20
+	MaxLiteral   = 256 << 10 // longest literal operation; longer runs are split
+)
+
+const (
+	opCopy    = 'C'
+	opLiteral = 'L'
+	opEnd     = 'E'
+
+	readSize = 64 << 10
+)
+
+// ErrInvalid is returned by Patch for deltas that are malformed or refer
+// to blocks the old version doesn't have.
+var ErrInvalid = errors.New("invalid delta")
+
+// Stats counts how a new version was made.
+type Stats struct {
+	Literal int64 // bytes sent as literal data
+	Copied  int64 // bytes copied from the old version
+}
+
+// BlockSizeFor returns the block size to sign a file of size bytes with:
+// about its square root, as rsync uses, in whole KiB.
+func BlockSizeFor(size int64) int {
+	bs := (int(math.Sqrt(float64(size))) + 1023) &^ 1023
+	return min(max(bs, MinBlockSize), MaxBlockSize)
+}
+
+// CheckBlockSize returns an error if blockSize is outside the limits.
+func CheckBlockSize(blockSize int) error {
+	if blockSize < MinBlockSize || blockSize > MaxBlockSize {
+		return fmt.Errorf("block size %d outside %d to %d", blockSize, MinBlockSize, MaxBlockSize)
+	}
+	return nil
+}
+
+// Sign reads a file and returns the signature of its blocks, with its size
+// and SHA-256. The last block may be short.
+func Sign(r io.Reader, blockSize int) (*proto.Signature, error) {
+	if err := CheckBlockSize(blockSize); err != nil {
+		return nil, err
+	}
+
+	sig := &proto.Signature{BlockSize: blockSize, Blocks: []proto.BlockSignature{}}
+	hasher := sha256.New()
+	buf := make([]byte, blockSize)
+	for {
+		n, err := io.ReadFull(r, buf)
+		if n > 0 {
+			block := buf[:n]
+			hasher.Write(block)
+			sig.Blocks = append(sig.Blocks, proto.BlockSignature{Weak: weakSum(block), Strong: strongSum(block)})
+			sig.Size += int64(n)
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	sig.FileHash = hex.EncodeToString(hasher.Sum(nil))
+	return sig, nil
+}
+
+// Diff reads the new version of the file sig describes and writes the
+// delta that rebuilds it from the old one. Only whole blocks are matched.
+func Diff(sig *proto.Signature, r io.Reader, w io.Writer) (Stats, error) {
+	bs := sig.BlockSize
+	if err := CheckBlockSize(bs); err != nil {
+		return Stats{}, err
+	}
+	index := make(map[uint32][]int)
+	for i, b := range sig.Blocks {
+		if int64(i+1)*int64(bs) <= sig.Size {
+			index[b.Weak] = append(index[b.Weak], i)
+		}
+	}
+
+	enc := &encoder{w: bufio.NewWriterSize(w, readSize), blockSize: bs}
+	// buf holds the pending literal data from lit and the window at pos.
+	// Literals are flushed at MaxLiteral and reads stop a block past pos,
+	// so moving the pending data to the front always leaves room to read.
+	buf := make([]byte, 0, MaxLiteral+2*bs+readSize)
+	lit, pos := 0, 0
+	eof := false
+	var sum rolling
+	rolled := false
+	for {
+		for !eof && len(buf)-pos <= bs {
+			if cap(buf)-len(buf) < readSize {
+				n := copy(buf, buf[lit:])
+				buf = buf[:n]
+				pos -= lit
+				lit = 0
+			}
+			n, err := r.Read(buf[len(buf):cap(buf)])
+			buf = buf[:len(buf)+n]
+			if err == io.EOF {
+				eof = true
+			} else if err != nil {
+				return enc.stats, err
+			}
+		}
+		if len(buf)-pos < bs {
+			break
+		}
+
+		window := buf[pos : pos+bs]
+		if !rolled {
+			sum.init(window)
+			rolled = true
+		}
+		if candidates := index[sum.sum()]; len(candidates) > 0 {
+			if i := matchBlock(sig, candidates, window); i >= 0 {
+				if err := enc.literal(buf[lit:pos]); err != nil {
+					return enc.stats, err
+				}
+				enc.copyBlock(i)
+				pos += bs
+				lit = pos
+				rolled = false
+				continue
+			}
+		}
+		if len(buf)-pos == bs {
+			// The input ended; the last window is literal data
+			break
+		}
+		sum.roll(buf[pos], buf[pos+bs])
+		pos++
+		if pos-lit >= MaxLiteral {
+			if err := enc.literal(buf[lit:pos]); err != nil {
+				return enc.stats, err
+			}
+			lit = pos
+		}
+	}
+	if err := enc.literal(buf[lit:]); err != nil {
+		return enc.stats, err
+	}
+	return enc.stats, enc.end()
+}
+
+// matchBlock returns which of the candidate blocks window has the strong
+// checksum of, or -1
+func matchBlock(sig *proto.Signature, candidates []int, window []byte) int {
+	strong := strongSum(window)
+	for _, i := range candidates {
+		if sig.Blocks[i].Strong == strong {
+			return i
+		}
+	}
+	return -1
+}
+
+// Patch reads a delta and writes the new version it makes of base, the old
+// version of baseSize bytes that was signed with blockSize.
+func Patch(base io.ReaderAt, baseSize int64, blockSize int, r io.Reader, w io.Writer) (Stats, error) {
+	var stats Stats
+	if err := CheckBlockSize(blockSize); err != nil {
+		return stats, err
+	}
+	blocks := uint64(baseSize / int64(blockSize))
+	br := bufio.NewReaderSize(r, readSize)
+	for {
+		op, err := br.ReadByte()
+		if err == io.EOF {
+			return stats, fmt.Errorf("%w: missing end", ErrInvalid)
+		}
+		if err != nil {
+			return stats, err
+		}
+
+		switch op {
+		case opCopy:
+			block, err := readUvarint(br)
+			if err != nil {
+				return stats, err
+			}
+			count, err := readUvarint(br)
+			if err != nil {
+				return stats, err
+			}
+			if count == 0 || block >= blocks || count > blocks-block {
+				return stats, fmt.Errorf("%w: blocks %d+%d outside the %d of the base", ErrInvalid, block, count, blocks)
+			}
+			n := int64(count) * int64(blockSize)
+			if _, err := io.Copy(w, io.NewSectionReader(base, int64(block)*int64(blockSize), n)); err != nil {
+				return stats, err
+			}
+			stats.Copied += n
+
+		case opLiteral:
+			n, err := readUvarint(br)
+			if err != nil {
+				return stats, err
+			}
+			if n == 0 || n > MaxLiteral {
+				return stats, fmt.Errorf("%w: literal of %d bytes", ErrInvalid, n)
+			}
+			if _, err := io.CopyN(w, br, int64(n)); err != nil {
+				if err == io.EOF {
+					err = fmt.Errorf("%w: truncated literal", ErrInvalid)
+				}
+				return stats, err
+			}
+			stats.Literal += int64(n)
+
+		case opEnd:
+			return stats, nil
+
+		default:
+			return stats, fmt.Errorf("%w: unknown operation %q", ErrInvalid, op)
+		}
+	}
+}
+
+// readUvarint reads an operand, treating a delta that ends inside one as
+// invalid
+func readUvarint(r io.ByteReader) (uint64, error) {
+	v, err := binary.ReadUvarint(r)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return 0, fmt.Errorf("%w: truncated operation", ErrInvalid)
+	}
+	return v, err
+}
+
+// encoder writes delta operations, merging copies of consecutive blocks.
+// Write errors stick to the bufio.Writer and come out of the next Write or
+// the final Flush.
+type encoder struct {
+	w         *bufio.Writer
+	blockSize int
+	run       uint64 // first block of the pending copy
+	runLen    uint64 // blocks in the pending copy, 0 for none
+	stats     Stats
+	tmp       [binary.MaxVarintLen64]byte
+}
+
+func (e *encoder) copyBlock(i int) {
+	if e.runLen > 0 && e.run+e.runLen == uint64(i) {
+		e.runLen++
+		return
+	}
+	e.flushCopy()
+	e.run, e.runLen = uint64(i), 1
+}
+
+func (e *encoder) flushCopy() {
+	if e.runLen == 0 {
+		return
+	}
+	e.w.WriteByte(opCopy)
+	e.uvarint(e.run)
+	e.uvarint(e.runLen)
+	e.stats.Copied += int64(e.runLen) * int64(e.blockSize)
+	e.runLen = 0
+}
+
+func (e *encoder) literal(p []byte) error {
+	if len(p) == 0 {
+		return nil
+	}
+	e.flushCopy()
+	e.w.WriteByte(opLiteral)
+	e.uvarint(uint64(len(p)))
+	_, err := e.w.Write(p)
+	e.stats.Literal += int64(len(p))
+	return err
+}
+
+func (e *encoder) end() error {
+	e.flushCopy()
+	e.w.WriteByte(opEnd)
+	return e.w.Flush()
+}
+
+func (e *encoder) uvarint(v uint64) {
+	n := binary.PutUvarint(e.tmp[:], v)
+	e.w.Write(e.tmp[:n])
+}
+
+// rolling is the rsync weak checksum of a window, which can be moved by a
+// byte without reading the whole window again
+type rolling struct {
+	a, b uint32
+	n    uint32
+}
+
+func (r *rolling) init(p []byte) {
+	r.a, r.b, r.n = 0, 0, uint32(len(p))
+	for i, c := range p {
+		r.a += uint32(c)
+		r.b += uint32(len(p)-i) * uint32(c)
+	}
+}
+
+// roll moves the window past out and over in
+func (r *rolling) roll(out, in byte) {
+	r.a += uint32(in) - uint32(out)
+	r.b += r.a - r.n*uint32(out)
+}
+
+func (r *rolling) sum() uint32 {
+	return r.a&0xffff | r.b<<16
+}
+
+func weakSum(p []byte) uint32 {
+	var r rolling
+	r.init(p)
+	return r.sum()
+}
+
+// strongSum is the first 16 bytes of the SHA-256 of p, in hex
+func strongSum(p []byte) string {
+	h := sha256.Sum256(p)
+	return hex.EncodeToString(h[:16])
+}
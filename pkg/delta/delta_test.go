@@ -0,0 +1,177 @@
+package delta
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"math/rand"
+	"testing"
+	"testing/iotest"
+)
+
+// roundTrip signs old, diffs new against it and patches old with the
+// delta, failing unless that gives back new
+func roundTrip(t *testing.T, old, new []byte, blockSize int) Stats {
+	t.Helper()
+	sig, err := Sign(bytes.NewReader(old), blockSize)
+	if err != nil {
+		t.Fatalf("Sign() error = %v", err)
+	}
+
+	var d bytes.Buffer
+	stats, err := Diff(sig, iotest.HalfReader(bytes.NewReader(new)), &d)
+	if err != nil {
+		t.Fatalf("Diff() error = %v", err)
+	}
+	if stats.Literal+stats.Copied != int64(len(new)) {
+		t.Errorf("Diff() stats = %+v, want %d bytes in all", stats, len(new))
+	}
+
+	var out bytes.Buffer
+	patched, err := Patch(bytes.NewReader(old), int64(len(old)), blockSize, &d, &out)
+	if err != nil {
+		t.Fatalf("Patch() error = %v", err)
+	}
+	if !bytes.Equal(out.Bytes(), new) {
+		t.Fatalf("Patch() built %d bytes that differ from the %d of the new version", out.Len(), len(new))
+	}
+	if patched != stats {
+		t.Errorf("Patch() stats = %+v, want %+v", patched, stats)
+	}
+	return stats
+}
+
+func TestSign(t *testing.T) {
+	data := make([]byte, 2500)
+	rand.New(rand.NewSource(1)).Read(data)
+
+	sig, err := Sign(bytes.NewReader(data), 1024)
+	if err != nil {
+		t.Fatalf("Sign() error = %v", err)
+	}
+	sum := sha256.Sum256(data)
+	if sig.Size != 2500 || sig.BlockSize != 1024 || sig.FileHash != hex.EncodeToString(sum[:]) {
+		t.Errorf("Sign() = size %d, block size %d, hash %s", sig.Size, sig.BlockSize, sig.FileHash)
+	}
+	if len(sig.Blocks) != 3 {
+		t.Fatalf("Sign() made %d blocks, want 3", len(sig.Blocks))
+	}
+	if sig.Blocks[2].Weak != weakSum(data[2048:]) || sig.Blocks[2].Strong != strongSum(data[2048:]) {
+		t.Error("last block signature doesn't match the short block")
+	}
+
+	if _, err := Sign(bytes.NewReader(data), 100); err == nil {
+		t.Error("Sign() accepted a block size below the minimum")
+	}
+}
+
+func TestRollingMatchesInit(t *testing.T) {
+	data := make([]byte, 5000)
+	rand.New(rand.NewSource(2)).Read(data)
+
+	const n = 1024
+	var r rolling
+	r.init(data[:n])
+	for i := 1; i+n <= len(data); i++ {
+		r.roll(data[i-1], data[i+n-1])
+		if got, want := r.sum(), weakSum(data[i:i+n]); got != want {
+			t.Fatalf("rolled sum at %d = %x, want %x", i, got, want)
+		}
+	}
+}
+
+func TestDiffSmallChanges(t *testing.T) {
+	rng := rand.New(rand.NewSource(3))
+	old := make([]byte, 1<<20)
+	rng.Read(old)
+
+	// Overwrite, insert and delete a little, so most blocks move
+	new := append([]byte(nil), old...)
+	copy(new[100000:], bytes.Repeat([]byte("x"), 3000))
+	new = append(new[:400000:400000], append([]byte("inserted bytes"), new[400000:]...)...)
+	new = append(new[:700000:700000], new[705000:]...)
+
+	stats := roundTrip(t, old, new, 2048)
+	if stats.Literal > 20000 {
+		t.Errorf("Diff() sent %d literal bytes for a few small changes", stats.Literal)
+	}
+}
+
+func TestDiffEdgeCases(t *testing.T) {
+	rng := rand.New(rand.NewSource(4))
+	random := func(n int) []byte {
+		b := make([]byte, n)
+		rng.Read(b)
+		return b
+	}
+	old := random(10000)
+
+	tests := []struct {
+		name string
+		old  []byte
+		new  []byte
+	}{
+		{"identical", old, old},
+		{"empty new", old, nil},
+		{"empty old", nil, old},
+		{"unrelated", old, random(7000)},
+		{"shorter than a block", old, old[:500]},
+		{"appended", old, append(append([]byte(nil), old...), random(3000)...)},
+		{"repeated block", old[:1024], bytes.Repeat(old[:1024], 5)},
+		{"long literal", old, random(3 * MaxLiteral)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			roundTrip(t, tt.old, tt.new, 1024)
+		})
+	}
+
+	stats := roundTrip(t, old, old, 1024)
+	if stats.Literal != int64(len(old)%1024) {
+		t.Errorf("identical file sent %d literal bytes, want only the short last block", stats.Literal)
+	}
+}
+
+func TestPatchRejectsInvalid(t *testing.T) {
+	base := make([]byte, 4096)
+	tests := []struct {
+		name  string
+		delta []byte
+	}{
+		{"empty", nil},
+		{"no end", []byte{opLiteral, 1, 'a'}},
+		{"block past the base", []byte{opCopy, 4, 1, opEnd}},
+		{"count past the base", []byte{opCopy, 2, 3, opEnd}},
+		{"zero count", []byte{opCopy, 0, 0, opEnd}},
+		{"truncated literal", []byte{opLiteral, 5, 'a'}},
+		{"truncated operand", []byte{opCopy, 0x80}},
+		{"literal too long", []byte{opLiteral, 0x81, 0x80, 0x80, 0x01}},
+		{"unknown", []byte{'X', opEnd}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Patch(bytes.NewReader(base), int64(len(base)), 1024, bytes.NewReader(tt.delta), &bytes.Buffer{})
+			if !errors.Is(err, ErrInvalid) {
+				t.Errorf("Patch() error = %v, want ErrInvalid", err)
+			}
+		})
+	}
+}
+
+func TestBlockSizeFor(t *testing.T) {
+	tests := []struct {
+		size int64
+		want int
+	}{
+		{0, MinBlockSize},
+		{1 << 20, 1024},
+		{100 << 20, 10240},
+		{1 << 50, MaxBlockSize},
+	}
+	for _, tt := range tests {
+		if got := BlockSizeFor(tt.size); got != tt.want {
+			t.Errorf("BlockSizeFor(%d) = %d, want %d", tt.size, got, tt.want)
+		}
+	}
+}
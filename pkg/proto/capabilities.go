@@ -0,0 +1,50 @@
+package proto
+
+// ProtocolVersion is the version of the goflux protocol described by this
+// package. Servers that predate capability negotiation are version 0.
+const ProtocolVersion = 1
+
+// Features a server can advertise.
+const (
+	FeatureUpload       = "upload"        // chunked uploads
+	FeatureResume       = "resume"        // upload IDs and resuming by ID
+	FeatureManifest     = "manifest"      // upload manifests skip chunks the server holds
+	FeatureLink         = "link"          // identical files are stored without sending data
+	FeatureChunkOffsets = "chunk_offsets" // variable-length chunks with declared offsets
+	FeatureDownload     = "download"      // ranged downloads
+	FeatureList         = "list"          // directory listings
+	FeatureFiles        = "files"         // deleting, moving, copying and making directories
+	FeatureStat         = "stat"          // GET /stat and detailed, paginated listings
+	FeatureSetAttr      = "setattr"       // setting modes and modification times
+	FeatureDelta        = "delta"         // block signatures and delta uploads
+)
+
+// Capabilities is what a server supports, served on GET /capabilities.
+type Capabilities struct {
+	Version      int      `json:"version"`        // protocol version
+	Features     []string `json:"features"`       // enabled features
+	MaxChunkSize int64    `json:"max_chunk_size"` // largest accepted chunk in bytes, 0 for no limit
+	MaxFileSize  int64    `json:"max_file_size"`  // largest accepted file in bytes, 0 for no limit
+	Checksums    []string `json:"checksums"`      // accepted chunk checksum algorithms
+	Compression  []string `json:"compression"`    // accepted compression algorithms
+}
+
+// Has reports whether the server advertises feature.
+func (c *Capabilities) Has(feature string) bool {
+	return contains(c.Features, feature)
+}
+
+// AcceptsChecksum reports whether the server accepts chunk checksums of the
+// named algorithm.
+func (c *Capabilities) AcceptsChecksum(name string) bool {
+	return contains(c.Checksums, name)
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,31 @@
+package proto
+
+// Signature describes the blocks of a stored file, so that a client can
+// send a new version of it as a delta: references to the blocks it still
+// has and literal data for the rest. It is served on GET /signature.
+//
+// The delta is sent as the body of POST /delta, whose query names the
+// path, the etag and block_size of the signature it was made against, and
+// the size and file_hash of the new version. The server rebuilds the new
+// version and only stores it if it matches them; if the file changed since
+// the signature was made it answers CodeChanged.
+type Signature struct {
+	Size      int64            `json:"size"`       // file length in bytes
+	ETag      string           `json:"etag"`       // version of the file the blocks describe
+	FileHash  string           `json:"file_hash"`  // hex SHA-256 of the file
+	BlockSize int              `json:"block_size"` // length of every block but the last
+	Blocks    []BlockSignature `json:"blocks"`
+}
+
+// BlockSignature holds the checksums of one block of a file.
+type BlockSignature struct {
+	Weak   uint32 `json:"weak"`   // rsync rolling checksum
+	Strong string `json:"strong"` // first 16 bytes of the SHA-256, in hex
+}
+
+// DeltaResult is the answer to POST /delta.
+type DeltaResult struct {
+	Size         int64 `json:"size"`          // bytes stored
+	LiteralBytes int64 `json:"literal_bytes"` // bytes sent as literal data
+	CopiedBytes  int64 `json:"copied_bytes"`  // bytes copied from the previous version
+}
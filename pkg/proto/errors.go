@@ -0,0 +1,114 @@
+package proto
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"strings"
+)
+
+// Code identifies the kind of a failure, so clients can react to it
+// without parsing messages.
+type Code string
+
+const (
+	CodeBadRequest          Code = "bad_request"          // malformed or incomplete request
+	CodeUnauthorized        Code = "unauthorized"         // missing or invalid credentials
+	CodeForbidden           Code = "forbidden"            // credentials lack a permission, or the upload belongs to another user
+	CodeNotFound            Code = "not_found"            // no such file or route
+	CodeUploadNotFound      Code = "upload_not_found"     // no upload with this ID; it completed or was discarded
+	CodeMethodNotAllowed    Code = "method_not_allowed"   // wrong HTTP method
+	CodeChecksumUnsupported Code = "checksum_unsupported" // checksum algorithm not accepted
+	CodeChecksumRequired    Code = "checksum_required"    // chunk sent without a checksum
+	CodeChecksumMismatch    Code = "checksum_mismatch"    // chunk data does not match its checksum; resend it
+	CodeFileHashMismatch    Code = "file_hash_mismatch"   // assembled file does not match; the upload was discarded
+	CodeChunksMissing       Code = "chunks_missing"       // chunks the server held were lost; query the upload and resend the missing ones
+	CodeTooLarge            Code = "too_large"            // chunk or file exceeds a server limit
+	CodeExists              Code = "already_exists"       // destination of a move, copy or mkdir exists
+	CodeNotEmpty            Code = "not_empty"            // directory has entries and the delete wasn't recursive
+	CodeChanged             Code = "changed"              // file changed since the signature a delta was made against
+	CodeInternal            Code = "internal"             // server-side failure; retrying may help
+)
+
+// Error is the JSON body of every failed request.
+type Error struct {
+	Status  int    `json:"status"`  // HTTP status code
+	Code    Code   `json:"code"`    // machine-readable kind of failure
+	Message string `json:"message"` // human-readable description
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s: %s", e.Code, e.Message)
+}
+
+// Is lets errors.Is match missing and existing files against
+// fs.ErrNotExist and fs.ErrExist.
+func (e *Error) Is(target error) bool {
+	switch target {
+	case fs.ErrNotExist:
+		return e.Code == CodeNotFound
+	case fs.ErrExist:
+		return e.Code == CodeExists
+	}
+	return false
+}
+
+// CodeForStatus returns the code a failure with an HTTP status has when
+// nothing more specific is known.
+func CodeForStatus(status int) Code {
+	switch status {
+	case http.StatusBadRequest:
+		return CodeBadRequest
+	case http.StatusUnauthorized:
+		return CodeUnauthorized
+	case http.StatusForbidden:
+		return CodeForbidden
+	case http.StatusNotFound:
+		return CodeNotFound
+	case http.StatusMethodNotAllowed:
+		return CodeMethodNotAllowed
+	case http.StatusNotAcceptable:
+		return CodeChecksumUnsupported
+	case http.StatusConflict:
+		return CodeFileHashMismatch
+	case http.StatusRequestEntityTooLarge:
+		return CodeTooLarge
+	case http.StatusUnprocessableEntity:
+		return CodeChecksumMismatch
+	case http.StatusPreconditionFailed:
+		return CodeChanged
+	}
+	if status >= 400 && status < 500 {
+		return CodeBadRequest
+	}
+	return CodeInternal
+}
+
+// WriteError answers a request with an Error body.
+func WriteError(w http.ResponseWriter, status int, code Code, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(Error{Status: status, Code: code, Message: message})
+}
+
+// ReadError reads the Error from a failed response. Servers that predate
+// structured errors answer with plain text, which becomes the message of
+// an Error coded after the status.
+func ReadError(resp *http.Response) *Error {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+
+	var e Error
+	if strings.HasPrefix(resp.Header.Get("Content-Type"), "application/json") &&
+		json.Unmarshal(body, &e) == nil && e.Code != "" {
+		e.Status = resp.StatusCode
+		return &e
+	}
+	return &Error{
+		Status:  resp.StatusCode,
+		Code:    CodeForStatus(resp.StatusCode),
+		Message: strings.TrimSpace(string(body)),
+	}
+}
@@ -0,0 +1,88 @@
+package proto
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// FileInfo describes a stored file or directory. It is served on
+// GET /stat and in detailed listings.
+type FileInfo struct {
+	Name    string    `json:"name"`
+	Size    int64     `json:"size"`           // content length in bytes; 0 for directories
+	Mode    uint32    `json:"mode"`           // Unix permission bits, e.g. 0644
+	ModTime time.Time `json:"mod_time"`       // last modification; zero if unknown
+	IsDir   bool      `json:"is_dir"`         // whether the entry is a directory
+	Hash    string    `json:"hash,omitempty"` // hex SHA-256 of the content, if the server knows it
+}
+
+// Listing is one page of a detailed directory listing, served on
+// GET /list with detail=true. Plain listings are a JSON array of names.
+type Listing struct {
+	Path       string     `json:"path"`        // directory listed
+	Entries    []FileInfo `json:"entries"`     // entries of this page
+	Total      int        `json:"total"`       // entries in the whole directory
+	NextOffset int        `json:"next_offset"` // offset of the next page, 0 after the last
+}
+
+// Orders of detailed listings, given in the sort query parameter. Entries
+// that compare equal are ordered by name.
+const (
+	SortName = "name"
+	SortSize = "size"
+	SortTime = "time"
+)
+
+// MaxListLimit is the largest page of a detailed listing, and its size
+// when the request sets no limit.
+const MaxListLimit = 1000
+
+// NewListing returns the page of entries, the whole sorted directory at
+// path, that starts at offset and holds at most limit entries. A limit of
+// 0 or above MaxListLimit is taken as MaxListLimit.
+func NewListing(path string, entries []FileInfo, offset, limit int) Listing {
+	if limit <= 0 || limit > MaxListLimit {
+		limit = MaxListLimit
+	}
+	listing := Listing{Path: path, Entries: []FileInfo{}, Total: len(entries)}
+	if offset < len(entries) {
+		end := min(offset+limit, len(entries))
+		listing.Entries = entries[offset:end]
+		if end < len(entries) {
+			listing.NextOffset = end
+		}
+	}
+	return listing
+}
+
+// SortFileInfos orders entries by the named key, ascending unless reverse
+// is set. An empty key sorts by name.
+func SortFileInfos(entries []FileInfo, by string, reverse bool) error {
+	var less func(a, b FileInfo) bool
+	switch by {
+	case "", SortName:
+		less = func(a, b FileInfo) bool { return false }
+	case SortSize:
+		less = func(a, b FileInfo) bool { return a.Size < b.Size }
+	case SortTime:
+		less = func(a, b FileInfo) bool { return a.ModTime.Before(b.ModTime) }
+	default:
+		return fmt.Errorf("unknown sort order %q (use %s, %s or %s)", by, SortName, SortSize, SortTime)
+	}
+
+	sort.SliceStable(entries, func(i, j int) bool {
+		a, b := entries[i], entries[j]
+		if reverse {
+			a, b = b, a
+		}
+		if less(a, b) {
+			return true
+		}
+		if less(b, a) {
+			return false
+		}
+		return a.Name < b.Name
+	})
+	return nil
+}
@@ -0,0 +1,202 @@
+package proto
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// The encodings below are the wire format of protocol version 1. A change
+// that breaks one of them breaks clients and servers of other versions.
+func TestWireFormat(t *testing.T) {
+	tests := []struct {
+		name string
+		msg  any
+		want string
+	}{
+		{"ChunkData", ChunkData{UploadID: "u1", Path: "/a", ChunkID: 2, Data: []byte("hi"), Checksum: "sha256:00", Total: 3, FileHash: "ff", Offset: 8},
+			`{"upload_id":"u1","path":"/a","chunk_id":2,"data":"aGk=","checksum":"sha256:00","total":3,"file_hash":"ff","offset":8}`},
+		{"ChunkResponse", ChunkResponse{UploadID: "u1", ChunkID: 2, Total: 3, Complete: true},
+			`{"upload_id":"u1","chunk_id":2,"total":3,"complete":true}`},
+		{"CreateUploadRequest", CreateUploadRequest{Path: "/a", TotalChunks: 3, ChunkSize: 4, FileHash: "ff", Checksum: "blake3"},
+			`{"path":"/a","total_chunks":3,"chunk_size":4,"file_hash":"ff","checksum":"blake3"}`},
+		{"CreateUploadResponse", CreateUploadResponse{UploadID: "u1", Checksum: "blake3"},
+			`{"upload_id":"u1","checksum":"blake3"}`},
+		{"Manifest", Manifest{Size: 10, FileHash: "ff", ChunkHashes: []string{"sha256:00"}, ChunkOffsets: []int64{0}},
+			`{"size":10,"file_hash":"ff","chunk_hashes":["sha256:00"],"chunk_offsets":[0]}`},
+		{"ManifestResponse", ManifestResponse{ReusedChunks: 1, MissingChunks: []int{1}},
+			`{"complete":false,"linked":false,"reused_chunks":1,"missing_chunks":[1]}`},
+		{"UploadStatus", UploadStatus{UploadID: "u1", Path: "/a", Checksum: "sha256", Exists: true, TotalChunks: 2, ReceivedMap: []bool{true, false}, MissingChunks: []int{1}},
+			`{"upload_id":"u1","path":"/a","checksum":"sha256","exists":true,"total_chunks":2,"received_map":[true,false],"missing_chunks":[1],"completed":false}`},
+		{"Capabilities", Capabilities{Version: 1, Features: []string{FeatureUpload}, MaxChunkSize: 4, Checksums: []string{"sha256"}},
+			`{"version":1,"features":["upload"],"max_chunk_size":4,"max_file_size":0,"checksums":["sha256"],"compression":null}`},
+		{"Listing", Listing{Path: "/d", Entries: []FileInfo{{Name: "a", Size: 2, Mode: 0644, ModTime: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC), Hash: "ff"}, {Name: "b", Mode: 0755, IsDir: true}}, Total: 3, NextOffset: 2},
+			`{"path":"/d","entries":[{"name":"a","size":2,"mode":420,"mod_time":"2024-01-02T03:04:05Z","is_dir":false,"hash":"ff"},{"name":"b","size":0,"mode":493,"mod_time":"0001-01-01T00:00:00Z","is_dir":true}],"total":3,"next_offset":2}`},
+		{"SetAttrRequest", SetAttrRequest{Path: "/a", Mode: 0600, ModTime: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)},
+			`{"path":"/a","mode":384,"mod_time":"2024-01-02T03:04:05Z"}`},
+		{"Signature", Signature{Size: 5, ETag: `"1-5"`, FileHash: "ff", BlockSize: 4, Blocks: []BlockSignature{{Weak: 7, Strong: "aa"}, {Weak: 1, Strong: "bb"}}},
+			`{"size":5,"etag":"\"1-5\"","file_hash":"ff","block_size":4,"blocks":[{"weak":7,"strong":"aa"},{"weak":1,"strong":"bb"}]}`},
+		{"DeltaResult", DeltaResult{Size: 5, LiteralBytes: 1, CopiedBytes: 4},
+			`{"size":5,"literal_bytes":1,"copied_bytes":4}`},
+		{"Error", Error{Status: 404, Code: CodeUploadNotFound, Message: "upload u1 not found"},
+			`{"status":404,"code":"upload_not_found","message":"upload u1 not found"}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := json.Marshal(tt.msg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("encoding = %s\nwant       %s", got, tt.want)
+			}
+
+			// Decoding the encoding gives back the message
+			decoded := reflect.New(reflect.TypeOf(tt.msg))
+			if err := json.Unmarshal(got, decoded.Interface()); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(decoded.Elem().Interface(), tt.msg) {
+				t.Errorf("decoded = %+v, want %+v", decoded.Elem().Interface(), tt.msg)
+			}
+		})
+	}
+}
+
+// Messages from clients and servers that predate upload IDs, checksum
+// algorithms and chunk offsets still decode.
+func TestDecodeOlderMessages(t *testing.T) {
+	var chunk ChunkData
+	if err := json.Unmarshal([]byte(`{"path":"/a","chunk_id":0,"data":"aGk=","checksum":"00","total":1}`), &chunk); err != nil {
+		t.Fatal(err)
+	}
+	if chunk.UploadID != "" || chunk.Offset != 0 || string(chunk.Data) != "hi" {
+		t.Errorf("ChunkData = %+v", chunk)
+	}
+
+	var created CreateUploadResponse
+	if err := json.Unmarshal([]byte(`{"upload_id":"u1"}`), &created); err != nil {
+		t.Fatal(err)
+	}
+	if created.Checksum != "" {
+		t.Errorf("CreateUploadResponse.Checksum = %q, want empty", created.Checksum)
+	}
+
+	// Unknown fields from newer versions are ignored
+	var status UploadStatus
+	if err := json.Unmarshal([]byte(`{"exists":true,"total_chunks":1,"future":42}`), &status); err != nil {
+		t.Fatal(err)
+	}
+	if !status.Exists || status.TotalChunks != 1 {
+		t.Errorf("UploadStatus = %+v", status)
+	}
+}
+
+func TestReadError(t *testing.T) {
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		want    Error
+	}{
+		{"structured", func(w http.ResponseWriter, r *http.Request) {
+			WriteError(w, http.StatusNotFound, CodeUploadNotFound, "upload u1 not found")
+		}, Error{Status: 404, Code: CodeUploadNotFound, Message: "upload u1 not found"}},
+		{"plain text", func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, "checksum mismatch", http.StatusUnprocessableEntity)
+		}, Error{Status: 422, Code: CodeChecksumMismatch, Message: "checksum mismatch"}},
+		{"JSON without code", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadGateway)
+			w.Write([]byte(`{"error":"upstream"}`))
+		}, Error{Status: 502, Code: CodeInternal, Message: `{"error":"upstream"}`}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			tt.handler(rec, httptest.NewRequest("GET", "/", nil))
+			got := ReadError(rec.Result())
+			if *got != tt.want {
+				t.Errorf("ReadError() = %+v, want %+v", *got, tt.want)
+			}
+		})
+	}
+}
+
+func TestErrorAs(t *testing.T) {
+	var err error = &Error{Status: 413, Code: CodeTooLarge, Message: "too big"}
+	var pe *Error
+	if !errors.As(err, &pe) || pe.Code != CodeTooLarge {
+		t.Errorf("errors.As() = %v", pe)
+	}
+	if err.Error() != "too_large: too big" {
+		t.Errorf("Error() = %q", err.Error())
+	}
+
+	exists := fmt.Errorf("copy failed: %w", &Error{Status: 409, Code: CodeExists, Message: "/b already exists"})
+	if !errors.Is(exists, fs.ErrExist) || errors.Is(exists, fs.ErrNotExist) {
+		t.Errorf("errors.Is() does not match %v to fs.ErrExist only", exists)
+	}
+}
+
+func TestSortFileInfos(t *testing.T) {
+	day := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	entries := []FileInfo{
+		{Name: "c", Size: 1, ModTime: day},
+		{Name: "a", Size: 3, ModTime: day.Add(time.Hour)},
+		{Name: "b", Size: 1, ModTime: day.Add(-time.Hour)},
+	}
+	tests := []struct {
+		by      string
+		reverse bool
+		want    string
+	}{
+		{"", false, "abc"},
+		{SortName, true, "cba"},
+		{SortSize, false, "bca"},
+		{SortSize, true, "acb"},
+		{SortTime, false, "bca"},
+	}
+	for _, tt := range tests {
+		if err := SortFileInfos(entries, tt.by, tt.reverse); err != nil {
+			t.Fatalf("SortFileInfos(%q) error = %v", tt.by, err)
+		}
+		var got string
+		for _, e := range entries {
+			got += e.Name
+		}
+		if got != tt.want {
+			t.Errorf("SortFileInfos(%q, %v) = %s, want %s", tt.by, tt.reverse, got, tt.want)
+		}
+	}
+	if err := SortFileInfos(entries, "color", false); err == nil {
+		t.Error("SortFileInfos() accepted an unknown order")
+	}
+}
+
+func TestNewListing(t *testing.T) {
+	entries := make([]FileInfo, MaxListLimit+5)
+	tests := []struct {
+		offset, limit     int
+		wantLen, wantNext int
+	}{
+		{0, 2, 2, 2},
+		{MaxListLimit + 3, 2, 2, 0},
+		{MaxListLimit + 4, 2, 1, 0},
+		{0, 0, MaxListLimit, MaxListLimit},
+		{0, MaxListLimit * 2, MaxListLimit, MaxListLimit},
+		{MaxListLimit + 5, 2, 0, 0},
+	}
+	for _, tt := range tests {
+		l := NewListing("/", entries, tt.offset, tt.limit)
+		if len(l.Entries) != tt.wantLen || l.NextOffset != tt.wantNext || l.Total != len(entries) {
+			t.Errorf("NewListing(offset %d, limit %d) = %d entries, next %d, total %d; want %d, next %d",
+				tt.offset, tt.limit, len(l.Entries), l.NextOffset, l.Total, tt.wantLen, tt.wantNext)
+		}
+	}
+}
@@ -1,15 +1,112 @@
+// Package proto defines the goflux wire protocol: the JSON messages and
+// headers exchanged by server and client, and the error body every failed
+// request carries. Field names are part of the protocol; changing one
+// breaks clients and servers of other versions.
 package proto
 
-// Request represents a generic transfer request (placeholder).
-type Request struct {
-	Path     string
-	Upload   bool
-	Offset   int64
-	Metadata map[string]string
+import "time"
+
+// HeaderProtocol carries ProtocolVersion on every server response.
+const HeaderProtocol = "X-Goflux-Protocol"
+
+// Headers carrying chunk metadata on the binary upload routes.
+const (
+	HeaderPath     = "X-Goflux-Path"
+	HeaderTotal    = "X-Goflux-Total"
+	HeaderChecksum = "X-Goflux-Checksum"
+	HeaderFileHash = "X-Goflux-File-Hash"
+	HeaderOffset   = "X-Goflux-Offset"
+)
+
+// ChunkData is one chunk of an upload. It is the JSON body of POST /upload;
+// the binary routes carry the same fields in headers.
+type ChunkData struct {
+	UploadID string `json:"upload_id,omitempty"` // upload created on /upload/create, if any
+	Path     string `json:"path"`
+	ChunkID  int    `json:"chunk_id"`
+	Data     []byte `json:"data"`
+	Checksum string `json:"checksum"`            // tagged, see package checksum
+	Total    int    `json:"total"`               // total number of chunks
+	FileHash string `json:"file_hash,omitempty"` // SHA-256 of the complete file
+	Offset   int64  `json:"offset,omitempty"`    // position of the chunk in the file
+}
+
+// ChunkResponse acknowledges a stored chunk.
+type ChunkResponse struct {
+	UploadID string `json:"upload_id"`
+	ChunkID  int    `json:"chunk_id"`
+	Total    int    `json:"total"`    // total number of chunks
+	Complete bool   `json:"complete"` // this chunk completed the upload
+}
+
+// CreateUploadRequest starts an upload on POST /upload/create.
+type CreateUploadRequest struct {
+	Path        string `json:"path"`
+	TotalChunks int    `json:"total_chunks"`
+	ChunkSize   int    `json:"chunk_size"`          // 0 for variable-length chunks
+	FileHash    string `json:"file_hash,omitempty"` // SHA-256 of the complete file
+	Checksum    string `json:"checksum,omitempty"`  // chunk checksum algorithm; empty for sha256
+}
+
+// CreateUploadResponse carries the ID of a newly created upload and the
+// checksum algorithm the server accepted for its chunks.
+type CreateUploadResponse struct {
+	UploadID string `json:"upload_id"`
+	Checksum string `json:"checksum,omitempty"` // absent from servers that only know sha256
+}
+
+// Manifest describes a file before its chunks are uploaded, so the server
+// can skip content it already holds.
+type Manifest struct {
+	Size         int64    `json:"size"`
+	FileHash     string   `json:"file_hash"`               // SHA-256 of the complete file
+	ChunkHashes  []string `json:"chunk_hashes"`            // tagged checksum of each chunk, in order
+	ChunkOffsets []int64  `json:"chunk_offsets,omitempty"` // offset of each chunk, for variable-length chunks
+}
+
+// ManifestResponse tells the client which chunks it still has to send.
+type ManifestResponse struct {
+	Complete      bool  `json:"complete"`       // upload finished without further chunks
+	Linked        bool  `json:"linked"`         // the whole file was already stored
+	ReusedChunks  int   `json:"reused_chunks"`  // chunks copied from content on the server
+	MissingChunks []int `json:"missing_chunks"` // chunks the client must send
+}
+
+// UploadStatus is the state of an upload, served on /upload/status.
+type UploadStatus struct {
+	UploadID      string `json:"upload_id,omitempty"` // upload the status describes
+	Path          string `json:"path,omitempty"`      // destination path of the upload
+	Checksum      string `json:"checksum,omitempty"`  // chunk checksum algorithm of the upload
+	Exists        bool   `json:"exists"`              // whether the upload exists
+	TotalChunks   int    `json:"total_chunks"`        // total chunks expected
+	ReceivedMap   []bool `json:"received_map"`        // bitmap of received chunks
+	MissingChunks []int  `json:"missing_chunks"`      // list of missing chunk IDs
+	Completed     bool   `json:"completed"`           // upload completed
+}
+
+// DeleteRequest removes a file or directory on POST /delete.
+type DeleteRequest struct {
+	Path      string `json:"path"`
+	Recursive bool   `json:"recursive,omitempty"` // also remove a directory's contents
+}
+
+// MoveRequest moves a file or directory on POST /move, or copies it on
+// POST /copy. Destination must not exist.
+type MoveRequest struct {
+	Source      string `json:"source"`
+	Destination string `json:"destination"`
+}
+
+// MkdirRequest creates a directory and its parents on POST /mkdir.
+type MkdirRequest struct {
+	Path string `json:"path"`
 }
 
-// Response represents a generic response (placeholder).
-type Response struct {
-	OK      bool
-	Message string
+// SetAttrRequest sets the permission bits and modification time of a file
+// or directory on POST /setattr. A zero Mode or ModTime leaves it
+// unchanged.
+type SetAttrRequest struct {
+	Path    string    `json:"path"`
+	Mode    uint32    `json:"mode,omitempty"`
+	ModTime time.Time `json:"mod_time"`
 }
@@ -0,0 +1,123 @@
+package resume
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/0xRepo-Source/goflux/pkg/checksum"
+)
+
+// ChunkLocation is where a chunk with a known hash can be read back from a
+// stored file.
+type ChunkLocation struct {
+	Path   string `json:"path"`   // stored file containing the chunk
+	Offset int64  `json:"offset"` // byte offset of the chunk in the file
+	Size   int64  `json:"size"`   // chunk length in bytes
+}
+
+// chunkIndexFile is the chunk index's file in the metadata directory, which
+// it shares with upload sessions
+const chunkIndexFile = "chunk-index.json"
+
+// ChunkIndex maps chunk hashes to locations in files the server stored, so
+// an upload can reuse chunks the server already holds instead of receiving
+// them again. Locations are hints: files may change after they were
+// indexed, so callers must verify a chunk's hash after reading it.
+type ChunkIndex struct {
+	file   string
+	chunks map[string]ChunkLocation // tagged chunk checksum -> location
+	mu     sync.RWMutex
+}
+
+// NewChunkIndex loads the chunk index kept in metaDir
+func NewChunkIndex(metaDir string) (*ChunkIndex, error) {
+	idx := &ChunkIndex{
+		file:   filepath.Join(metaDir, chunkIndexFile),
+		chunks: make(map[string]ChunkLocation),
+	}
+
+	data, err := os.ReadFile(idx.file)
+	if os.IsNotExist(err) {
+		return idx, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read chunk index: %w", err)
+	}
+	var chunks map[string]ChunkLocation
+	if err := json.Unmarshal(data, &chunks); err != nil {
+		return nil, fmt.Errorf("failed to parse chunk index: %w", err)
+	}
+	// Indexes written before checksums were tagged hold bare SHA-256 hashes
+	for hash, loc := range chunks {
+		if sum, err := checksum.Normalize(hash); err == nil {
+			idx.chunks[sum] = loc
+		}
+	}
+	return idx, nil
+}
+
+// Lookup returns a location holding the chunk with the given hash
+func (idx *ChunkIndex) Lookup(hash string) (ChunkLocation, bool) {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	loc, ok := idx.chunks[hash]
+	return loc, ok
+}
+
+// Record indexes the chunks of a file that was just stored at path,
+// replacing entries that pointed at the file's previous content. hashes and
+// locations are parallel slices with one entry per chunk.
+func (idx *ChunkIndex) Record(path string, hashes []string, locations []ChunkLocation) error {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	idx.forgetPath(path)
+	for i, hash := range hashes {
+		if hash != "" && i < len(locations) {
+			idx.chunks[hash] = locations[i]
+		}
+	}
+	return idx.save()
+}
+
+// Forget drops a chunk whose recorded location turned out to be stale
+func (idx *ChunkIndex) Forget(hash string) error {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	if _, ok := idx.chunks[hash]; !ok {
+		return nil
+	}
+	delete(idx.chunks, hash)
+	return idx.save()
+}
+
+// forgetPath drops every chunk located in path. Callers must hold idx.mu.
+func (idx *ChunkIndex) forgetPath(path string) {
+	for hash, loc := range idx.chunks {
+		if loc.Path == path {
+			delete(idx.chunks, hash)
+		}
+	}
+}
+
+// save atomically writes the index to disk. Callers must hold idx.mu.
+func (idx *ChunkIndex) save() error {
+	data, err := json.Marshal(idx.chunks)
+	if err != nil {
+		return err
+	}
+
+	tmp := idx.file + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("failed to write chunk index: %w", err)
+	}
+	if err := os.Rename(tmp, idx.file); err != nil {
+		return fmt.Errorf("failed to commit chunk index: %w", err)
+	}
+	return nil
+}
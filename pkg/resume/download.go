@@ -0,0 +1,148 @@
+package resume
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// DownloadSession tracks the state of a partial download. It is stored as a
+// JSON file next to the partially written local file.
+type DownloadSession struct {
+	RemotePath   string    `json:"remote_path"`   // source path on the server
+	ETag         string    `json:"etag"`          // version of the remote file being downloaded
+	Size         int64     `json:"size"`          // total size of the remote file
+	BlockSize    int64     `json:"block_size"`    // size of each downloaded range
+	DoneMap      []bool    `json:"done_map"`      // bitmap of completed blocks
+	CreatedAt    time.Time `json:"created_at"`    // when download started
+	LastModified time.Time `json:"last_modified"` // last block completed
+
+	stateFile string
+	mu        sync.Mutex
+}
+
+// NewDownloadSession creates a download session persisted at stateFile
+func NewDownloadSession(stateFile, remotePath, etag string, size, blockSize int64) (*DownloadSession, error) {
+	if blockSize <= 0 {
+		return nil, fmt.Errorf("invalid block size: %d", blockSize)
+	}
+	if size < 0 {
+		return nil, fmt.Errorf("invalid size: %d", size)
+	}
+
+	blocks := int((size + blockSize - 1) / blockSize)
+	d := &DownloadSession{
+		RemotePath:   remotePath,
+		ETag:         etag,
+		Size:         size,
+		BlockSize:    blockSize,
+		DoneMap:      make([]bool, blocks),
+		CreatedAt:    time.Now(),
+		LastModified: time.Now(),
+		stateFile:    stateFile,
+	}
+
+	if err := d.save(); err != nil {
+		return nil, fmt.Errorf("failed to save download session: %w", err)
+	}
+	return d, nil
+}
+
+// LoadDownloadSession loads a download session from stateFile. It returns
+// os.ErrNotExist (wrapped) if there is no session to resume.
+func LoadDownloadSession(stateFile string) (*DownloadSession, error) {
+	data, err := os.ReadFile(stateFile)
+	if err != nil {
+		return nil, err
+	}
+
+	var d DownloadSession
+	if err := json.Unmarshal(data, &d); err != nil {
+		return nil, fmt.Errorf("failed to parse download session %s: %w", stateFile, err)
+	}
+
+	blocks := 0
+	if d.BlockSize > 0 {
+		blocks = int((d.Size + d.BlockSize - 1) / d.BlockSize)
+	}
+	if d.BlockSize <= 0 || len(d.DoneMap) != blocks {
+		return nil, fmt.Errorf("invalid download session %s", stateFile)
+	}
+
+	d.stateFile = stateFile
+	return &d, nil
+}
+
+// Block returns the offset and length of a block
+func (d *DownloadSession) Block(i int) (int64, int64) {
+	offset := int64(i) * d.BlockSize
+	length := d.BlockSize
+	if offset+length > d.Size {
+		length = d.Size - offset
+	}
+	return offset, length
+}
+
+// MissingBlocks returns the blocks that have not been downloaded yet
+func (d *DownloadSession) MissingBlocks() []int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	missing := []int{}
+	for i, done := range d.DoneMap {
+		if !done {
+			missing = append(missing, i)
+		}
+	}
+	return missing
+}
+
+// DoneSize returns the length of the file up to the end of the last
+// downloaded block, which a partial file must have to be resumed
+func (d *DownloadSession) DoneSize() int64 {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for i := len(d.DoneMap) - 1; i >= 0; i-- {
+		if d.DoneMap[i] {
+			offset, length := d.Block(i)
+			return offset + length
+		}
+	}
+	return 0
+}
+
+// MarkBlockDone records a completed block and persists the session
+func (d *DownloadSession) MarkBlockDone(i int) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if i < 0 || i >= len(d.DoneMap) {
+		return fmt.Errorf("invalid block: %d (total: %d)", i, len(d.DoneMap))
+	}
+
+	d.DoneMap[i] = true
+	d.LastModified = time.Now()
+	return d.save()
+}
+
+// Delete removes the persisted session
+func (d *DownloadSession) Delete() error {
+	if err := os.Remove(d.stateFile); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to delete download session: %w", err)
+	}
+	return nil
+}
+
+// save writes the session to its state file. Callers must hold d.mu or
+// have exclusive access to d.
+func (d *DownloadSession) save() error {
+	data, err := json.MarshalIndent(d, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(d.stateFile, data, 0644)
+}
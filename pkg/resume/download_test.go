@@ -0,0 +1,77 @@
+package resume
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestDownloadSessionResumes(t *testing.T) {
+	stateFile := filepath.Join(t.TempDir(), "a.bin.part.json")
+	d, err := NewDownloadSession(stateFile, "/a.bin", `"1-a"`, 10, 4)
+	if err != nil {
+		t.Fatalf("NewDownloadSession() error = %v", err)
+	}
+	if offset, length := d.Block(2); offset != 8 || length != 2 {
+		t.Errorf("Block(2) = %d, %d, want the short last block at 8", offset, length)
+	}
+
+	// A download interrupted after writing blocks 0 and 2
+	for _, block := range []int{0, 2} {
+		if err := d.MarkBlockDone(block); err != nil {
+			t.Fatalf("MarkBlockDone(%d) error = %v", block, err)
+		}
+	}
+	if err := d.MarkBlockDone(3); err == nil {
+		t.Error("MarkBlockDone() accepted a block past the end")
+	}
+
+	loaded, err := LoadDownloadSession(stateFile)
+	if err != nil {
+		t.Fatalf("LoadDownloadSession() error = %v", err)
+	}
+	if loaded.RemotePath != "/a.bin" || loaded.ETag != `"1-a"` || loaded.Size != 10 {
+		t.Errorf("loaded session = %+v", loaded)
+	}
+	if missing := loaded.MissingBlocks(); !slices.Equal(missing, []int{1}) {
+		t.Errorf("MissingBlocks() = %v, want [1]", missing)
+	}
+
+	if err := loaded.MarkBlockDone(1); err != nil {
+		t.Fatalf("MarkBlockDone(1) error = %v", err)
+	}
+	if err := loaded.Delete(); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	if _, err := LoadDownloadSession(stateFile); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("LoadDownloadSession() after Delete() error = %v, want fs.ErrNotExist", err)
+	}
+}
+
+func TestLoadDownloadSessionRejectsCorruptState(t *testing.T) {
+	tests := []struct {
+		name  string
+		state string
+	}{
+		{"truncated", `{"remote_path": "/a.bin", "size": 10, "block_s`},
+		{"not json", "\x00\x00\x00"},
+		{"no block size", `{"remote_path": "/a.bin", "size": 10, "block_size": 0, "done_map": []}`},
+		{"short done map", `{"remote_path": "/a.bin", "size": 10, "block_size": 4, "done_map": [true, false]}`},
+		{"long done map", `{"remote_path": "/a.bin", "size": 10, "block_size": 4, "done_map": [true, false, true, true]}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stateFile := filepath.Join(t.TempDir(), "a.bin.part.json")
+			if err := os.WriteFile(stateFile, []byte(tt.state), 0644); err != nil {
+				t.Fatal(err)
+			}
+			_, err := LoadDownloadSession(stateFile)
+			if err == nil || errors.Is(err, fs.ErrNotExist) {
+				t.Errorf("LoadDownloadSession() error = %v, want a corrupt state error", err)
+			}
+		})
+	}
+}
@@ -1,31 +1,40 @@
 package resume
 
 import (
+	"crypto/rand"
 	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
 	"sync"
 	"time"
+
+	"github.com/0xRepo-Source/goflux/pkg/checksum"
 )
 
 // UploadSession tracks the state of a partial upload
 type UploadSession struct {
-	Path         string    `json:"path"`          // destination path
-	TotalChunks  int       `json:"total_chunks"`  // expected number of chunks
-	ChunkSize    int       `json:"chunk_size"`    // size of each chunk
-	FileHash     string    `json:"file_hash"`     // SHA-256 of complete file (optional)
-	ReceivedMap  []bool    `json:"received_map"`  // bitmap of received chunks
-	CreatedAt    time.Time `json:"created_at"`    // when upload started
-	LastModified time.Time `json:"last_modified"` // last chunk received
-	Completed    bool      `json:"completed"`     // upload completed
+	ID           string             `json:"id"`                      // upload ID the client refers to
+	Path         string             `json:"path"`                    // destination path
+	User         string             `json:"user,omitempty"`          // authenticated user that created the upload
+	TotalChunks  int                `json:"total_chunks"`            // expected number of chunks
+	ChunkSize    int                `json:"chunk_size"`              // size of each chunk
+	FileHash     string             `json:"file_hash"`               // SHA-256 of complete file (optional)
+	Checksum     checksum.Algorithm `json:"checksum"`                // algorithm of the chunk checksums
+	ReceivedMap  []bool             `json:"received_map"`            // bitmap of received chunks
+	ChunkHashes  []string           `json:"chunk_hashes"`            // verified tagged checksum of each received chunk
+	ChunkOffsets []int64            `json:"chunk_offsets,omitempty"` // declared offset of each chunk, -1 if unknown
+	CreatedAt    time.Time          `json:"created_at"`              // when upload started
+	LastModified time.Time          `json:"last_modified"`           // last chunk received
+	Completed    bool               `json:"completed"`               // all chunks received and the file being stored
 }
 
 // SessionStore manages upload sessions with persistence
 type SessionStore struct {
-	sessions map[string]*UploadSession // keyed by upload ID (hash of path)
+	sessions map[string]*UploadSession // keyed by upload ID
 	metaDir  string                    // directory for metadata files
 	mu       sync.RWMutex
 }
@@ -50,12 +59,43 @@ func NewSessionStore(metaDir string) (*SessionStore, error) {
 	return store, nil
 }
 
-// GetOrCreateSession gets an existing session or creates a new one
-func (s *SessionStore) GetOrCreateSession(path string, totalChunks, chunkSize int) (*UploadSession, error) {
+// ErrFileChanged is returned when a chunk arrives for an existing session
+// but names a different whole-file hash, i.e. the source file was modified
+// since the upload started.
+var ErrFileChanged = errors.New("file hash differs from existing upload session")
+
+// ErrSessionNotFound is returned for an unknown upload ID.
+var ErrSessionNotFound = errors.New("upload session not found")
+
+// CreateSession starts a new upload with a random upload ID. fileHash is
+// the SHA-256 of the complete file and may be empty; alg is the algorithm
+// of the chunk checksums; user is the authenticated user starting the
+// upload, if any.
+func (s *SessionStore) CreateSession(path string, totalChunks, chunkSize int, fileHash string, alg checksum.Algorithm, user string) (*UploadSession, error) {
+	if totalChunks < 0 {
+		return nil, fmt.Errorf("invalid chunk count: %d", totalChunks)
+	}
+
+	idBytes := make([]byte, 16)
+	if _, err := rand.Read(idBytes); err != nil {
+		return nil, fmt.Errorf("failed to generate upload ID: %w", err)
+	}
+
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	sessionID := s.makeSessionID(path)
+	return s.createLocked(hex.EncodeToString(idBytes), path, totalChunks, chunkSize, fileHash, alg, user)
+}
+
+// GetOrCreateSession gets or creates the session for a client that does not
+// use upload IDs. Such sessions are keyed by LegacySessionID(path), so
+// concurrent uploads to the same path share one session, and their chunks
+// carry SHA-256 checksums.
+func (s *SessionStore) GetOrCreateSession(path string, totalChunks, chunkSize int, fileHash string) (*UploadSession, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sessionID := LegacySessionID(path)
 
 	// Check if session exists
 	if session, exists := s.sessions[sessionID]; exists {
@@ -63,46 +103,82 @@ func (s *SessionStore) GetOrCreateSession(path string, totalChunks, chunkSize in
 		if session.TotalChunks != totalChunks {
 			return nil, fmt.Errorf("chunk count mismatch: session has %d, request has %d", session.TotalChunks, totalChunks)
 		}
-		return session, nil
+		if fileHash != "" && session.FileHash != fileHash {
+			if session.FileHash != "" {
+				return nil, ErrFileChanged
+			}
+			session.FileHash = fileHash
+			if err := s.saveSession(sessionID, session); err != nil {
+				return nil, fmt.Errorf("failed to save session: %w", err)
+			}
+		}
+		return session.clone(), nil
 	}
 
-	// Create new session
+	return s.createLocked(sessionID, path, totalChunks, chunkSize, fileHash, checksum.SHA256, "")
+}
+
+// createLocked registers and persists a new session. Callers must hold s.mu.
+func (s *SessionStore) createLocked(id, path string, totalChunks, chunkSize int, fileHash string, alg checksum.Algorithm, user string) (*UploadSession, error) {
 	session := &UploadSession{
+		ID:           id,
 		Path:         path,
+		User:         user,
 		TotalChunks:  totalChunks,
 		ChunkSize:    chunkSize,
+		FileHash:     fileHash,
+		Checksum:     alg,
 		ReceivedMap:  make([]bool, totalChunks),
+		ChunkHashes:  make([]string, totalChunks),
 		CreatedAt:    time.Now(),
 		LastModified: time.Now(),
 		Completed:    false,
 	}
 
-	s.sessions[sessionID] = session
+	s.sessions[id] = session
 
 	// Persist to disk
-	if err := s.saveSession(sessionID, session); err != nil {
+	if err := s.saveSession(id, session); err != nil {
+		delete(s.sessions, id)
 		return nil, fmt.Errorf("failed to save session: %w", err)
 	}
 
-	return session, nil
+	return session.clone(), nil
 }
 
-// MarkChunkReceived marks a chunk as received
-func (s *SessionStore) MarkChunkReceived(path string, chunkID int) error {
+// MarkChunkReceived marks a chunk as received and records its verified hash
+// and the offset the client declared for it (-1 if none). It reports
+// whether this call completed the upload, which is true for exactly one
+// caller even when chunks arrive concurrently.
+func (s *SessionStore) MarkChunkReceived(id string, chunkID int, hash string, offset int64) (bool, error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	sessionID := s.makeSessionID(path)
-	session, exists := s.sessions[sessionID]
+	session, exists := s.sessions[id]
 	if !exists {
-		return fmt.Errorf("session not found for path: %s", path)
+		return false, ErrSessionNotFound
 	}
 
 	if chunkID < 0 || chunkID >= session.TotalChunks {
-		return fmt.Errorf("invalid chunk ID: %d (total: %d)", chunkID, session.TotalChunks)
+		return false, fmt.Errorf("invalid chunk ID: %d (total: %d)", chunkID, session.TotalChunks)
+	}
+
+	// Sessions persisted before chunk hashes were tracked have no slice yet
+	if len(session.ChunkHashes) != session.TotalChunks {
+		session.ChunkHashes = make([]string, session.TotalChunks)
 	}
 
 	session.ReceivedMap[chunkID] = true
+	session.ChunkHashes[chunkID] = hash
+	if offset >= 0 && session.ChunkOffsets == nil {
+		session.ChunkOffsets = make([]int64, session.TotalChunks)
+		for i := range session.ChunkOffsets {
+			session.ChunkOffsets[i] = -1
+		}
+	}
+	if session.ChunkOffsets != nil {
+		session.ChunkOffsets[chunkID] = offset
+	}
 	session.LastModified = time.Now()
 
 	// Check if all chunks received
@@ -113,32 +189,79 @@ func (s *SessionStore) MarkChunkReceived(path string, chunkID int) error {
 			break
 		}
 	}
+	justCompleted := allReceived && !session.Completed
 	session.Completed = allReceived
 
 	// Persist to disk
-	return s.saveSession(sessionID, session)
+	if err := s.saveSession(id, session); err != nil {
+		return false, err
+	}
+	return justCompleted, nil
 }
 
-// GetSession retrieves a session by path
-func (s *SessionStore) GetSession(path string) (*UploadSession, bool) {
+// MarkChunkMissing clears a chunk that was received but can no longer be
+// trusted, so the client sends it again on resume
+func (s *SessionStore) MarkChunkMissing(id string, chunkID int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	session, exists := s.sessions[id]
+	if !exists {
+		return ErrSessionNotFound
+	}
+
+	if chunkID < 0 || chunkID >= session.TotalChunks {
+		return fmt.Errorf("invalid chunk ID: %d (total: %d)", chunkID, session.TotalChunks)
+	}
+
+	session.ReceivedMap[chunkID] = false
+	if chunkID < len(session.ChunkHashes) {
+		session.ChunkHashes[chunkID] = ""
+	}
+	if chunkID < len(session.ChunkOffsets) {
+		session.ChunkOffsets[chunkID] = -1
+	}
+	session.Completed = false
+	session.LastModified = time.Now()
+
+	return s.saveSession(id, session)
+}
+
+// MarkIncomplete clears the completion of an upload whose file could not be
+// stored, so the next chunk received for it completes it again
+func (s *SessionStore) MarkIncomplete(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	session, exists := s.sessions[id]
+	if !exists {
+		return ErrSessionNotFound
+	}
+	session.Completed = false
+	return s.saveSession(id, session)
+}
+
+// GetSession retrieves a snapshot of a session by upload ID
+func (s *SessionStore) GetSession(id string) (*UploadSession, bool) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
-	sessionID := s.makeSessionID(path)
-	session, exists := s.sessions[sessionID]
-	return session, exists
+	session, exists := s.sessions[id]
+	if !exists {
+		return nil, false
+	}
+	return session.clone(), true
 }
 
 // DeleteSession removes a completed session
-func (s *SessionStore) DeleteSession(path string) error {
+func (s *SessionStore) DeleteSession(id string) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	sessionID := s.makeSessionID(path)
-	delete(s.sessions, sessionID)
+	delete(s.sessions, id)
 
 	// Delete metadata file
-	metaFile := filepath.Join(s.metaDir, sessionID+".json")
+	metaFile := filepath.Join(s.metaDir, id+".json")
 	if err := os.Remove(metaFile); err != nil && !os.IsNotExist(err) {
 		return fmt.Errorf("failed to delete session file: %w", err)
 	}
@@ -147,14 +270,13 @@ func (s *SessionStore) DeleteSession(path string) error {
 }
 
 // GetMissingChunks returns a list of chunk IDs that haven't been received
-func (s *SessionStore) GetMissingChunks(path string) ([]int, error) {
+func (s *SessionStore) GetMissingChunks(id string) ([]int, error) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
-	sessionID := s.makeSessionID(path)
-	session, exists := s.sessions[sessionID]
+	session, exists := s.sessions[id]
 	if !exists {
-		return nil, fmt.Errorf("session not found for path: %s", path)
+		return nil, ErrSessionNotFound
 	}
 
 	missing := []int{}
@@ -197,8 +319,21 @@ func (s *SessionStore) CleanupOldSessions(maxAge time.Duration) error {
 	return nil
 }
 
-// makeSessionID creates a unique session ID from the path
-func (s *SessionStore) makeSessionID(path string) string {
+// clone returns a copy of the session that is safe to read while the
+// store keeps updating the original
+func (u *UploadSession) clone() *UploadSession {
+	c := *u
+	c.ReceivedMap = append([]bool(nil), u.ReceivedMap...)
+	c.ChunkHashes = append([]string(nil), u.ChunkHashes...)
+	if u.ChunkOffsets != nil {
+		c.ChunkOffsets = append([]int64(nil), u.ChunkOffsets...)
+	}
+	return &c
+}
+
+// LegacySessionID derives the session ID used for clients that upload by
+// path without creating an upload first.
+func LegacySessionID(path string) string {
 	hash := sha256.Sum256([]byte(path))
 	return hex.EncodeToString(hash[:])[:16] // Use first 16 chars
 }
@@ -226,7 +361,7 @@ func (s *SessionStore) loadSessions() error {
 	}
 
 	for _, file := range files {
-		if filepath.Ext(file.Name()) != ".json" {
+		if filepath.Ext(file.Name()) != ".json" || file.Name() == chunkIndexFile {
 			continue
 		}
 
@@ -245,6 +380,22 @@ func (s *SessionStore) loadSessions() error {
 			continue
 		}
 
+		// Sessions saved before upload IDs existed don't record their ID
+		if session.ID == "" {
+			session.ID = sessionID
+		}
+		// ...nor their checksum algorithm, which was always SHA-256
+		if session.Checksum == "" {
+			session.Checksum = checksum.SHA256
+			for i, hash := range session.ChunkHashes {
+				if hash != "" {
+					session.ChunkHashes[i], _ = checksum.Normalize(hash)
+				}
+			}
+		}
+		// Stored uploads are deleted, so a completed one was interrupted
+		// while it was stored and has to be completed again
+		session.Completed = false
 		s.sessions[sessionID] = &session
 	}
 
@@ -0,0 +1,123 @@
+package resume
+
+import (
+	"testing"
+
+	"github.com/0xRepo-Source/goflux/pkg/checksum"
+)
+
+func TestCreateSessionIsolatesUploads(t *testing.T) {
+	store, err := NewSessionStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewSessionStore() error = %v", err)
+	}
+
+	a, err := store.CreateSession("/data/a.bin", 2, 4, "", checksum.SHA256, "alice")
+	if err != nil {
+		t.Fatalf("CreateSession() error = %v", err)
+	}
+	b, err := store.CreateSession("/data/a.bin", 2, 4, "", checksum.SHA256, "bob")
+	if err != nil {
+		t.Fatalf("CreateSession() error = %v", err)
+	}
+	if a.ID == b.ID {
+		t.Fatalf("uploads to the same path share ID %s", a.ID)
+	}
+
+	if _, err := store.MarkChunkReceived(a.ID, 0, "h0", 0); err != nil {
+		t.Fatalf("MarkChunkReceived() error = %v", err)
+	}
+	missing, err := store.GetMissingChunks(b.ID)
+	if err != nil {
+		t.Fatalf("GetMissingChunks() error = %v", err)
+	}
+	if len(missing) != 2 {
+		t.Errorf("GetMissingChunks(b) = %v, want both chunks missing", missing)
+	}
+}
+
+func TestSessionsSurviveReload(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewSessionStore(dir)
+	if err != nil {
+		t.Fatalf("NewSessionStore() error = %v", err)
+	}
+
+	// The chunk index shares the directory but is not a session
+	index, err := NewChunkIndex(dir)
+	if err != nil {
+		t.Fatalf("NewChunkIndex() error = %v", err)
+	}
+	if err := index.Record("/a", []string{"h0"}, []ChunkLocation{{Path: "/a", Size: 4}}); err != nil {
+		t.Fatalf("Record() error = %v", err)
+	}
+
+	created, err := store.CreateSession("/a", 3, 4, "", checksum.BLAKE3, "")
+	if err != nil {
+		t.Fatalf("CreateSession() error = %v", err)
+	}
+	legacy, err := store.GetOrCreateSession("/b", 3, 4, "")
+	if err != nil {
+		t.Fatalf("GetOrCreateSession() error = %v", err)
+	}
+	if legacy.ID != LegacySessionID("/b") {
+		t.Errorf("legacy session ID = %s, want %s", legacy.ID, LegacySessionID("/b"))
+	}
+	if _, err := store.MarkChunkReceived(created.ID, 1, "h1", 4); err != nil {
+		t.Fatalf("MarkChunkReceived() error = %v", err)
+	}
+
+	reloaded, err := NewSessionStore(dir)
+	if err != nil {
+		t.Fatalf("NewSessionStore() error = %v", err)
+	}
+	session, ok := reloaded.GetSession(created.ID)
+	if !ok {
+		t.Fatalf("session %s not reloaded", created.ID)
+	}
+	if session.Path != "/a" || !session.ReceivedMap[1] || session.Checksum != checksum.BLAKE3 {
+		t.Errorf("reloaded session = %+v, want blake3 upload to /a with chunk 1 received", session)
+	}
+	if _, ok := reloaded.GetSession(legacy.ID); !ok {
+		t.Errorf("legacy session %s not reloaded", legacy.ID)
+	}
+	if _, ok := reloaded.GetSession("chunk-index"); ok {
+		t.Error("chunk index loaded as an upload session")
+	}
+}
+
+func TestIncompleteUploadCompletesAgain(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewSessionStore(dir)
+	if err != nil {
+		t.Fatalf("NewSessionStore() error = %v", err)
+	}
+	s, err := store.CreateSession("/a.bin", 1, 4, "", checksum.SHA256, "")
+	if err != nil {
+		t.Fatalf("CreateSession() error = %v", err)
+	}
+
+	if done, err := store.MarkChunkReceived(s.ID, 0, "h0", 0); err != nil || !done {
+		t.Fatalf("MarkChunkReceived() = %v, %v, want the upload completed", done, err)
+	}
+	if done, _ := store.MarkChunkReceived(s.ID, 0, "h0", 0); done {
+		t.Error("a duplicate chunk completed the upload twice")
+	}
+
+	// A failed store makes the next chunk complete the upload again
+	if err := store.MarkIncomplete(s.ID); err != nil {
+		t.Fatalf("MarkIncomplete() error = %v", err)
+	}
+	if done, _ := store.MarkChunkReceived(s.ID, 0, "h0", 0); !done {
+		t.Error("chunk after MarkIncomplete() didn't complete the upload")
+	}
+
+	// So does a restart while it was being stored
+	reloaded, err := NewSessionStore(dir)
+	if err != nil {
+		t.Fatalf("NewSessionStore() error = %v", err)
+	}
+	if done, _ := reloaded.MarkChunkReceived(s.ID, 0, "h0", 0); !done {
+		t.Error("chunk after a reload didn't complete the upload")
+	}
+}
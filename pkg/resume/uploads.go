@@ -0,0 +1,133 @@
+package resume
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// UploadRecord remembers the upload ID the server issued for an upload, so
+// an interrupted upload of the same file can be resumed later.
+type UploadRecord struct {
+	Server     string    `json:"server"`      // server the upload was created on
+	RemotePath string    `json:"remote_path"` // destination path on the server
+	FileHash   string    `json:"file_hash"`   // SHA-256 of the local file
+	UploadID   string    `json:"upload_id"`   // ID returned by the server
+	CreatedAt  time.Time `json:"created_at"`  // when the upload was created
+}
+
+// UploadIndex is the client's record of unfinished uploads, persisted as a
+// JSON file.
+type UploadIndex struct {
+	file    string // empty for an index that is not persisted
+	records []UploadRecord
+	mu      sync.Mutex
+}
+
+// DefaultUploadIndexPath returns the location of the upload index in the
+// user's cache directory.
+func DefaultUploadIndexPath() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "goflux", "uploads.json"), nil
+}
+
+// OpenUploadIndex loads the upload index stored in file, which need not
+// exist yet. An empty file name gives an index kept in memory only.
+func OpenUploadIndex(file string) (*UploadIndex, error) {
+	idx := &UploadIndex{file: file}
+	if file == "" {
+		return idx, nil
+	}
+
+	data, err := os.ReadFile(file)
+	if os.IsNotExist(err) {
+		return idx, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read upload index: %w", err)
+	}
+
+	if err := json.Unmarshal(data, &idx.records); err != nil {
+		return nil, fmt.Errorf("failed to parse upload index %s: %w", file, err)
+	}
+	return idx, nil
+}
+
+// Lookup returns the upload ID recorded for uploading the file with the
+// given hash to remotePath on server, or "" if there is none.
+func (idx *UploadIndex) Lookup(server, remotePath, fileHash string) string {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	for _, r := range idx.records {
+		if r.Server == server && r.RemotePath == remotePath && r.FileHash == fileHash {
+			return r.UploadID
+		}
+	}
+	return ""
+}
+
+// Remember records the upload ID for an upload, replacing any earlier one
+// for the same server, path and file.
+func (idx *UploadIndex) Remember(server, remotePath, fileHash, uploadID string) error {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	idx.remove(server, remotePath, fileHash)
+	idx.records = append(idx.records, UploadRecord{
+		Server:     server,
+		RemotePath: remotePath,
+		FileHash:   fileHash,
+		UploadID:   uploadID,
+		CreatedAt:  time.Now(),
+	})
+	return idx.save()
+}
+
+// Forget drops the record of a finished or abandoned upload
+func (idx *UploadIndex) Forget(server, remotePath, fileHash string) error {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	if !idx.remove(server, remotePath, fileHash) {
+		return nil
+	}
+	return idx.save()
+}
+
+// remove deletes matching records and reports whether there were any.
+// Callers must hold idx.mu.
+func (idx *UploadIndex) remove(server, remotePath, fileHash string) bool {
+	kept := idx.records[:0]
+	for _, r := range idx.records {
+		if r.Server != server || r.RemotePath != remotePath || r.FileHash != fileHash {
+			kept = append(kept, r)
+		}
+	}
+	removed := len(kept) != len(idx.records)
+	idx.records = kept
+	return removed
+}
+
+// save writes the index to its file. Callers must hold idx.mu.
+func (idx *UploadIndex) save() error {
+	if idx.file == "" {
+		return nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(idx.file), 0755); err != nil {
+		return fmt.Errorf("failed to create upload index directory: %w", err)
+	}
+
+	data, err := json.MarshalIndent(idx.records, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(idx.file, data, 0600)
+}
@@ -0,0 +1,140 @@
+package server
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/0xRepo-Source/goflux/pkg/delta"
+	"github.com/0xRepo-Source/goflux/pkg/proto"
+)
+
+// deltaPermissions are required for GET /signature and POST /delta. A
+// signature tells a client the content of a stored file block by block,
+// and a delta copies from it, so both read as well as write.
+var deltaPermissions = []string{"download", "upload"}
+
+// handleSignature answers GET /signature with the proto.Signature of a
+// stored file, made with the block_size parameter or one suited to the
+// file's size.
+func (s *Server) handleSignature(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	path := query.Get("path")
+	if path == "" {
+		writeError(w, http.StatusBadRequest, errors.New("path required"))
+		return
+	}
+
+	file, err := s.storage.Open(path)
+	if err != nil {
+		writeStorageError(w, err, path, path)
+		return
+	}
+	defer file.Close()
+
+	blockSize := delta.BlockSizeFor(file.Size())
+	if v := query.Get("block_size"); v != "" {
+		if blockSize, err = strconv.Atoi(v); err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid block_size: %w", err))
+			return
+		}
+	}
+	if err := delta.CheckBlockSize(blockSize); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	sig, err := delta.Sign(file, blockSize)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, fmt.Errorf("failed to sign %s: %w", path, err))
+		return
+	}
+	sig.ETag = etagFor(sig.FileHash)
+	writeJSON(w, sig)
+}
+
+// handleDelta answers POST /delta, rebuilding a new version of a stored
+// file from the delta in the body and the version its signature was made
+// from. The new version is streamed into storage, which only replaces the
+// file once it is complete and matches the declared size and hash.
+func (s *Server) handleDelta(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	path := query.Get("path")
+	etag := query.Get("etag")
+	fileHash := query.Get("file_hash")
+	if path == "" || etag == "" || fileHash == "" {
+		writeError(w, http.StatusBadRequest, errors.New("path, etag and file_hash required"))
+		return
+	}
+	blockSize, err := strconv.Atoi(query.Get("block_size"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid block_size: %w", err))
+		return
+	}
+	if err := delta.CheckBlockSize(blockSize); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	size, err := strconv.ParseInt(query.Get("size"), 10, 64)
+	if err != nil || size < 0 {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid size %q", query.Get("size")))
+		return
+	}
+	if s.maxFileSize > 0 && size > s.maxFileSize {
+		writeError(w, http.StatusRequestEntityTooLarge, fmt.Errorf("file exceeds limit of %d bytes", s.maxFileSize))
+		return
+	}
+
+	base, err := s.storage.Open(path)
+	if err != nil {
+		writeStorageError(w, err, path, path)
+		return
+	}
+	defer base.Close()
+	hash, err := base.Hash()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if etagFor(hash) != etag {
+		proto.WriteError(w, http.StatusPreconditionFailed, proto.CodeChanged, fmt.Sprintf("%s changed since its signature was made", path))
+		return
+	}
+
+	// The patch writes into a pipe that storage reads from. Failing the
+	// pipe makes storage discard what it has, and storage failing closes
+	// the pipe so the patch stops.
+	pr, pw := io.Pipe()
+	patched := make(chan delta.Stats, 1)
+	go func() {
+		stats, err := delta.Patch(base, base.Size(), blockSize, r.Body, pw)
+		pw.CloseWithError(err)
+		patched <- stats
+	}()
+
+	// A version shorter than declared fails the hash check
+	var src io.Reader = &hashCheckReader{r: pr, hasher: sha256.New(), want: fileHash}
+	src = &sizeLimitReader{r: src, limit: size}
+	stored, err := s.storage.PutStream(path, src)
+	pr.Close()
+	stats := <-patched
+	if err != nil {
+		var mismatch *fileHashError
+		var tooLarge *fileSizeError
+		switch {
+		case errors.Is(err, delta.ErrInvalid):
+			writeError(w, http.StatusBadRequest, err)
+		case errors.As(err, &mismatch) || errors.As(err, &tooLarge):
+			writeError(w, StatusFileHashMismatch, fmt.Errorf("delta rejected: %w", err))
+		default:
+			writeStorageError(w, err, path, path)
+		}
+		return
+	}
+
+	fmt.Printf("File saved from delta: %s (%d bytes, %d sent, %d reused)\n", path, stored, stats.Literal, stats.Copied)
+	writeJSON(w, proto.DeltaResult{Size: stored, LiteralBytes: stats.Literal, CopiedBytes: stats.Copied})
+}
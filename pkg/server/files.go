@@ -0,0 +1,189 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"strconv"
+
+	"github.com/0xRepo-Source/goflux/pkg/proto"
+	"github.com/0xRepo-Source/goflux/pkg/storage"
+)
+
+// handleDelete answers POST /delete, removing a file or, if the request is
+// recursive, a directory with everything below it.
+func (s *Server) handleDelete(w http.ResponseWriter, r *http.Request) {
+	var req proto.DeleteRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if req.Path == "" {
+		writeError(w, http.StatusBadRequest, errors.New("path required"))
+		return
+	}
+
+	if err := s.storage.Delete(req.Path, req.Recursive); err != nil {
+		writeStorageError(w, err, req.Path, req.Path)
+		return
+	}
+	fmt.Printf("Deleted: %s\n", req.Path)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleMove answers POST /move, moving a file or directory.
+func (s *Server) handleMove(w http.ResponseWriter, r *http.Request) {
+	s.handleTransfer(w, r, s.storage.Rename, "Moved")
+}
+
+// handleCopy answers POST /copy, copying a file or directory tree.
+func (s *Server) handleCopy(w http.ResponseWriter, r *http.Request) {
+	s.handleTransfer(w, r, s.storage.Copy, "Copied")
+}
+
+// handleTransfer decodes a proto.MoveRequest and applies op to it
+func (s *Server) handleTransfer(w http.ResponseWriter, r *http.Request, op func(src, dst string) error, verb string) {
+	var req proto.MoveRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if req.Source == "" || req.Destination == "" {
+		writeError(w, http.StatusBadRequest, errors.New("source and destination required"))
+		return
+	}
+
+	if err := op(req.Source, req.Destination); err != nil {
+		writeStorageError(w, err, req.Source, req.Destination)
+		return
+	}
+	fmt.Printf("%s: %s -> %s\n", verb, req.Source, req.Destination)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleMkdir answers POST /mkdir, creating a directory and its parents.
+func (s *Server) handleMkdir(w http.ResponseWriter, r *http.Request) {
+	var req proto.MkdirRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if req.Path == "" {
+		writeError(w, http.StatusBadRequest, errors.New("path required"))
+		return
+	}
+
+	if err := s.storage.Mkdir(req.Path); err != nil {
+		writeStorageError(w, err, req.Path, req.Path)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleSetAttr answers POST /setattr, setting the mode and modification
+// time of a file or directory.
+func (s *Server) handleSetAttr(w http.ResponseWriter, r *http.Request) {
+	var req proto.SetAttrRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if req.Path == "" {
+		writeError(w, http.StatusBadRequest, errors.New("path required"))
+		return
+	}
+
+	if err := s.storage.SetAttr(req.Path, fs.FileMode(req.Mode).Perm(), req.ModTime); err != nil {
+		writeStorageError(w, err, req.Path, req.Path)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// writeStorageError answers a request whose storage operation failed. src
+// is named when it's missing and dst when it's in the way; the storage
+// error itself isn't shown for those, as it may contain server paths.
+func writeStorageError(w http.ResponseWriter, err error, src, dst string) {
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		proto.WriteError(w, http.StatusNotFound, proto.CodeNotFound, fmt.Sprintf("%s not found", src))
+	case errors.Is(err, fs.ErrExist):
+		proto.WriteError(w, http.StatusConflict, proto.CodeExists, fmt.Sprintf("%s already exists", dst))
+	case errors.Is(err, storage.ErrNotEmpty):
+		proto.WriteError(w, http.StatusConflict, proto.CodeNotEmpty, fmt.Sprintf("%s is not empty", src))
+	case errors.Is(err, storage.ErrInvalidPath):
+		writeError(w, http.StatusBadRequest, err)
+	default:
+		writeError(w, http.StatusInternalServerError, err)
+	}
+}
+
+// handleStat answers GET /stat with the proto.FileInfo of a path.
+func (s *Server) handleStat(w http.ResponseWriter, r *http.Request) {
+	path := r.URL.Query().Get("path")
+	if path == "" {
+		path = "/"
+	}
+
+	info, err := s.storage.Stat(path)
+	if err != nil {
+		writeStorageError(w, err, path, path)
+		return
+	}
+	writeJSON(w, fileInfo(*info))
+}
+
+// listDetails answers a detailed listing with a page of proto.FileInfo
+// entries, sorted by the sort and reverse parameters and paged by offset
+// and limit.
+func (s *Server) listDetails(w http.ResponseWriter, r *http.Request, path string) {
+	query := r.URL.Query()
+	var offset, limit int
+	if v := query.Get("offset"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid offset %q", v))
+			return
+		}
+		offset = n
+	}
+	if v := query.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid limit %q", v))
+			return
+		}
+		limit = n
+	}
+	reverse, _ := strconv.ParseBool(query.Get("reverse"))
+
+	infos, err := s.storage.ReadDir(path)
+	if err != nil {
+		writeStorageError(w, err, path, path)
+		return
+	}
+	entries := make([]proto.FileInfo, len(infos))
+	for i, info := range infos {
+		entries[i] = fileInfo(info)
+	}
+	if err := proto.SortFileInfos(entries, query.Get("sort"), reverse); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	writeJSON(w, proto.NewListing(path, entries, offset, limit))
+}
+
+// fileInfo converts a storage.FileInfo to its wire form
+func fileInfo(info storage.FileInfo) proto.FileInfo {
+	return proto.FileInfo{
+		Name:    info.Name,
+		Size:    info.Size,
+		Mode:    uint32(info.Mode.Perm()),
+		ModTime: info.ModTime,
+		IsDir:   info.IsDir,
+		Hash:    info.Hash,
+	}
+}
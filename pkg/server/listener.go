@@ -0,0 +1,152 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Listener accepts connections on one transport and answers them until it
+// fails. A server can serve any number of listeners at once.
+type Listener interface {
+	// Serve answers connections with handler, the goflux HTTP API.
+	// Transports that don't carry HTTP, like ssh, serve the storage backend
+	// their own way and ignore it.
+	Serve(handler http.Handler) error
+
+	// String describes the listener, e.g. "https 0.0.0.0:443".
+	String() string
+}
+
+// Transports lists the transports Listen accepts.
+var Transports = []string{"http", "https", "unix", "quic", "ssh"}
+
+// Listen adds a listener for a built-in transport: http and https on a TCP
+// address, unix on a socket path, quic on a UDP address and ssh, which
+// serves SFTP, on a TCP address. https and quic need EnableTLS and ssh
+// needs EnableSSH to have been called.
+func (s *Server) Listen(transport, addr string) error {
+	var l Listener
+	switch transport {
+	case "http":
+		l = &tcpListener{server: s, addr: addr}
+	case "https":
+		if s.certs == nil {
+			return fmt.Errorf("%s listener on %s requires TLS", transport, addr)
+		}
+		l = &tcpListener{server: s, addr: addr, tls: true}
+	case "unix":
+		l = &unixListener{path: addr}
+	case "quic":
+		if s.certs == nil {
+			return fmt.Errorf("%s listener on %s requires TLS", transport, addr)
+		}
+		l = &quicListener{server: s, addr: addr}
+	case "ssh":
+		if s.sshConfig == nil {
+			return fmt.Errorf("ssh listener on %s requires ssh to be enabled", addr)
+		}
+		l = &sshListener{server: s, addr: addr}
+	default:
+		return fmt.Errorf("unknown transport %q (use %s)", transport, strings.Join(Transports, ", "))
+	}
+	s.AddListener(l)
+	return nil
+}
+
+// AddListener makes Serve answer on l as well.
+func (s *Server) AddListener(l Listener) {
+	s.listeners = append(s.listeners, l)
+}
+
+// tcpListener serves HTTP, or HTTPS, on a TCP address
+type tcpListener struct {
+	server *Server
+	addr   string
+	tls    bool
+}
+
+func (l *tcpListener) Serve(handler http.Handler) error {
+	httpServer := &http.Server{Addr: l.addr, Handler: handler}
+	if !l.tls {
+		fmt.Printf("goflux server listening on %s\n", l.addr)
+		return httpServer.ListenAndServe()
+	}
+
+	httpServer.TLSConfig = l.server.tlsConfig()
+	fmt.Printf("goflux server listening on %s (TLS)\n", l.addr)
+	return httpServer.ListenAndServeTLS("", "")
+}
+
+func (l *tcpListener) String() string {
+	if l.tls {
+		return "https " + l.addr
+	}
+	return "http " + l.addr
+}
+
+// url returns the address of the listener as a URL
+func (l *tcpListener) url() string {
+	if l.tls {
+		return "https://" + l.addr
+	}
+	return "http://" + l.addr
+}
+
+// unixListener serves HTTP on a unix socket, for clients on the same host.
+// The socket is only accessible to the server's user and group: it is
+// created in a private directory and moved into place once its mode is
+// set, so it is never reachable with the mode the umask gives it.
+type unixListener struct {
+	path string
+}
+
+func (l *unixListener) Serve(handler http.Handler) error {
+	// Replace the socket left behind by a previous run, but nothing else
+	if info, err := os.Lstat(l.path); err == nil {
+		if info.Mode().Type() != fs.ModeSocket {
+			return fmt.Errorf("%s exists and is not a socket", l.path)
+		}
+		if err := os.Remove(l.path); err != nil {
+			return err
+		}
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+
+	// The directory is created with mode 0700, and next to the socket so
+	// the rename stays on one file system
+	dir, err := os.MkdirTemp(filepath.Dir(l.path), ".goflux-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+	tmpPath := filepath.Join(dir, "s")
+
+	ln, err := net.Listen("unix", tmpPath)
+	if err != nil {
+		return err
+	}
+	ln.(*net.UnixListener).SetUnlinkOnClose(false)
+	defer ln.Close()
+	if err := os.Chmod(tmpPath, 0660); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpPath, l.path); err != nil {
+		return err
+	}
+	defer os.Remove(l.path)
+	os.Remove(dir)
+
+	fmt.Printf("goflux server listening on %s (unix socket)\n", l.path)
+	return http.Serve(ln, handler)
+}
+
+func (l *unixListener) String() string {
+	return "unix " + l.path
+}
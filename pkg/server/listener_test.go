@@ -0,0 +1,79 @@
+package server
+
+import (
+	"context"
+	"io"
+	"io/fs"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestUnixListener(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "goflux.sock")
+	l := &unixListener{path: path}
+	go l.Serve(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "ok")
+	}))
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		info, err := os.Lstat(path)
+		if err == nil && info.Mode().Type() == fs.ModeSocket {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("socket not created: %v", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// The socket only appears once its mode is set, and nothing else is
+	// left beside it
+	info, err := os.Lstat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0660 {
+		t.Errorf("socket mode = %v, want 0660", info.Mode().Perm())
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("socket directory holds %d entries, want 1", len(entries))
+	}
+
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", path)
+		},
+	}}
+	resp, err := client.Get("http://unix/")
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	defer resp.Body.Close()
+	if body, _ := io.ReadAll(resp.Body); string(body) != "ok" {
+		t.Errorf("Get() = %q, want ok", body)
+	}
+}
+
+func TestUnixListenerKeepsOtherFiles(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "goflux.sock")
+	if err := os.WriteFile(path, []byte("data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	l := &unixListener{path: path}
+	if err := l.Serve(http.NotFoundHandler()); err == nil {
+		t.Fatal("Serve() replaced a regular file")
+	}
+	if data, err := os.ReadFile(path); err != nil || string(data) != "data" {
+		t.Errorf("file = %q, %v; want it unchanged", data, err)
+	}
+}
@@ -0,0 +1,293 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/0xRepo-Source/goflux/pkg/checksum"
+	"github.com/0xRepo-Source/goflux/pkg/proto"
+	"github.com/0xRepo-Source/goflux/pkg/resume"
+	"github.com/0xRepo-Source/goflux/pkg/storage"
+)
+
+// handleUploadManifest accepts the manifest of an upload on
+// POST /upload/{id}/manifest, before its chunks are sent, and answers which
+// chunks the client still has to send. If the storage backend already holds
+// the whole file it is linked into place and the upload completes without
+// any data being sent. Otherwise chunks the server holds in stored files,
+// or earlier in the same upload, are copied into the upload.
+func (s *Server) handleUploadManifest(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+
+	var manifest proto.Manifest
+	if err := json.NewDecoder(r.Body).Decode(&manifest); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	lock := s.acquireSessionLock(id)
+	defer s.releaseSessionLock(id, lock)
+	lock.Lock()
+	defer lock.Unlock()
+
+	session, status, err := s.lookupUpload(id, s.requestUser(r))
+	if err != nil {
+		writeError(w, status, err)
+		return
+	}
+	if len(manifest.ChunkHashes) != session.TotalChunks {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("manifest lists %d chunks, upload has %d", len(manifest.ChunkHashes), session.TotalChunks))
+		return
+	}
+	if s.maxFileSize > 0 && manifest.Size > s.maxFileSize {
+		writeError(w, http.StatusRequestEntityTooLarge, fmt.Errorf("file exceeds limit of %d bytes", s.maxFileSize))
+		return
+	}
+	if manifest.ChunkOffsets != nil && len(manifest.ChunkOffsets) != session.TotalChunks {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("manifest lists %d chunk offsets, upload has %d chunks", len(manifest.ChunkOffsets), session.TotalChunks))
+		return
+	}
+	fileHash := session.FileHash
+	if fileHash == "" {
+		fileHash = manifest.FileHash
+	} else if manifest.FileHash != "" && !strings.EqualFold(manifest.FileHash, fileHash) {
+		writeError(w, http.StatusBadRequest, errors.New("manifest file hash does not match upload"))
+		return
+	}
+
+	hashes := make([]string, len(manifest.ChunkHashes))
+	for i, hash := range manifest.ChunkHashes {
+		if hash == "" {
+			continue
+		}
+		alg, digest, err := checksum.Split(hash)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("chunk %d: %v", i, err))
+			return
+		}
+		if alg != session.Checksum {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("chunk %d has a %s checksum, upload uses %s", i, alg, session.Checksum))
+			return
+		}
+		hashes[i] = string(alg) + ":" + digest
+	}
+
+	sessionChunksDir := filepath.Join(s.chunksDir, id)
+	response := proto.ManifestResponse{MissingChunks: []int{}}
+
+	linked, err := s.linkFile(session.Path, fileHash, manifest.Size)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, fmt.Errorf("link failed: %v", err))
+		return
+	}
+	if linked {
+		fmt.Printf("File linked: %s (%d bytes)\n", session.Path, manifest.Size)
+		s.recordChunks(session.Path, hashes, manifestLocations(manifest, session.ChunkSize))
+		os.RemoveAll(sessionChunksDir)
+		if err := s.sessionStore.DeleteSession(id); err != nil {
+			fmt.Printf("Warning: failed to delete session metadata: %v\n", err)
+		}
+		response.Complete = true
+		response.Linked = true
+		writeJSON(w, response)
+		return
+	}
+
+	// Chunks already in this upload can stand in for identical ones, if
+	// their checksums can't be forged to match other data
+	local := make(map[string]string)
+	if session.Checksum.Cryptographic() {
+		for i, received := range session.ReceivedMap {
+			if received && i < len(session.ChunkHashes) && session.ChunkHashes[i] != "" {
+				local[session.ChunkHashes[i]] = filepath.Join(sessionChunksDir, chunkFileName(i))
+			}
+		}
+	}
+
+	completed := false
+	for i, hash := range hashes {
+		if session.ReceivedMap[i] || hash == "" {
+			continue
+		}
+		offset := int64(-1)
+		if manifest.ChunkOffsets != nil {
+			offset = manifest.ChunkOffsets[i]
+		}
+		reused, done, err := s.reuseChunk(id, session.Checksum, i, hash, offset, sessionChunksDir, local)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		if reused {
+			response.ReusedChunks++
+		}
+		completed = completed || done
+	}
+
+	if completed {
+		if status, err := s.completeUpload(id, sessionChunksDir); err != nil {
+			writeError(w, status, err)
+			return
+		}
+		response.Complete = true
+	} else {
+		missing, err := s.sessionStore.GetMissingChunks(id)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, fmt.Errorf("failed to get missing chunks: %v", err))
+			return
+		}
+		response.MissingChunks = missing
+	}
+
+	writeJSON(w, response)
+}
+
+// linkFile stores path by linking it to content the storage backend already
+// holds, if the backend supports that and has the content.
+func (s *Server) linkFile(path, fileHash string, size int64) (bool, error) {
+	linker, ok := s.storage.(storage.Linker)
+	if !ok || fileHash == "" {
+		return false, nil
+	}
+	return linker.Link(path, fileHash, size)
+}
+
+// reuseChunk fills a chunk of an upload from identical data the server
+// already has: a chunk received earlier in the same upload, or a chunk of a
+// stored file found through the chunk index. The data is verified against
+// hash, a tagged checksum of the upload's algorithm alg, before it is used;
+// offset is where the client declared the chunk, or -1. Like recordChunks,
+// it only trusts cryptographic checksums to identify data. It reports
+// whether the chunk was filled and whether that completed the upload.
+// Callers must hold the session's write lock.
+func (s *Server) reuseChunk(id string, alg checksum.Algorithm, chunkID int, hash string, offset int64, sessionChunksDir string, local map[string]string) (bool, bool, error) {
+	if !alg.Cryptographic() {
+		return false, false, nil
+	}
+
+	var src io.Reader
+	if path, ok := local[hash]; ok {
+		f, err := os.Open(path)
+		if err != nil {
+			return false, false, nil
+		}
+		defer f.Close()
+		src = f
+	} else if loc, ok := s.chunkIndex.Lookup(hash); ok {
+		f, err := s.storage.Open(loc.Path)
+		if err != nil {
+			s.chunkIndex.Forget(hash)
+			return false, false, nil
+		}
+		defer f.Close()
+		src = io.NewSectionReader(f, loc.Offset, loc.Size)
+	} else {
+		return false, false, nil
+	}
+
+	if err := os.MkdirAll(sessionChunksDir, 0755); err != nil {
+		return false, false, fmt.Errorf("failed to create session chunks dir: %w", err)
+	}
+	chunkPath := filepath.Join(sessionChunksDir, chunkFileName(chunkID))
+	got, err := writeChunkFile(chunkPath, src, alg)
+	if err != nil {
+		return false, false, fmt.Errorf("failed to copy chunk %d: %w", chunkID, err)
+	}
+	if got != hash {
+		// The stored file changed since it was indexed
+		os.Remove(chunkPath)
+		s.chunkIndex.Forget(hash)
+		return false, false, nil
+	}
+
+	completed, err := s.sessionStore.MarkChunkReceived(id, chunkID, hash, offset)
+	if err != nil {
+		return false, false, fmt.Errorf("failed to mark chunk: %w", err)
+	}
+	local[hash] = chunkPath
+	return true, completed, nil
+}
+
+// recordChunks adds the chunks of a file just stored at path to the chunk
+// index. locations has the offset and size of each chunk, or is nil if
+// they are unknown. Chunks with non-cryptographic checksums are left out:
+// other uploads could forge a match and be handed this file's data.
+func (s *Server) recordChunks(path string, hashes []string, locations []resume.ChunkLocation) {
+	if len(locations) != len(hashes) {
+		return
+	}
+	indexed := make([]string, len(hashes))
+	for i, hash := range hashes {
+		if alg, _, err := checksum.Split(hash); err == nil && alg.Cryptographic() {
+			indexed[i] = hash
+		}
+		locations[i].Path = path
+	}
+	if err := s.chunkIndex.Record(path, indexed, locations); err != nil {
+		fmt.Printf("Warning: failed to update chunk index: %v\n", err)
+	}
+}
+
+// manifestLocations returns the offset and size of each chunk in a
+// manifest: from its chunk offsets if it has them, or else from their
+// position if every chunk but the last has chunkSize bytes. It returns nil
+// if the manifest does not describe a consistent layout.
+func manifestLocations(manifest proto.Manifest, chunkSize int) []resume.ChunkLocation {
+	n := len(manifest.ChunkHashes)
+	if n == 0 {
+		return nil
+	}
+
+	offsets := manifest.ChunkOffsets
+	if offsets == nil {
+		cs := int64(chunkSize)
+		if cs <= 0 || manifest.Size <= int64(n-1)*cs || manifest.Size > int64(n)*cs {
+			return nil
+		}
+		offsets = make([]int64, n)
+		for i := range offsets {
+			offsets[i] = int64(i) * cs
+		}
+	}
+
+	locations := make([]resume.ChunkLocation, n)
+	for i, offset := range offsets {
+		end := manifest.Size
+		if i+1 < n {
+			end = offsets[i+1]
+		}
+		if offset < 0 || end <= offset {
+			return nil
+		}
+		locations[i] = resume.ChunkLocation{Offset: offset, Size: end - offset}
+	}
+	if offsets[0] != 0 {
+		return nil
+	}
+	return locations
+}
+
+// writeJSON encodes v as the JSON response body
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		writeError(w, http.StatusInternalServerError, fmt.Errorf("encode failed: %v", err))
+	}
+}
+
+// writeError answers a request with a proto.Error body. Errors that are
+// already a *proto.Error keep their code; others are coded after status.
+func writeError(w http.ResponseWriter, status int, err error) {
+	var pe *proto.Error
+	if errors.As(err, &pe) {
+		proto.WriteError(w, status, pe.Code, pe.Message)
+		return
+	}
+	proto.WriteError(w, status, proto.CodeForStatus(status), err.Error())
+}
@@ -0,0 +1,41 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/http3"
+)
+
+// quicListener serves the goflux protocol over HTTP/3 on a UDP address.
+// Every request, and so every chunk, travels on its own QUIC stream, so a
+// lost packet only delays the chunk it belongs to rather than every chunk
+// on the connection.
+type quicListener struct {
+	server *Server
+	addr   string
+}
+
+func (l *quicListener) Serve(handler http.Handler) error {
+	server := &http3.Server{
+		Addr:      l.addr,
+		Handler:   handler,
+		TLSConfig: http3.ConfigureTLSConfig(l.server.tlsConfig()),
+		QUICConfig: &quic.Config{
+			// Room for many parallel chunks in flight on long links
+			MaxIncomingStreams:         256,
+			MaxStreamReceiveWindow:     16 << 20,
+			MaxConnectionReceiveWindow: 64 << 20,
+			KeepAlivePeriod:            15 * time.Second,
+		},
+	}
+
+	fmt.Printf("goflux server listening on %s (QUIC)\n", l.addr)
+	return server.ListenAndServe()
+}
+
+func (l *quicListener) String() string {
+	return "quic " + l.addr
+}
@@ -1,27 +1,59 @@
 package server
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"hash"
 	"io"
+	"io/fs"
 	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
 	"sync"
 
 	"github.com/0xRepo-Source/goflux/pkg/auth"
+	"github.com/0xRepo-Source/goflux/pkg/checksum"
+	"github.com/0xRepo-Source/goflux/pkg/proto"
 	"github.com/0xRepo-Source/goflux/pkg/resume"
 	"github.com/0xRepo-Source/goflux/pkg/storage"
-	"github.com/0xRepo-Source/goflux/pkg/transport"
+	"golang.org/x/crypto/ssh"
 )
 
+// StatusChecksumMismatch is returned when a chunk's data does not match the
+// checksum sent with it. The chunk is discarded and can be sent again.
+const StatusChecksumMismatch = http.StatusUnprocessableEntity
+
+// StatusFileHashMismatch is returned when the reassembled file does not match
+// the whole-file hash declared by the client. The upload is discarded.
+const StatusFileHashMismatch = http.StatusConflict
+
 // Server is a goflux server instance.
 type Server struct {
 	storage      storage.Storage
 	chunksDir    string               // directory for temporary chunk storage
 	sessionStore *resume.SessionStore // tracks upload sessions for resume
-	mu           sync.Mutex
-	authMiddle   *auth.Middleware // nil if auth disabled
+	chunkIndex   *resume.ChunkIndex   // locates chunks of stored files for reuse
+	authMiddle   *auth.Middleware     // nil if auth disabled
+	certs        *certReloader        // nil if TLS disabled
+	clientCAs    *x509.CertPool       // nil if client certificates are not requested
+	checksums    []checksum.Algorithm // chunk checksum algorithms clients may use
+	maxChunkSize int64                // largest accepted chunk, 0 for no limit
+	maxFileSize  int64                // largest accepted file, 0 for no limit
+	sshConfig    *ssh.ServerConfig    // nil if SSH disabled
+	sftpDir      string               // staging directory for SFTP uploads
+	listeners    []Listener           // transports Serve answers on
+
+	locksMu      sync.Mutex              // guards sessionLocks
+	sessionLocks map[string]*sessionLock // per-upload locks, keyed by upload ID
 }
 
 // New creates a new Server.
@@ -37,35 +69,200 @@ func New(store storage.Storage, metaDir string) (*Server, error) {
 		return nil, fmt.Errorf("failed to create chunks directory: %w", err)
 	}
 
+	chunkIndex, err := resume.NewChunkIndex(metaDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load chunk index: %w", err)
+	}
+
 	return &Server{
 		storage:      store,
 		chunksDir:    chunksDir,
 		sessionStore: sessionStore,
+		chunkIndex:   chunkIndex,
+		checksums:    []checksum.Algorithm{checksum.SHA256, checksum.BLAKE3},
+		sessionLocks: make(map[string]*sessionLock),
 	}, nil
 }
 
+// SetChecksums sets the chunk checksum algorithms clients may create
+// uploads with. Only cryptographic algorithms are accepted by default;
+// faster ones are for trusted networks.
+func (s *Server) SetChecksums(algs []checksum.Algorithm) {
+	s.checksums = algs
+}
+
+// SetLimits sets the largest chunk and file the server accepts, in bytes.
+// Zero means no limit.
+func (s *Server) SetLimits(maxChunkSize, maxFileSize int64) {
+	s.maxChunkSize = maxChunkSize
+	s.maxFileSize = maxFileSize
+}
+
+// Capabilities returns what the server supports, as advertised on
+// GET /capabilities.
+func (s *Server) Capabilities() *proto.Capabilities {
+	caps := &proto.Capabilities{
+		Version: proto.ProtocolVersion,
+		Features: []string{
+			proto.FeatureUpload,
+			proto.FeatureResume,
+			proto.FeatureManifest,
+			proto.FeatureChunkOffsets,
+			proto.FeatureDownload,
+			proto.FeatureList,
+			proto.FeatureFiles,
+			proto.FeatureStat,
+			proto.FeatureSetAttr,
+			proto.FeatureDelta,
+		},
+		MaxChunkSize: s.maxChunkSize,
+		MaxFileSize:  s.maxFileSize,
+		Checksums:    []string{},
+		Compression:  []string{}, // chunks are sent uncompressed
+	}
+	if _, ok := s.storage.(storage.Linker); ok {
+		caps.Features = append(caps.Features, proto.FeatureLink)
+	}
+	for _, alg := range s.checksums {
+		caps.Checksums = append(caps.Checksums, string(alg))
+	}
+	return caps
+}
+
+// handleCapabilities answers GET /capabilities. It needs no authentication,
+// so clients can negotiate before they present credentials.
+func (s *Server) handleCapabilities(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, s.Capabilities())
+}
+
+// acceptsChecksum reports whether uploads may use alg
+func (s *Server) acceptsChecksum(alg checksum.Algorithm) bool {
+	for _, a := range s.checksums {
+		if a == alg {
+			return true
+		}
+	}
+	return false
+}
+
 // EnableAuth enables authentication on the server
 func (s *Server) EnableAuth(tokenStore *auth.TokenStore) {
 	s.authMiddle = auth.NewMiddleware(tokenStore)
 }
 
-// Start starts the HTTP server.
+// EnableCertAuth accepts TLS client certificates signed by the CAs in
+// clientCAFile and authenticates them as the users mapped in certs. It
+// requires TLS and must be called after EnableAuth, if token auth is used.
+func (s *Server) EnableCertAuth(clientCAFile string, certs *auth.CertStore) error {
+	pem, err := os.ReadFile(clientCAFile)
+	if err != nil {
+		return fmt.Errorf("failed to read client CA file: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return fmt.Errorf("no certificates found in client CA file %s", clientCAFile)
+	}
+
+	if s.authMiddle == nil {
+		s.authMiddle = auth.NewMiddleware(nil)
+	}
+	s.authMiddle.SetCertStore(certs)
+	s.clientCAs = pool
+	return nil
+}
+
+// EnableTLS provides the certificate and key for https and quic listeners.
+// The files are reloaded on SIGHUP or when they change on disk, so
+// certificates can be renewed without restarting the server.
+func (s *Server) EnableTLS(certFile, keyFile string) error {
+	certs, err := newCertReloader(certFile, keyFile)
+	if err != nil {
+		return err
+	}
+	s.certs = certs
+	return nil
+}
+
+// Start serves HTTP, or HTTPS if TLS is enabled, on addr along with any
+// listeners added before.
 func (s *Server) Start(addr string, webRoot string) error {
+	transport := "http"
+	if s.certs != nil {
+		transport = "https"
+	}
+	if err := s.Listen(transport, addr); err != nil {
+		return err
+	}
+	return s.Serve(webRoot)
+}
+
+// Serve answers on every listener until one of them fails, and returns its
+// error. The web UI is served from webRoot unless it is empty.
+func (s *Server) Serve(webRoot string) error {
+	if len(s.listeners) == 0 {
+		return fmt.Errorf("no listeners configured")
+	}
+	if s.clientCAs != nil && s.certs == nil {
+		return fmt.Errorf("client certificate authentication requires TLS")
+	}
+
+	handler := withProtocol(s.routes(webRoot))
+	if s.certs != nil {
+		go s.certs.watch(nil)
+	}
+
+	errc := make(chan error, len(s.listeners))
+	for _, l := range s.listeners {
+		go func(l Listener) {
+			errc <- fmt.Errorf("%s: %w", l, l.Serve(handler))
+		}(l)
+	}
+	return <-errc
+}
+
+// routes returns the goflux HTTP API, and the web UI if webRoot is set
+func (s *Server) routes(webRoot string) http.Handler {
 	// Create a new ServeMux to avoid conflicts with default mux
 	mux := http.NewServeMux()
 
 	// Register handlers with authentication if enabled
 	if s.authMiddle != nil {
 		mux.HandleFunc("/upload", s.authMiddle.RequireAuth("upload", s.handleUpload))
+		mux.HandleFunc("POST /upload/create", s.authMiddle.RequireAuth("upload", s.handleCreateUpload))
+		mux.HandleFunc("PUT /upload/{id}/chunks/{n}", s.authMiddle.RequireAuth("upload", s.handleUploadChunk))
+		mux.HandleFunc("POST /upload/{id}/manifest", s.authMiddle.RequireAuth("upload", s.handleUploadManifest))
+		mux.HandleFunc("PUT /upload/chunks/{n}", s.authMiddle.RequireAuth("upload", s.handleUploadChunk))
 		mux.HandleFunc("/upload/status", s.authMiddle.RequireAuth("upload", s.handleUploadStatus))
 		mux.HandleFunc("/download", s.authMiddle.RequireAuth("download", s.handleDownload))
 		mux.HandleFunc("/list", s.authMiddle.RequireAuth("list", s.handleList))
+		mux.HandleFunc("GET /stat", s.authMiddle.RequireAuth("list", s.handleStat))
+		mux.HandleFunc("POST /delete", s.authMiddle.RequireAuth("delete", s.handleDelete))
+		mux.HandleFunc("POST /move", s.authMiddle.RequireAuth("move", s.handleMove))
+		mux.HandleFunc("POST /copy", s.authMiddle.RequireAuth("copy", s.handleCopy))
+		mux.HandleFunc("POST /mkdir", s.authMiddle.RequireAuth("mkdir", s.handleMkdir))
+		mux.HandleFunc("POST /setattr", s.authMiddle.RequireAuth("upload", s.handleSetAttr))
+		mux.HandleFunc("GET /signature", s.authMiddle.RequireAll(deltaPermissions, s.handleSignature))
+		mux.HandleFunc("POST /delta", s.authMiddle.RequireAll(deltaPermissions, s.handleDelta))
+		mux.HandleFunc("GET /capabilities", s.handleCapabilities)
 		fmt.Println("Authentication enabled")
 	} else {
 		mux.HandleFunc("/upload", s.handleUpload)
+		mux.HandleFunc("POST /upload/create", s.handleCreateUpload)
+		mux.HandleFunc("PUT /upload/{id}/chunks/{n}", s.handleUploadChunk)
+		mux.HandleFunc("POST /upload/{id}/manifest", s.handleUploadManifest)
+		mux.HandleFunc("PUT /upload/chunks/{n}", s.handleUploadChunk)
 		mux.HandleFunc("/upload/status", s.handleUploadStatus)
 		mux.HandleFunc("/download", s.handleDownload)
 		mux.HandleFunc("/list", s.handleList)
+		mux.HandleFunc("GET /stat", s.handleStat)
+		mux.HandleFunc("POST /delete", s.handleDelete)
+		mux.HandleFunc("POST /move", s.handleMove)
+		mux.HandleFunc("POST /copy", s.handleCopy)
+		mux.HandleFunc("POST /mkdir", s.handleMkdir)
+		mux.HandleFunc("POST /setattr", s.handleSetAttr)
+		mux.HandleFunc("GET /signature", s.handleSignature)
+		mux.HandleFunc("POST /delta", s.handleDelta)
+		mux.HandleFunc("GET /capabilities", s.handleCapabilities)
 		fmt.Println("⚠️  Authentication disabled - all endpoints are public!")
 	}
 
@@ -74,158 +271,714 @@ func (s *Server) Start(addr string, webRoot string) error {
 		if err := s.EnableWebUI(mux, webRoot); err != nil {
 			fmt.Printf("Warning: Could not enable web UI: %v\n", err)
 		} else {
-			fmt.Printf("Web UI enabled at http://%s\n", addr)
+			for _, l := range s.listeners {
+				if l, ok := l.(*tcpListener); ok {
+					fmt.Printf("Web UI enabled at %s\n", l.url())
+				}
+			}
 		}
 	}
+	return mux
+}
 
-	fmt.Printf("goflux server listening on %s\n", addr)
-	return http.ListenAndServe(addr, mux)
+// tlsConfig returns the TLS configuration of HTTPS and QUIC listeners
+func (s *Server) tlsConfig() *tls.Config {
+	config := &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: s.certs.GetCertificate,
+	}
+
+	// Verify client certificates when offered; clients without one can
+	// still authenticate with a token
+	if s.clientCAs != nil {
+		config.ClientAuth = tls.VerifyClientCertIfGiven
+		config.ClientCAs = s.clientCAs
+	}
+	return config
 }
 
 func (s *Server) handleUpload(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
-		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		writeError(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
 		return
 	}
 
 	body, err := io.ReadAll(r.Body)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		writeError(w, http.StatusBadRequest, err)
 		return
 	}
 
-	var chunkData transport.ChunkData
+	var chunkData proto.ChunkData
 	if err := json.Unmarshal(body, &chunkData); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	// Offsets of JSON chunks are not trusted: clients that predate them
+	// send none, which decodes as 0
+	s.receiveChunk(w, r, chunkData, -1, len(chunkData.Data), bytes.NewReader(chunkData.Data))
+}
+
+// handleCreateUpload starts an upload and returns its upload ID. Chunks are
+// then sent to PUT /upload/{id}/chunks/{n}, and an interrupted upload is
+// resumed by querying /upload/status?id= and sending the missing chunks.
+func (s *Server) handleCreateUpload(w http.ResponseWriter, r *http.Request) {
+	var req proto.CreateUploadRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if req.Path == "" || req.TotalChunks <= 0 {
+		writeError(w, http.StatusBadRequest, errors.New("path and total_chunks required"))
+		return
+	}
+	alg, err := checksum.Parse(req.Checksum)
+	if err != nil || !s.acceptsChecksum(alg) {
+		writeError(w, http.StatusNotAcceptable, fmt.Errorf("checksum algorithm %q not accepted, use one of %v", req.Checksum, s.checksums))
+		return
+	}
+	if s.maxChunkSize > 0 && int64(req.ChunkSize) > s.maxChunkSize {
+		writeError(w, http.StatusRequestEntityTooLarge, fmt.Errorf("chunk size %d exceeds limit of %d bytes", req.ChunkSize, s.maxChunkSize))
+		return
+	}
+	if s.maxFileSize > 0 && req.ChunkSize > 0 && int64(req.TotalChunks-1)*int64(req.ChunkSize) >= s.maxFileSize {
+		writeError(w, http.StatusRequestEntityTooLarge, fmt.Errorf("file exceeds limit of %d bytes", s.maxFileSize))
 		return
 	}
 
-	s.mu.Lock()
-	defer s.mu.Unlock()
+	session, err := s.sessionStore.CreateSession(req.Path, req.TotalChunks, req.ChunkSize, req.FileHash, alg, s.requestUser(r))
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, fmt.Errorf("session error: %v", err))
+		return
+	}
+	if err := os.MkdirAll(filepath.Join(s.chunksDir, session.ID), 0755); err != nil {
+		writeError(w, http.StatusInternalServerError, fmt.Errorf("failed to create session chunks dir: %v", err))
+		return
+	}
 
-	// Get or create upload session
-	session, err := s.sessionStore.GetOrCreateSession(chunkData.Path, chunkData.Total, len(chunkData.Data))
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(proto.CreateUploadResponse{UploadID: session.ID, Checksum: string(alg)}); err != nil {
+		writeError(w, http.StatusInternalServerError, fmt.Errorf("encode failed: %v", err))
+		return
+	}
+}
+
+// handleUploadChunk accepts a raw binary chunk on PUT /upload/{id}/chunks/{n},
+// or on PUT /upload/chunks/{n} for clients that don't create uploads. The
+// chunk metadata travels in X-Goflux-* headers and the body is the chunk
+// itself, so nothing needs to be base64-encoded or buffered.
+func (s *Server) handleUploadChunk(w http.ResponseWriter, r *http.Request) {
+	chunkID, err := strconv.Atoi(r.PathValue("n"))
 	if err != nil {
-		http.Error(w, fmt.Sprintf("session error: %v", err), http.StatusInternalServerError)
+		writeError(w, http.StatusBadRequest, errors.New("invalid chunk number"))
 		return
 	}
 
-	// Create session-specific chunks directory using path hash
-	sessionHash := fmt.Sprintf("%x", []byte(chunkData.Path))
-	sessionChunksDir := filepath.Join(s.chunksDir, sessionHash[:16])
-	if err := os.MkdirAll(sessionChunksDir, 0755); err != nil {
-		http.Error(w, fmt.Sprintf("failed to create session chunks dir: %v", err), http.StatusInternalServerError)
+	// The offset is optional; -1 marks it unknown
+	offset := int64(-1)
+	if h := r.Header.Get(proto.HeaderOffset); h != "" {
+		offset, err = strconv.ParseInt(h, 10, 64)
+		if err != nil || offset < 0 {
+			writeError(w, http.StatusBadRequest, errors.New("invalid "+proto.HeaderOffset+" header"))
+			return
+		}
+	}
+
+	if id := r.PathValue("id"); id != "" {
+		meta := proto.ChunkData{
+			UploadID: id,
+			ChunkID:  chunkID,
+			Checksum: r.Header.Get(proto.HeaderChecksum),
+		}
+		s.receiveChunk(w, r, meta, offset, int(r.ContentLength), r.Body)
 		return
 	}
 
-	// Write chunk to disk
-	chunkPath := filepath.Join(sessionChunksDir, fmt.Sprintf("chunk_%06d.dat", chunkData.ChunkID))
-	if err := os.WriteFile(chunkPath, chunkData.Data, 0644); err != nil {
-		http.Error(w, fmt.Sprintf("failed to write chunk: %v", err), http.StatusInternalServerError)
+	path, err := url.PathUnescape(r.Header.Get(proto.HeaderPath))
+	if err != nil || path == "" {
+		writeError(w, http.StatusBadRequest, errors.New(proto.HeaderPath+" header required"))
 		return
 	}
 
-	// Mark chunk as received in session
-	if err := s.sessionStore.MarkChunkReceived(chunkData.Path, chunkData.ChunkID); err != nil {
-		http.Error(w, fmt.Sprintf("failed to mark chunk: %v", err), http.StatusInternalServerError)
+	total, err := strconv.Atoi(r.Header.Get(proto.HeaderTotal))
+	if err != nil || total <= 0 {
+		writeError(w, http.StatusBadRequest, errors.New(proto.HeaderTotal+" header required"))
+		return
+	}
+
+	meta := proto.ChunkData{
+		Path:     path,
+		ChunkID:  chunkID,
+		Checksum: r.Header.Get(proto.HeaderChecksum),
+		Total:    total,
+		FileHash: r.Header.Get(proto.HeaderFileHash),
+	}
+	s.receiveChunk(w, r, meta, offset, int(r.ContentLength), r.Body)
+}
+
+// receiveChunk writes one chunk of an upload to disk, verifies it against
+// the client's checksum, records it in the session and reassembles
+// the file once every chunk has arrived. Chunks without a checksum are
+// refused, since nothing would catch their corruption in transit. meta
+// describes the chunk; its Data and Offset fields are ignored in favour of
+// data and offset, which is -1 if the client did not declare one. Chunks
+// without an upload ID belong to the legacy session for their path.
+//
+// Chunks of the same upload are written concurrently under the session's
+// read lock; resetting and reassembling a session take the write lock.
+func (s *Server) receiveChunk(w http.ResponseWriter, r *http.Request, meta proto.ChunkData, offset int64, chunkSize int, data io.Reader) {
+	id := meta.UploadID
+	if id == "" {
+		id = resume.LegacySessionID(meta.Path)
+	}
+
+	lock := s.acquireSessionLock(id)
+	defer s.releaseSessionLock(id, lock)
+
+	lock.Lock()
+	session, status, err := s.openSession(id, meta, chunkSize, s.requestUser(r))
+	lock.Unlock()
+	if err != nil {
+		writeError(w, status, err)
+		return
+	}
+	sessionChunksDir := filepath.Join(s.chunksDir, id)
+
+	if s.maxChunkSize > 0 {
+		data = http.MaxBytesReader(w, io.NopCloser(data), s.maxChunkSize)
+	}
+
+	// Receive the body before taking the lock. Chunks of one upload can
+	// share a connection, as they do over QUIC, and a chunk waiting for the
+	// lock without reading its body would stall the one holding it
+	staged, hash, status, err := stageChunk(session.Checksum, meta, sessionChunksDir, data)
+	if err != nil {
+		writeError(w, status, err)
+		return
+	}
+
+	lock.RLock()
+	completed, status, err := s.storeChunk(id, meta, offset, sessionChunksDir, staged, hash)
+	lock.RUnlock()
+	if err != nil {
+		writeError(w, status, err)
 		return
 	}
 
 	// Check if upload is complete
-	if session.Completed {
-		// Reassemble file from disk chunks
-		if err := s.reassembleFromDisk(sessionChunksDir, chunkData.Path, chunkData.Total); err != nil {
-			http.Error(w, fmt.Sprintf("reassembly failed: %v", err), http.StatusInternalServerError)
+	if completed {
+		lock.Lock()
+		status, err := s.completeUpload(id, sessionChunksDir)
+		lock.Unlock()
+		if err != nil {
+			writeError(w, status, err)
 			return
 		}
+	}
+
+	writeJSON(w, proto.ChunkResponse{
+		UploadID: meta.UploadID,
+		ChunkID:  meta.ChunkID,
+		Total:    session.TotalChunks,
+		Complete: completed,
+	})
+}
 
-		// Clean up chunks directory and session
-		os.RemoveAll(sessionChunksDir)
-		if err := s.sessionStore.DeleteSession(chunkData.Path); err != nil {
-			fmt.Printf("Warning: failed to delete session metadata: %v\n", err)
+// openSession looks up the upload session a chunk belongs to and makes sure
+// its chunk directory exists. Legacy sessions are created on their first
+// chunk; sessions with an upload ID must have been created and may only be
+// used by the user that created them. On failure it returns the HTTP status
+// to answer with. Callers must hold the session's write lock.
+func (s *Server) openSession(id string, meta proto.ChunkData, chunkSize int, user string) (*resume.UploadSession, int, error) {
+	sessionChunksDir := filepath.Join(s.chunksDir, id)
+
+	var session *resume.UploadSession
+	var err error
+	if meta.UploadID != "" {
+		var status int
+		if session, status, err = s.lookupUpload(id, user); err != nil {
+			return nil, status, err
+		}
+	} else {
+		session, err = s.sessionStore.GetOrCreateSession(meta.Path, meta.Total, chunkSize, meta.FileHash)
+		if errors.Is(err, resume.ErrFileChanged) {
+			// The source file changed since the upload started, so the chunks
+			// received so far are useless; start over
+			fmt.Printf("Restarting upload of %s: file changed\n", meta.Path)
+			os.RemoveAll(sessionChunksDir)
+			if err := s.sessionStore.DeleteSession(id); err != nil {
+				return nil, http.StatusInternalServerError, fmt.Errorf("session error: %w", err)
+			}
+			session, err = s.sessionStore.GetOrCreateSession(meta.Path, meta.Total, chunkSize, meta.FileHash)
+		}
+		if err != nil {
+			return nil, http.StatusInternalServerError, fmt.Errorf("session error: %w", err)
 		}
 	}
 
-	w.WriteHeader(http.StatusOK)
-	fmt.Fprintf(w, "chunk %d/%d received", chunkData.ChunkID+1, chunkData.Total)
+	if err := os.MkdirAll(sessionChunksDir, 0755); err != nil {
+		return nil, http.StatusInternalServerError, fmt.Errorf("failed to create session chunks dir: %w", err)
+	}
+	return session, http.StatusOK, nil
 }
 
-// reassembleFromDisk reads chunks from disk and assembles the final file
-func (s *Server) reassembleFromDisk(chunksDir, remotePath string, totalChunks int) error {
-	// Open output file for writing
-	tempPath := filepath.Join(s.chunksDir, "temp_"+filepath.Base(remotePath))
-	outFile, err := os.Create(tempPath)
+// lookupUpload returns the session of an upload created with
+// /upload/create, checking that user may use it. On failure it returns the
+// HTTP status to answer with.
+func (s *Server) lookupUpload(id, user string) (*resume.UploadSession, int, error) {
+	session, exists := s.sessionStore.GetSession(id)
+	if !exists {
+		return nil, http.StatusNotFound, uploadNotFound(id)
+	}
+	if session.User != "" && session.User != user {
+		return nil, http.StatusForbidden, fmt.Errorf("upload %s belongs to another user", id)
+	}
+	return session, http.StatusOK, nil
+}
+
+// uploadNotFound is the error for chunks of an upload that doesn't exist,
+// or no longer does because another request completed or discarded it
+func uploadNotFound(id string) error {
+	return &proto.Error{Code: proto.CodeUploadNotFound, Message: fmt.Sprintf("upload %s not found", id)}
+}
+
+// requestUser returns the authenticated user of a request, or "" if
+// authentication is disabled.
+func (s *Server) requestUser(r *http.Request) string {
+	if s.authMiddle == nil {
+		return ""
+	}
+	return r.Header.Get("X-Authenticated-User")
+}
+
+// stageChunk writes a chunk body to a temporary file in the session
+// directory and verifies it. The chunk's checksum must use alg, the upload's
+// algorithm. It returns the staged file and the chunk's tagged hash; on
+// failure it also returns the HTTP status to answer with.
+func stageChunk(alg checksum.Algorithm, meta proto.ChunkData, sessionChunksDir string, data io.Reader) (string, string, int, error) {
+	if meta.Checksum == "" {
+		return "", "", http.StatusBadRequest, &proto.Error{Code: proto.CodeChecksumRequired, Message: fmt.Sprintf("chunk %d has no checksum", meta.ChunkID)}
+	}
+	sumAlg, digest, err := checksum.Split(meta.Checksum)
 	if err != nil {
-		return fmt.Errorf("failed to create temp file: %w", err)
+		return "", "", http.StatusBadRequest, fmt.Errorf("chunk %d: %w", meta.ChunkID, err)
+	}
+	if sumAlg != alg {
+		return "", "", http.StatusBadRequest, fmt.Errorf("chunk %d has a %s checksum, upload uses %s", meta.ChunkID, sumAlg, alg)
 	}
-	defer outFile.Close()
+	want := string(alg) + ":" + digest
 
-	// Read and write each chunk in order
-	for i := 0; i < totalChunks; i++ {
-		chunkPath := filepath.Join(chunksDir, fmt.Sprintf("chunk_%06d.dat", i))
-		chunkData, err := os.ReadFile(chunkPath)
-		if err != nil {
-			return fmt.Errorf("failed to read chunk %d: %w", i, err)
+	// Write chunk to disk, hashing it on the way. Retries of a chunk can
+	// arrive concurrently, so each gets its own file.
+	f, err := os.CreateTemp(sessionChunksDir, chunkFileName(meta.ChunkID)+".*.tmp")
+	if errors.Is(err, fs.ErrNotExist) {
+		// The upload was completed or discarded since the chunk arrived
+		return "", "", http.StatusNotFound, uploadNotFound(filepath.Base(sessionChunksDir))
+	}
+	if err != nil {
+		return "", "", http.StatusInternalServerError, fmt.Errorf("failed to write chunk: %w", err)
+	}
+	f.Close()
+	staged := f.Name()
+	hash, err := writeChunkFile(staged, data, alg)
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return "", "", http.StatusRequestEntityTooLarge, fmt.Errorf("chunk %d exceeds limit of %d bytes", meta.ChunkID, tooLarge.Limit)
+	}
+	if errors.Is(err, fs.ErrNotExist) {
+		return "", "", http.StatusNotFound, uploadNotFound(filepath.Base(sessionChunksDir))
+	}
+	if err != nil {
+		return "", "", http.StatusInternalServerError, fmt.Errorf("failed to write chunk: %w", err)
+	}
+
+	// Reject corrupted chunks before they are marked as received
+	if want != hash {
+		os.Remove(staged)
+		return "", "", StatusChecksumMismatch, fmt.Errorf("checksum mismatch for chunk %d", meta.ChunkID)
+	}
+	return staged, hash, http.StatusOK, nil
+}
+
+// storeChunk moves a chunk staged by stageChunk into place and marks it
+// received. It reports whether this chunk completed the upload; on failure
+// it also returns the HTTP status to answer with. Callers must hold the
+// session's read lock.
+func (s *Server) storeChunk(id string, meta proto.ChunkData, offset int64, sessionChunksDir, staged, hash string) (bool, int, error) {
+	// A copy of a chunk received while another completed the upload finds
+	// the upload gone
+	if _, exists := s.sessionStore.GetSession(id); !exists {
+		os.Remove(staged)
+		return false, http.StatusNotFound, uploadNotFound(id)
+	}
+
+	chunkPath := filepath.Join(sessionChunksDir, chunkFileName(meta.ChunkID))
+	if err := os.Rename(staged, chunkPath); err != nil {
+		os.Remove(staged)
+		return false, http.StatusInternalServerError, fmt.Errorf("failed to store chunk: %w", err)
+	}
+
+	// Mark chunk as received in session
+	completed, err := s.sessionStore.MarkChunkReceived(id, meta.ChunkID, hash, offset)
+	if err != nil {
+		return false, http.StatusInternalServerError, fmt.Errorf("failed to mark chunk: %w", err)
+	}
+	return completed, http.StatusOK, nil
+}
+
+// completeUpload reassembles a finished upload into storage and removes its
+// session. On failure it returns the HTTP status to answer with. Callers
+// must hold the session's write lock.
+func (s *Server) completeUpload(id, sessionChunksDir string) (int, error) {
+	session, exists := s.sessionStore.GetSession(id)
+	if !exists {
+		return http.StatusInternalServerError, fmt.Errorf("upload %s not found", id)
+	}
+
+	// Reassemble file from disk chunks
+	locations, err := s.reassembleFromDisk(sessionChunksDir, session)
+	if err != nil {
+		// A chunk that went bad on disk is dropped and the client told to
+		// send the missing chunks again
+		var corrupt *corruptChunkError
+		if errors.As(err, &corrupt) {
+			os.Remove(filepath.Join(sessionChunksDir, chunkFileName(corrupt.chunkID)))
+			if err := s.sessionStore.MarkChunkMissing(id, corrupt.chunkID); err != nil {
+				fmt.Printf("Warning: failed to reset chunk %d: %v\n", corrupt.chunkID, err)
+			}
+			return http.StatusConflict, &proto.Error{Code: proto.CodeChunksMissing, Message: fmt.Sprintf("upload %s: %v; resend the missing chunks", id, err)}
 		}
 
-		if _, err := outFile.Write(chunkData); err != nil {
-			return fmt.Errorf("failed to write chunk %d: %w", i, err)
+		// A whole-file or chunk layout mismatch cannot be repaired by
+		// resending chunks, so the upload is discarded and the client must
+		// start over
+		var mismatch *fileHashError
+		var misplaced *offsetError
+		var tooLarge *fileSizeError
+		if errors.As(err, &mismatch) || errors.As(err, &misplaced) || errors.As(err, &tooLarge) {
+			os.RemoveAll(sessionChunksDir)
+			if err := s.sessionStore.DeleteSession(id); err != nil {
+				fmt.Printf("Warning: failed to delete session metadata: %v\n", err)
+			}
+			if tooLarge != nil {
+				return http.StatusRequestEntityTooLarge, fmt.Errorf("upload rejected: %w", err)
+			}
+			return StatusFileHashMismatch, fmt.Errorf("upload rejected: %w", err)
 		}
+
+		// The file wasn't stored, so the next chunk to arrive, such as a
+		// retry of this one, tries again
+		if err := s.sessionStore.MarkIncomplete(id); err != nil {
+			fmt.Printf("Warning: failed to reset upload %s: %v\n", id, err)
+		}
+		return http.StatusInternalServerError, fmt.Errorf("reassembly failed: %w", err)
+	}
+
+	s.recordChunks(session.Path, session.ChunkHashes, locations)
+
+	// Clean up chunks directory and session
+	os.RemoveAll(sessionChunksDir)
+	if err := s.sessionStore.DeleteSession(id); err != nil {
+		fmt.Printf("Warning: failed to delete session metadata: %v\n", err)
+	}
+	return http.StatusOK, nil
+}
+
+// sessionLock serializes reassembly of an upload against its chunk writes
+type sessionLock struct {
+	sync.RWMutex
+	refs int // requests currently using the lock
+}
+
+// acquireSessionLock returns the lock for an upload, creating it if needed
+func (s *Server) acquireSessionLock(key string) *sessionLock {
+	s.locksMu.Lock()
+	defer s.locksMu.Unlock()
+
+	lock, ok := s.sessionLocks[key]
+	if !ok {
+		lock = &sessionLock{}
+		s.sessionLocks[key] = lock
 	}
+	lock.refs++
+	return lock
+}
+
+// releaseSessionLock drops a reference taken by acquireSessionLock and
+// forgets the lock once nobody uses it
+func (s *Server) releaseSessionLock(key string, lock *sessionLock) {
+	s.locksMu.Lock()
+	defer s.locksMu.Unlock()
 
-	outFile.Close()
+	lock.refs--
+	if lock.refs == 0 {
+		delete(s.sessionLocks, key)
+	}
+}
 
-	// Read the assembled file and put into storage
-	finalData, err := os.ReadFile(tempPath)
+// writeChunkFile streams a chunk body to disk and returns its tagged
+// checksum
+func writeChunkFile(chunkPath string, data io.Reader, alg checksum.Algorithm) (string, error) {
+	f, err := os.Create(chunkPath)
 	if err != nil {
-		return fmt.Errorf("failed to read assembled file: %w", err)
+		return "", err
+	}
+	hasher := alg.New()
+	if _, err := io.Copy(io.MultiWriter(f, hasher), data); err != nil {
+		f.Close()
+		os.Remove(chunkPath)
+		return "", err
+	}
+	if err := f.Close(); err != nil {
+		return "", err
+	}
+	return alg.Format(hasher.Sum(nil)), nil
+}
+
+// reassembleFromDisk streams chunks from disk in order into the final file,
+// re-checking each one against the hash verified when it was received and
+// the offset the client declared for it. When the session carries a
+// whole-file hash, the file is only committed to storage if the assembled
+// data matches it. It returns where each chunk ended up in the file.
+func (s *Server) reassembleFromDisk(chunksDir string, session *resume.UploadSession) ([]resume.ChunkLocation, error) {
+	reader := &chunkReader{
+		dir:     chunksDir,
+		total:   session.TotalChunks,
+		alg:     session.Checksum,
+		hashes:  session.ChunkHashes,
+		offsets: session.ChunkOffsets,
 	}
+	defer reader.Close()
 
-	if err := s.storage.Put(remotePath, finalData); err != nil {
-		return fmt.Errorf("storage failed: %w", err)
+	var src io.Reader = reader
+	if session.FileHash != "" {
+		src = &hashCheckReader{r: reader, hasher: sha256.New(), want: session.FileHash}
+	}
+	if s.maxFileSize > 0 {
+		src = &sizeLimitReader{r: src, limit: s.maxFileSize}
 	}
 
-	// Clean up temp file
-	os.Remove(tempPath)
+	size, err := s.storage.PutStream(session.Path, src)
+	if err != nil {
+		return nil, fmt.Errorf("storage failed: %w", err)
+	}
+
+	fmt.Printf("File saved: %s (%d bytes)\n", session.Path, size)
+	return reader.locations, nil
+}
 
-	fmt.Printf("File saved: %s (%d bytes)\n", remotePath, len(finalData))
+// corruptChunkError reports a chunk whose data on disk no longer matches
+// the hash recorded when it was received.
+type corruptChunkError struct {
+	chunkID int
+}
+
+func (e *corruptChunkError) Error() string {
+	return fmt.Sprintf("chunk %d corrupted on disk", e.chunkID)
+}
+
+// offsetError reports a chunk that does not start at the offset the client
+// declared for it, because the chunks before it have different lengths.
+type offsetError struct {
+	chunkID   int
+	want, got int64
+}
+
+func (e *offsetError) Error() string {
+	return fmt.Sprintf("chunk %d declared at offset %d but starts at %d", e.chunkID, e.want, e.got)
+}
+
+// chunkReader reads the chunk files of a session back to back, holding at
+// most one chunk file open at a time. It records where each chunk lands in
+// the assembled file.
+type chunkReader struct {
+	dir       string
+	total     int
+	alg       checksum.Algorithm
+	hashes    []string // expected tagged checksum per chunk; empty entries are not checked
+	offsets   []int64  // declared offset per chunk; negative entries are not checked
+	next      int
+	cur       *os.File
+	hasher    hash.Hash
+	pos       int64                  // bytes read so far
+	locations []resume.ChunkLocation // offset and size of each chunk read
+}
+
+func (c *chunkReader) Read(p []byte) (int, error) {
+	for {
+		if c.cur == nil {
+			if c.next >= c.total {
+				return 0, io.EOF
+			}
+			f, err := os.Open(filepath.Join(c.dir, chunkFileName(c.next)))
+			if os.IsNotExist(err) {
+				// Lost chunk files are resent like corrupted ones
+				return 0, &corruptChunkError{chunkID: c.next}
+			}
+			if err != nil {
+				return 0, fmt.Errorf("failed to read chunk %d: %w", c.next, err)
+			}
+			if c.next < len(c.offsets) && c.offsets[c.next] >= 0 && c.offsets[c.next] != c.pos {
+				f.Close()
+				return 0, &offsetError{chunkID: c.next, want: c.offsets[c.next], got: c.pos}
+			}
+			c.cur = f
+			c.hasher = c.alg.New()
+			c.locations = append(c.locations, resume.ChunkLocation{Offset: c.pos})
+			c.next++
+		}
+
+		n, err := c.cur.Read(p)
+		c.hasher.Write(p[:n])
+		c.pos += int64(n)
+		c.locations[len(c.locations)-1].Size += int64(n)
+		if err == io.EOF {
+			c.cur.Close()
+			c.cur = nil
+			if err := c.verify(c.next - 1); err != nil {
+				return n, err
+			}
+			if n > 0 {
+				return n, nil
+			}
+			continue
+		}
+		return n, err
+	}
+}
+
+// verify compares the hash of the chunk just read with the recorded one
+func (c *chunkReader) verify(chunkID int) error {
+	if chunkID >= len(c.hashes) || c.hashes[chunkID] == "" {
+		return nil
+	}
+	if c.alg.Format(c.hasher.Sum(nil)) != c.hashes[chunkID] {
+		return &corruptChunkError{chunkID: chunkID}
+	}
 	return nil
 }
 
-// UploadStatusResponse contains the status of an upload session
-type UploadStatusResponse struct {
-	Exists        bool   `json:"exists"`         // whether a session exists
-	TotalChunks   int    `json:"total_chunks"`   // total chunks expected
-	ReceivedMap   []bool `json:"received_map"`   // bitmap of received chunks
-	MissingChunks []int  `json:"missing_chunks"` // list of missing chunk IDs
-	Completed     bool   `json:"completed"`      // upload completed
+// Close releases the chunk file currently being read, if any.
+func (c *chunkReader) Close() error {
+	if c.cur == nil {
+		return nil
+	}
+	err := c.cur.Close()
+	c.cur = nil
+	return err
+}
+
+// fileHashError reports an assembled file whose SHA-256 differs from the
+// whole-file hash the client declared.
+type fileHashError struct {
+	want, got string
+}
+
+func (e *fileHashError) Error() string {
+	return fmt.Sprintf("file hash mismatch: expected %s, got %s", e.want, e.got)
+}
+
+// hashCheckReader hashes everything read through it and turns the final
+// io.EOF into a fileHashError if the hash does not match, which makes
+// storage discard the file instead of committing it.
+type hashCheckReader struct {
+	r      io.Reader
+	hasher hash.Hash
+	want   string
+}
+
+func (h *hashCheckReader) Read(p []byte) (int, error) {
+	n, err := h.r.Read(p)
+	h.hasher.Write(p[:n])
+	if err == io.EOF {
+		if got := hex.EncodeToString(h.hasher.Sum(nil)); !strings.EqualFold(got, h.want) {
+			return n, &fileHashError{want: h.want, got: got}
+		}
+	}
+	return n, err
+}
+
+// fileSizeError reports an assembled file larger than the server accepts.
+type fileSizeError struct {
+	limit int64
+}
+
+func (e *fileSizeError) Error() string {
+	return fmt.Sprintf("file exceeds limit of %d bytes", e.limit)
+}
+
+// sizeLimitReader fails with a fileSizeError once more than limit bytes
+// were read through it, which makes storage discard the file.
+type sizeLimitReader struct {
+	r     io.Reader
+	limit int64
+	n     int64
+}
+
+func (l *sizeLimitReader) Read(p []byte) (int, error) {
+	n, err := l.r.Read(p)
+	l.n += int64(n)
+	if l.n > l.limit {
+		return n, &fileSizeError{limit: l.limit}
+	}
+	return n, err
+}
+
+// withProtocol tags every response with the protocol version the server
+// speaks.
+func withProtocol(h http.Handler) http.Handler {
+	version := strconv.Itoa(proto.ProtocolVersion)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(proto.HeaderProtocol, version)
+		h.ServeHTTP(w, r)
+	})
+}
+
+// chunkFileName returns the on-disk name of a chunk
+func chunkFileName(chunkID int) string {
+	return fmt.Sprintf("chunk_%06d.dat", chunkID)
 }
 
 func (s *Server) handleUploadStatus(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
-		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		writeError(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
 		return
 	}
 
-	path := r.URL.Query().Get("path")
-	if path == "" {
-		http.Error(w, "path required", http.StatusBadRequest)
-		return
+	// Uploads are looked up by ID; clients that don't create uploads
+	// query their legacy session by path
+	query := r.URL.Query()
+	id := query.Get("id")
+	if id == "" {
+		path := query.Get("path")
+		if path == "" {
+			writeError(w, http.StatusBadRequest, errors.New("id or path required"))
+			return
+		}
+		id = resume.LegacySessionID(path)
 	}
 
-	session, exists := s.sessionStore.GetSession(path)
+	session, exists := s.sessionStore.GetSession(id)
+	if exists && session.User != "" && session.User != s.requestUser(r) {
+		// Don't reveal other users' uploads
+		exists = false
+	}
 
-	response := UploadStatusResponse{
+	response := proto.UploadStatus{
 		Exists: exists,
 	}
 
 	if exists {
-		missing, err := s.sessionStore.GetMissingChunks(path)
+		missing, err := s.sessionStore.GetMissingChunks(id)
 		if err != nil {
-			http.Error(w, fmt.Sprintf("failed to get missing chunks: %v", err), http.StatusInternalServerError)
+			writeError(w, http.StatusInternalServerError, fmt.Errorf("failed to get missing chunks: %v", err))
 			return
 		}
 
+		response.UploadID = session.ID
+		response.Path = session.Path
+		response.Checksum = string(session.Checksum)
 		response.TotalChunks = session.TotalChunks
 		response.ReceivedMap = session.ReceivedMap
 		response.MissingChunks = missing
@@ -234,7 +987,7 @@ func (s *Server) handleUploadStatus(w http.ResponseWriter, r *http.Request) {
 
 	w.Header().Set("Content-Type", "application/json")
 	if err := json.NewEncoder(w).Encode(response); err != nil {
-		http.Error(w, fmt.Sprintf("encode failed: %v", err), http.StatusInternalServerError)
+		writeError(w, http.StatusInternalServerError, fmt.Errorf("encode failed: %v", err))
 		return
 	}
 }
@@ -242,21 +995,37 @@ func (s *Server) handleUploadStatus(w http.ResponseWriter, r *http.Request) {
 func (s *Server) handleDownload(w http.ResponseWriter, r *http.Request) {
 	path := r.URL.Query().Get("path")
 	if path == "" {
-		http.Error(w, "path required", http.StatusBadRequest)
+		writeError(w, http.StatusBadRequest, errors.New("path required"))
 		return
 	}
 
-	data, err := s.storage.Get(path)
+	file, err := s.storage.Open(path)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusNotFound)
+		writeStorageError(w, err, path, path)
 		return
 	}
+	defer file.Close()
 
-	w.Header().Set("Content-Type", "application/octet-stream")
-	if _, err := w.Write(data); err != nil {
-		http.Error(w, fmt.Sprintf("write failed: %v", err), http.StatusInternalServerError)
+	hash, err := file.Hash()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
 		return
 	}
+
+	// ServeContent answers HEAD, Range and If-Range requests, which is what
+	// lets clients resume interrupted downloads and fetch ranges in parallel.
+	// The hash lets them check the file they put together.
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("ETag", etagFor(hash))
+	w.Header().Set(proto.HeaderFileHash, hash)
+	http.ServeContent(w, r, filepath.Base(path), file.ModTime(), file)
+}
+
+// etagFor derives a strong ETag from the SHA-256 of a stored file, so the
+// ETag changes whenever the content does, even if a replacement kept the
+// size and modification time.
+func etagFor(hash string) string {
+	return `"` + hash + `"`
 }
 
 func (s *Server) handleList(w http.ResponseWriter, r *http.Request) {
@@ -264,16 +1033,20 @@ func (s *Server) handleList(w http.ResponseWriter, r *http.Request) {
 	if path == "" {
 		path = "/"
 	}
+	if detail, _ := strconv.ParseBool(r.URL.Query().Get("detail")); detail {
+		s.listDetails(w, r, path)
+		return
+	}
 
 	files, err := s.storage.List(path)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeStorageError(w, err, path, path)
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
 	if err := json.NewEncoder(w).Encode(files); err != nil {
-		http.Error(w, fmt.Sprintf("encode failed: %v", err), http.StatusInternalServerError)
+		writeError(w, http.StatusInternalServerError, fmt.Errorf("encode failed: %v", err))
 		return
 	}
 }
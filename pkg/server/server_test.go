@@ -0,0 +1,751 @@
+package server
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/0xRepo-Source/goflux/pkg/auth"
+	"github.com/0xRepo-Source/goflux/pkg/checksum"
+	"github.com/0xRepo-Source/goflux/pkg/delta"
+	"github.com/0xRepo-Source/goflux/pkg/proto"
+	"github.com/0xRepo-Source/goflux/pkg/resume"
+	"github.com/0xRepo-Source/goflux/pkg/storage"
+)
+
+// testServer is a Server answering over httptest
+type testServer struct {
+	*Server
+	url string
+}
+
+// newTestServer starts a server storing files in store, or in a temporary
+// directory if store is nil
+func newTestServer(t *testing.T, store storage.Storage) *testServer {
+	t.Helper()
+	if store == nil {
+		local, err := storage.NewLocal(t.TempDir())
+		if err != nil {
+			t.Fatalf("NewLocal() error = %v", err)
+		}
+		store = local
+	}
+	s, err := New(store, t.TempDir())
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	ts := httptest.NewServer(s.routes(""))
+	t.Cleanup(ts.Close)
+	return &testServer{Server: s, url: ts.URL}
+}
+
+// do makes a request and decodes a successful JSON answer into v, if it
+// isn't nil. It returns the status and the error body of a failure.
+func (ts *testServer) do(t *testing.T, method, route string, header http.Header, body io.Reader, v any) (int, *proto.Error) {
+	t.Helper()
+	req, err := http.NewRequest(method, ts.url+route, body)
+	if err != nil {
+		t.Fatalf("NewRequest() error = %v", err)
+	}
+	for k, vs := range header {
+		req.Header[k] = vs
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("%s %s error = %v", method, route, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return resp.StatusCode, proto.ReadError(resp)
+	}
+	if v != nil {
+		if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+			t.Fatalf("%s %s answer: %v", method, route, err)
+		}
+	}
+	return resp.StatusCode, nil
+}
+
+// createUpload creates an upload of the given chunks to path, with SHA-256
+// chunk checksums, and returns its ID
+func (ts *testServer) createUpload(t *testing.T, path string, chunks [][]byte) string {
+	t.Helper()
+	return ts.create(t, proto.CreateUploadRequest{Path: path, TotalChunks: len(chunks), ChunkSize: len(chunks[0]), FileHash: fileHash(chunks)})
+}
+
+// create creates an upload and returns its ID
+func (ts *testServer) create(t *testing.T, req proto.CreateUploadRequest) string {
+	t.Helper()
+	data, _ := json.Marshal(req)
+	var created proto.CreateUploadResponse
+	if status, e := ts.do(t, "POST", "/upload/create", nil, bytes.NewReader(data), &created); e != nil {
+		t.Fatalf("create upload: %d %v", status, e)
+	}
+	return created.UploadID
+}
+
+// putChunk sends chunk n of an upload with the given checksum header,
+// which is left out if empty
+func (ts *testServer) putChunk(t *testing.T, id string, n int, data []byte, sum string) (int, *proto.ChunkResponse, *proto.Error) {
+	t.Helper()
+	header := http.Header{}
+	if sum != "" {
+		header.Set(proto.HeaderChecksum, sum)
+	}
+	var resp proto.ChunkResponse
+	status, e := ts.do(t, "PUT", "/upload/"+id+"/chunks/"+strconv.Itoa(n), header, bytes.NewReader(data), &resp)
+	return status, &resp, e
+}
+
+// status returns the status of an upload
+func (ts *testServer) status(t *testing.T, id string) proto.UploadStatus {
+	t.Helper()
+	var status proto.UploadStatus
+	if code, e := ts.do(t, "GET", "/upload/status?id="+id, nil, nil, &status); e != nil {
+		t.Fatalf("upload status: %d %v", code, e)
+	}
+	return status
+}
+
+// stored returns the content of a stored file, failing if it is missing
+func (ts *testServer) stored(t *testing.T, path string) []byte {
+	t.Helper()
+	f, err := ts.storage.Open(path)
+	if err != nil {
+		t.Fatalf("Open(%s) error = %v", path, err)
+	}
+	defer f.Close()
+	data, err := io.ReadAll(f)
+	if err != nil {
+		t.Fatalf("read %s: %v", path, err)
+	}
+	return data
+}
+
+func fileHash(chunks [][]byte) string {
+	_, digest, _ := checksum.Split(checksum.SHA256.Sum(bytes.Join(chunks, nil)))
+	return digest
+}
+
+// flakyStorage fails the first failures calls to PutStream
+type flakyStorage struct {
+	storage.Storage
+	failures atomic.Int32
+}
+
+func (f *flakyStorage) PutStream(path string, r io.Reader) (int64, error) {
+	if f.failures.Add(-1) >= 0 {
+		return 0, errors.New("disk unavailable")
+	}
+	return f.Storage.PutStream(path, r)
+}
+
+func TestFailedStoreIsRetried(t *testing.T) {
+	local, err := storage.NewLocal(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewLocal() error = %v", err)
+	}
+	store := &flakyStorage{Storage: local}
+	store.failures.Store(1)
+	ts := newTestServer(t, store)
+
+	chunks := [][]byte{[]byte("hello "), []byte("world")}
+	id := ts.createUpload(t, "/a.txt", chunks)
+	if status, _, e := ts.putChunk(t, id, 0, chunks[0], checksum.SHA256.Sum(chunks[0])); e != nil {
+		t.Fatalf("chunk 0: %d %v", status, e)
+	}
+	status, _, e := ts.putChunk(t, id, 1, chunks[1], checksum.SHA256.Sum(chunks[1]))
+	if status != http.StatusInternalServerError {
+		t.Fatalf("chunk 1 with failing storage: %d %v, want 500", status, e)
+	}
+	if st := ts.status(t, id); !st.Exists || st.Completed || len(st.MissingChunks) != 0 {
+		t.Fatalf("status after failed store = %+v, want an incomplete upload with every chunk", st)
+	}
+
+	// The retry of the last chunk stores the file
+	status, resp, e := ts.putChunk(t, id, 1, chunks[1], checksum.SHA256.Sum(chunks[1]))
+	if e != nil || !resp.Complete {
+		t.Fatalf("retry of chunk 1: %d %+v %v, want a completed upload", status, resp, e)
+	}
+	if got := ts.stored(t, "/a.txt"); string(got) != "hello world" {
+		t.Errorf("stored %q", got)
+	}
+	if st := ts.status(t, id); st.Exists {
+		t.Errorf("upload still exists after it was stored: %+v", st)
+	}
+}
+
+func TestLostChunkIsRequested(t *testing.T) {
+	ts := newTestServer(t, nil)
+
+	chunks := [][]byte{[]byte("hello "), []byte("world")}
+	id := ts.createUpload(t, "/a.txt", chunks)
+	if status, _, e := ts.putChunk(t, id, 0, chunks[0], checksum.SHA256.Sum(chunks[0])); e != nil {
+		t.Fatalf("chunk 0: %d %v", status, e)
+	}
+	if err := os.WriteFile(filepath.Join(ts.chunksDir, id, chunkFileName(0)), []byte("rotten"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	status, _, e := ts.putChunk(t, id, 1, chunks[1], checksum.SHA256.Sum(chunks[1]))
+	if e == nil || e.Code != proto.CodeChunksMissing {
+		t.Fatalf("chunk 1 over a corrupt chunk 0: %d %v, want %s", status, e, proto.CodeChunksMissing)
+	}
+	if st := ts.status(t, id); !st.Exists || st.Completed || len(st.MissingChunks) != 1 || st.MissingChunks[0] != 0 {
+		t.Fatalf("status = %+v, want chunk 0 missing", st)
+	}
+
+	status, resp, e := ts.putChunk(t, id, 0, chunks[0], checksum.SHA256.Sum(chunks[0]))
+	if e != nil || !resp.Complete {
+		t.Fatalf("resent chunk 0: %d %+v %v, want a completed upload", status, resp, e)
+	}
+	if got := ts.stored(t, "/a.txt"); string(got) != "hello world" {
+		t.Errorf("stored %q", got)
+	}
+}
+
+func TestChunkChecksums(t *testing.T) {
+	data := []byte("hello world")
+	tests := []struct {
+		name   string
+		sum    string
+		status int
+		code   proto.Code // empty if the chunk is accepted
+	}{
+		{"valid", checksum.SHA256.Sum(data), http.StatusOK, ""},
+		{"untagged", checksum.SHA256.Sum(data)[len("sha256:"):], http.StatusOK, ""},
+		{"missing", "", http.StatusBadRequest, proto.CodeChecksumRequired},
+		{"wrong data", checksum.SHA256.Sum([]byte("hello there")), StatusChecksumMismatch, proto.CodeChecksumMismatch},
+		{"other algorithm", checksum.BLAKE3.Sum(data), http.StatusBadRequest, proto.CodeBadRequest},
+		{"malformed", "sha256:beef", http.StatusBadRequest, proto.CodeBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newTestServer(t, nil)
+			id := ts.createUpload(t, "/a.txt", [][]byte{data})
+
+			status, resp, e := ts.putChunk(t, id, 0, data, tt.sum)
+			if status != tt.status || (e == nil) != (tt.code == "") || (e != nil && e.Code != tt.code) {
+				t.Fatalf("putChunk() = %d %v, want %d %q", status, e, tt.status, tt.code)
+			}
+			if e == nil {
+				if !resp.Complete {
+					t.Errorf("accepted chunk didn't complete the upload: %+v", resp)
+				}
+				return
+			}
+
+			// The rejected chunk isn't recorded, and sending it with the right
+			// checksum completes the upload
+			if st := ts.status(t, id); !st.Exists || len(st.MissingChunks) != 1 {
+				t.Fatalf("status after rejected chunk = %+v, want chunk 0 missing", st)
+			}
+			if status, resp, e := ts.putChunk(t, id, 0, data, checksum.SHA256.Sum(data)); e != nil || !resp.Complete {
+				t.Fatalf("retry: %d %+v %v, want a completed upload", status, resp, e)
+			}
+			if got := ts.stored(t, "/a.txt"); !bytes.Equal(got, data) {
+				t.Errorf("stored %q", got)
+			}
+		})
+	}
+}
+
+func TestFileHashMismatch(t *testing.T) {
+	ts := newTestServer(t, nil)
+
+	chunks := [][]byte{[]byte("hello "), []byte("world")}
+	id := ts.create(t, proto.CreateUploadRequest{Path: "/a.txt", TotalChunks: 2, ChunkSize: 6, FileHash: fileHash([][]byte{[]byte("other")})})
+	if status, _, e := ts.putChunk(t, id, 0, chunks[0], checksum.SHA256.Sum(chunks[0])); e != nil {
+		t.Fatalf("chunk 0: %d %v", status, e)
+	}
+	status, _, e := ts.putChunk(t, id, 1, chunks[1], checksum.SHA256.Sum(chunks[1]))
+	if status != StatusFileHashMismatch || e == nil || e.Code != proto.CodeFileHashMismatch {
+		t.Fatalf("last chunk of a file with the wrong hash: %d %v, want %d %s", status, e, StatusFileHashMismatch, proto.CodeFileHashMismatch)
+	}
+
+	// The upload is discarded and nothing is stored
+	if st := ts.status(t, id); st.Exists {
+		t.Errorf("rejected upload still exists: %+v", st)
+	}
+	if ts.storage.Exists("/a.txt") {
+		t.Error("rejected upload was stored")
+	}
+	if status, _, e := ts.putChunk(t, id, 1, chunks[1], checksum.SHA256.Sum(chunks[1])); e == nil || e.Code != proto.CodeUploadNotFound {
+		t.Errorf("chunk of a rejected upload: %d %v, want %s", status, e, proto.CodeUploadNotFound)
+	}
+}
+
+func TestConcurrentChunks(t *testing.T) {
+	ts := newTestServer(t, nil)
+
+	chunks := make([][]byte, 32)
+	for i := range chunks {
+		chunks[i] = bytes.Repeat([]byte{byte('a' + i%26)}, 1000)
+	}
+	id := ts.createUpload(t, "/a.txt", chunks)
+
+	// Send every chunk twice at once; exactly one of them completes the
+	// upload, and copies that arrive after it find the upload gone
+	type result struct {
+		status int
+		resp   proto.ChunkResponse
+	}
+	results := make(chan result, 2*len(chunks))
+	var wg sync.WaitGroup
+	for i, data := range chunks {
+		for range 2 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				req, _ := http.NewRequest("PUT", ts.url+"/upload/"+id+"/chunks/"+strconv.Itoa(i), bytes.NewReader(data))
+				req.Header.Set(proto.HeaderChecksum, checksum.SHA256.Sum(data))
+				resp, err := http.DefaultClient.Do(req)
+				if err != nil {
+					results <- result{}
+					return
+				}
+				defer resp.Body.Close()
+				var r result
+				r.status = resp.StatusCode
+				if resp.StatusCode == http.StatusOK {
+					json.NewDecoder(resp.Body).Decode(&r.resp)
+				} else if e := proto.ReadError(resp); e.Code != proto.CodeUploadNotFound {
+					r.status = 0
+				}
+				results <- r
+			}()
+		}
+	}
+	wg.Wait()
+	close(results)
+
+	completions := 0
+	for r := range results {
+		switch {
+		case r.status == 0:
+			t.Error("chunk failed")
+		case r.resp.Complete:
+			completions++
+		}
+	}
+	if completions != 1 {
+		t.Errorf("%d chunks completed the upload, want 1", completions)
+	}
+	if got := ts.stored(t, "/a.txt"); !bytes.Equal(got, bytes.Join(chunks, nil)) {
+		t.Errorf("stored %d bytes that differ from the %d sent", len(got), 32*1000)
+	}
+}
+
+func TestLegacyUploads(t *testing.T) {
+	chunks := [][]byte{[]byte("hello "), []byte("world")}
+
+	tests := []struct {
+		name string
+		send func(t *testing.T, ts *testServer, n int) (int, *proto.Error)
+	}{
+		{"binary", func(t *testing.T, ts *testServer, n int) (int, *proto.Error) {
+			header := http.Header{}
+			header.Set(proto.HeaderPath, "/a.txt")
+			header.Set(proto.HeaderTotal, "2")
+			header.Set(proto.HeaderChecksum, checksum.SHA256.Sum(chunks[n]))
+			header.Set(proto.HeaderFileHash, fileHash(chunks))
+			return ts.do(t, "PUT", "/upload/chunks/"+strconv.Itoa(n), header, bytes.NewReader(chunks[n]), nil)
+		}},
+		{"json", func(t *testing.T, ts *testServer, n int) (int, *proto.Error) {
+			data, _ := json.Marshal(proto.ChunkData{Path: "/a.txt", ChunkID: n, Data: chunks[n], Checksum: checksum.SHA256.Sum(chunks[n]), Total: 2, FileHash: fileHash(chunks)})
+			return ts.do(t, "POST", "/upload", nil, bytes.NewReader(data), nil)
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newTestServer(t, nil)
+
+			// An upload created for the same path is a separate session
+			created := ts.createUpload(t, "/a.txt", chunks)
+			if created == resume.LegacySessionID("/a.txt") {
+				t.Fatal("created upload has the legacy session ID of its path")
+			}
+
+			if status, e := tt.send(t, ts, 0); e != nil {
+				t.Fatalf("chunk 0: %d %v", status, e)
+			}
+			var st proto.UploadStatus
+			if status, e := ts.do(t, "GET", "/upload/status?path=/a.txt", nil, nil, &st); e != nil {
+				t.Fatalf("status by path: %d %v", status, e)
+			}
+			if !st.Exists || st.UploadID != resume.LegacySessionID("/a.txt") || len(st.MissingChunks) != 1 || st.MissingChunks[0] != 1 {
+				t.Fatalf("status by path = %+v, want the legacy session missing chunk 1", st)
+			}
+			if st := ts.status(t, created); len(st.MissingChunks) != 2 {
+				t.Fatalf("created upload = %+v, want it untouched by the legacy chunk", st)
+			}
+
+			if status, e := tt.send(t, ts, 1); e != nil {
+				t.Fatalf("chunk 1: %d %v", status, e)
+			}
+			if got := ts.stored(t, "/a.txt"); string(got) != "hello world" {
+				t.Errorf("stored %q", got)
+			}
+			if st := ts.status(t, created); !st.Exists {
+				t.Error("completing the legacy session removed the created upload")
+			}
+		})
+	}
+}
+
+func TestUploadManifest(t *testing.T) {
+	old := [][]byte{[]byte("aaaa"), []byte("bbbb")}
+	tests := []struct {
+		name    string
+		cas     bool     // store files in a CAS instead of a directory
+		chunks  [][]byte // chunks of the new upload
+		sent    []int    // chunks sent before the manifest
+		alg     checksum.Algorithm
+		linked  bool
+		reused  int
+		missing []int
+	}{
+		{"whole file linked", true, old, nil, checksum.SHA256, true, 0, []int{}},
+		{"stored chunk reused", false, [][]byte{[]byte("bbbb"), []byte("cccc")}, nil, checksum.SHA256, false, 1, []int{1}},
+		{"chunk of the upload reused", false, [][]byte{[]byte("dddd"), []byte("dddd"), []byte("eeee")}, []int{0}, checksum.SHA256, false, 1, []int{2}},
+		{"nothing reused", false, [][]byte{[]byte("xxxx"), []byte("yyyy")}, nil, checksum.SHA256, false, 0, []int{0, 1}},
+		{"weak checksums not trusted", false, [][]byte{[]byte("dddd"), []byte("dddd"), []byte("eeee")}, []int{0}, checksum.XXH3, false, 0, []int{1, 2}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var store storage.Storage
+			if tt.cas {
+				cas, err := storage.NewCAS(t.TempDir())
+				if err != nil {
+					t.Fatalf("NewCAS() error = %v", err)
+				}
+				store = cas
+			}
+			ts := newTestServer(t, store)
+			ts.SetChecksums([]checksum.Algorithm{checksum.SHA256, checksum.XXH3})
+
+			// Store a file through an upload, so its chunks are indexed
+			oldID := ts.createUpload(t, "/old.txt", old)
+			for i, data := range old {
+				if status, _, e := ts.putChunk(t, oldID, i, data, checksum.SHA256.Sum(data)); e != nil {
+					t.Fatalf("old chunk %d: %d %v", i, status, e)
+				}
+			}
+
+			id := ts.create(t, proto.CreateUploadRequest{Path: "/new.txt", TotalChunks: len(tt.chunks), ChunkSize: 4, FileHash: fileHash(tt.chunks), Checksum: string(tt.alg)})
+			for _, i := range tt.sent {
+				if status, _, e := ts.putChunk(t, id, i, tt.chunks[i], tt.alg.Sum(tt.chunks[i])); e != nil {
+					t.Fatalf("chunk %d: %d %v", i, status, e)
+				}
+			}
+			manifest := proto.Manifest{Size: int64(len(bytes.Join(tt.chunks, nil))), FileHash: fileHash(tt.chunks)}
+			for _, data := range tt.chunks {
+				manifest.ChunkHashes = append(manifest.ChunkHashes, tt.alg.Sum(data))
+			}
+			data, _ := json.Marshal(manifest)
+			var resp proto.ManifestResponse
+			if status, e := ts.do(t, "POST", "/upload/"+id+"/manifest", nil, bytes.NewReader(data), &resp); e != nil {
+				t.Fatalf("manifest: %d %v", status, e)
+			}
+			if resp.Linked != tt.linked || resp.ReusedChunks != tt.reused || !slices.Equal(resp.MissingChunks, tt.missing) {
+				t.Fatalf("manifest answer = %+v, want linked %v, %d reused, missing %v", resp, tt.linked, tt.reused, tt.missing)
+			}
+
+			for _, i := range tt.missing {
+				if status, _, e := ts.putChunk(t, id, i, tt.chunks[i], tt.alg.Sum(tt.chunks[i])); e != nil {
+					t.Fatalf("missing chunk %d: %d %v", i, status, e)
+				}
+			}
+			if got := ts.stored(t, "/new.txt"); !bytes.Equal(got, bytes.Join(tt.chunks, nil)) {
+				t.Errorf("stored %q", got)
+			}
+		})
+	}
+}
+
+func TestStatAndList(t *testing.T) {
+	ts := newTestServer(t, nil)
+	for i, name := range []string{"e", "b", "d", "a", "c"} {
+		if err := ts.storage.Put("/dir/"+name, bytes.Repeat([]byte("x"), i+1)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var info proto.FileInfo
+	if status, e := ts.do(t, "GET", "/stat?path=/dir/d", nil, nil, &info); e != nil {
+		t.Fatalf("stat: %d %v", status, e)
+	}
+	if info.Name != "d" || info.Size != 3 || info.IsDir {
+		t.Errorf("stat /dir/d = %+v", info)
+	}
+	if status, e := ts.do(t, "GET", "/stat?path=/dir", nil, nil, &info); e != nil || !info.IsDir {
+		t.Errorf("stat /dir = %d %+v %v, want a directory", status, info, e)
+	}
+
+	tests := []struct {
+		query  string
+		names  []string
+		total  int
+		next   int
+		status int
+	}{
+		{"", []string{"a", "b", "c", "d", "e"}, 5, 0, http.StatusOK},
+		{"&limit=2", []string{"a", "b"}, 5, 2, http.StatusOK},
+		{"&limit=2&offset=2", []string{"c", "d"}, 5, 4, http.StatusOK},
+		{"&limit=2&offset=4", []string{"e"}, 5, 0, http.StatusOK},
+		{"&offset=9", []string{}, 5, 0, http.StatusOK},
+		{"&sort=size&reverse=true&limit=3", []string{"c", "a", "d"}, 5, 3, http.StatusOK},
+		{"&offset=-1", nil, 0, 0, http.StatusBadRequest},
+		{"&limit=x", nil, 0, 0, http.StatusBadRequest},
+		{"&sort=color", nil, 0, 0, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		var listing proto.Listing
+		status, e := ts.do(t, "GET", "/list?path=/dir&detail=true"+tt.query, nil, nil, &listing)
+		if status != tt.status {
+			t.Errorf("list%s: %d %v, want %d", tt.query, status, e, tt.status)
+			continue
+		}
+		if e != nil {
+			continue
+		}
+		var names []string
+		for _, entry := range listing.Entries {
+			names = append(names, entry.Name)
+		}
+		if !slices.Equal(names, tt.names) || listing.Total != tt.total || listing.NextOffset != tt.next {
+			t.Errorf("list%s = %v of %d, next %d; want %v of %d, next %d", tt.query, names, listing.Total, listing.NextOffset, tt.names, tt.total, tt.next)
+		}
+	}
+
+	for _, route := range []string{"/stat?path=/missing", "/list?path=/missing&detail=true"} {
+		if status, e := ts.do(t, "GET", route, nil, nil, nil); status != http.StatusNotFound || e.Code != proto.CodeNotFound {
+			t.Errorf("%s: %d %v, want %s", route, status, e, proto.CodeNotFound)
+		}
+	}
+}
+
+func TestSignatureAndDelta(t *testing.T) {
+	ts := newTestServer(t, nil)
+
+	rng := rand.New(rand.NewSource(1))
+	old := make([]byte, 40000)
+	rng.Read(old)
+	if err := ts.storage.Put("/a.bin", old); err != nil {
+		t.Fatal(err)
+	}
+	new := append(append([]byte(nil), old[:20000]...), append([]byte("inserted"), old[20000:]...)...)
+
+	var sig proto.Signature
+	if status, e := ts.do(t, "GET", "/signature?path=/a.bin&block_size=1024", nil, nil, &sig); e != nil {
+		t.Fatalf("signature: %d %v", status, e)
+	}
+	if sig.Size != int64(len(old)) || sig.BlockSize != 1024 || sig.ETag == "" {
+		t.Fatalf("signature = size %d, block size %d, etag %q", sig.Size, sig.BlockSize, sig.ETag)
+	}
+
+	// sendDelta sends the delta from sig to content, declaring its hash
+	sendDelta := func(content []byte, hash string) (int, proto.DeltaResult, *proto.Error) {
+		var d bytes.Buffer
+		if _, err := delta.Diff(&sig, bytes.NewReader(content), &d); err != nil {
+			t.Fatalf("Diff() error = %v", err)
+		}
+		query := url.Values{
+			"path":       {"/a.bin"},
+			"etag":       {sig.ETag},
+			"block_size": {strconv.Itoa(sig.BlockSize)},
+			"size":       {strconv.Itoa(len(content))},
+			"file_hash":  {hash},
+		}
+		var result proto.DeltaResult
+		status, e := ts.do(t, "POST", "/delta?"+query.Encode(), nil, &d, &result)
+		return status, result, e
+	}
+
+	// A delta that doesn't rebuild the declared file is refused
+	status, _, e := sendDelta(new, fileHash([][]byte{old}))
+	if status != StatusFileHashMismatch {
+		t.Fatalf("delta with the wrong hash: %d %v, want %d", status, e, StatusFileHashMismatch)
+	}
+	if got := ts.stored(t, "/a.bin"); !bytes.Equal(got, old) {
+		t.Fatal("refused delta changed the file")
+	}
+
+	status, result, e := sendDelta(new, fileHash([][]byte{new}))
+	if e != nil {
+		t.Fatalf("delta: %d %v", status, e)
+	}
+	if result.Size != int64(len(new)) || result.LiteralBytes > 2048 {
+		t.Errorf("delta result = %+v", result)
+	}
+	if got := ts.stored(t, "/a.bin"); !bytes.Equal(got, new) {
+		t.Fatal("delta stored the wrong content")
+	}
+
+	// The signature no longer describes the stored file
+	if status, _, e := sendDelta(old, fileHash([][]byte{old})); status != http.StatusPreconditionFailed || e.Code != proto.CodeChanged {
+		t.Errorf("delta against a changed file: %d %v, want %s", status, e, proto.CodeChanged)
+	}
+
+	if status, e := ts.do(t, "GET", "/signature?path=/missing", nil, nil, nil); status != http.StatusNotFound || e.Code != proto.CodeNotFound {
+		t.Errorf("signature of a missing file: %d %v, want %s", status, e, proto.CodeNotFound)
+	}
+}
+
+func TestDeltaPermissions(t *testing.T) {
+	var tokens []auth.Token
+	for name, permissions := range map[string][]string{
+		"upload":   {"upload"},
+		"download": {"download"},
+		"both":     {"upload", "download"},
+	} {
+		hash := sha256.Sum256([]byte(name))
+		tokens = append(tokens, auth.Token{
+			ID:          name,
+			TokenHash:   hex.EncodeToString(hash[:]),
+			User:        name,
+			Permissions: permissions,
+			ExpiresAt:   time.Now().Add(time.Hour),
+		})
+	}
+	tokensFile := filepath.Join(t.TempDir(), "tokens.json")
+	data, _ := json.Marshal(auth.TokenStoreFile{Tokens: tokens})
+	if err := os.WriteFile(tokensFile, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	tokenStore, err := auth.NewTokenStore(tokensFile)
+	if err != nil {
+		t.Fatalf("NewTokenStore() error = %v", err)
+	}
+
+	store, err := storage.NewLocal(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewLocal() error = %v", err)
+	}
+	if err := store.Put("/a.bin", []byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	s, err := New(store, t.TempDir())
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	s.EnableAuth(tokenStore)
+	srv := httptest.NewServer(s.routes(""))
+	t.Cleanup(srv.Close)
+	ts := &testServer{Server: s, url: srv.URL}
+
+	// A signature reveals the content of the file, so it takes download as
+	// well as upload permission
+	for _, tt := range []struct {
+		token  string
+		status int
+	}{
+		{"upload", http.StatusForbidden},
+		{"download", http.StatusForbidden},
+		{"both", http.StatusOK},
+	} {
+		header := http.Header{"Authorization": {"Bearer " + tt.token}}
+		if status, e := ts.do(t, "GET", "/signature?path=/a.bin", header, nil, nil); status != tt.status {
+			t.Errorf("signature as %s: %d %v, want %d", tt.token, status, e, tt.status)
+		}
+		if tt.status != http.StatusOK {
+			if status, e := ts.do(t, "POST", "/delta?path=/a.bin", header, nil, nil); status != tt.status {
+				t.Errorf("delta as %s: %d %v, want %d", tt.token, status, e, tt.status)
+			}
+		}
+	}
+}
+
+func TestDownload(t *testing.T) {
+	ts := newTestServer(t, nil)
+	if err := ts.storage.Put("/dir/a.txt", []byte("hello world")); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		path   string
+		rng    string
+		status int
+		code   proto.Code
+		body   string
+	}{
+		{"file", "/dir/a.txt", "", http.StatusOK, "", "hello world"},
+		{"range", "/dir/a.txt", "bytes=6-", http.StatusPartialContent, "", "world"},
+		{"missing", "/dir/b.txt", "", http.StatusNotFound, proto.CodeNotFound, ""},
+		{"directory", "/dir", "", http.StatusBadRequest, proto.CodeBadRequest, ""},
+		{"no path", "", "", http.StatusBadRequest, proto.CodeBadRequest, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, _ := http.NewRequest("GET", ts.url+"/download?path="+url.QueryEscape(tt.path), nil)
+			if tt.rng != "" {
+				req.Header.Set("Range", tt.rng)
+			}
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+
+			if resp.StatusCode != tt.status {
+				t.Fatalf("status = %d, want %d", resp.StatusCode, tt.status)
+			}
+			if tt.code != "" {
+				if e := proto.ReadError(resp); e.Code != tt.code {
+					t.Errorf("error = %v, want %s", e, tt.code)
+				}
+				return
+			}
+			body, _ := io.ReadAll(resp.Body)
+			if string(body) != tt.body || resp.Header.Get("ETag") == "" {
+				t.Errorf("body %q with ETag %q, want %q", body, resp.Header.Get("ETag"), tt.body)
+			}
+			if want := fileHash([][]byte{[]byte("hello world")}); resp.Header.Get(proto.HeaderFileHash) != want {
+				t.Errorf("hash %q, want %q", resp.Header.Get(proto.HeaderFileHash), want)
+			}
+		})
+	}
+}
+
+func TestDownloadETagFollowsContent(t *testing.T) {
+	ts := newTestServer(t, nil)
+	modTime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	etag := func(data string) string {
+		t.Helper()
+		if err := ts.storage.Put("/a.txt", []byte(data)); err != nil {
+			t.Fatal(err)
+		}
+		if err := ts.storage.SetAttr("/a.txt", 0, modTime); err != nil {
+			t.Fatal(err)
+		}
+		resp, err := http.Head(ts.url + "/download?path=/a.txt")
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.Header.Get("ETag")
+	}
+
+	// A replacement with the same size and time must not resume into the
+	// previous version
+	if first, second := etag("hello"), etag("world"); first == second {
+		t.Errorf("replaced file kept ETag %s", first)
+	}
+}
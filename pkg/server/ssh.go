@@ -0,0 +1,498 @@
+package server
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/0xRepo-Source/goflux/pkg/auth"
+	"github.com/0xRepo-Source/goflux/pkg/storage"
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+)
+
+// Extensions of ssh.Permissions carrying the authenticated goflux user
+const (
+	sshUserExtension        = "goflux-user"
+	sshPermissionsExtension = "goflux-permissions"
+)
+
+// EnableSSH makes ssh listeners serve the storage backend over the SFTP
+// subsystem, authenticating clients by the public keys mapped in keys. The
+// host key is read from hostKeyFile, which is created with a new ed25519
+// key if it does not exist.
+func (s *Server) EnableSSH(hostKeyFile string, keys *auth.KeyStore) error {
+	hostKey, err := loadHostKey(hostKeyFile)
+	if err != nil {
+		return err
+	}
+
+	// Files uploaded over SFTP are staged here until they are closed
+	stagingDir := filepath.Join(filepath.Dir(s.chunksDir), "sftp")
+	if err := os.RemoveAll(stagingDir); err != nil {
+		return fmt.Errorf("failed to clear sftp staging directory: %w", err)
+	}
+	if err := os.MkdirAll(stagingDir, 0700); err != nil {
+		return fmt.Errorf("failed to create sftp staging directory: %w", err)
+	}
+
+	config := &ssh.ServerConfig{
+		PublicKeyCallback: func(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			user, permissions, err := keys.Validate(key)
+			if err != nil {
+				return nil, err
+			}
+			return &ssh.Permissions{Extensions: map[string]string{
+				sshUserExtension:        user,
+				sshPermissionsExtension: strings.Join(permissions, ","),
+			}}, nil
+		},
+	}
+	config.AddHostKey(hostKey)
+
+	s.sshConfig = config
+	s.sftpDir = stagingDir
+	return nil
+}
+
+// sshListener serves the storage backend over SFTP on a TCP address
+type sshListener struct {
+	server *Server
+	addr   string
+}
+
+// Serve accepts SSH connections until the listener fails. SSH does not
+// carry the HTTP API, so handler is unused.
+func (l *sshListener) Serve(handler http.Handler) error {
+	ln, err := net.Listen("tcp", l.addr)
+	if err != nil {
+		return err
+	}
+	defer ln.Close()
+
+	fmt.Printf("goflux sftp listening on %s\n", l.addr)
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return err
+		}
+		go l.server.serveSSH(conn)
+	}
+}
+
+func (l *sshListener) String() string {
+	return "ssh " + l.addr
+}
+
+// serveSSH runs an SSH connection, answering its session channels
+func (s *Server) serveSSH(nConn net.Conn) {
+	defer nConn.Close()
+
+	// Don't let unauthenticated connections linger
+	nConn.SetDeadline(time.Now().Add(30 * time.Second))
+	conn, chans, reqs, err := ssh.NewServerConn(nConn, s.sshConfig)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	nConn.SetDeadline(time.Time{})
+	go ssh.DiscardRequests(reqs)
+
+	user := conn.Permissions.Extensions[sshUserExtension]
+	permissions := strings.Split(conn.Permissions.Extensions[sshPermissionsExtension], ",")
+
+	for newChannel := range chans {
+		if newChannel.ChannelType() != "session" {
+			newChannel.Reject(ssh.UnknownChannelType, "only session channels are supported")
+			continue
+		}
+		channel, requests, err := newChannel.Accept()
+		if err != nil {
+			continue
+		}
+		go s.serveSSHSession(channel, requests, user, permissions)
+	}
+}
+
+// serveSSHSession serves the sftp subsystem on a session channel. Shells
+// and commands are refused, so clients such as scp must speak SFTP.
+func (s *Server) serveSSHSession(channel ssh.Channel, requests <-chan *ssh.Request, user string, permissions []string) {
+	defer channel.Close()
+
+	for req := range requests {
+		var subsystem struct{ Name string }
+		if req.Type != "subsystem" || ssh.Unmarshal(req.Payload, &subsystem) != nil || subsystem.Name != "sftp" {
+			// Requests such as env and pty-req are harmless to refuse
+			if req.WantReply {
+				req.Reply(false, nil)
+			}
+			continue
+		}
+		req.Reply(true, nil)
+
+		handler := &sftpHandler{server: s, user: user, permissions: permissions, uploads: make(map[string]*sftpUpload)}
+		server := sftp.NewRequestServer(channel, sftp.Handlers{
+			FileGet:  handler,
+			FilePut:  handler,
+			FileCmd:  handler,
+			FileList: handler,
+		})
+		status := uint32(0)
+		if err := server.Serve(); err != nil && !errors.Is(err, io.EOF) {
+			status = 1
+		}
+		// Closing the server closes the channel, so report the status first
+		channel.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{status}))
+		server.Close()
+		return
+	}
+}
+
+// loadHostKey reads a PEM encoded private key, creating an ed25519 key if
+// the file does not exist
+func loadHostKey(filename string) (ssh.Signer, error) {
+	data, err := os.ReadFile(filename)
+	if os.IsNotExist(err) {
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate ssh host key: %w", err)
+		}
+		block, err := ssh.MarshalPrivateKey(key, "goflux host key")
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode ssh host key: %w", err)
+		}
+		data = pem.EncodeToMemory(block)
+		if err := os.WriteFile(filename, data, 0600); err != nil {
+			return nil, fmt.Errorf("failed to write ssh host key: %w", err)
+		}
+		fmt.Printf("Generated SSH host key: %s\n", filename)
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read ssh host key: %w", err)
+	}
+
+	signer, err := ssh.ParsePrivateKey(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse ssh host key %s: %w", filename, err)
+	}
+	fmt.Printf("SSH host key fingerprint: %s\n", ssh.FingerprintSHA256(signer.PublicKey()))
+	return signer, nil
+}
+
+// sftpHandler answers the SFTP requests of one session from the storage
+// backend, with the permissions of the session's user.
+type sftpHandler struct {
+	server      *Server
+	user        string
+	permissions []string
+
+	mu      sync.Mutex
+	uploads map[string]*sftpUpload // files being written, by path
+}
+
+// require fails with a permission error unless the user has permission
+func (h *sftpHandler) require(permission string) error {
+	if !auth.HasPermission(h.permissions, permission) {
+		return sftp.ErrSSHFxPermissionDenied
+	}
+	return nil
+}
+
+// Fileread opens a stored file for reading
+func (h *sftpHandler) Fileread(r *sftp.Request) (io.ReaderAt, error) {
+	if err := h.require("download"); err != nil {
+		return nil, err
+	}
+	file, err := h.server.storage.Open(sftpPath(r))
+	if err != nil {
+		return nil, sftpError(err)
+	}
+	return file, nil
+}
+
+// Filewrite stages a file in the sftp directory; it replaces the stored
+// file when the client closes it. Opening without truncation starts from
+// the stored content, so clients can resume uploads.
+func (h *sftpHandler) Filewrite(r *sftp.Request) (io.WriterAt, error) {
+	if err := h.require("upload"); err != nil {
+		return nil, err
+	}
+	p := sftpPath(r)
+
+	tmp, err := os.CreateTemp(h.server.sftpDir, "put-*")
+	if err != nil {
+		return nil, err
+	}
+	w := &sftpUpload{File: tmp, handler: h, path: p}
+
+	if flags := r.Pflags(); !flags.Trunc {
+		if stored, err := h.server.storage.Open(p); err == nil {
+			_, err = io.Copy(tmp, stored)
+			stored.Close()
+			if err != nil {
+				w.discard()
+				return nil, err
+			}
+		}
+	}
+
+	h.mu.Lock()
+	h.uploads[p] = w
+	h.mu.Unlock()
+	return w, nil
+}
+
+// Filecmd answers commands that change the namespace, each with the
+// permission of the matching HTTP endpoint. Uploads create directories
+// implicitly, so upload permission is enough for mkdir too. Renames never
+// replace an existing file, even as posix-rename. Attribute changes other
+// than mode and modification time are ignored.
+func (h *sftpHandler) Filecmd(r *sftp.Request) error {
+	p := sftpPath(r)
+	switch r.Method {
+	case "Setstat":
+		if err := h.require("upload"); err != nil {
+			return err
+		}
+		return h.setstat(p, r)
+	case "Mkdir":
+		if h.require("mkdir") != nil && h.require("upload") != nil {
+			return sftp.ErrSSHFxPermissionDenied
+		}
+		return sftpError(h.server.storage.Mkdir(p))
+	case "Remove", "Rmdir":
+		if err := h.require("delete"); err != nil {
+			return err
+		}
+		info, err := h.stat(p)
+		if err != nil {
+			return err
+		}
+		if info.IsDir() != (r.Method == "Rmdir") {
+			return sftp.ErrSSHFxFailure
+		}
+		return sftpError(h.server.storage.Delete(p, false))
+	case "Rename", "PosixRename":
+		if err := h.require("move"); err != nil {
+			return err
+		}
+		return sftpError(h.server.storage.Rename(p, path.Clean("/"+r.Target)))
+	}
+	return sftp.ErrSSHFxOpUnsupported
+}
+
+// Filelist answers directory listings and stats
+func (h *sftpHandler) Filelist(r *sftp.Request) (sftp.ListerAt, error) {
+	p := sftpPath(r)
+	switch r.Method {
+	case "List":
+		if err := h.require("list"); err != nil {
+			return nil, err
+		}
+		entries, err := h.server.storage.ReadDir(p)
+		if err != nil {
+			return nil, sftpError(err)
+		}
+		infos := make([]os.FileInfo, len(entries))
+		for i := range entries {
+			infos[i] = &sftpFileInfo{entries[i]}
+		}
+		return listerAt(infos), nil
+	case "Stat":
+		// Clients stat paths before uploading and downloading too
+		if h.require("list") != nil && h.require("download") != nil && h.require("upload") != nil {
+			return nil, sftp.ErrSSHFxPermissionDenied
+		}
+		info, err := h.stat(p)
+		if err != nil {
+			return nil, err
+		}
+		return listerAt{info}, nil
+	}
+	return nil, sftp.ErrSSHFxOpUnsupported
+}
+
+// setstat sets the mode and modification time of p. Clients set them on a
+// file they're still writing too, so for a file being uploaded they're
+// kept until it's stored.
+func (h *sftpHandler) setstat(p string, r *sftp.Request) error {
+	flags, attrs := r.AttrFlags(), r.Attributes()
+	var mode fs.FileMode
+	var modTime time.Time
+	if flags.Permissions {
+		mode = attrs.FileMode().Perm()
+	}
+	if flags.Acmodtime {
+		modTime = attrs.ModTime()
+	}
+	if mode == 0 && modTime.IsZero() {
+		return nil
+	}
+
+	h.mu.Lock()
+	if u, ok := h.uploads[p]; ok {
+		if mode != 0 {
+			u.mode = mode
+		}
+		if !modTime.IsZero() {
+			u.modTime = modTime
+		}
+		h.mu.Unlock()
+		return nil
+	}
+	h.mu.Unlock()
+
+	err := h.server.storage.SetAttr(p, mode, modTime)
+	if errors.Is(err, storage.ErrInvalidPath) {
+		// The root keeps its attributes
+		return nil
+	}
+	return sftpError(err)
+}
+
+// stat describes the stored file or directory at p
+func (h *sftpHandler) stat(p string) (os.FileInfo, error) {
+	info, err := h.server.storage.Stat(p)
+	if err != nil {
+		return nil, sftpError(err)
+	}
+	return &sftpFileInfo{*info}, nil
+}
+
+// sftpPath returns the storage path of a request
+func sftpPath(r *sftp.Request) string {
+	return path.Clean("/" + r.Filepath)
+}
+
+// sftpError maps storage errors to SFTP status codes
+func sftpError(err error) error {
+	if errors.Is(err, os.ErrNotExist) {
+		return os.ErrNotExist
+	}
+	return err
+}
+
+// sftpUpload is a file being uploaded over SFTP, staged on local disk so
+// clients can write it out of order
+type sftpUpload struct {
+	*os.File
+	handler *sftpHandler
+	path    string
+
+	// Set by setstat while the file is written; guarded by handler.mu
+	mode    fs.FileMode
+	modTime time.Time
+
+	// failed is the first error that lost data of the file, a failed write
+	// or the connection ending with the file open; guarded by handler.mu
+	failed error
+}
+
+// WriteAt writes to the staged file, enforcing the server's file size limit
+func (u *sftpUpload) WriteAt(p []byte, off int64) (int, error) {
+	if max := u.handler.server.maxFileSize; max > 0 && off+int64(len(p)) > max {
+		err := fmt.Errorf("file exceeds limit of %d bytes", max)
+		u.fail(err)
+		return 0, err
+	}
+	n, err := u.File.WriteAt(p, off)
+	if err != nil {
+		u.fail(err)
+	}
+	return n, err
+}
+
+// TransferError is called by the sftp server when the connection ends
+// while the file is open, before it closes the file
+func (u *sftpUpload) TransferError(err error) {
+	u.fail(fmt.Errorf("connection lost: %w", err))
+}
+
+// fail records an error that makes the staged file incomplete
+func (u *sftpUpload) fail(err error) {
+	u.handler.mu.Lock()
+	if u.failed == nil {
+		u.failed = err
+	}
+	u.handler.mu.Unlock()
+}
+
+// Close stores the staged file, unless part of it was lost
+func (u *sftpUpload) Close() error {
+	defer u.discard()
+
+	u.handler.mu.Lock()
+	failed := u.failed
+	u.handler.mu.Unlock()
+	if failed != nil {
+		fmt.Printf("Discarded sftp upload of %s: %v\n", u.path, failed)
+		return failed
+	}
+
+	if _, err := u.File.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	storage := u.handler.server.storage
+	size, err := storage.PutStream(u.path, u.File)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("File saved over sftp: %s (%d bytes, user %s)\n", u.path, size, u.handler.user)
+
+	u.handler.mu.Lock()
+	mode, modTime := u.mode, u.modTime
+	u.handler.mu.Unlock()
+	if mode != 0 || !modTime.IsZero() {
+		return storage.SetAttr(u.path, mode, modTime)
+	}
+	return nil
+}
+
+// discard removes the staged file
+func (u *sftpUpload) discard() {
+	u.handler.mu.Lock()
+	if u.handler.uploads[u.path] == u {
+		delete(u.handler.uploads, u.path)
+	}
+	u.handler.mu.Unlock()
+
+	u.File.Close()
+	os.Remove(u.File.Name())
+}
+
+// listerAt serves a fixed list of file infos
+type listerAt []os.FileInfo
+
+func (l listerAt) ListAt(buf []os.FileInfo, offset int64) (int, error) {
+	if offset >= int64(len(l)) {
+		return 0, io.EOF
+	}
+	n := copy(buf, l[offset:])
+	if n < len(buf) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// sftpFileInfo adapts a storage.FileInfo to os.FileInfo
+type sftpFileInfo struct {
+	info storage.FileInfo
+}
+
+func (fi *sftpFileInfo) Name() string       { return fi.info.Name }
+func (fi *sftpFileInfo) Size() int64        { return fi.info.Size }
+func (fi *sftpFileInfo) Mode() os.FileMode  { return fi.info.Mode }
+func (fi *sftpFileInfo) ModTime() time.Time { return fi.info.ModTime }
+func (fi *sftpFileInfo) IsDir() bool        { return fi.info.IsDir }
+func (fi *sftpFileInfo) Sys() any           { return nil }
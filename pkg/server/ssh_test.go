@@ -0,0 +1,134 @@
+package server
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/json"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/0xRepo-Source/goflux/pkg/auth"
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+)
+
+// sshTestServer serves a test server's storage over SFTP and returns the
+// address to dial and the client configuration of a user allowed to upload
+func sshTestServer(t *testing.T, ts *testServer) (string, *ssh.ClientConfig) {
+	t.Helper()
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	keysFile := filepath.Join(dir, "ssh-keys.json")
+	data, _ := json.Marshal(auth.KeyStoreFile{Keys: []auth.KeyMapping{{
+		Key:         string(ssh.MarshalAuthorizedKey(signer.PublicKey())),
+		User:        "ops",
+		Permissions: []string{"upload", "download", "list"},
+	}}})
+	if err := os.WriteFile(keysFile, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	keys, err := auth.NewKeyStore(keysFile)
+	if err != nil {
+		t.Fatalf("NewKeyStore() error = %v", err)
+	}
+	if err := ts.EnableSSH(filepath.Join(dir, "host_key"), keys); err != nil {
+		t.Fatalf("EnableSSH() error = %v", err)
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go ts.serveSSH(conn)
+		}
+	}()
+
+	return ln.Addr().String(), &ssh.ClientConfig{
+		User:            "ops",
+		Auth:            []ssh.AuthMethod{ssh.PublicKeys(signer)},
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+	}
+}
+
+// waitStaged waits until no upload is staged in the sftp directory
+func waitStaged(t *testing.T, ts *testServer) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if entries, _ := os.ReadDir(ts.sftpDir); len(entries) == 0 {
+			return
+		}
+	}
+	t.Fatal("sftp upload still staged")
+}
+
+func TestSFTPUploadCommitsOnlyOnClose(t *testing.T) {
+	data := bytes.Repeat([]byte("goflux"), 10000)
+
+	tests := []struct {
+		name    string
+		limit   int64                                      // file size limit, or 0
+		finish  func(conn *ssh.Client, f *sftp.File) error // ends the upload
+		wantErr bool
+		stored  bool
+	}{
+		{"closed", 0, func(conn *ssh.Client, f *sftp.File) error { return f.Close() }, false, true},
+		{"connection dropped", 0, func(conn *ssh.Client, f *sftp.File) error { return conn.Close() }, false, false},
+		{"write failed", 1000, func(conn *ssh.Client, f *sftp.File) error { return f.Close() }, true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newTestServer(t, nil)
+			ts.SetLimits(0, tt.limit)
+			addr, config := sshTestServer(t, ts)
+
+			conn, err := ssh.Dial("tcp", addr, config)
+			if err != nil {
+				t.Fatalf("Dial() error = %v", err)
+			}
+			defer conn.Close()
+			client, err := sftp.NewClient(conn)
+			if err != nil {
+				t.Fatalf("NewClient() error = %v", err)
+			}
+			f, err := client.Create("/a.bin")
+			if err != nil {
+				t.Fatalf("Create() error = %v", err)
+			}
+			f.Write(data)
+
+			if err := tt.finish(conn, f); (err != nil) != tt.wantErr {
+				t.Fatalf("finishing the upload: error = %v, want error %v", err, tt.wantErr)
+			}
+			waitStaged(t, ts)
+
+			if !tt.stored {
+				if ts.storage.Exists("/a.bin") {
+					t.Error("incomplete upload was stored")
+				}
+				return
+			}
+			if got := ts.stored(t, "/a.bin"); !bytes.Equal(got, data) {
+				t.Errorf("stored %d bytes that differ from the %d written", len(got), len(data))
+			}
+		})
+	}
+}
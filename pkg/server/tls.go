@@ -0,0 +1,107 @@
+package server
+
+import (
+	"crypto/tls"
+	"fmt"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// certPollInterval is how often the certificate files are checked for changes
+const certPollInterval = 10 * time.Second
+
+// certReloader serves a TLS certificate that can be replaced on disk while
+// the server is running. It reloads on SIGHUP and whenever the certificate
+// or key file's modification time changes.
+type certReloader struct {
+	certFile string
+	keyFile  string
+	poll     time.Duration // interval between checks for changes
+
+	mu      sync.RWMutex
+	cert    *tls.Certificate
+	certMod time.Time
+	keyMod  time.Time
+}
+
+// newCertReloader loads the initial certificate and key
+func newCertReloader(certFile, keyFile string) (*certReloader, error) {
+	c := &certReloader{certFile: certFile, keyFile: keyFile, poll: certPollInterval}
+	if err := c.reload(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// reload reads the certificate and key from disk and swaps them in
+func (c *certReloader) reload() error {
+	certMod, keyMod := modTime(c.certFile), modTime(c.keyFile)
+
+	cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		return fmt.Errorf("failed to load TLS certificate: %w", err)
+	}
+
+	c.mu.Lock()
+	c.cert = &cert
+	c.certMod = certMod
+	c.keyMod = keyMod
+	c.mu.Unlock()
+	return nil
+}
+
+// GetCertificate implements tls.Config.GetCertificate
+func (c *certReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.cert, nil
+}
+
+// changed reports whether either file was modified since the last reload
+func (c *certReloader) changed() bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return !modTime(c.certFile).Equal(c.certMod) || !modTime(c.keyFile).Equal(c.keyMod)
+}
+
+// watch reloads the certificate on SIGHUP or when the files change, until
+// stop is closed. A failed reload keeps serving the previous certificate.
+func (c *certReloader) watch(stop <-chan struct{}) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	ticker := time.NewTicker(c.poll)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-hup:
+		case <-ticker.C:
+			if !c.changed() {
+				continue
+			}
+		}
+
+		if err := c.reload(); err != nil {
+			fmt.Printf("Warning: keeping previous TLS certificate: %v\n", err)
+			continue
+		}
+		fmt.Printf("Reloaded TLS certificate from %s\n", c.certFile)
+	}
+}
+
+// modTime returns a file's modification time, or the zero time if it
+// cannot be read
+func modTime(path string) time.Time {
+	info, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}
+	}
+	return info.ModTime()
+}
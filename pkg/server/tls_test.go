@@ -0,0 +1,188 @@
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// writeCert writes a self-signed certificate for cn and its key, and sets
+// the modification time of both files
+func writeCert(t *testing.T, certFile, keyFile, cn string, modTime time.Time) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &tmpl, &tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writePEM(t, certFile, "CERTIFICATE", der, modTime)
+	writePEM(t, keyFile, "EC PRIVATE KEY", keyDER, modTime)
+}
+
+func writePEM(t *testing.T, name, blockType string, der []byte, modTime time.Time) {
+	t.Helper()
+	if err := os.WriteFile(name, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(name, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// reloaderTest is a certificate reloader serving TLS on a local address
+type reloaderTest struct {
+	*certReloader
+	certFile, keyFile string
+	addr              string
+}
+
+// newReloaderTest serves a certificate for cn with a reloader that checks
+// the files every poll and is watching for SIGHUP
+func newReloaderTest(t *testing.T, cn string, poll time.Duration) *reloaderTest {
+	t.Helper()
+	dir := t.TempDir()
+	rt := &reloaderTest{certFile: filepath.Join(dir, "cert.pem"), keyFile: filepath.Join(dir, "key.pem")}
+	writeCert(t, rt.certFile, rt.keyFile, cn, time.Now().Add(-time.Hour))
+
+	c, err := newCertReloader(rt.certFile, rt.keyFile)
+	if err != nil {
+		t.Fatalf("newCertReloader() error = %v", err)
+	}
+	c.poll = poll
+	rt.certReloader = c
+
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{GetCertificate: c.GetCertificate})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rt.addr = ln.Addr().String()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			conn.(*tls.Conn).Handshake()
+			conn.Close()
+		}
+	}()
+
+	stop := make(chan struct{})
+	go c.watch(stop)
+	t.Cleanup(func() {
+		close(stop)
+		ln.Close()
+	})
+	return rt
+}
+
+// served returns the common name of the certificate the server presents
+func (rt *reloaderTest) served(t *testing.T) string {
+	t.Helper()
+	conn, err := tls.DialWithDialer(&net.Dialer{Timeout: 5 * time.Second}, "tcp", rt.addr, &tls.Config{InsecureSkipVerify: true})
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	defer conn.Close()
+	return conn.ConnectionState().PeerCertificates[0].Subject.CommonName
+}
+
+// waitServed waits until the server presents the certificate for cn,
+// calling poke before each check
+func (rt *reloaderTest) waitServed(t *testing.T, cn string, poke func()) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		poke()
+		got := rt.served(t)
+		if got == cn {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("served certificate for %q, want %q", got, cn)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// catchSIGHUP keeps a SIGHUP sent before the reloader is listening from
+// stopping the test binary
+func catchSIGHUP(t *testing.T) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	t.Cleanup(func() { signal.Stop(hup) })
+}
+
+func sendSIGHUP() {
+	syscall.Kill(os.Getpid(), syscall.SIGHUP)
+}
+
+func TestCertReloaderSIGHUP(t *testing.T) {
+	catchSIGHUP(t)
+	rt := newReloaderTest(t, "old", time.Hour)
+	if got := rt.served(t); got != "old" {
+		t.Fatalf("served certificate for %q, want old", got)
+	}
+
+	// The new files keep the old modification time, so only the signal
+	// finds them
+	writeCert(t, rt.certFile, rt.keyFile, "new", time.Now().Add(-time.Hour))
+	rt.waitServed(t, "new", sendSIGHUP)
+}
+
+func TestCertReloaderFileChange(t *testing.T) {
+	rt := newReloaderTest(t, "old", 10*time.Millisecond)
+	if got := rt.served(t); got != "old" {
+		t.Fatalf("served certificate for %q, want old", got)
+	}
+
+	writeCert(t, rt.certFile, rt.keyFile, "new", time.Now())
+	rt.waitServed(t, "new", func() {})
+}
+
+func TestCertReloaderKeepsCertOnBadKey(t *testing.T) {
+	catchSIGHUP(t)
+	rt := newReloaderTest(t, "old", 10*time.Millisecond)
+
+	// A certificate rotated with the key of another one is refused
+	scratch := t.TempDir()
+	writeCert(t, rt.certFile, filepath.Join(scratch, "key.pem"), "bad", time.Now())
+	writeCert(t, filepath.Join(scratch, "cert.pem"), rt.keyFile, "unrelated", time.Now())
+	if err := rt.reload(); err == nil {
+		t.Fatal("reload() with a mismatched key succeeded")
+	}
+	sendSIGHUP()
+	time.Sleep(100 * time.Millisecond)
+	if got := rt.served(t); got != "old" {
+		t.Errorf("served certificate for %q after a bad rotation, want old", got)
+	}
+
+	// Once the files are fixed the next check picks them up
+	writeCert(t, rt.certFile, rt.keyFile, "new", time.Now().Add(time.Minute))
+	rt.waitServed(t, "new", func() {})
+}
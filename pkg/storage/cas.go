@@ -0,0 +1,657 @@
+package storage
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	pathpkg "path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CAS is a content-addressed storage backend. File contents are stored once
+// as blobs named by their SHA-256, and paths map to blobs through an index,
+// so identical files uploaded to many paths share one copy on disk. Blobs
+// are reference counted by the paths that use them and removed once no
+// path refers to them.
+//
+// Directories exist implicitly while paths lie below them; empty
+// directories made with Mkdir are recorded in the index as entries without
+// a blob.
+//
+// On disk, Root holds blobs/<first 2 hex>/<sha256>, the index in
+// index.json and in-flight uploads in tmp/.
+type CAS struct {
+	Root string
+
+	mu    sync.RWMutex
+	index map[string]casEntry // cleaned path -> blob
+	refs  map[string]int      // blob hash -> number of paths using it
+}
+
+// casEntry records the blob stored at a path, or an explicit directory.
+type casEntry struct {
+	Hash    string      `json:"hash,omitempty"`
+	Size    int64       `json:"size"`
+	ModTime time.Time   `json:"mod_time"`       // when the path was last written, or as set by SetAttr
+	Mode    fs.FileMode `json:"mode,omitempty"` // permission bits set by SetAttr, 0 for the default
+	Dir     bool        `json:"dir,omitempty"`
+}
+
+// NewCAS opens or creates a content-addressed store in root and removes
+// blobs left unreferenced by an earlier crash.
+func NewCAS(root string) (*CAS, error) {
+	for _, dir := range []string{root, filepath.Join(root, "blobs"), filepath.Join(root, "tmp")} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return nil, fmt.Errorf("failed to create %s: %w", dir, err)
+		}
+	}
+
+	c := &CAS{
+		Root:  root,
+		index: make(map[string]casEntry),
+		refs:  make(map[string]int),
+	}
+
+	data, err := os.ReadFile(c.indexPath())
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read index: %w", err)
+	}
+	if err == nil {
+		if err := json.Unmarshal(data, &c.index); err != nil {
+			return nil, fmt.Errorf("failed to parse index: %w", err)
+		}
+	}
+	for _, e := range c.index {
+		if !e.Dir {
+			c.refs[e.Hash]++
+		}
+	}
+
+	if _, err := c.GC(); err != nil {
+		return nil, fmt.Errorf("garbage collection failed: %w", err)
+	}
+	return c, nil
+}
+
+func (c *CAS) Put(path string, data []byte) error {
+	_, err := c.PutStream(path, bytes.NewReader(data))
+	return err
+}
+
+func (c *CAS) Get(path string) ([]byte, error) {
+	f, err := c.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return io.ReadAll(f)
+}
+
+func (c *CAS) Exists(path string) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	p := cleanPath(path)
+	if _, ok := c.index[p]; ok {
+		return true
+	}
+	return c.isDir(p)
+}
+
+// List returns the names of the files and directories directly below path.
+func (c *CAS) List(path string) ([]string, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.list(path)
+}
+
+// list returns the sorted names directly below path. Callers must hold c.mu.
+func (c *CAS) list(path string) ([]string, error) {
+	dir := cleanPath(path)
+	if e, ok := c.index[dir]; ok && !e.Dir {
+		return nil, fmt.Errorf("%s is not a directory", path)
+	}
+	if dir != "/" && !c.isDir(dir) {
+		return nil, fmt.Errorf("%s: %w", path, os.ErrNotExist)
+	}
+
+	seen := make(map[string]bool)
+	var names []string
+	for p := range c.index {
+		rest, ok := strings.CutPrefix(p, dirPrefix(dir))
+		if !ok {
+			continue
+		}
+		name, _, _ := strings.Cut(rest, "/")
+		if !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// PutStream stores the content read from r as a blob and points path at
+// it. Content that is already stored is not written a second time.
+func (c *CAS) PutStream(path string, r io.Reader) (int64, error) {
+	p := cleanPath(path)
+	if p == "/" {
+		return 0, fmt.Errorf("invalid path: %s", path)
+	}
+
+	// Hash into a temp file first; the blob name is only known at the end
+	tmp, err := os.CreateTemp(filepath.Join(c.Root, "tmp"), "upload-*")
+	if err != nil {
+		return 0, fmt.Errorf("failed to create temp file: %w", err)
+	}
+	tmpPath := tmp.Name()
+	defer os.Remove(tmpPath)
+
+	hasher := sha256.New()
+	n, err := io.Copy(io.MultiWriter(tmp, hasher), r)
+	if err != nil {
+		tmp.Close()
+		return n, err
+	}
+	if err := tmp.Close(); err != nil {
+		return n, err
+	}
+	hash := hex.EncodeToString(hasher.Sum(nil))
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	blob := c.blobPath(hash)
+	if _, err := os.Stat(blob); os.IsNotExist(err) {
+		if err := os.MkdirAll(filepath.Dir(blob), 0755); err != nil {
+			return n, fmt.Errorf("failed to create blob directory: %w", err)
+		}
+		if err := os.Chmod(tmpPath, 0644); err != nil {
+			return n, err
+		}
+		if err := os.Rename(tmpPath, blob); err != nil {
+			return n, fmt.Errorf("failed to commit blob: %w", err)
+		}
+	}
+
+	if err := c.setEntry(p, casEntry{Hash: hash, Size: n, ModTime: time.Now()}); err != nil {
+		// Drop the blob again if nothing else refers to it
+		if c.refs[hash] == 0 {
+			os.Remove(blob)
+		}
+		return n, err
+	}
+	return n, nil
+}
+
+// Link points path at an already stored blob with the given SHA-256 and
+// size, so a file whose content the server holds is stored without being
+// uploaded. It reports false if no such blob is stored.
+func (c *CAS) Link(path, hash string, size int64) (bool, error) {
+	p := cleanPath(path)
+	if p == "/" {
+		return false, fmt.Errorf("invalid path: %s", path)
+	}
+	hash = strings.ToLower(hash)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.refs[hash] == 0 {
+		return false, nil
+	}
+	info, err := os.Stat(c.blobPath(hash))
+	if err != nil || info.Size() != size {
+		return false, nil
+	}
+
+	if err := c.setEntry(p, casEntry{Hash: hash, Size: size, ModTime: time.Now()}); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// setEntry points a path at a stored blob, updating the reference counts
+// and persisting the index. Callers must hold c.mu.
+func (c *CAS) setEntry(p string, entry casEntry) error {
+	if c.isDir(p) {
+		return fmt.Errorf("%s is a directory", p)
+	}
+	if err := c.checkParents(p); err != nil {
+		return err
+	}
+
+	old, replaced := c.index[p]
+	c.index[p] = entry
+	c.refs[entry.Hash]++
+	if err := c.saveIndex(); err != nil {
+		// Roll back so the in-memory index matches the one on disk
+		if replaced {
+			c.index[p] = old
+		} else {
+			delete(c.index, p)
+		}
+		c.refs[entry.Hash]--
+		if c.refs[entry.Hash] == 0 {
+			delete(c.refs, entry.Hash)
+		}
+		return err
+	}
+
+	if replaced {
+		c.release(old.Hash)
+	}
+	return nil
+}
+
+func (c *CAS) Open(path string) (File, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	p := cleanPath(path)
+	entry, ok := c.index[p]
+	if !ok || entry.Dir {
+		if ok || c.isDir(p) {
+			return nil, fmt.Errorf("%s is a directory: %w", path, ErrInvalidPath)
+		}
+		return nil, fmt.Errorf("%s: %w", path, os.ErrNotExist)
+	}
+
+	// Opening under the lock keeps the blob from being collected first
+	f, err := os.Open(c.blobPath(entry.Hash))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open blob for %s: %w", path, err)
+	}
+	return &casFile{File: f, entry: entry}, nil
+}
+
+// GC removes blobs that no path refers to, along with abandoned temp
+// files, and returns the number of bytes freed. Blobs are normally removed
+// as soon as their last path is overwritten; GC catches those left behind
+// by a crash.
+func (c *CAS) GC() (int64, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var freed int64
+	blobsDir := filepath.Join(c.Root, "blobs")
+	err := filepath.WalkDir(blobsDir, func(p string, d os.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		if c.refs[d.Name()] > 0 {
+			return nil
+		}
+		if info, err := d.Info(); err == nil {
+			freed += info.Size()
+		}
+		return os.Remove(p)
+	})
+	if err != nil {
+		return freed, err
+	}
+
+	// Nothing is uploading while the lock is held at startup, but later
+	// calls may race with PutStream, so only remove stale temp files
+	tmps, err := os.ReadDir(filepath.Join(c.Root, "tmp"))
+	if err != nil {
+		return freed, err
+	}
+	for _, t := range tmps {
+		info, err := t.Info()
+		if err == nil && time.Since(info.ModTime()) > 24*time.Hour {
+			freed += info.Size()
+			os.Remove(filepath.Join(c.Root, "tmp", t.Name()))
+		}
+	}
+	return freed, nil
+}
+
+// release drops a reference to a blob and removes the blob once unused.
+// Callers must hold c.mu.
+func (c *CAS) release(hash string) {
+	c.refs[hash]--
+	if c.refs[hash] > 0 {
+		return
+	}
+	delete(c.refs, hash)
+	if err := os.Remove(c.blobPath(hash)); err != nil && !os.IsNotExist(err) {
+		fmt.Printf("Warning: failed to remove blob %s: %v\n", hash, err)
+	}
+}
+
+// Stat describes the file or directory at path. Files carry the hash of
+// their blob.
+func (c *CAS) Stat(path string) (*FileInfo, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	p := cleanPath(path)
+	if e, ok := c.index[p]; ok {
+		fi := casFileInfo(pathpkg.Base(p), e)
+		return &fi, nil
+	}
+	if p != "/" && !c.isDir(p) {
+		return nil, fmt.Errorf("%s: %w", path, os.ErrNotExist)
+	}
+	return &FileInfo{Name: pathpkg.Base(p), Mode: fs.ModeDir | 0755, ModTime: c.latestBelow(p), IsDir: true}, nil
+}
+
+// SetAttr records the mode and modification time of path. An implicit
+// directory becomes an explicit one to hold them.
+func (c *CAS) SetAttr(path string, mode fs.FileMode, modTime time.Time) error {
+	p := cleanPath(path)
+	if p == "/" {
+		return fmt.Errorf("%w: cannot set attributes of the root", ErrInvalidPath)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	old, ok := c.index[p]
+	entry := old
+	if !ok {
+		if !c.isDir(p) {
+			return fmt.Errorf("%s: %w", path, os.ErrNotExist)
+		}
+		entry = casEntry{ModTime: c.latestBelow(p), Dir: true}
+	}
+	if mode != 0 {
+		entry.Mode = mode.Perm()
+	}
+	if !modTime.IsZero() {
+		entry.ModTime = modTime
+	}
+
+	c.index[p] = entry
+	if err := c.saveIndex(); err != nil {
+		if ok {
+			c.index[p] = old
+		} else {
+			delete(c.index, p)
+		}
+		return err
+	}
+	return nil
+}
+
+// ReadDir describes the entries directly below path. Directories that
+// only exist implicitly take the latest modification time below them.
+func (c *CAS) ReadDir(path string) ([]FileInfo, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	names, err := c.list(path)
+	if err != nil {
+		return nil, err
+	}
+	dir := cleanPath(path)
+	infos := make([]FileInfo, 0, len(names))
+	for _, name := range names {
+		p := pathpkg.Join(dir, name)
+		if e, ok := c.index[p]; ok {
+			infos = append(infos, casFileInfo(name, e))
+			continue
+		}
+		infos = append(infos, FileInfo{Name: name, Mode: fs.ModeDir | 0755, ModTime: c.latestBelow(p), IsDir: true})
+	}
+	return infos, nil
+}
+
+// casFileInfo describes an index entry
+func casFileInfo(name string, e casEntry) FileInfo {
+	if e.Dir {
+		mode := e.Mode
+		if mode == 0 {
+			mode = 0755
+		}
+		return FileInfo{Name: name, Mode: fs.ModeDir | mode, ModTime: e.ModTime, IsDir: true}
+	}
+	mode := e.Mode
+	if mode == 0 {
+		mode = 0644
+	}
+	return FileInfo{Name: name, Size: e.Size, Mode: mode, ModTime: e.ModTime, Hash: e.Hash}
+}
+
+// latestBelow returns the latest modification time of the paths below
+// dir. Callers must hold c.mu.
+func (c *CAS) latestBelow(dir string) time.Time {
+	var latest time.Time
+	prefix := dirPrefix(dir)
+	for p, e := range c.index {
+		if strings.HasPrefix(p, prefix) && e.ModTime.After(latest) {
+			latest = e.ModTime
+		}
+	}
+	return latest
+}
+
+// Delete removes the file or directory at path. Blobs no longer used by any
+// path are removed with it.
+func (c *CAS) Delete(path string, recursive bool) error {
+	p := cleanPath(path)
+	if p == "/" {
+		return fmt.Errorf("%w: cannot delete the root", ErrInvalidPath)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	removed := c.entriesAt(p)
+	if len(removed) == 0 {
+		return fmt.Errorf("%s: %w", path, os.ErrNotExist)
+	}
+	// Anything besides p itself lies below it
+	if _, ok := removed[p]; !recursive && (!ok || len(removed) > 1) {
+		return fmt.Errorf("%s: %w", path, ErrNotEmpty)
+	}
+
+	for k := range removed {
+		delete(c.index, k)
+	}
+	if err := c.saveIndex(); err != nil {
+		for k, e := range removed {
+			c.index[k] = e
+		}
+		return err
+	}
+	for _, e := range removed {
+		if !e.Dir {
+			c.release(e.Hash)
+		}
+	}
+	return nil
+}
+
+// Rename moves the file or directory at src to dst. Only the index
+// changes; no blob is copied.
+func (c *CAS) Rename(src, dst string) error {
+	return c.transfer(src, dst, false)
+}
+
+// Copy copies the file or directory tree at src to dst. The copies share
+// their blobs with the originals, so no content is duplicated on disk.
+func (c *CAS) Copy(src, dst string) error {
+	return c.transfer(src, dst, true)
+}
+
+// transfer points every path at or below src at the same place below dst,
+// keeping the originals if keep is set.
+func (c *CAS) transfer(src, dst string, keep bool) error {
+	s, d := cleanPath(src), cleanPath(dst)
+	if s == "/" || d == "/" {
+		return fmt.Errorf("%w: cannot move or copy the root", ErrInvalidPath)
+	}
+	if d == s || strings.HasPrefix(d, s+"/") {
+		return fmt.Errorf("%w: cannot move or copy %s into itself", ErrInvalidPath, src)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	moved := c.entriesAt(s)
+	if len(moved) == 0 {
+		return fmt.Errorf("%s: %w", src, os.ErrNotExist)
+	}
+	if _, ok := c.index[d]; ok || c.isDir(d) {
+		return fmt.Errorf("%s: %w", dst, os.ErrExist)
+	}
+	if err := c.checkParents(d); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	for k, e := range moved {
+		if keep {
+			e.ModTime = now
+		} else {
+			delete(c.index, k)
+		}
+		c.index[d+strings.TrimPrefix(k, s)] = e
+	}
+	if err := c.saveIndex(); err != nil {
+		for k, e := range moved {
+			delete(c.index, d+strings.TrimPrefix(k, s))
+			c.index[k] = e
+		}
+		return err
+	}
+	if keep {
+		for _, e := range moved {
+			if !e.Dir {
+				c.refs[e.Hash]++
+			}
+		}
+	}
+	return nil
+}
+
+// Mkdir records an empty directory at path. Its parents exist implicitly.
+func (c *CAS) Mkdir(path string) error {
+	p := cleanPath(path)
+	if p == "/" {
+		return nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if e, ok := c.index[p]; ok {
+		if e.Dir {
+			return nil
+		}
+		return fmt.Errorf("%s: %w", path, os.ErrExist)
+	}
+	if c.isDir(p) {
+		return nil
+	}
+	if err := c.checkParents(p); err != nil {
+		return err
+	}
+
+	c.index[p] = casEntry{ModTime: time.Now(), Dir: true}
+	if err := c.saveIndex(); err != nil {
+		delete(c.index, p)
+		return err
+	}
+	return nil
+}
+
+// entriesAt returns the index entries for p and every path below it.
+// Callers must hold c.mu.
+func (c *CAS) entriesAt(p string) map[string]casEntry {
+	entries := make(map[string]casEntry)
+	prefix := dirPrefix(p)
+	for k, e := range c.index {
+		if k == p || strings.HasPrefix(k, prefix) {
+			entries[k] = e
+		}
+	}
+	return entries
+}
+
+// checkParents fails if a file is stored where a parent directory of p
+// would be. Callers must hold c.mu.
+func (c *CAS) checkParents(p string) error {
+	for dir := pathpkg.Dir(p); dir != "/"; dir = pathpkg.Dir(dir) {
+		if e, ok := c.index[dir]; ok && !e.Dir {
+			return fmt.Errorf("%s is not a directory", dir)
+		}
+	}
+	return nil
+}
+
+// isDir reports whether dir was made with Mkdir or any stored path lies
+// below it. Callers must hold c.mu.
+func (c *CAS) isDir(dir string) bool {
+	if e, ok := c.index[dir]; ok {
+		return e.Dir
+	}
+	prefix := dirPrefix(dir)
+	for p := range c.index {
+		if strings.HasPrefix(p, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// saveIndex atomically writes the path index. Callers must hold c.mu.
+func (c *CAS) saveIndex() error {
+	data, err := json.MarshalIndent(c.index, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp := c.indexPath() + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("failed to write index: %w", err)
+	}
+	if err := os.Rename(tmp, c.indexPath()); err != nil {
+		return fmt.Errorf("failed to commit index: %w", err)
+	}
+	return nil
+}
+
+func (c *CAS) indexPath() string {
+	return filepath.Join(c.Root, "index.json")
+}
+
+func (c *CAS) blobPath(hash string) string {
+	return filepath.Join(c.Root, "blobs", hash[:2], hash)
+}
+
+// cleanPath normalizes a storage path to an absolute slash-separated form,
+// so "a/b", "/a/b" and "/a/./b/" name the same file.
+func cleanPath(p string) string {
+	return pathpkg.Clean("/" + filepath.ToSlash(p))
+}
+
+// dirPrefix returns the prefix shared by every path below dir.
+func dirPrefix(dir string) string {
+	if dir == "/" {
+		return "/"
+	}
+	return dir + "/"
+}
+
+// casFile is an open blob reporting the size and time of its path.
+type casFile struct {
+	*os.File
+	entry casEntry
+}
+
+func (f *casFile) Size() int64           { return f.entry.Size }
+func (f *casFile) ModTime() time.Time    { return f.entry.ModTime }
+func (f *casFile) Hash() (string, error) { return f.entry.Hash, nil }
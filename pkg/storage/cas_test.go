@@ -0,0 +1,222 @@
+package storage
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// countBlobs returns the number of blobs stored in a CAS root
+func countBlobs(t *testing.T, root string) int {
+	t.Helper()
+	blobs, err := filepath.Glob(filepath.Join(root, "blobs", "*", "*"))
+	if err != nil {
+		t.Fatalf("Glob() error = %v", err)
+	}
+	return len(blobs)
+}
+
+func TestCASDedupe(t *testing.T) {
+	tmpDir := t.TempDir()
+	store, err := NewCAS(tmpDir)
+	if err != nil {
+		t.Fatalf("NewCAS() error = %v", err)
+	}
+
+	data := []byte("build artifact")
+	for _, p := range []string{"ci/1/app.tar", "ci/2/app.tar", "/release/app.tar"} {
+		if err := store.Put(p, data); err != nil {
+			t.Fatalf("Put(%s) error = %v", p, err)
+		}
+	}
+
+	if n := countBlobs(t, tmpDir); n != 1 {
+		t.Errorf("stored %d blobs, want 1", n)
+	}
+
+	got, err := store.Get("/ci/2/app.tar")
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	if string(got) != string(data) {
+		t.Errorf("Get() = %s, want %s", got, data)
+	}
+
+	names, err := store.List("/ci")
+	if err != nil {
+		t.Fatalf("List() error = %v", err)
+	}
+	if want := []string{"1", "2"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("List(/ci) = %v, want %v", names, want)
+	}
+}
+
+func TestCASReleasesUnreferencedBlobs(t *testing.T) {
+	tmpDir := t.TempDir()
+	store, err := NewCAS(tmpDir)
+	if err != nil {
+		t.Fatalf("NewCAS() error = %v", err)
+	}
+
+	store.Put("a.bin", []byte("v1"))
+	store.Put("b.bin", []byte("v1"))
+
+	// Overwriting one path keeps the blob the other path still uses
+	store.Put("a.bin", []byte("v2"))
+	if n := countBlobs(t, tmpDir); n != 2 {
+		t.Errorf("stored %d blobs after first overwrite, want 2", n)
+	}
+
+	// Overwriting the last reference frees it
+	store.Put("b.bin", []byte("v2"))
+	if n := countBlobs(t, tmpDir); n != 1 {
+		t.Errorf("stored %d blobs after second overwrite, want 1", n)
+	}
+}
+
+func TestCASReopen(t *testing.T) {
+	tmpDir := t.TempDir()
+	store, err := NewCAS(tmpDir)
+	if err != nil {
+		t.Fatalf("NewCAS() error = %v", err)
+	}
+	if err := store.Put("docs/readme.txt", []byte("hello")); err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+
+	// An orphaned blob, as left by a crash before the index was saved
+	orphan := filepath.Join(tmpDir, "blobs", "ff", "ff00")
+	os.MkdirAll(filepath.Dir(orphan), 0755)
+	os.WriteFile(orphan, []byte("orphan"), 0644)
+
+	reopened, err := NewCAS(tmpDir)
+	if err != nil {
+		t.Fatalf("NewCAS() reopen error = %v", err)
+	}
+	if _, err := os.Stat(orphan); !os.IsNotExist(err) {
+		t.Errorf("orphaned blob not collected: %v", err)
+	}
+
+	f, err := reopened.Open("docs/readme.txt")
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	defer f.Close()
+	got, _ := io.ReadAll(f)
+	if string(got) != "hello" || f.Size() != 5 {
+		t.Errorf("Open() = %q (size %d), want hello (size 5)", got, f.Size())
+	}
+
+	if _, err := reopened.Open("docs/missing.txt"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Open(missing) error = %v, want not exist", err)
+	}
+}
+
+func TestCASLink(t *testing.T) {
+	store, err := NewCAS(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewCAS() error = %v", err)
+	}
+
+	data := []byte("linked content")
+	if err := store.Put("src.txt", data); err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+	sum := sha256.Sum256(data)
+	hash := hex.EncodeToString(sum[:])
+
+	linked, err := store.Link("dst/copy.txt", hash, int64(len(data)))
+	if err != nil || !linked {
+		t.Fatalf("Link() = %v, %v, want true, nil", linked, err)
+	}
+	got, err := store.Get("dst/copy.txt")
+	if err != nil || string(got) != string(data) {
+		t.Errorf("Get(linked) = %q, %v, want %q", got, err, data)
+	}
+
+	if linked, err := store.Link("other.txt", hash, 1); err != nil || linked {
+		t.Errorf("Link() with wrong size = %v, %v, want false, nil", linked, err)
+	}
+	missing := sha256.Sum256([]byte("not stored"))
+	if linked, err := store.Link("other.txt", hex.EncodeToString(missing[:]), 10); err != nil || linked {
+		t.Errorf("Link() of unknown content = %v, %v, want false, nil", linked, err)
+	}
+}
+
+func TestCASFileOps(t *testing.T) {
+	store, err := NewCAS(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewCAS() error = %v", err)
+	}
+	testFileOps(t, store)
+}
+
+func TestCASCopySharesBlobs(t *testing.T) {
+	tmpDir := t.TempDir()
+	store, err := NewCAS(tmpDir)
+	if err != nil {
+		t.Fatalf("NewCAS() error = %v", err)
+	}
+
+	if err := store.Put("a/data.bin", []byte("shared")); err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+	if err := store.Copy("a", "b"); err != nil {
+		t.Fatalf("Copy() error = %v", err)
+	}
+	if err := store.Mkdir("empty"); err != nil {
+		t.Fatalf("Mkdir() error = %v", err)
+	}
+	if n := countBlobs(t, tmpDir); n != 1 {
+		t.Errorf("stored %d blobs after Copy(), want 1", n)
+	}
+
+	// The blob stays until the last path using it is deleted
+	if err := store.Delete("a", true); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	if n := countBlobs(t, tmpDir); n != 1 {
+		t.Errorf("stored %d blobs with one copy left, want 1", n)
+	}
+
+	// Empty directories survive reopening
+	reopened, err := NewCAS(tmpDir)
+	if err != nil {
+		t.Fatalf("NewCAS() reopen error = %v", err)
+	}
+	names, err := reopened.List("/")
+	if err != nil {
+		t.Fatalf("List() error = %v", err)
+	}
+	if want := []string{"b", "empty"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("List(/) = %v, want %v", names, want)
+	}
+
+	if err := reopened.Delete("b/data.bin", false); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	if n := countBlobs(t, tmpDir); n != 0 {
+		t.Errorf("stored %d blobs after deleting every path, want 0", n)
+	}
+}
+
+func TestCASStatReadDir(t *testing.T) {
+	store, err := NewCAS(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewCAS() error = %v", err)
+	}
+	testStatReadDir(t, store)
+}
+
+func TestCASSetAttr(t *testing.T) {
+	store, err := NewCAS(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewCAS() error = %v", err)
+	}
+	testSetAttr(t, store)
+}
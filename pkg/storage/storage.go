@@ -1,9 +1,29 @@
 package storage
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"io"
+	"io/fs"
 	"os"
+	pathpkg "path"
 	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrNotEmpty is returned when deleting a directory that still has
+	// entries without asking for a recursive delete.
+	ErrNotEmpty = errors.New("directory not empty")
+
+	// ErrInvalidPath is returned for operations that can't apply to a
+	// path, like deleting the root or moving a directory into itself.
+	ErrInvalidPath = errors.New("invalid path")
 )
 
 // Storage is an interface for storing and retrieving files.
@@ -12,13 +32,93 @@ type Storage interface {
 	Get(path string) ([]byte, error)
 	Exists(path string) bool
 	List(path string) ([]string, error)
+
+	// PutStream stores everything read from r at path and returns the
+	// number of bytes written. The file only replaces any previous
+	// content once r has been read to io.EOF; if reading fails the
+	// partial data is discarded.
+	PutStream(path string, r io.Reader) (int64, error)
+
+	// Open opens the file at path for streaming reads.
+	Open(path string) (File, error)
+
+	// Delete removes the file or directory at path. A directory that isn't
+	// empty is only removed, along with everything below it, if recursive
+	// is set; otherwise Delete fails with ErrNotEmpty.
+	Delete(path string, recursive bool) error
+
+	// Rename moves the file or directory at src to dst, creating missing
+	// parent directories. It fails with os.ErrExist if dst exists.
+	Rename(src, dst string) error
+
+	// Copy copies the file or directory tree at src to dst, creating
+	// missing parent directories. It fails with os.ErrExist if dst exists.
+	Copy(src, dst string) error
+
+	// Mkdir creates the directory at path along with any missing parents.
+	// It succeeds if the directory already exists.
+	Mkdir(path string) error
+
+	// Stat describes the file or directory at path.
+	Stat(path string) (*FileInfo, error)
+
+	// ReadDir describes the entries of the directory at path, sorted by
+	// name.
+	ReadDir(path string) ([]FileInfo, error)
+
+	// SetAttr sets the permission bits and modification time of the file
+	// or directory at path. A zero mode or time leaves it unchanged.
+	SetAttr(path string, mode fs.FileMode, modTime time.Time) error
+}
+
+// FileInfo describes a stored file or directory.
+type FileInfo struct {
+	Name    string      // base name, "/" for the root
+	Size    int64       // content length in bytes; 0 for directories
+	Mode    fs.FileMode // permission bits, with fs.ModeDir for directories
+	ModTime time.Time   // last modification, zero if unknown
+	IsDir   bool
+	Hash    string // hex SHA-256 of the content, empty if not known
+}
+
+// Linker is implemented by storage backends that can store a file by
+// referring to content they already hold, so identical files need not be
+// uploaded again.
+type Linker interface {
+	// Link makes path refer to stored content with the given SHA-256 and
+	// size. It reports false, without error, if no such content is stored.
+	Link(path, hash string, size int64) (bool, error)
+}
+
+// File is an open handle to a stored file.
+type File interface {
+	io.ReadSeekCloser
+	io.ReaderAt
+	Size() int64
+	ModTime() time.Time
+
+	// Hash returns the hex SHA-256 of the content, reading the file if the
+	// storage doesn't already know it.
+	Hash() (string, error)
 }
 
 // Local is a simple local filesystem storage implementation.
 type Local struct {
 	Root string
+
+	hashMu sync.Mutex
+	hashes map[string]localHash // by filesystem path
+}
+
+// localHash is the content hash of a file as it was when hashed
+type localHash struct {
+	info fs.FileInfo
+	hash string
 }
 
+// maxLocalHashes bounds the hash cache, which starts over when it is full
+const maxLocalHashes = 100000
+
 // NewLocal creates a new local filesystem storage backend.
 func NewLocal(root string) (*Local, error) {
 	if err := os.MkdirAll(root, 0755); err != nil {
@@ -28,27 +128,23 @@ func NewLocal(root string) (*Local, error) {
 }
 
 func (l *Local) Put(path string, data []byte) error {
-	fullPath := filepath.Join(l.Root, path)
-	dir := filepath.Dir(fullPath)
-	if err := os.MkdirAll(dir, 0755); err != nil {
-		return fmt.Errorf("failed to create directory: %w", err)
-	}
-	return os.WriteFile(fullPath, data, 0644)
+	_, err := l.PutStream(path, bytes.NewReader(data))
+	return err
 }
 
 func (l *Local) Get(path string) ([]byte, error) {
-	fullPath := filepath.Join(l.Root, path)
+	fullPath := l.fullPath(path)
 	return os.ReadFile(fullPath)
 }
 
 func (l *Local) Exists(path string) bool {
-	fullPath := filepath.Join(l.Root, path)
+	fullPath := l.fullPath(path)
 	_, err := os.Stat(fullPath)
 	return err == nil
 }
 
 func (l *Local) List(path string) ([]string, error) {
-	fullPath := filepath.Join(l.Root, path)
+	fullPath := l.fullPath(path)
 	entries, err := os.ReadDir(fullPath)
 	if err != nil {
 		return nil, err
@@ -59,3 +155,301 @@ func (l *Local) List(path string) ([]string, error) {
 	}
 	return names, nil
 }
+
+func (l *Local) PutStream(path string, r io.Reader) (int64, error) {
+	if cleanPath(path) == "/" {
+		return 0, fmt.Errorf("%w: %s", ErrInvalidPath, path)
+	}
+	fullPath := l.fullPath(path)
+	dir := filepath.Dir(fullPath)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return 0, fmt.Errorf("failed to create directory: %w", err)
+	}
+
+	// Write to a temp file next to the target so the final rename is atomic
+	tmp, err := os.CreateTemp(dir, ".goflux-*.tmp")
+	if err != nil {
+		return 0, fmt.Errorf("failed to create temp file: %w", err)
+	}
+	tmpPath := tmp.Name()
+
+	n, err := io.Copy(tmp, r)
+	if err != nil {
+		tmp.Close()
+		os.Remove(tmpPath)
+		return n, err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmpPath)
+		return n, err
+	}
+	if err := os.Chmod(tmpPath, 0644); err != nil {
+		os.Remove(tmpPath)
+		return n, err
+	}
+	if err := os.Rename(tmpPath, fullPath); err != nil {
+		os.Remove(tmpPath)
+		return n, fmt.Errorf("failed to commit file: %w", err)
+	}
+	return n, nil
+}
+
+func (l *Local) Open(path string) (File, error) {
+	fullPath := l.fullPath(path)
+	f, err := os.Open(fullPath)
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	if info.IsDir() {
+		f.Close()
+		return nil, fmt.Errorf("%s is a directory: %w", path, ErrInvalidPath)
+	}
+	return &localFile{File: f, info: info, local: l, fullPath: fullPath}, nil
+}
+
+func (l *Local) Delete(path string, recursive bool) error {
+	if cleanPath(path) == "/" {
+		return fmt.Errorf("%w: cannot delete the root", ErrInvalidPath)
+	}
+	fullPath := l.fullPath(path)
+	info, err := os.Lstat(fullPath)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return os.Remove(fullPath)
+	}
+	if recursive {
+		return os.RemoveAll(fullPath)
+	}
+
+	entries, err := os.ReadDir(fullPath)
+	if err != nil {
+		return err
+	}
+	if len(entries) > 0 {
+		return fmt.Errorf("%s: %w", path, ErrNotEmpty)
+	}
+	return os.Remove(fullPath)
+}
+
+func (l *Local) Rename(src, dst string) error {
+	srcPath, dstPath, err := l.transferPaths(src, dst)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(dstPath), 0755); err != nil {
+		return fmt.Errorf("failed to create directory: %w", err)
+	}
+	return os.Rename(srcPath, dstPath)
+}
+
+func (l *Local) Copy(src, dst string) error {
+	srcPath, dstPath, err := l.transferPaths(src, dst)
+	if err != nil {
+		return err
+	}
+	return filepath.WalkDir(srcPath, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(srcPath, p)
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return os.MkdirAll(filepath.Join(dstPath, rel), 0755)
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+
+		f, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		// Write through PutStream so a failed copy leaves no partial file
+		_, err = l.PutStream(pathpkg.Join(cleanPath(dst), filepath.ToSlash(rel)), f)
+		return err
+	})
+}
+
+func (l *Local) Mkdir(path string) error {
+	fullPath := l.fullPath(path)
+	if info, err := os.Stat(fullPath); err == nil && !info.IsDir() {
+		return fmt.Errorf("%s: %w", path, os.ErrExist)
+	}
+	return os.MkdirAll(fullPath, 0755)
+}
+
+func (l *Local) Stat(path string) (*FileInfo, error) {
+	fi, err := l.describe(l.fullPath(path))
+	if err != nil {
+		return nil, err
+	}
+	if cleanPath(path) == "/" {
+		fi.Name = "/"
+	}
+	return &fi, nil
+}
+
+func (l *Local) ReadDir(path string) ([]FileInfo, error) {
+	fullPath := l.fullPath(path)
+	entries, err := os.ReadDir(fullPath)
+	if err != nil {
+		return nil, err
+	}
+	infos := make([]FileInfo, 0, len(entries))
+	for _, e := range entries {
+		fi, err := l.describe(filepath.Join(fullPath, e.Name()))
+		if errors.Is(err, fs.ErrNotExist) {
+			// Removed since the directory was read
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		infos = append(infos, fi)
+	}
+	return infos, nil
+}
+
+// describe returns the FileInfo of the file or directory at fullPath,
+// with the content hash of regular files
+func (l *Local) describe(fullPath string) (FileInfo, error) {
+	info, err := os.Stat(fullPath)
+	if err != nil {
+		return FileInfo{}, err
+	}
+	if !info.Mode().IsRegular() {
+		return localFileInfo(info), nil
+	}
+
+	f, err := os.Open(fullPath)
+	if err != nil {
+		return FileInfo{}, err
+	}
+	defer f.Close()
+	if info, err = f.Stat(); err != nil {
+		return FileInfo{}, err
+	}
+	fi := localFileInfo(info)
+	fi.Hash, err = l.contentHash(fullPath, f, info)
+	return fi, err
+}
+
+// contentHash returns the hex SHA-256 of the open file f at fullPath,
+// which info describes. Hashes are cached: writes replace files rather
+// than changing them in place, so a hash is reused as long as the same
+// file is at fullPath with the same size and modification time.
+func (l *Local) contentHash(fullPath string, f io.ReaderAt, info fs.FileInfo) (string, error) {
+	l.hashMu.Lock()
+	cached, ok := l.hashes[fullPath]
+	l.hashMu.Unlock()
+	if ok && os.SameFile(cached.info, info) && cached.info.Size() == info.Size() && cached.info.ModTime().Equal(info.ModTime()) {
+		return cached.hash, nil
+	}
+
+	hasher := sha256.New()
+	if _, err := io.Copy(hasher, io.NewSectionReader(f, 0, info.Size())); err != nil {
+		return "", fmt.Errorf("failed to hash %s: %w", fullPath, err)
+	}
+	hash := hex.EncodeToString(hasher.Sum(nil))
+
+	l.hashMu.Lock()
+	if l.hashes == nil || len(l.hashes) >= maxLocalHashes {
+		l.hashes = make(map[string]localHash)
+	}
+	l.hashes[fullPath] = localHash{info: info, hash: hash}
+	l.hashMu.Unlock()
+	return hash, nil
+}
+
+func (l *Local) SetAttr(path string, mode fs.FileMode, modTime time.Time) error {
+	if cleanPath(path) == "/" {
+		return fmt.Errorf("%w: cannot set attributes of the root", ErrInvalidPath)
+	}
+	fullPath := l.fullPath(path)
+	info, err := os.Stat(fullPath)
+	if err != nil {
+		return err
+	}
+	if mode != 0 {
+		// Keep the bits the server needs to read files and fill directories
+		keep := fs.FileMode(0400)
+		if info.IsDir() {
+			keep = 0700
+		}
+		if err := os.Chmod(fullPath, mode.Perm()|keep); err != nil {
+			return err
+		}
+	}
+	if !modTime.IsZero() {
+		return os.Chtimes(fullPath, modTime, modTime)
+	}
+	return nil
+}
+
+// localFileInfo describes a file on disk, without its content hash.
+func localFileInfo(info fs.FileInfo) FileInfo {
+	fi := FileInfo{
+		Name:    info.Name(),
+		Mode:    info.Mode() & (fs.ModeDir | fs.ModePerm),
+		ModTime: info.ModTime(),
+		IsDir:   info.IsDir(),
+	}
+	if !fi.IsDir {
+		fi.Size = info.Size()
+	}
+	return fi
+}
+
+// fullPath maps a storage path to the filesystem. Paths are cleaned as if
+// rooted first, so ".." can't reach outside Root.
+func (l *Local) fullPath(path string) string {
+	return filepath.Join(l.Root, filepath.FromSlash(cleanPath(path)))
+}
+
+// transferPaths checks that src can be moved or copied to dst and returns
+// both as filesystem paths.
+func (l *Local) transferPaths(src, dst string) (string, string, error) {
+	s, d := cleanPath(src), cleanPath(dst)
+	if s == "/" || d == "/" {
+		return "", "", fmt.Errorf("%w: cannot move or copy the root", ErrInvalidPath)
+	}
+	if d == s || strings.HasPrefix(d, s+"/") {
+		return "", "", fmt.Errorf("%w: cannot move or copy %s into itself", ErrInvalidPath, src)
+	}
+
+	srcPath, dstPath := l.fullPath(src), l.fullPath(dst)
+	if _, err := os.Lstat(srcPath); err != nil {
+		return "", "", err
+	}
+	if _, err := os.Lstat(dstPath); err == nil {
+		return "", "", fmt.Errorf("%s: %w", dst, os.ErrExist)
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return "", "", err
+	}
+	return srcPath, dstPath, nil
+}
+
+// localFile adapts an *os.File to the File interface.
+type localFile struct {
+	*os.File
+	info     os.FileInfo
+	local    *Local
+	fullPath string
+}
+
+func (f *localFile) Size() int64        { return f.info.Size() }
+func (f *localFile) ModTime() time.Time { return f.info.ModTime() }
+
+func (f *localFile) Hash() (string, error) {
+	return f.local.contentHash(f.fullPath, f.File, f.info)
+}
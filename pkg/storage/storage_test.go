@@ -1,9 +1,15 @@
 package storage
 
 import (
+	"bytes"
+	"errors"
+	"io"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
+	"testing/iotest"
+	"time"
 )
 
 func TestNewLocal(t *testing.T) {
@@ -148,3 +154,333 @@ func TestLocalGetNonExistent(t *testing.T) {
 		t.Error("Get() for non-existent file should return error")
 	}
 }
+
+func TestLocalPutStreamOpen(t *testing.T) {
+	tmpDir := t.TempDir()
+	store, err := NewLocal(tmpDir)
+	if err != nil {
+		t.Fatalf("NewLocal() error = %v", err)
+	}
+
+	testData := bytes.Repeat([]byte("streaming "), 1000)
+
+	n, err := store.PutStream("stream/file.bin", bytes.NewReader(testData))
+	if err != nil {
+		t.Fatalf("PutStream() error = %v", err)
+	}
+	if n != int64(len(testData)) {
+		t.Errorf("PutStream() wrote %d bytes, want %d", n, len(testData))
+	}
+
+	f, err := store.Open("stream/file.bin")
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	defer f.Close()
+
+	if f.Size() != int64(len(testData)) {
+		t.Errorf("Size() = %d, want %d", f.Size(), len(testData))
+	}
+
+	result, err := io.ReadAll(f)
+	if err != nil {
+		t.Fatalf("ReadAll() error = %v", err)
+	}
+	if !bytes.Equal(result, testData) {
+		t.Error("Open() returned incorrect data")
+	}
+}
+
+func TestLocalPutStreamFailureKeepsOriginal(t *testing.T) {
+	tmpDir := t.TempDir()
+	store, err := NewLocal(tmpDir)
+	if err != nil {
+		t.Fatalf("NewLocal() error = %v", err)
+	}
+
+	if err := store.Put("keep.txt", []byte("original")); err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+
+	failing := io.MultiReader(strings.NewReader("partial"), iotest.ErrReader(errors.New("boom")))
+	if _, err := store.PutStream("keep.txt", failing); err == nil {
+		t.Fatal("PutStream() expected error from failing reader, got nil")
+	}
+
+	result, err := store.Get("keep.txt")
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	if string(result) != "original" {
+		t.Errorf("Get() = %s, want original", result)
+	}
+
+	entries, err := store.List("/")
+	if err != nil {
+		t.Fatalf("List() error = %v", err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("List() = %v, want only keep.txt (temp file left behind)", entries)
+	}
+}
+
+// testFileOps exercises Delete, Rename, Copy and Mkdir on a backend
+func testFileOps(t *testing.T, store Storage) {
+	t.Helper()
+	for _, p := range []string{"docs/a.txt", "docs/sub/b.txt"} {
+		if err := store.Put(p, []byte(p)); err != nil {
+			t.Fatalf("Put(%s) error = %v", p, err)
+		}
+	}
+
+	// Mkdir
+	if err := store.Mkdir("empty/inner"); err != nil {
+		t.Fatalf("Mkdir() error = %v", err)
+	}
+	if err := store.Mkdir("empty/inner"); err != nil {
+		t.Errorf("Mkdir() of existing directory error = %v", err)
+	}
+	if names, err := store.List("empty/inner"); err != nil || len(names) != 0 {
+		t.Errorf("List(empty/inner) = %v, %v, want empty directory", names, err)
+	}
+	if err := store.Mkdir("docs/a.txt"); !errors.Is(err, os.ErrExist) {
+		t.Errorf("Mkdir() over a file error = %v, want os.ErrExist", err)
+	}
+
+	// Copy
+	if err := store.Copy("docs", "backup/docs"); err != nil {
+		t.Fatalf("Copy() error = %v", err)
+	}
+	if got, err := store.Get("backup/docs/sub/b.txt"); err != nil || string(got) != "docs/sub/b.txt" {
+		t.Errorf("Get() of copy = %q, %v", got, err)
+	}
+	if !store.Exists("docs/sub/b.txt") {
+		t.Error("Copy() removed the source")
+	}
+	if err := store.Copy("docs/a.txt", "backup/docs/a.txt"); !errors.Is(err, os.ErrExist) {
+		t.Errorf("Copy() onto existing file error = %v, want os.ErrExist", err)
+	}
+	if err := store.Copy("docs", "docs/sub/docs"); !errors.Is(err, ErrInvalidPath) {
+		t.Errorf("Copy() into itself error = %v, want ErrInvalidPath", err)
+	}
+
+	// Rename
+	if err := store.Rename("docs/sub", "moved/sub"); err != nil {
+		t.Fatalf("Rename() error = %v", err)
+	}
+	if store.Exists("docs/sub") {
+		t.Error("Rename() left the source behind")
+	}
+	if got, err := store.Get("moved/sub/b.txt"); err != nil || string(got) != "docs/sub/b.txt" {
+		t.Errorf("Get() after Rename() = %q, %v", got, err)
+	}
+	if err := store.Rename("missing", "elsewhere"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Rename() of missing path error = %v, want os.ErrNotExist", err)
+	}
+
+	// Delete
+	if err := store.Delete("docs/a.txt", false); err != nil {
+		t.Fatalf("Delete() of file error = %v", err)
+	}
+	if store.Exists("docs/a.txt") {
+		t.Error("Delete() left the file behind")
+	}
+	if err := store.Delete("backup", false); !errors.Is(err, ErrNotEmpty) {
+		t.Errorf("Delete() of non-empty directory error = %v, want ErrNotEmpty", err)
+	}
+	if err := store.Delete("backup", true); err != nil {
+		t.Fatalf("Delete(recursive) error = %v", err)
+	}
+	if store.Exists("backup/docs/a.txt") {
+		t.Error("Delete(recursive) left files behind")
+	}
+	if err := store.Delete("empty/inner", false); err != nil {
+		t.Errorf("Delete() of empty directory error = %v", err)
+	}
+	if err := store.Delete("/", true); !errors.Is(err, ErrInvalidPath) {
+		t.Errorf("Delete(/) error = %v, want ErrInvalidPath", err)
+	}
+	if err := store.Delete("missing", false); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Delete() of missing path error = %v, want os.ErrNotExist", err)
+	}
+}
+
+func TestLocalFileOps(t *testing.T) {
+	store, err := NewLocal(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewLocal() error = %v", err)
+	}
+	testFileOps(t, store)
+}
+
+func TestLocalStaysInsideRoot(t *testing.T) {
+	parent := t.TempDir()
+	root := filepath.Join(parent, "root")
+	store, err := NewLocal(root)
+	if err != nil {
+		t.Fatalf("NewLocal() error = %v", err)
+	}
+
+	if err := store.Put("../escaped.txt", []byte("data")); err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(parent, "escaped.txt")); err == nil {
+		t.Error("Put() wrote outside the storage root")
+	}
+	if _, err := os.Stat(filepath.Join(root, "escaped.txt")); err != nil {
+		t.Errorf("Put() did not store the file inside the root: %v", err)
+	}
+}
+
+// testStatReadDir checks Stat and ReadDir on a backend
+func testStatReadDir(t *testing.T, store Storage) {
+	t.Helper()
+	data := []byte("hello")
+	for _, p := range []string{"dir/b.txt", "dir/a/c.txt"} {
+		if err := store.Put(p, data); err != nil {
+			t.Fatalf("Put(%s) error = %v", p, err)
+		}
+	}
+	if err := store.Mkdir("dir/empty"); err != nil {
+		t.Fatalf("Mkdir() error = %v", err)
+	}
+
+	info, err := store.Stat("/dir/b.txt")
+	if err != nil {
+		t.Fatalf("Stat() error = %v", err)
+	}
+	if info.Name != "b.txt" || info.Size != 5 || info.IsDir || info.ModTime.IsZero() {
+		t.Errorf("Stat() = %+v", info)
+	}
+	wantHash := "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824"
+	if info.Hash != wantHash {
+		t.Errorf("Stat().Hash = %q, want %q", info.Hash, wantHash)
+	}
+
+	if info, err := store.Stat("dir"); err != nil || !info.IsDir || !info.Mode.IsDir() {
+		t.Errorf("Stat(dir) = %+v, %v, want a directory", info, err)
+	}
+	if info, err := store.Stat("/"); err != nil || !info.IsDir || info.Name != "/" {
+		t.Errorf("Stat(/) = %+v, %v, want the root directory", info, err)
+	}
+	if _, err := store.Stat("dir/missing"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Stat() of missing path error = %v, want os.ErrNotExist", err)
+	}
+
+	infos, err := store.ReadDir("dir")
+	if err != nil {
+		t.Fatalf("ReadDir() error = %v", err)
+	}
+	var got []string
+	for _, fi := range infos {
+		name := fi.Name
+		if fi.IsDir {
+			name += "/"
+		}
+		got = append(got, name)
+	}
+	if want := "a/ b.txt empty/"; strings.Join(got, " ") != want {
+		t.Errorf("ReadDir() = %v, want %s", got, want)
+	}
+	if infos[1].Size != 5 || infos[1].Hash != wantHash {
+		t.Errorf("ReadDir() b.txt = %d bytes hashed %q, want 5 bytes hashed %q", infos[1].Size, infos[1].Hash, wantHash)
+	}
+}
+
+func TestLocalStatReadDir(t *testing.T) {
+	store, err := NewLocal(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewLocal() error = %v", err)
+	}
+	testStatReadDir(t, store)
+}
+
+func TestLocalHashFollowsContent(t *testing.T) {
+	store, err := NewLocal(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewLocal() error = %v", err)
+	}
+	modTime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	put := func(data string) string {
+		t.Helper()
+		if err := store.Put("a.txt", []byte(data)); err != nil {
+			t.Fatalf("Put() error = %v", err)
+		}
+		if err := store.SetAttr("a.txt", 0, modTime); err != nil {
+			t.Fatalf("SetAttr() error = %v", err)
+		}
+		info, err := store.Stat("a.txt")
+		if err != nil {
+			t.Fatalf("Stat() error = %v", err)
+		}
+		return info.Hash
+	}
+
+	// Same size and modification time, different content
+	first, second := put("hello"), put("world")
+	if first == second {
+		t.Errorf("replaced file kept hash %s", first)
+	}
+	if want := "486ea46224d1bb4fb680f34f7c9ad96a8f24ec88be73ea8e5a6c65260e9cb8a7"; second != want {
+		t.Errorf("Stat().Hash = %s, want %s", second, want)
+	}
+}
+
+// testSetAttr checks that modes and modification times set on files and
+// directories are reported by Stat and ReadDir
+func testSetAttr(t *testing.T, store Storage) {
+	t.Helper()
+	if err := store.Put("dir/a.txt", []byte("hello")); err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+	modTime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	if err := store.SetAttr("dir/a.txt", 0600, modTime); err != nil {
+		t.Fatalf("SetAttr() error = %v", err)
+	}
+	info, err := store.Stat("dir/a.txt")
+	if err != nil {
+		t.Fatalf("Stat() error = %v", err)
+	}
+	if info.Mode.Perm() != 0600 || !info.ModTime.Equal(modTime) {
+		t.Errorf("Stat() after SetAttr = %v %v, want -rw------- %v", info.Mode, info.ModTime, modTime)
+	}
+
+	// A zero mode keeps the mode
+	later := modTime.Add(time.Hour)
+	if err := store.SetAttr("dir/a.txt", 0, later); err != nil {
+		t.Fatalf("SetAttr() error = %v", err)
+	}
+	if info, _ := store.Stat("dir/a.txt"); info.Mode.Perm() != 0600 || !info.ModTime.Equal(later) {
+		t.Errorf("Stat() after SetAttr of time = %v %v", info.Mode, info.ModTime)
+	}
+
+	if err := store.SetAttr("dir", 0750, modTime); err != nil {
+		t.Fatalf("SetAttr(dir) error = %v", err)
+	}
+	infos, err := store.ReadDir("/")
+	if err != nil || len(infos) != 1 {
+		t.Fatalf("ReadDir() = %v, %v", infos, err)
+	}
+	if !infos[0].IsDir || infos[0].Mode.Perm() != 0750 || !infos[0].ModTime.Equal(modTime) {
+		t.Errorf("ReadDir() after SetAttr(dir) = %+v", infos[0])
+	}
+	if _, err := store.Stat("dir/a.txt"); err != nil {
+		t.Errorf("Stat() of file in directory after SetAttr error = %v", err)
+	}
+
+	if err := store.SetAttr("dir/missing", 0644, modTime); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("SetAttr() of missing path error = %v, want os.ErrNotExist", err)
+	}
+	if err := store.SetAttr("/", 0755, modTime); !errors.Is(err, ErrInvalidPath) {
+		t.Errorf("SetAttr(/) error = %v, want ErrInvalidPath", err)
+	}
+}
+
+func TestLocalSetAttr(t *testing.T) {
+	store, err := NewLocal(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewLocal() error = %v", err)
+	}
+	testSetAttr(t, store)
+}
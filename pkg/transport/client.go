@@ -0,0 +1,183 @@
+package transport
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/0xRepo-Source/goflux/pkg/checksum"
+	"github.com/0xRepo-Source/goflux/pkg/proto"
+)
+
+// Client is a connection to a goflux server over one transport. Clients
+// are safe for concurrent use.
+type Client interface {
+	// URL returns the server URL the client was created for.
+	URL() string
+
+	// Capabilities returns what the server supports.
+	Capabilities() (*proto.Capabilities, error)
+
+	// CreateUpload starts an upload and returns its ID.
+	CreateUpload(path string, totalChunks, chunkSize int, fileHash string, alg checksum.Algorithm) (string, error)
+
+	// SendManifest lets the server skip chunks it already holds.
+	SendManifest(uploadID string, manifest proto.Manifest) (*proto.ManifestResponse, error)
+
+	// UploadChunk uploads a single chunk.
+	UploadChunk(chunk proto.ChunkData) error
+
+	// QueryUploadStatus reports the upload session for a path, for servers
+	// without upload IDs.
+	QueryUploadStatus(path string) (*proto.UploadStatus, error)
+
+	// QueryUploadStatusByID reports the upload with the given ID.
+	QueryUploadStatusByID(id string) (*proto.UploadStatus, error)
+
+	// StatDownload describes a remote file without downloading it.
+	StatDownload(path string) (*RemoteFile, error)
+
+	// DownloadRange writes length bytes of a remote file starting at offset
+	// to the same offset in dst, failing with ErrRemoteChanged if the file
+	// no longer matches etag. It needs a RemoteFile with Ranges set.
+	DownloadRange(path string, offset, length int64, etag string, dst io.WriterAt) error
+
+	// Download writes a whole remote file to w and returns the number of
+	// bytes written.
+	Download(path string, w io.Writer) (int64, error)
+
+	// List lists files at a path.
+	List(path string) ([]string, error)
+
+	// Stat describes a remote file or directory.
+	Stat(path string) (*proto.FileInfo, error)
+
+	// ListDetails returns a page of the detailed listing of a directory.
+	ListDetails(path string, opts ListOptions) (*proto.Listing, error)
+
+	// Delete removes a remote file, or a directory and everything below it
+	// if recursive is set. A non-empty directory fails with ErrNotEmpty
+	// otherwise.
+	Delete(path string, recursive bool) error
+
+	// Move moves or renames a remote file or directory; dst must not exist.
+	Move(src, dst string) error
+
+	// Copy copies a remote file or directory tree; dst must not exist.
+	Copy(src, dst string) error
+
+	// Mkdir creates a remote directory and its parents.
+	Mkdir(path string) error
+
+	// SetAttr sets the permission bits and modification time of a remote
+	// file or directory. A zero mode or time leaves it unchanged.
+	SetAttr(path string, mode fs.FileMode, modTime time.Time) error
+
+	// Signature returns the block signature of a remote file, with blocks
+	// of blockSize bytes or a size the server picks if it is 0.
+	Signature(path string, blockSize int) (*proto.Signature, error)
+
+	// PutDelta replaces a remote file with the new version the delta
+	// written by diff makes of the version sig describes, failing with
+	// ErrRemoteChanged if the file changed since. diff may be called more
+	// than once.
+	PutDelta(path string, sig *proto.Signature, size int64, fileHash string, diff func(w io.Writer) error) (*proto.DeltaResult, error)
+
+	// Close releases the client's connections.
+	Close() error
+}
+
+var (
+	_ Client = (*HTTPClient)(nil)
+	_ Client = (*SSHClient)(nil)
+)
+
+// Options configures the clients created by Dial. Each transport uses the
+// options that apply to it.
+type Options struct {
+	Token string      // bearer token for HTTP-based transports
+	Retry RetryPolicy // zero value for DefaultRetryPolicy
+	TLS   TLSOptions  // server verification for https and quic
+	SSH   SSHOptions  // authentication and host verification for ssh
+}
+
+// DialFunc creates a client for a server URL.
+type DialFunc func(rawURL string, opts Options) (Client, error)
+
+var (
+	registryMu sync.RWMutex
+	registry   = make(map[string]DialFunc)
+)
+
+func init() {
+	Register("http", httpDialer(NewHTTPClient))
+	Register("https", httpDialer(NewHTTPClient))
+	Register("unix", httpDialer(NewUnixClient))
+	Register("quic", httpDialer(NewQUICClient))
+	Register("ssh", func(rawURL string, opts Options) (Client, error) {
+		return DialSSH(rawURL, opts.SSH)
+	})
+}
+
+// Register makes a transport available to Dial for URLs with the given
+// scheme, replacing any transport registered for it before.
+func Register(scheme string, dial DialFunc) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	registry[strings.ToLower(scheme)] = dial
+}
+
+// Schemes returns the URL schemes Dial understands, sorted.
+func Schemes() []string {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	schemes := make([]string, 0, len(registry))
+	for scheme := range registry {
+		schemes = append(schemes, scheme)
+	}
+	sort.Strings(schemes)
+	return schemes
+}
+
+// Dial creates a client for the server at rawURL with the transport
+// registered for its scheme. URLs without a scheme use http.
+func Dial(rawURL string, opts Options) (Client, error) {
+	if !strings.Contains(rawURL, "://") {
+		rawURL = "http://" + rawURL
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid server url: %w", err)
+	}
+
+	registryMu.RLock()
+	dial, ok := registry[strings.ToLower(u.Scheme)]
+	registryMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unsupported server url scheme %q (use %s)", u.Scheme, strings.Join(Schemes(), ", "))
+	}
+	return dial(rawURL, opts)
+}
+
+// httpDialer returns a DialFunc for a transport that speaks the goflux HTTP
+// protocol, creating its clients with newClient
+func httpDialer(newClient func(baseURL string) *HTTPClient) DialFunc {
+	return func(rawURL string, opts Options) (Client, error) {
+		client := newClient(rawURL)
+		if opts.Token != "" {
+			client.SetAuthToken(opts.Token)
+		}
+		if opts.Retry != (RetryPolicy{}) {
+			client.SetRetryPolicy(opts.Retry)
+		}
+		if err := client.SetTLSOptions(opts.TLS); err != nil {
+			return nil, err
+		}
+		return client, nil
+	}
+}
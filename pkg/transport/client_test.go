@@ -0,0 +1,102 @@
+package transport
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDialSelectsTransportByScheme(t *testing.T) {
+	tests := []struct {
+		url     string
+		baseURL string
+	}{
+		{"http://example.com:8080", "http://example.com:8080"},
+		{"example.com:8080", "http://example.com:8080"},
+		{"https://example.com", "https://example.com"},
+		{"quic://example.com:443", "https://example.com:443"},
+		{"unix:///run/goflux.sock", "http://goflux"},
+	}
+	for _, tt := range tests {
+		client, err := Dial(tt.url, Options{})
+		if err != nil {
+			t.Errorf("Dial(%q) error = %v", tt.url, err)
+			continue
+		}
+		httpClient, ok := client.(*HTTPClient)
+		if !ok {
+			t.Errorf("Dial(%q) = %T, want *HTTPClient", tt.url, client)
+			continue
+		}
+		if httpClient.BaseURL != tt.baseURL {
+			t.Errorf("Dial(%q) BaseURL = %q, want %q", tt.url, httpClient.BaseURL, tt.baseURL)
+		}
+		client.Close()
+	}
+
+	if _, err := Dial("ftp://example.com", Options{}); err == nil || !strings.Contains(err.Error(), "ftp") {
+		t.Errorf("Dial(ftp://) error = %v, want unsupported scheme", err)
+	}
+}
+
+func TestRegister(t *testing.T) {
+	var dialed string
+	Register("test", func(rawURL string, opts Options) (Client, error) {
+		dialed = rawURL
+		return NewHTTPClient("http://localhost"), nil
+	})
+	defer func() {
+		registryMu.Lock()
+		delete(registry, "test")
+		registryMu.Unlock()
+	}()
+
+	if _, err := Dial("TEST://host", Options{}); err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	if dialed != "TEST://host" {
+		t.Errorf("registered transport dialed %q", dialed)
+	}
+	if schemes := Schemes(); !strings.Contains(strings.Join(schemes, ","), "test") {
+		t.Errorf("Schemes() = %v, want test included", schemes)
+	}
+}
+
+func TestUnixClient(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "goflux.sock")
+	ln, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Skipf("unix sockets unavailable: %v", err)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /list", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		json.NewEncoder(w).Encode([]string{r.URL.Query().Get("path")})
+	})
+	server := &http.Server{Handler: mux}
+	go server.Serve(ln)
+	defer server.Close()
+
+	client, err := Dial("unix://"+socket, Options{Token: "secret"})
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	defer client.Close()
+
+	files, err := client.List("/docs")
+	if err != nil {
+		t.Fatalf("List() error = %v", err)
+	}
+	if len(files) != 1 || files[0] != "/docs" {
+		t.Errorf("List() = %v", files)
+	}
+	if client.URL() != "unix://"+socket {
+		t.Errorf("URL() = %q", client.URL())
+	}
+}
@@ -0,0 +1,151 @@
+package transport
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/0xRepo-Source/goflux/pkg/proto"
+)
+
+// ErrDeltaUnsupported is returned by Signature and PutDelta when the server
+// cannot take delta uploads.
+var ErrDeltaUnsupported = errors.New("server does not support delta uploads")
+
+// Signature returns the block signature of a remote file, with blocks of
+// blockSize bytes or, if it is 0, a size the server picks.
+func (h *HTTPClient) Signature(path string, blockSize int) (*proto.Signature, error) {
+	query := url.Values{"path": {path}}
+	if blockSize > 0 {
+		query.Set("block_size", strconv.Itoa(blockSize))
+	}
+
+	var sig proto.Signature
+	err := h.withRetry(func() error {
+		if err := h.checkDelta(); err != nil {
+			return err
+		}
+		req, err := http.NewRequest("GET", h.BaseURL+"/signature?"+query.Encode(), nil)
+		if err != nil {
+			return err
+		}
+
+		// Add auth token if set
+		if h.authToken != "" {
+			req.Header.Set("Authorization", "Bearer "+h.authToken)
+		}
+
+		resp, err := h.client.Do(req)
+		if err != nil {
+			return networkError(err)
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			return readError(resp, "signature")
+		}
+		return json.NewDecoder(resp.Body).Decode(&sig)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &sig, nil
+}
+
+// PutDelta replaces a remote file with the new version of size bytes and
+// hex SHA-256 fileHash that the delta written by diff makes of the version
+// sig describes. The delta is streamed as diff writes it, and diff is
+// called again for every retry, so it must write the whole delta each
+// time. If the file changed since sig was made, ErrRemoteChanged is
+// returned; this is also the answer to a retry of a delta the server
+// applied before its response was lost.
+func (h *HTTPClient) PutDelta(path string, sig *proto.Signature, size int64, fileHash string, diff func(w io.Writer) error) (*proto.DeltaResult, error) {
+	query := url.Values{
+		"path":       {path},
+		"etag":       {sig.ETag},
+		"block_size": {strconv.Itoa(sig.BlockSize)},
+		"size":       {strconv.FormatInt(size, 10)},
+		"file_hash":  {fileHash},
+	}
+	var result *proto.DeltaResult
+	err := h.withRetry(func() error {
+		if err := h.checkDelta(); err != nil {
+			return err
+		}
+
+		pr, pw := io.Pipe()
+		diffed := make(chan error, 1)
+		go func() {
+			err := diff(pw)
+			pw.CloseWithError(err)
+			diffed <- err
+		}()
+		var err error
+		result, err = h.postDelta(query, pr)
+		pr.Close()
+		if diffErr := <-diffed; diffErr != nil && !errors.Is(diffErr, io.ErrClosedPipe) {
+			return fmt.Errorf("failed to compute delta: %w", diffErr)
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// postDelta makes a single delta upload with the body read from r
+func (h *HTTPClient) postDelta(query url.Values, r io.Reader) (*proto.DeltaResult, error) {
+	req, err := http.NewRequest("POST", h.BaseURL+"/delta?"+query.Encode(), r)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+
+	// Add auth token if set
+	if h.authToken != "" {
+		req.Header.Set("Authorization", "Bearer "+h.authToken)
+	}
+
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return nil, networkError(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, readError(resp, "delta")
+	}
+	var result proto.DeltaResult
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// checkDelta fails with ErrDeltaUnsupported unless the server advertises
+// delta uploads
+func (h *HTTPClient) checkDelta() error {
+	caps, err := h.Capabilities()
+	if err != nil {
+		return err
+	}
+	if !caps.Has(proto.FeatureDelta) {
+		return ErrDeltaUnsupported
+	}
+	return nil
+}
+
+// Signature is not available over SFTP.
+func (c *SSHClient) Signature(remotePath string, blockSize int) (*proto.Signature, error) {
+	return nil, ErrDeltaUnsupported
+}
+
+// PutDelta is not available over SFTP.
+func (c *SSHClient) PutDelta(remotePath string, sig *proto.Signature, size int64, fileHash string, diff func(w io.Writer) error) (*proto.DeltaResult, error) {
+	return nil, ErrDeltaUnsupported
+}
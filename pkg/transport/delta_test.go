@@ -0,0 +1,123 @@
+package transport
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/0xRepo-Source/goflux/pkg/proto"
+)
+
+// writeString returns a diff function that writes s
+func writeString(s string) func(w io.Writer) error {
+	return func(w io.Writer) error {
+		_, err := io.WriteString(w, s)
+		return err
+	}
+}
+
+func TestSignatureAndPutDelta(t *testing.T) {
+	var query string
+	var body string
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /capabilities", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(proto.Capabilities{Version: proto.ProtocolVersion, Features: []string{proto.FeatureDelta}})
+	})
+	mux.HandleFunc("GET /signature", func(w http.ResponseWriter, r *http.Request) {
+		query = r.URL.RawQuery
+		json.NewEncoder(w).Encode(proto.Signature{Size: 10, ETag: `"1-a"`, BlockSize: 4096})
+	})
+	mux.HandleFunc("POST /delta", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("etag") != `"1-a"` {
+			proto.WriteError(w, http.StatusPreconditionFailed, proto.CodeChanged, "/a changed since its signature was made")
+			return
+		}
+		query = r.URL.RawQuery
+		data, _ := io.ReadAll(r.Body)
+		body = string(data)
+		json.NewEncoder(w).Encode(proto.DeltaResult{Size: 12, LiteralBytes: 2, CopiedBytes: 10})
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	client := NewHTTPClient(srv.URL)
+	sig, err := client.Signature("/a", 4096)
+	if err != nil {
+		t.Fatalf("Signature() error = %v", err)
+	}
+	if sig.ETag != `"1-a"` || query != "block_size=4096&path=%2Fa" {
+		t.Errorf("Signature() = %+v with query %s", sig, query)
+	}
+
+	result, err := client.PutDelta("/a", sig, 12, "ff", writeString("delta"))
+	if err != nil {
+		t.Fatalf("PutDelta() error = %v", err)
+	}
+	if *result != (proto.DeltaResult{Size: 12, LiteralBytes: 2, CopiedBytes: 10}) || body != "delta" {
+		t.Errorf("PutDelta() = %+v, server received %q", result, body)
+	}
+	if want := "block_size=4096&etag=%221-a%22&file_hash=ff&path=%2Fa&size=12"; query != want {
+		t.Errorf("PutDelta() query = %s, want %s", query, want)
+	}
+
+	sig.ETag = `"2-a"`
+	if _, err := client.PutDelta("/a", sig, 12, "ff", writeString("delta")); !errors.Is(err, ErrRemoteChanged) {
+		t.Errorf("PutDelta() against a changed file error = %v, want ErrRemoteChanged", err)
+	}
+
+	// Servers without the feature are told apart from failures
+	if _, err := NewHTTPClient(srv.URL+"/missing").Signature("/a", 0); !errors.Is(err, ErrDeltaUnsupported) {
+		t.Errorf("Signature() on an older server error = %v, want ErrDeltaUnsupported", err)
+	}
+}
+
+func TestPutDeltaRetries(t *testing.T) {
+	var posts atomic.Int32
+	var body string
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /capabilities", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(proto.Capabilities{Version: proto.ProtocolVersion, Features: []string{proto.FeatureDelta}})
+	})
+	mux.HandleFunc("POST /delta", func(w http.ResponseWriter, r *http.Request) {
+		if posts.Add(1) == 1 {
+			// The connection drops halfway through the first delta
+			io.ReadFull(r.Body, make([]byte, 3))
+			conn, _, _ := w.(http.Hijacker).Hijack()
+			conn.Close()
+			return
+		}
+		data, _ := io.ReadAll(r.Body)
+		body = string(data)
+		json.NewEncoder(w).Encode(proto.DeltaResult{Size: 12, LiteralBytes: 12})
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	client := NewHTTPClient(srv.URL)
+	client.SetRetryPolicy(RetryPolicy{MaxAttempts: 3})
+	diffs := 0
+	diff := func(w io.Writer) error {
+		diffs++
+		return writeString("the whole delta")(w)
+	}
+	if _, err := client.PutDelta("/a", &proto.Signature{ETag: `"1-a"`, BlockSize: 4096}, 12, "ff", diff); err != nil {
+		t.Fatalf("PutDelta() error = %v", err)
+	}
+	if posts.Load() != 2 || diffs != 2 || body != "the whole delta" {
+		t.Errorf("PutDelta() made %d requests from %d diffs; server received %q", posts.Load(), diffs, body)
+	}
+
+	// A failure to compute the delta is not retried
+	posts.Store(1)
+	failed := errors.New("read error")
+	_, err := client.PutDelta("/a", &proto.Signature{ETag: `"1-a"`, BlockSize: 4096}, 12, "ff", func(io.Writer) error { return failed })
+	if !errors.Is(err, failed) || posts.Load() > 2 {
+		t.Errorf("PutDelta() with a failing diff = %v after %d requests, want %v after at most 1", err, posts.Load()-1, failed)
+	}
+}
@@ -0,0 +1,190 @@
+package transport
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/0xRepo-Source/goflux/pkg/proto"
+)
+
+// ErrFilesUnsupported is returned by Delete, Move, Copy and Mkdir when the
+// server cannot change its namespace.
+var ErrFilesUnsupported = errors.New("server does not support deleting, moving, copying or creating directories")
+
+// ErrStatUnsupported is returned by Stat and ListDetails when the server
+// cannot describe files.
+var ErrStatUnsupported = errors.New("server does not support stat or detailed listings")
+
+// ErrSetAttrUnsupported is returned by SetAttr when the server cannot set
+// modes and modification times.
+var ErrSetAttrUnsupported = errors.New("server does not support setting file attributes")
+
+// ErrNotEmpty is returned by Delete for a directory that still has entries
+// when the delete isn't recursive.
+var ErrNotEmpty = errors.New("directory not empty")
+
+// ListOptions selects the order and page of a detailed listing.
+type ListOptions struct {
+	Sort    string // proto.SortName, SortSize or SortTime; empty for name
+	Reverse bool   // descending order
+	Offset  int    // index of the first entry
+	Limit   int    // entries per page, 0 for proto.MaxListLimit
+}
+
+// Stat describes a remote file or directory.
+func (h *HTTPClient) Stat(path string) (*proto.FileInfo, error) {
+	var info proto.FileInfo
+	err := h.withRetry(func() error {
+		return h.getDetails("/stat", url.Values{"path": {path}}, "stat", &info)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &info, nil
+}
+
+// ListDetails returns a page of the detailed listing of a remote
+// directory. Listing.NextOffset is the offset of the next page, or 0 after
+// the last.
+func (h *HTTPClient) ListDetails(path string, opts ListOptions) (*proto.Listing, error) {
+	query := url.Values{
+		"path":    {path},
+		"detail":  {"true"},
+		"sort":    {opts.Sort},
+		"reverse": {strconv.FormatBool(opts.Reverse)},
+		"offset":  {strconv.Itoa(opts.Offset)},
+		"limit":   {strconv.Itoa(opts.Limit)},
+	}
+	var listing proto.Listing
+	err := h.withRetry(func() error {
+		return h.getDetails("/list", query, "list", &listing)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &listing, nil
+}
+
+// getDetails makes a single request for file details and decodes the
+// answer into v.
+func (h *HTTPClient) getDetails(route string, query url.Values, op string, v any) error {
+	caps, err := h.Capabilities()
+	if err != nil {
+		return err
+	}
+	if !caps.Has(proto.FeatureStat) {
+		return ErrStatUnsupported
+	}
+
+	req, err := http.NewRequest("GET", h.BaseURL+route+"?"+query.Encode(), nil)
+	if err != nil {
+		return err
+	}
+
+	// Add auth token if set
+	if h.authToken != "" {
+		req.Header.Set("Authorization", "Bearer "+h.authToken)
+	}
+
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return networkError(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return readError(resp, op)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// Delete removes a remote file, or a directory and everything below it if
+// recursive is set. Changes to the namespace are sent once rather than
+// retried, since a retry could fail on the effect of an attempt that
+// succeeded.
+func (h *HTTPClient) Delete(path string, recursive bool) error {
+	return h.fileOp("/delete", "delete", proto.DeleteRequest{Path: path, Recursive: recursive})
+}
+
+// Move moves or renames a remote file or directory. dst must not exist.
+func (h *HTTPClient) Move(src, dst string) error {
+	return h.fileOp("/move", "move", proto.MoveRequest{Source: src, Destination: dst})
+}
+
+// Copy copies a remote file or directory tree. dst must not exist.
+func (h *HTTPClient) Copy(src, dst string) error {
+	return h.fileOp("/copy", "copy", proto.MoveRequest{Source: src, Destination: dst})
+}
+
+// Mkdir creates a remote directory and its parents. Unlike the other
+// changes it is idempotent, so it is retried.
+func (h *HTTPClient) Mkdir(path string) error {
+	return h.withRetry(func() error {
+		return h.fileOp("/mkdir", "mkdir", proto.MkdirRequest{Path: path})
+	})
+}
+
+// SetAttr sets the permission bits and modification time of a remote file
+// or directory. A zero mode or time leaves it unchanged.
+func (h *HTTPClient) SetAttr(path string, mode fs.FileMode, modTime time.Time) error {
+	req := proto.SetAttrRequest{Path: path, Mode: uint32(mode.Perm()), ModTime: modTime}
+	return h.withRetry(func() error {
+		caps, err := h.Capabilities()
+		if err != nil {
+			return err
+		}
+		if !caps.Has(proto.FeatureSetAttr) {
+			return ErrSetAttrUnsupported
+		}
+		return h.post("/setattr", "setattr", req)
+	})
+}
+
+// fileOp makes a single request to a file operation route. The operations
+// are newer than capability negotiation, so servers that don't advertise
+// them don't have them.
+func (h *HTTPClient) fileOp(route, op string, body any) error {
+	caps, err := h.Capabilities()
+	if err != nil {
+		return err
+	}
+	if !caps.Has(proto.FeatureFiles) {
+		return ErrFilesUnsupported
+	}
+	return h.post(route, op, body)
+}
+
+// post makes a single POST of body as JSON, expecting no answer
+func (h *HTTPClient) post(route, op string, body any) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", h.BaseURL+route, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	// Add auth token if set
+	if h.authToken != "" {
+		req.Header.Set("Authorization", "Bearer "+h.authToken)
+	}
+
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return networkError(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK {
+		return readError(resp, op)
+	}
+	return nil
+}
@@ -0,0 +1,135 @@
+package transport
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/0xRepo-Source/goflux/pkg/proto"
+)
+
+func TestFileOps(t *testing.T) {
+	var moved proto.MoveRequest
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /capabilities", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(proto.Capabilities{Version: proto.ProtocolVersion, Features: []string{proto.FeatureFiles}})
+	})
+	mux.HandleFunc("POST /move", func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&moved)
+		w.WriteHeader(http.StatusNoContent)
+	})
+	mux.HandleFunc("POST /copy", func(w http.ResponseWriter, r *http.Request) {
+		proto.WriteError(w, http.StatusConflict, proto.CodeExists, "/b already exists")
+	})
+	mux.HandleFunc("POST /delete", func(w http.ResponseWriter, r *http.Request) {
+		proto.WriteError(w, http.StatusConflict, proto.CodeNotEmpty, "/a is not empty")
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	client := NewHTTPClient(srv.URL)
+	if err := client.Move("/a", "/b"); err != nil {
+		t.Fatalf("Move() error = %v", err)
+	}
+	if moved.Source != "/a" || moved.Destination != "/b" {
+		t.Errorf("server received %+v", moved)
+	}
+	if err := client.Copy("/a", "/b"); !errors.Is(err, os.ErrExist) {
+		t.Errorf("Copy() error = %v, want os.ErrExist", err)
+	}
+	if err := client.Delete("/a", false); !errors.Is(err, ErrNotEmpty) {
+		t.Errorf("Delete() error = %v, want ErrNotEmpty", err)
+	}
+}
+
+func TestFileOpsUnsupported(t *testing.T) {
+	// Servers that predate capability negotiation predate file operations
+	srv := httptest.NewServer(http.NotFoundHandler())
+	defer srv.Close()
+
+	client := NewHTTPClient(srv.URL)
+	if err := client.Mkdir("/a"); !errors.Is(err, ErrFilesUnsupported) {
+		t.Errorf("Mkdir() error = %v, want ErrFilesUnsupported", err)
+	}
+}
+
+func TestStatAndListDetails(t *testing.T) {
+	var query url.Values
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /capabilities", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(proto.Capabilities{Version: proto.ProtocolVersion, Features: []string{proto.FeatureStat}})
+	})
+	mux.HandleFunc("GET /stat", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("path") != "/a.txt" {
+			proto.WriteError(w, http.StatusNotFound, proto.CodeNotFound, "not found")
+			return
+		}
+		json.NewEncoder(w).Encode(proto.FileInfo{Name: "a.txt", Size: 3, Mode: 0644, Hash: "ff"})
+	})
+	mux.HandleFunc("GET /list", func(w http.ResponseWriter, r *http.Request) {
+		query = r.URL.Query()
+		json.NewEncoder(w).Encode(proto.Listing{Path: "/", Entries: []proto.FileInfo{{Name: "b", IsDir: true}}, Total: 2, NextOffset: 1})
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	client := NewHTTPClient(srv.URL)
+	info, err := client.Stat("/a.txt")
+	if err != nil {
+		t.Fatalf("Stat() error = %v", err)
+	}
+	if info.Name != "a.txt" || info.Size != 3 || info.Hash != "ff" {
+		t.Errorf("Stat() = %+v", info)
+	}
+	if _, err := client.Stat("/missing"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Stat() of missing file error = %v, want os.ErrNotExist", err)
+	}
+
+	listing, err := client.ListDetails("/", ListOptions{Sort: proto.SortSize, Reverse: true, Limit: 1})
+	if err != nil {
+		t.Fatalf("ListDetails() error = %v", err)
+	}
+	if len(listing.Entries) != 1 || !listing.Entries[0].IsDir || listing.NextOffset != 1 {
+		t.Errorf("ListDetails() = %+v", listing)
+	}
+	if query.Get("detail") != "true" || query.Get("sort") != "size" || query.Get("reverse") != "true" || query.Get("limit") != "1" {
+		t.Errorf("ListDetails() sent query %v", query)
+	}
+}
+
+func TestSetAttr(t *testing.T) {
+	var got proto.SetAttrRequest
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /capabilities", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(proto.Capabilities{Version: proto.ProtocolVersion, Features: []string{proto.FeatureSetAttr}})
+	})
+	mux.HandleFunc("POST /setattr", func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&got)
+		w.WriteHeader(http.StatusNoContent)
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	modTime := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	client := NewHTTPClient(srv.URL)
+	if err := client.SetAttr("/a.txt", fs.ModeDir|0750, modTime); err != nil {
+		t.Fatalf("SetAttr() error = %v", err)
+	}
+	if got.Path != "/a.txt" || got.Mode != 0750 || !got.ModTime.Equal(modTime) {
+		t.Errorf("server received %+v", got)
+	}
+
+	// Servers without the feature are told apart from failures
+	if err := NewHTTPClient(srv.URL+"/missing").SetAttr("/a.txt", 0644, modTime); !errors.Is(err, ErrSetAttrUnsupported) {
+		t.Errorf("SetAttr() on an older server error = %v, want ErrSetAttrUnsupported", err)
+	}
+}
@@ -0,0 +1,36 @@
+package transport
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/http3"
+)
+
+// quicConfig tunes QUIC connections for transfers over long, lossy links.
+// The receive windows bound how much downloaded data can be in flight; the
+// defaults are too small to fill an intercontinental link.
+func quicConfig() *quic.Config {
+	return &quic.Config{
+		MaxStreamReceiveWindow:     16 << 20,
+		MaxConnectionReceiveWindow: 64 << 20,
+		KeepAlivePeriod:            15 * time.Second, // keeps NAT bindings open between requests
+	}
+}
+
+// NewQUICClient creates a client that speaks the goflux protocol over
+// HTTP/3 to the server at baseURL, given as quic://host:port. Every request
+// travels on its own QUIC stream, so a lost packet only delays the chunk it
+// belongs to instead of every chunk in flight, as it does over TCP.
+func NewQUICClient(baseURL string) *HTTPClient {
+	quicTransport := &http3.Transport{QUICConfig: quicConfig()}
+	return &HTTPClient{
+		BaseURL: "https://" + strings.TrimPrefix(baseURL, "quic://"),
+		url:     baseURL,
+		client:  &http.Client{Transport: quicTransport},
+		quic:    quicTransport,
+		retry:   DefaultRetryPolicy(),
+	}
+}
@@ -0,0 +1,121 @@
+package transport
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"math/big"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/0xRepo-Source/goflux/pkg/proto"
+	"github.com/quic-go/quic-go/http3"
+)
+
+// newQUICServer serves handler over HTTP/3 on loopback with a self-signed
+// certificate and returns its quic:// URL
+func newQUICServer(t *testing.T, handler http.Handler) string {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &http3.Server{
+		Handler: handler,
+		TLSConfig: http3.ConfigureTLSConfig(&tls.Config{
+			Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}},
+		}),
+	}
+	go server.Serve(conn)
+	t.Cleanup(func() {
+		server.Close()
+		conn.Close()
+	})
+	return "quic://" + conn.LocalAddr().String()
+}
+
+func TestQUICClient(t *testing.T) {
+	var mu sync.Mutex
+	received := make(map[string]bool)
+	var nonQUIC atomic.Int32
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("PUT /upload/{id}/chunks/{n}", func(w http.ResponseWriter, r *http.Request) {
+		if r.ProtoMajor != 3 {
+			nonQUIC.Add(1)
+		}
+		mu.Lock()
+		received[r.PathValue("n")] = true
+		mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(proto.ChunkResponse{UploadID: r.PathValue("id")})
+	})
+	mux.HandleFunc("GET /list", func(w http.ResponseWriter, r *http.Request) {
+		if r.ProtoMajor != 3 {
+			nonQUIC.Add(1)
+		}
+		json.NewEncoder(w).Encode([]string{"a.txt", "b/"})
+	})
+
+	client := NewQUICClient(newQUICServer(t, mux))
+	if err := client.SetTLSOptions(TLSOptions{InsecureSkipVerify: true}); err != nil {
+		t.Fatal(err)
+	}
+	client.SetRetryPolicy(RetryPolicy{MaxAttempts: 1})
+
+	// Chunks sent concurrently share one connection on separate streams
+	const chunks = 16
+	var wg sync.WaitGroup
+	errs := make(chan error, chunks)
+	for i := 0; i < chunks; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs <- client.UploadChunk(proto.ChunkData{UploadID: "u1", ChunkID: i, Data: []byte("chunk")})
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatalf("UploadChunk() error = %v", err)
+		}
+	}
+	if len(received) != chunks {
+		t.Errorf("server received %d chunks, want %d", len(received), chunks)
+	}
+
+	files, err := client.List("/")
+	if err != nil {
+		t.Fatalf("List() error = %v", err)
+	}
+	if len(files) != 2 || files[0] != "a.txt" {
+		t.Errorf("List() = %v", files)
+	}
+	if n := nonQUIC.Load(); n != 0 {
+		t.Errorf("%d requests were not made over HTTP/3", n)
+	}
+}
@@ -0,0 +1,127 @@
+package transport
+
+import (
+	"crypto/tls"
+	"errors"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// RetryPolicy controls how HTTPClient retries requests that failed with a
+// network error, a 5xx or 429 response, or a rejected chunk checksum.
+type RetryPolicy struct {
+	MaxAttempts int           // attempts per request, including the first; 1 disables retries
+	BaseDelay   time.Duration // delay before the first retry, doubled on each further retry
+	MaxDelay    time.Duration // upper bound for a single delay
+	MaxElapsed  time.Duration // give up once a request has been retried this long (0 = no limit)
+}
+
+// DefaultRetryPolicy returns the retry policy used by NewHTTPClient.
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts: 6,
+		BaseDelay:   500 * time.Millisecond,
+		MaxDelay:    30 * time.Second,
+		MaxElapsed:  5 * time.Minute,
+	}
+}
+
+// backoff returns how long to wait before the given retry (1 for the first
+// retry). It grows exponentially with full jitter over the upper half of
+// the interval, and honours a longer delay requested by the server.
+func (p RetryPolicy) backoff(retry int, requested time.Duration) time.Duration {
+	delay := p.BaseDelay
+	for i := 1; i < retry && delay < p.MaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > p.MaxDelay {
+		delay = p.MaxDelay
+	}
+	if delay > 0 {
+		delay = delay/2 + rand.N(delay/2+1)
+	}
+	if requested > delay {
+		delay = requested
+	}
+	return delay
+}
+
+// temporaryError marks a failure that may succeed if the request is sent
+// again.
+type temporaryError struct {
+	err        error
+	network    bool          // the request may not have reached the server
+	retryAfter time.Duration // delay requested by the server, if any
+}
+
+func (e *temporaryError) Error() string { return e.err.Error() }
+func (e *temporaryError) Unwrap() error { return e.err }
+
+// networkError wraps an error returned by http.Client.Do. Certificate
+// verification failures are permanent and are returned unchanged.
+func networkError(err error) error {
+	var certErr *tls.CertificateVerificationError
+	if errors.As(err, &certErr) {
+		return err
+	}
+	return &temporaryError{err: err, network: true}
+}
+
+// responseError marks err as temporary if resp has a status worth retrying
+func responseError(resp *http.Response, err error) error {
+	if resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests {
+		return &temporaryError{err: err, retryAfter: retryAfter(resp)}
+	}
+	return err
+}
+
+// retryAfter parses a Retry-After header given in seconds
+func retryAfter(resp *http.Response) time.Duration {
+	secs, err := strconv.Atoi(resp.Header.Get("Retry-After"))
+	if err != nil || secs < 0 {
+		return 0
+	}
+	return time.Duration(secs) * time.Second
+}
+
+// isNetworkError reports whether err is a temporary network failure
+func isNetworkError(err error) bool {
+	var temp *temporaryError
+	return errors.As(err, &temp) && temp.network
+}
+
+// withRetry runs op until it succeeds, fails with a permanent error or the
+// retry policy is exhausted, sleeping with backoff between attempts.
+func (h *HTTPClient) withRetry(op func() error) error {
+	policy := h.retry
+	start := time.Now()
+
+	for attempt := 1; ; attempt++ {
+		err := op()
+		if err == nil {
+			return nil
+		}
+
+		var temp *temporaryError
+		var requested time.Duration
+		switch {
+		case errors.As(err, &temp):
+			requested = temp.retryAfter
+		case errors.Is(err, ErrChecksumMismatch):
+			// Corrupted in transit; sending the same bytes again is safe
+		default:
+			return err
+		}
+
+		if attempt >= policy.MaxAttempts {
+			return err
+		}
+		delay := policy.backoff(attempt, requested)
+		if policy.MaxElapsed > 0 && time.Since(start)+delay > policy.MaxElapsed {
+			return err
+		}
+		time.Sleep(delay)
+	}
+}
@@ -0,0 +1,544 @@
+package transport
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/0xRepo-Source/goflux/pkg/checksum"
+	"github.com/0xRepo-Source/goflux/pkg/proto"
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// SSHOptions configures how the client authenticates to SSH servers and
+// verifies them.
+type SSHOptions struct {
+	KeyFile               string // private key; empty for the SSH agent and the default keys in ~/.ssh
+	KnownHostsFile        string // trusted host keys; empty for ~/.ssh/known_hosts
+	InsecureIgnoreHostKey bool   // accept any host key (testing only)
+}
+
+// SSHClient transfers files over SFTP, to goflux-server or any other SFTP
+// server. As a Client it writes chunks straight into the remote file at
+// their offsets; the server does not verify checksums and uploads cannot
+// be resumed after the client exits.
+type SSHClient struct {
+	url   string
+	conn  *ssh.Client
+	sftp  *sftp.Client
+	agent net.Conn // connection to the SSH agent, if it was used
+
+	mu      sync.Mutex
+	uploads map[string]*sftpUpload // uploads in progress by ID
+	nextID  int
+}
+
+// sftpUpload is a remote file being written chunk by chunk
+type sftpUpload struct {
+	path     string
+	file     *sftp.File
+	alg      checksum.Algorithm
+	received []bool
+	pending  int // chunks not yet received
+}
+
+// DialSSH connects to the SFTP server at rawURL, of the form
+// ssh://user@host[:port]. The user defaults to $USER and the port to 22.
+func DialSSH(rawURL string, opts SSHOptions) (*SSHClient, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ssh url: %w", err)
+	}
+	if u.Scheme != "ssh" || u.Hostname() == "" {
+		return nil, fmt.Errorf("invalid ssh url %q, want ssh://user@host[:port]", rawURL)
+	}
+	user := u.User.Username()
+	if user == "" {
+		user = os.Getenv("USER")
+	}
+	port := u.Port()
+	if port == "" {
+		port = "22"
+	}
+
+	config, agentConn, err := opts.clientConfig(user)
+	if err != nil {
+		return nil, err
+	}
+	closeAgent := func() {
+		if agentConn != nil {
+			agentConn.Close()
+		}
+	}
+
+	conn, err := ssh.Dial("tcp", net.JoinHostPort(u.Hostname(), port), config)
+	if err != nil {
+		closeAgent()
+		return nil, err
+	}
+	client, err := sftp.NewClient(conn, sftp.UseConcurrentWrites(true))
+	if err != nil {
+		conn.Close()
+		closeAgent()
+		return nil, fmt.Errorf("failed to start sftp: %w", err)
+	}
+	return &SSHClient{url: rawURL, conn: conn, sftp: client, agent: agentConn, uploads: make(map[string]*sftpUpload)}, nil
+}
+
+// clientConfig builds an ssh.ClientConfig from the options. It also returns
+// the connection to the SSH agent if its keys are offered, which the caller
+// must close.
+func (o SSHOptions) clientConfig(user string) (*ssh.ClientConfig, net.Conn, error) {
+	hostKeyCallback, err := o.hostKeyCallback()
+	if err != nil {
+		return nil, nil, err
+	}
+	auth, agentConn, err := o.authMethods()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return &ssh.ClientConfig{
+		User:            user,
+		Auth:            auth,
+		HostKeyCallback: hostKeyCallback,
+	}, agentConn, nil
+}
+
+// hostKeyCallback verifies host keys against the known hosts file, unless
+// they are to be ignored.
+func (o SSHOptions) hostKeyCallback() (ssh.HostKeyCallback, error) {
+	if o.InsecureIgnoreHostKey {
+		return ssh.InsecureIgnoreHostKey(), nil
+	}
+	knownHosts := o.KnownHostsFile
+	if knownHosts == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, err
+		}
+		knownHosts = filepath.Join(home, ".ssh", "known_hosts")
+	}
+	callback, err := knownhosts.New(knownHosts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read known hosts: %w", err)
+	}
+	return callback, nil
+}
+
+// authMethods returns the configured key, or the SSH agent's keys followed
+// by the default keys in ~/.ssh. It also returns the connection to the
+// agent, if one was made.
+func (o SSHOptions) authMethods() ([]ssh.AuthMethod, net.Conn, error) {
+	if o.KeyFile != "" {
+		signer, err := loadSSHKey(o.KeyFile)
+		if err != nil {
+			return nil, nil, err
+		}
+		return []ssh.AuthMethod{ssh.PublicKeys(signer)}, nil, nil
+	}
+
+	var methods []ssh.AuthMethod
+	var agentConn net.Conn
+	if sock := os.Getenv("SSH_AUTH_SOCK"); sock != "" {
+		if conn, err := net.Dial("unix", sock); err == nil {
+			agentConn = conn
+			methods = append(methods, ssh.PublicKeysCallback(agent.NewClient(conn).Signers))
+		}
+	}
+
+	var signers []ssh.Signer
+	if home, err := os.UserHomeDir(); err == nil {
+		for _, name := range []string{"id_ed25519", "id_ecdsa", "id_rsa"} {
+			// Missing and passphrase-protected keys are skipped; the
+			// agent holds the latter
+			if signer, err := loadSSHKey(filepath.Join(home, ".ssh", name)); err == nil {
+				signers = append(signers, signer)
+			}
+		}
+	}
+	if len(signers) > 0 {
+		methods = append(methods, ssh.PublicKeys(signers...))
+	}
+
+	if len(methods) == 0 {
+		return nil, nil, errors.New("no ssh key found: set ssh_key or start an ssh agent")
+	}
+	return methods, agentConn, nil
+}
+
+// loadSSHKey reads an unencrypted private key
+func loadSSHKey(filename string) (ssh.Signer, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read ssh key: %w", err)
+	}
+	signer, err := ssh.ParsePrivateKey(data)
+	if err != nil {
+		var missing *ssh.PassphraseMissingError
+		if errors.As(err, &missing) {
+			return nil, fmt.Errorf("ssh key %s is passphrase-protected, add it to an ssh agent instead", filename)
+		}
+		return nil, fmt.Errorf("failed to parse ssh key %s: %w", filename, err)
+	}
+	return signer, nil
+}
+
+// Close closes the SFTP session, the connection and the connection to the
+// SSH agent. Uploads that are not complete are abandoned.
+func (c *SSHClient) Close() error {
+	c.sftp.Close()
+	if c.agent != nil {
+		c.agent.Close()
+	}
+	return c.conn.Close()
+}
+
+// Upload stores everything read from r at remotePath, creating its parent
+// directories, and returns the number of bytes written.
+func (c *SSHClient) Upload(r io.Reader, remotePath string) (int64, error) {
+	if err := c.sftp.MkdirAll(path.Dir(remotePath)); err != nil {
+		return 0, fmt.Errorf("failed to create %s: %w", path.Dir(remotePath), err)
+	}
+	f, err := c.sftp.Create(remotePath)
+	if err != nil {
+		return 0, err
+	}
+	n, err := f.ReadFrom(r)
+	if err != nil {
+		f.Close()
+		return n, err
+	}
+	// goflux-server stores the file when it is closed
+	if err := f.Close(); err != nil {
+		return n, err
+	}
+	return n, nil
+}
+
+// Download writes the file at remotePath to w and returns the number of
+// bytes written.
+func (c *SSHClient) Download(remotePath string, w io.Writer) (int64, error) {
+	f, err := c.sftp.Open(remotePath)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	return f.WriteTo(w)
+}
+
+// Stat describes the file or directory at remotePath. SFTP doesn't carry
+// content hashes.
+func (c *SSHClient) Stat(remotePath string) (*proto.FileInfo, error) {
+	info, err := c.sftp.Stat(remotePath)
+	if err != nil {
+		return nil, err
+	}
+	fi := sftpFileInfo(info)
+	if path.Clean("/"+remotePath) == "/" {
+		fi.Name = "/"
+	}
+	return &fi, nil
+}
+
+// ListDetails returns a page of the detailed listing of a directory. SFTP
+// has no paging, so the whole directory is read and paged locally.
+func (c *SSHClient) ListDetails(dir string, opts ListOptions) (*proto.Listing, error) {
+	infos, err := c.sftp.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]proto.FileInfo, len(infos))
+	for i, info := range infos {
+		entries[i] = sftpFileInfo(info)
+	}
+	if err := proto.SortFileInfos(entries, opts.Sort, opts.Reverse); err != nil {
+		return nil, err
+	}
+	listing := proto.NewListing(dir, entries, opts.Offset, opts.Limit)
+	return &listing, nil
+}
+
+// sftpFileInfo converts the attributes of a remote file
+func sftpFileInfo(info os.FileInfo) proto.FileInfo {
+	fi := proto.FileInfo{
+		Name:    info.Name(),
+		Mode:    uint32(info.Mode().Perm()),
+		ModTime: info.ModTime(),
+		IsDir:   info.IsDir(),
+	}
+	if !fi.IsDir {
+		fi.Size = info.Size()
+	}
+	return fi
+}
+
+// List lists files at a path.
+func (c *SSHClient) List(dir string) ([]string, error) {
+	infos, err := c.sftp.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(infos))
+	for _, info := range infos {
+		names = append(names, info.Name())
+	}
+	return names, nil
+}
+
+// Delete removes a remote file, or a directory and everything below it if
+// recursive is set.
+func (c *SSHClient) Delete(remotePath string, recursive bool) error {
+	info, err := c.sftp.Lstat(remotePath)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return c.sftp.Remove(remotePath)
+	}
+	if recursive {
+		return c.sftp.RemoveAll(remotePath)
+	}
+	if entries, err := c.sftp.ReadDir(remotePath); err == nil && len(entries) > 0 {
+		return fmt.Errorf("%w: %s", ErrNotEmpty, remotePath)
+	}
+	return c.sftp.RemoveDirectory(remotePath)
+}
+
+// Move renames a remote file or directory, creating the parent directories
+// of dst.
+func (c *SSHClient) Move(src, dst string) error {
+	if err := c.sftp.MkdirAll(path.Dir(dst)); err != nil {
+		return fmt.Errorf("failed to create %s: %w", path.Dir(dst), err)
+	}
+	return c.sftp.Rename(src, dst)
+}
+
+// Copy copies a remote file or directory tree. SFTP has no copy request,
+// so the data makes a round trip through the client.
+func (c *SSHClient) Copy(src, dst string) error {
+	if _, err := c.sftp.Lstat(dst); err == nil {
+		return fmt.Errorf("%w: %s", os.ErrExist, dst)
+	}
+
+	walker := c.sftp.Walk(src)
+	for walker.Step() {
+		if err := walker.Err(); err != nil {
+			return err
+		}
+		target := path.Join(dst, strings.TrimPrefix(walker.Path(), src))
+		if walker.Stat().IsDir() {
+			if err := c.sftp.MkdirAll(target); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := c.copyFile(walker.Path(), target); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// copyFile copies a single remote file
+func (c *SSHClient) copyFile(src, dst string) error {
+	f, err := c.sftp.Open(src)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = c.Upload(f, dst)
+	return err
+}
+
+// Mkdir creates a remote directory and its parents.
+func (c *SSHClient) Mkdir(remotePath string) error {
+	return c.sftp.MkdirAll(remotePath)
+}
+
+// SetAttr sets the permission bits and modification time of a remote file
+// or directory. A zero mode or time leaves it unchanged.
+func (c *SSHClient) SetAttr(remotePath string, mode fs.FileMode, modTime time.Time) error {
+	if mode != 0 {
+		if err := c.sftp.Chmod(remotePath, mode.Perm()); err != nil {
+			return err
+		}
+	}
+	if !modTime.IsZero() {
+		return c.sftp.Chtimes(remotePath, modTime, modTime)
+	}
+	return nil
+}
+
+// URL returns the server URL the client was created for.
+func (c *SSHClient) URL() string {
+	return c.url
+}
+
+// Capabilities describes what the client can do over SFTP. Any checksum
+// algorithm is accepted since SFTP servers don't check them.
+func (c *SSHClient) Capabilities() (*proto.Capabilities, error) {
+	caps := &proto.Capabilities{
+		Version:  proto.ProtocolVersion,
+		Features: []string{proto.FeatureUpload, proto.FeatureChunkOffsets, proto.FeatureDownload, proto.FeatureList, proto.FeatureFiles, proto.FeatureStat, proto.FeatureSetAttr},
+	}
+	for _, alg := range checksum.Algorithms {
+		caps.Checksums = append(caps.Checksums, string(alg))
+	}
+	return caps, nil
+}
+
+// CreateUpload creates the remote file, along with its parent directories,
+// and returns an ID for writing chunks to it.
+func (c *SSHClient) CreateUpload(remotePath string, totalChunks, chunkSize int, fileHash string, alg checksum.Algorithm) (string, error) {
+	if err := c.sftp.MkdirAll(path.Dir(remotePath)); err != nil {
+		return "", fmt.Errorf("failed to create %s: %w", path.Dir(remotePath), err)
+	}
+	f, err := c.sftp.Create(remotePath)
+	if err != nil {
+		return "", err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.nextID++
+	id := fmt.Sprintf("sftp-%d", c.nextID)
+	c.uploads[id] = &sftpUpload{path: remotePath, file: f, alg: alg, received: make([]bool, totalChunks), pending: totalChunks}
+	return id, nil
+}
+
+// SendManifest always fails with ErrManifestUnsupported; every chunk has
+// to be sent.
+func (c *SSHClient) SendManifest(uploadID string, manifest proto.Manifest) (*proto.ManifestResponse, error) {
+	return nil, ErrManifestUnsupported
+}
+
+// UploadChunk writes a chunk of an upload created with CreateUpload at its
+// offset. The remote file is closed, which makes goflux-server store it,
+// once every chunk has been written.
+func (c *SSHClient) UploadChunk(chunk proto.ChunkData) error {
+	c.mu.Lock()
+	upload, ok := c.uploads[chunk.UploadID]
+	c.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrUploadNotFound, chunk.UploadID)
+	}
+	if chunk.ChunkID < 0 || chunk.ChunkID >= len(upload.received) {
+		return fmt.Errorf("chunk %d out of range", chunk.ChunkID)
+	}
+	if chunk.Checksum != "" {
+		if err := checksum.Verify(chunk.Data, chunk.Checksum); err != nil {
+			return fmt.Errorf("chunk %d: %w", chunk.ChunkID, err)
+		}
+	}
+
+	if _, err := upload.file.WriteAt(chunk.Data, chunk.Offset); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	if !upload.received[chunk.ChunkID] {
+		upload.received[chunk.ChunkID] = true
+		upload.pending--
+	}
+	done := upload.pending == 0
+	if done {
+		delete(c.uploads, chunk.UploadID)
+	}
+	c.mu.Unlock()
+
+	if done {
+		return upload.file.Close()
+	}
+	return nil
+}
+
+// QueryUploadStatus reports that no upload exists; SFTP servers keep no
+// upload sessions.
+func (c *SSHClient) QueryUploadStatus(remotePath string) (*proto.UploadStatus, error) {
+	return &proto.UploadStatus{Path: remotePath}, nil
+}
+
+// QueryUploadStatusByID reports an upload created by this client.
+func (c *SSHClient) QueryUploadStatusByID(id string) (*proto.UploadStatus, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	upload, ok := c.uploads[id]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUploadNotFound, id)
+	}
+	status := &proto.UploadStatus{
+		UploadID:    id,
+		Path:        upload.path,
+		Checksum:    string(upload.alg),
+		Exists:      true,
+		TotalChunks: len(upload.received),
+		ReceivedMap: append([]bool(nil), upload.received...),
+	}
+	for i, received := range upload.received {
+		if !received {
+			status.MissingChunks = append(status.MissingChunks, i)
+		}
+	}
+	return status, nil
+}
+
+// StatDownload fetches the size of a remote file. Its ETag is derived from
+// the size and modification time.
+func (c *SSHClient) StatDownload(remotePath string) (*RemoteFile, error) {
+	info, err := c.sftp.Stat(remotePath)
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() {
+		return nil, fmt.Errorf("%s is a directory", remotePath)
+	}
+	return &RemoteFile{Size: info.Size(), ETag: sftpETag(info), Ranges: true}, nil
+}
+
+// DownloadRange writes length bytes of a remote file starting at offset to
+// the same offset in dst.
+func (c *SSHClient) DownloadRange(remotePath string, offset, length int64, etag string, dst io.WriterAt) error {
+	f, err := c.sftp.Open(remotePath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if etag != "" {
+		info, err := f.Stat()
+		if err != nil {
+			return err
+		}
+		if sftpETag(info) != etag {
+			return ErrRemoteChanged
+		}
+	}
+
+	n, err := io.Copy(io.NewOffsetWriter(dst, offset), io.NewSectionReader(f, offset, length))
+	if err != nil {
+		return err
+	}
+	if n != length {
+		return fmt.Errorf("download ended after %d of %d bytes", n, length)
+	}
+	return nil
+}
+
+// sftpETag identifies a version of a remote file by its size and
+// modification time
+func sftpETag(info os.FileInfo) string {
+	return fmt.Sprintf("%d-%d", info.Size(), info.ModTime().Unix())
+}
@@ -0,0 +1,102 @@
+package transport
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+func newHostKey(t *testing.T) ssh.PublicKey {
+	t.Helper()
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := ssh.NewPublicKey(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func TestSSHHostKeyCallback(t *testing.T) {
+	known, other := newHostKey(t), newHostKey(t)
+	knownHosts := filepath.Join(t.TempDir(), "known_hosts")
+	line := knownhosts.Line([]string{knownhosts.Normalize("files.example.com:2222")}, known)
+	if err := os.WriteFile(knownHosts, []byte(line+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	addr := &net.TCPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 2222}
+
+	tests := []struct {
+		name string
+		opts SSHOptions
+		key  ssh.PublicKey
+		ok   bool
+	}{
+		{"known key", SSHOptions{KnownHostsFile: knownHosts}, known, true},
+		{"changed key", SSHOptions{KnownHostsFile: knownHosts}, other, false},
+		{"ignored", SSHOptions{KnownHostsFile: knownHosts, InsecureIgnoreHostKey: true}, other, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			callback, err := tt.opts.hostKeyCallback()
+			if err != nil {
+				t.Fatalf("hostKeyCallback() error = %v", err)
+			}
+			if err := callback("files.example.com:2222", addr, tt.key); (err == nil) != tt.ok {
+				t.Errorf("callback() error = %v, want accepted %v", err, tt.ok)
+			}
+		})
+	}
+
+	if _, err := (SSHOptions{KnownHostsFile: filepath.Join(t.TempDir(), "missing")}).hostKeyCallback(); err == nil {
+		t.Error("hostKeyCallback() accepted a missing known hosts file")
+	}
+}
+
+func TestSSHAgentConnection(t *testing.T) {
+	sock := filepath.Join(t.TempDir(), "agent.sock")
+	l, err := net.Listen("unix", sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	t.Setenv("SSH_AUTH_SOCK", sock)
+	t.Setenv("HOME", t.TempDir())
+
+	// Without a key file the agent is offered, and its connection handed
+	// back to be closed with the client
+	methods, agentConn, err := SSHOptions{}.authMethods()
+	if err != nil {
+		t.Fatalf("authMethods() error = %v", err)
+	}
+	if agentConn == nil || len(methods) != 1 {
+		t.Fatalf("authMethods() = %d methods, agent connection %v", len(methods), agentConn)
+	}
+	agentConn.Close()
+
+	// A key file replaces the agent, which isn't dialled
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, err := ssh.MarshalPrivateKey(priv, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyFile := filepath.Join(t.TempDir(), "id_ed25519")
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(block), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, agentConn, err := (SSHOptions{KeyFile: keyFile}).authMethods(); err != nil || agentConn != nil {
+		t.Errorf("authMethods() with a key file = agent connection %v, error %v", agentConn, err)
+	}
+}
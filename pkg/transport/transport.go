@@ -3,32 +3,67 @@ package transport
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/0xRepo-Source/goflux/pkg/checksum"
+	"github.com/0xRepo-Source/goflux/pkg/proto"
+	"github.com/quic-go/quic-go/http3"
 )
 
-// Transport is an abstraction for underlying transport (ssh, quic, http).
-type Transport interface {
-	Dial(addr string) error
-	Listen(addr string) error
-}
+// ErrChecksumMismatch is returned when the server rejected a chunk because
+// its data did not match the checksum. Sending the chunk again is safe.
+var ErrChecksumMismatch = errors.New("chunk checksum mismatch")
 
-// ChunkData represents chunk data being transferred.
-type ChunkData struct {
-	Path     string `json:"path"`
-	ChunkID  int    `json:"chunk_id"`
-	Data     []byte `json:"data"`
-	Checksum string `json:"checksum"`
-	Total    int    `json:"total"` // total number of chunks
-}
+// ErrFileHashMismatch is returned when the reassembled file did not match
+// the whole-file hash sent by the client. The server discards the upload.
+var ErrFileHashMismatch = errors.New("file hash mismatch")
+
+// ErrUploadIDsUnsupported is returned by CreateUpload when the server
+// predates upload IDs. Chunks can still be uploaded without an upload ID.
+var ErrUploadIDsUnsupported = errors.New("server does not support upload IDs")
+
+// ErrChunksMissing is returned for a chunk that completed an upload whose
+// other chunks the server no longer holds. The upload status lists the
+// chunks to send again.
+var ErrChunksMissing = errors.New("chunks missing from upload")
+
+// ErrUploadNotFound is returned when the server has no upload with the
+// given ID, e.g. because it completed or was discarded.
+var ErrUploadNotFound = errors.New("upload not found")
+
+// ErrChecksumUnsupported is returned by CreateUpload when the server does
+// not accept the requested chunk checksum algorithm.
+var ErrChecksumUnsupported = errors.New("checksum algorithm not accepted by server")
+
+// ErrTooLarge is returned when a chunk or file exceeds the server's limits.
+var ErrTooLarge = errors.New("exceeds server limit")
 
-// HTTPClient is an HTTP-based transport client.
+// ErrManifestUnsupported is returned by SendManifest when the server cannot
+// check manifests; all chunks have to be sent.
+var ErrManifestUnsupported = errors.New("server does not support upload manifests")
+
+// HTTPClient is an HTTP-based transport client. It serves the http, https,
+// unix and quic schemes.
 type HTTPClient struct {
-	BaseURL   string
-	client    *http.Client
-	authToken string
+	BaseURL      string
+	url          string // server URL as given, for URL
+	client       *http.Client
+	transport    *http.Transport  // nil for QUIC clients
+	quic         *http3.Transport // nil for TCP and unix socket clients
+	authToken    string
+	retry        RetryPolicy
+	legacyUpload atomic.Bool // server only understands JSON chunk uploads
+
+	capsMu sync.Mutex
+	caps   *proto.Capabilities // negotiated capabilities, nil until fetched
 }
 
 func NewHTTPClient(baseURL string) *HTTPClient {
@@ -37,9 +72,16 @@ func NewHTTPClient(baseURL string) *HTTPClient {
 		baseURL = "http://" + baseURL
 	}
 
+	// Keep enough idle connections around for parallel chunk transfers
+	httpTransport := http.DefaultTransport.(*http.Transport).Clone()
+	httpTransport.MaxIdleConnsPerHost = 32
+
 	return &HTTPClient{
-		BaseURL: baseURL,
-		client:  &http.Client{},
+		BaseURL:   baseURL,
+		url:       baseURL,
+		client:    &http.Client{Transport: httpTransport},
+		transport: httpTransport,
+		retry:     DefaultRetryPolicy(),
 	}
 }
 
@@ -48,17 +90,324 @@ func (h *HTTPClient) SetAuthToken(token string) {
 	h.authToken = token
 }
 
-func (h *HTTPClient) Dial(addr string) error {
-	h.BaseURL = addr
+// SetTLSOptions configures certificate verification for HTTPS and QUIC
+// servers
+func (h *HTTPClient) SetTLSOptions(opts TLSOptions) error {
+	cfg, err := opts.Config()
+	if err != nil {
+		return err
+	}
+	if h.quic != nil {
+		h.quic.TLSClientConfig = cfg
+		return nil
+	}
+	h.transport.TLSClientConfig = cfg
 	return nil
 }
 
-func (h *HTTPClient) Listen(addr string) error {
-	return fmt.Errorf("HTTPClient cannot listen")
+// SetRetryPolicy replaces the policy used to retry failed requests
+func (h *HTTPClient) SetRetryPolicy(policy RetryPolicy) {
+	h.retry = policy
+}
+
+// URL returns the server URL the client was created for
+func (h *HTTPClient) URL() string {
+	return h.url
+}
+
+// Close closes idle connections to the server
+func (h *HTTPClient) Close() error {
+	if h.quic != nil {
+		return h.quic.Close()
+	}
+	h.transport.CloseIdleConnections()
+	return nil
+}
+
+// Capabilities returns what the server supports. The server is asked once;
+// a server that predates GET /capabilities is described as protocol
+// version 0 with no advertised features, and callers should then try
+// features and fall back when they fail.
+func (h *HTTPClient) Capabilities() (*proto.Capabilities, error) {
+	h.capsMu.Lock()
+	defer h.capsMu.Unlock()
+
+	if h.caps != nil {
+		return h.caps, nil
+	}
+	var caps *proto.Capabilities
+	err := h.withRetry(func() error {
+		var err error
+		caps, err = h.fetchCapabilities()
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	h.caps = caps
+	return caps, nil
+}
+
+// fetchCapabilities makes a single capabilities request.
+func (h *HTTPClient) fetchCapabilities() (*proto.Capabilities, error) {
+	resp, err := h.client.Get(h.BaseURL + "/capabilities")
+	if err != nil {
+		return nil, networkError(err)
+	}
+	defer resp.Body.Close()
+
+	// Older servers answer with the web UI, a 404 or a 405
+	if resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusMethodNotAllowed ||
+		(resp.StatusCode == http.StatusOK && !strings.HasPrefix(resp.Header.Get("Content-Type"), "application/json")) {
+		return &proto.Capabilities{Checksums: []string{string(checksum.SHA256)}}, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, readError(resp, "capabilities query")
+	}
+
+	var caps proto.Capabilities
+	if err := json.NewDecoder(resp.Body).Decode(&caps); err != nil {
+		return nil, err
+	}
+	return &caps, nil
+}
+
+// supports reports whether the server may support feature: it advertised
+// it, or it predates capability negotiation and has to be tried.
+func (h *HTTPClient) supports(feature string) bool {
+	caps, err := h.Capabilities()
+	if err != nil || caps.Version == 0 {
+		return true
+	}
+	return caps.Has(feature)
+}
+
+// CreateUpload starts an upload of totalChunks chunks to path and returns
+// its upload ID, which is then set on every proto.ChunkData of the upload.
+// fileHash is the SHA-256 of the complete file and may be empty; alg is the
+// algorithm of the chunks' checksums, which the server must accept.
+func (h *HTTPClient) CreateUpload(path string, totalChunks, chunkSize int, fileHash string, alg checksum.Algorithm) (string, error) {
+	if !h.supports(proto.FeatureResume) {
+		return "", ErrUploadIDsUnsupported
+	}
+	var id string
+	err := h.withRetry(func() error {
+		var err error
+		id, err = h.createUpload(path, totalChunks, chunkSize, fileHash, alg)
+		return err
+	})
+	return id, err
+}
+
+// createUpload makes a single create upload request.
+func (h *HTTPClient) createUpload(path string, totalChunks, chunkSize int, fileHash string, alg checksum.Algorithm) (string, error) {
+	data, err := json.Marshal(proto.CreateUploadRequest{
+		Path:        path,
+		TotalChunks: totalChunks,
+		ChunkSize:   chunkSize,
+		FileHash:    fileHash,
+		Checksum:    string(alg),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	req, err := http.NewRequest("POST", h.BaseURL+"/upload/create", bytes.NewReader(data))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	// Add auth token if set
+	if h.authToken != "" {
+		req.Header.Set("Authorization", "Bearer "+h.authToken)
+	}
+
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return "", networkError(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusMethodNotAllowed {
+		return "", ErrUploadIDsUnsupported
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", readError(resp, "create upload")
+	}
+
+	var created proto.CreateUploadResponse
+	if err := json.NewDecoder(resp.Body).Decode(&created); err != nil {
+		return "", err
+	}
+
+	// Servers that predate checksum algorithms ignore the request and
+	// verify chunks with SHA-256
+	accepted, err := checksum.Parse(created.Checksum)
+	if err != nil {
+		return "", err
+	}
+	if accepted != alg {
+		return "", fmt.Errorf("%w: requested %s, server uses %s", ErrChecksumUnsupported, alg, accepted)
+	}
+	return created.UploadID, nil
+}
+
+// SendManifest sends the manifest of an upload created with CreateUpload
+// and returns which chunks the server still needs.
+func (h *HTTPClient) SendManifest(uploadID string, manifest proto.Manifest) (*proto.ManifestResponse, error) {
+	if !h.supports(proto.FeatureManifest) {
+		return nil, ErrManifestUnsupported
+	}
+	var result *proto.ManifestResponse
+	err := h.withRetry(func() error {
+		var err error
+		result, err = h.sendManifest(uploadID, manifest)
+		return err
+	})
+	return result, err
 }
 
-// UploadChunk uploads a single chunk.
-func (h *HTTPClient) UploadChunk(chunk ChunkData) error {
+// sendManifest makes a single manifest request.
+func (h *HTTPClient) sendManifest(uploadID string, manifest proto.Manifest) (*proto.ManifestResponse, error) {
+	data, err := json.Marshal(manifest)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest("POST", h.BaseURL+"/upload/"+url.PathEscape(uploadID)+"/manifest", bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	// Add auth token if set
+	if h.authToken != "" {
+		req.Header.Set("Authorization", "Bearer "+h.authToken)
+	}
+
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return nil, networkError(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusMethodNotAllowed {
+		return nil, ErrManifestUnsupported
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, readError(resp, "manifest")
+	}
+
+	var result proto.ManifestResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// UploadChunk uploads a single chunk as a raw binary body, retrying
+// temporary failures according to the client's RetryPolicy. Servers that
+// predate the binary route are detected on the first chunk and the client
+// falls back to the JSON upload route for the rest of the session. It is
+// safe to call from multiple goroutines.
+func (h *HTTPClient) UploadChunk(chunk proto.ChunkData) error {
+	reconnected := false
+	return h.withRetry(func() error {
+		if reconnected {
+			// The lost attempt may have reached the server before the
+			// connection dropped; don't resend a chunk it already has
+			if status, err := h.queryChunkStatus(chunk); err == nil && status.Exists &&
+				chunk.ChunkID < len(status.ReceivedMap) && status.ReceivedMap[chunk.ChunkID] {
+				return nil
+			}
+		}
+		err := h.uploadChunk(chunk)
+		reconnected = isNetworkError(err)
+		return err
+	})
+}
+
+// queryChunkStatus queries the status of the upload a chunk belongs to
+func (h *HTTPClient) queryChunkStatus(chunk proto.ChunkData) (*proto.UploadStatus, error) {
+	if chunk.UploadID != "" {
+		return h.queryUploadStatus("id", chunk.UploadID)
+	}
+	return h.queryUploadStatus("path", chunk.Path)
+}
+
+// uploadChunk makes a single attempt at uploading a chunk.
+func (h *HTTPClient) uploadChunk(chunk proto.ChunkData) error {
+	if chunk.UploadID != "" {
+		return h.uploadChunkByID(chunk)
+	}
+	if h.legacyUpload.Load() {
+		return h.uploadChunkJSON(chunk)
+	}
+
+	req, err := http.NewRequest("PUT", h.BaseURL+"/upload/chunks/"+strconv.Itoa(chunk.ChunkID), bytes.NewReader(chunk.Data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set(proto.HeaderPath, url.PathEscape(chunk.Path))
+	req.Header.Set(proto.HeaderTotal, strconv.Itoa(chunk.Total))
+	req.Header.Set(proto.HeaderChecksum, chunk.Checksum)
+	req.Header.Set(proto.HeaderOffset, strconv.FormatInt(chunk.Offset, 10))
+	if chunk.FileHash != "" {
+		req.Header.Set(proto.HeaderFileHash, chunk.FileHash)
+	}
+
+	// Add auth token if set
+	if h.authToken != "" {
+		req.Header.Set("Authorization", "Bearer "+h.authToken)
+	}
+
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return networkError(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusMethodNotAllowed {
+		h.legacyUpload.Store(true)
+		return h.uploadChunkJSON(chunk)
+	}
+
+	return checkUploadResponse(resp)
+}
+
+// uploadChunkByID uploads a chunk of an upload created with CreateUpload.
+func (h *HTTPClient) uploadChunkByID(chunk proto.ChunkData) error {
+	chunkURL := fmt.Sprintf("%s/upload/%s/chunks/%d", h.BaseURL, url.PathEscape(chunk.UploadID), chunk.ChunkID)
+	req, err := http.NewRequest("PUT", chunkURL, bytes.NewReader(chunk.Data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set(proto.HeaderChecksum, chunk.Checksum)
+	req.Header.Set(proto.HeaderOffset, strconv.FormatInt(chunk.Offset, 10))
+
+	// Add auth token if set
+	if h.authToken != "" {
+		req.Header.Set("Authorization", "Bearer "+h.authToken)
+	}
+
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return networkError(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		// Servers that predate structured errors don't code this 404
+		return fmt.Errorf("%w: %s", ErrUploadNotFound, chunk.UploadID)
+	}
+	return checkUploadResponse(resp)
+}
+
+// uploadChunkJSON uploads a chunk base64-encoded inside a JSON body.
+func (h *HTTPClient) uploadChunkJSON(chunk proto.ChunkData) error {
 	data, err := json.Marshal(chunk)
 	if err != nil {
 		return err
@@ -77,29 +426,75 @@ func (h *HTTPClient) UploadChunk(chunk ChunkData) error {
 
 	resp, err := h.client.Do(req)
 	if err != nil {
-		return err
+		return networkError(err)
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("upload failed: %s", string(body))
+	return checkUploadResponse(resp)
+}
+
+// checkUploadResponse turns a chunk upload response into an error
+func checkUploadResponse(resp *http.Response) error {
+	if resp.StatusCode == http.StatusOK {
+		return nil
 	}
-	return nil
+	return readError(resp, "upload")
 }
 
-// UploadStatusResponse contains the status of an upload session
-type UploadStatusResponse struct {
-	Exists        bool   `json:"exists"`
-	TotalChunks   int    `json:"total_chunks"`
-	ReceivedMap   []bool `json:"received_map"`
-	MissingChunks []int  `json:"missing_chunks"`
-	Completed     bool   `json:"completed"`
+// readError turns a failed response into an error. Failures the client can
+// act on wrap one of the package's errors; the rest wrap the *proto.Error
+// and are marked temporary if worth retrying.
+func readError(resp *http.Response, op string) error {
+	e := proto.ReadError(resp)
+	switch e.Code {
+	case proto.CodeChecksumMismatch:
+		return fmt.Errorf("%w: %s", ErrChecksumMismatch, e.Message)
+	case proto.CodeFileHashMismatch:
+		return fmt.Errorf("%w: %s", ErrFileHashMismatch, e.Message)
+	case proto.CodeTooLarge:
+		return fmt.Errorf("%w: %s", ErrTooLarge, e.Message)
+	case proto.CodeChecksumUnsupported:
+		return fmt.Errorf("%w: %s", ErrChecksumUnsupported, e.Message)
+	case proto.CodeUploadNotFound:
+		return fmt.Errorf("%w: %s", ErrUploadNotFound, e.Message)
+	case proto.CodeChunksMissing:
+		return fmt.Errorf("%w: %s", ErrChunksMissing, e.Message)
+	case proto.CodeNotEmpty:
+		return fmt.Errorf("%w: %s", ErrNotEmpty, e.Message)
+	case proto.CodeChanged:
+		return fmt.Errorf("%w: %s", ErrRemoteChanged, e.Message)
+	}
+	return responseError(resp, fmt.Errorf("%s failed: %w", op, e))
+}
+
+// QueryUploadStatus checks the status of the upload to path made without
+// an upload ID
+func (h *HTTPClient) QueryUploadStatus(path string) (*proto.UploadStatus, error) {
+	var status *proto.UploadStatus
+	err := h.withRetry(func() error {
+		var err error
+		status, err = h.queryUploadStatus("path", path)
+		return err
+	})
+	return status, err
+}
+
+// QueryUploadStatusByID checks the status of an upload created with
+// CreateUpload
+func (h *HTTPClient) QueryUploadStatusByID(id string) (*proto.UploadStatus, error) {
+	var status *proto.UploadStatus
+	err := h.withRetry(func() error {
+		var err error
+		status, err = h.queryUploadStatus("id", id)
+		return err
+	})
+	return status, err
 }
 
-// QueryUploadStatus checks the status of an upload on the server
-func (h *HTTPClient) QueryUploadStatus(path string) (*UploadStatusResponse, error) {
-	req, err := http.NewRequest("GET", h.BaseURL+"/upload/status?path="+path, nil)
+// queryUploadStatus makes a single upload status request, identifying the
+// upload by the given query parameter ("id" or "path").
+func (h *HTTPClient) queryUploadStatus(param, value string) (*proto.UploadStatus, error) {
+	req, err := http.NewRequest("GET", h.BaseURL+"/upload/status?"+param+"="+url.QueryEscape(value), nil)
 	if err != nil {
 		return nil, err
 	}
@@ -111,16 +506,15 @@ func (h *HTTPClient) QueryUploadStatus(path string) (*UploadStatusResponse, erro
 
 	resp, err := h.client.Do(req)
 	if err != nil {
-		return nil, err
+		return nil, networkError(err)
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("status query failed: %s", string(body))
+		return nil, readError(resp, "status query")
 	}
 
-	var status UploadStatusResponse
+	var status proto.UploadStatus
 	if err := json.NewDecoder(resp.Body).Decode(&status); err != nil {
 		return nil, err
 	}
@@ -128,11 +522,28 @@ func (h *HTTPClient) QueryUploadStatus(path string) (*UploadStatusResponse, erro
 	return &status, nil
 }
 
-// Download downloads a file.
-func (h *HTTPClient) Download(path string) ([]byte, error) {
-	req, err := http.NewRequest("GET", h.BaseURL+"/download?path="+path, nil)
+// Download writes a whole remote file to w and returns the number of bytes
+// written. It is for servers that can't send ranges of a file, so a failed
+// request is only retried if nothing had been written yet.
+func (h *HTTPClient) Download(path string, w io.Writer) (int64, error) {
+	var written int64
+	err := h.withRetry(func() error {
+		n, err := h.download(path, w)
+		written += n
+		if err != nil && n > 0 {
+			// What was written can't be taken back
+			return fmt.Errorf("download interrupted after %d bytes: %v", n, err)
+		}
+		return err
+	})
+	return written, err
+}
+
+// download makes a single download request.
+func (h *HTTPClient) download(path string, w io.Writer) (int64, error) {
+	req, err := http.NewRequest("GET", h.BaseURL+"/download?path="+url.QueryEscape(path), nil)
 	if err != nil {
-		return nil, err
+		return 0, err
 	}
 
 	// Add auth token if set
@@ -141,22 +552,155 @@ func (h *HTTPClient) Download(path string) ([]byte, error) {
 	}
 
 	resp, err := h.client.Do(req)
+	if err != nil {
+		return 0, networkError(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return 0, readError(resp, "download")
+	}
+
+	n, err := io.Copy(w, resp.Body)
+	if err != nil {
+		return n, networkError(err)
+	}
+	return n, nil
+}
+
+// ErrRemoteChanged is returned by DownloadRange when the remote file no
+// longer matches the ETag the download started with, and by PutDelta when
+// it no longer matches the signature the delta was made against.
+var ErrRemoteChanged = errors.New("remote file changed")
+
+// RemoteFile describes a downloadable file.
+type RemoteFile struct {
+	Size   int64  // length in bytes, -1 if the server doesn't say
+	ETag   string // identifies this version of the file for If-Range
+	Hash   string // hex SHA-256 of the content, empty if the server doesn't say
+	Ranges bool   // DownloadRange works, so a download can be split and resumed
+}
+
+// StatDownload fetches the size, ETag and hash of a remote file without
+// downloading it.
+func (h *HTTPClient) StatDownload(path string) (*RemoteFile, error) {
+	var info *RemoteFile
+	err := h.withRetry(func() error {
+		var err error
+		info, err = h.statDownload(path)
+		return err
+	})
+	return info, err
+}
+
+// statDownload makes a single HEAD request for a remote file.
+func (h *HTTPClient) statDownload(path string) (*RemoteFile, error) {
+	req, err := http.NewRequest("HEAD", h.BaseURL+"/download?path="+url.QueryEscape(path), nil)
 	if err != nil {
 		return nil, err
 	}
+
+	// Add auth token if set
+	if h.authToken != "" {
+		req.Header.Set("Authorization", "Bearer "+h.authToken)
+	}
+
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return nil, networkError(err)
+	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("download failed: %s", string(body))
+		return nil, responseError(resp, fmt.Errorf("download failed: status %d", resp.StatusCode))
+	}
+
+	info := &RemoteFile{
+		Size: resp.ContentLength,
+		ETag: resp.Header.Get("ETag"),
+		Hash: resp.Header.Get(proto.HeaderFileHash),
+	}
+	// Servers from before ranged downloads stream files whole, often
+	// without saying how long they are
+	info.Ranges = info.Size >= 0 && info.ETag != "" && resp.Header.Get("Accept-Ranges") == "bytes"
+	return info, nil
+}
+
+// DownloadRange downloads length bytes of a remote file starting at offset
+// and writes them to dst at the same offset. etag pins the version of the
+// file: if it changed on the server, ErrRemoteChanged is returned. Retries
+// continue from the last byte received rather than the start of the range.
+func (h *HTTPClient) DownloadRange(path string, offset, length int64, etag string, dst io.WriterAt) error {
+	var written int64
+	return h.withRetry(func() error {
+		n, err := h.downloadRange(path, offset+written, length-written, etag, io.NewOffsetWriter(dst, offset+written))
+		written += n
+		return err
+	})
+}
+
+// downloadRange makes a single ranged download request and returns the
+// number of bytes written to w.
+func (h *HTTPClient) downloadRange(path string, offset, length int64, etag string, w io.Writer) (int64, error) {
+	if length <= 0 {
+		return 0, nil
+	}
+
+	req, err := http.NewRequest("GET", h.BaseURL+"/download?path="+url.QueryEscape(path), nil)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", offset, offset+length-1))
+	if etag != "" {
+		req.Header.Set("If-Range", etag)
+	}
+
+	// Add auth token if set
+	if h.authToken != "" {
+		req.Header.Set("Authorization", "Bearer "+h.authToken)
+	}
+
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return 0, networkError(err)
 	}
+	defer resp.Body.Close()
 
-	return io.ReadAll(resp.Body)
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+	case http.StatusOK:
+		// The server ignored the range because If-Range no longer matched
+		return 0, ErrRemoteChanged
+	case http.StatusRequestedRangeNotSatisfiable:
+		return 0, ErrRemoteChanged
+	default:
+		return 0, readError(resp, "download")
+	}
+
+	n, err := io.Copy(w, io.LimitReader(resp.Body, length))
+	if err != nil {
+		return n, networkError(err)
+	}
+	if n < length {
+		return n, networkError(fmt.Errorf("download interrupted after %d of %d bytes", n, length))
+	}
+	return n, nil
 }
 
 // List lists files at a path.
 func (h *HTTPClient) List(path string) ([]string, error) {
-	req, err := http.NewRequest("GET", h.BaseURL+"/list?path="+path, nil)
+	var files []string
+	err := h.withRetry(func() error {
+		var err error
+		files, err = h.list(path)
+		return err
+	})
+	return files, err
+}
+
+// list makes a single list request.
+func (h *HTTPClient) list(path string) ([]string, error) {
+	req, err := http.NewRequest("GET", h.BaseURL+"/list?path="+url.QueryEscape(path), nil)
 	if err != nil {
 		return nil, err
 	}
@@ -168,12 +712,12 @@ func (h *HTTPClient) List(path string) ([]string, error) {
 
 	resp, err := h.client.Do(req)
 	if err != nil {
-		return nil, err
+		return nil, networkError(err)
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("list failed: status %d", resp.StatusCode)
+		return nil, readError(resp, "list")
 	}
 
 	var files []string
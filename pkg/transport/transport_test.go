@@ -0,0 +1,112 @@
+package transport
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/0xRepo-Source/goflux/pkg/checksum"
+	"github.com/0xRepo-Source/goflux/pkg/proto"
+)
+
+func TestCapabilitiesSkipUnsupportedFeatures(t *testing.T) {
+	var capsCalls, uploadCalls atomic.Int32
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /capabilities", func(w http.ResponseWriter, r *http.Request) {
+		capsCalls.Add(1)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(proto.Capabilities{
+			Version:   proto.ProtocolVersion,
+			Features:  []string{proto.FeatureUpload, proto.FeatureDownload},
+			Checksums: []string{"sha256"},
+		})
+	})
+	mux.HandleFunc("/upload/", func(w http.ResponseWriter, r *http.Request) {
+		uploadCalls.Add(1)
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	client := NewHTTPClient(srv.URL)
+	caps, err := client.Capabilities()
+	if err != nil {
+		t.Fatalf("Capabilities() error = %v", err)
+	}
+	if caps.Version != proto.ProtocolVersion || !caps.Has(proto.FeatureUpload) || caps.Has(proto.FeatureResume) {
+		t.Errorf("Capabilities() = %+v", caps)
+	}
+
+	if _, err := client.CreateUpload("/a", 1, 4, "", checksum.SHA256); !errors.Is(err, ErrUploadIDsUnsupported) {
+		t.Errorf("CreateUpload() error = %v, want ErrUploadIDsUnsupported", err)
+	}
+	if _, err := client.SendManifest("id", proto.Manifest{}); !errors.Is(err, ErrManifestUnsupported) {
+		t.Errorf("SendManifest() error = %v, want ErrManifestUnsupported", err)
+	}
+	if uploadCalls.Load() != 0 {
+		t.Errorf("client sent %d requests for unadvertised features", uploadCalls.Load())
+	}
+	if capsCalls.Load() != 1 {
+		t.Errorf("capabilities fetched %d times, want 1", capsCalls.Load())
+	}
+}
+
+func TestCapabilitiesOlderServer(t *testing.T) {
+	var createCalls atomic.Int32
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /upload/create", func(w http.ResponseWriter, r *http.Request) {
+		createCalls.Add(1)
+		json.NewEncoder(w).Encode(proto.CreateUploadResponse{UploadID: "abc"})
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	client := NewHTTPClient(srv.URL)
+	caps, err := client.Capabilities()
+	if err != nil {
+		t.Fatalf("Capabilities() error = %v", err)
+	}
+	if caps.Version != 0 || !caps.AcceptsChecksum("sha256") {
+		t.Errorf("Capabilities() = %+v, want version 0 accepting sha256", caps)
+	}
+
+	// Features of servers that cannot advertise them are tried
+	id, err := client.CreateUpload("/a", 1, 4, "", checksum.SHA256)
+	if err != nil || id != "abc" {
+		t.Errorf("CreateUpload() = %q, %v, want abc", id, err)
+	}
+	if createCalls.Load() != 1 {
+		t.Errorf("create called %d times, want 1", createCalls.Load())
+	}
+}
+
+func TestStructuredErrors(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("PUT /upload/{id}/chunks/{n}", func(w http.ResponseWriter, r *http.Request) {
+		switch r.PathValue("n") {
+		case "0":
+			proto.WriteError(w, http.StatusUnprocessableEntity, proto.CodeChecksumMismatch, "checksum mismatch")
+		case "1":
+			proto.WriteError(w, http.StatusNotFound, proto.CodeUploadNotFound, "upload u1 not found")
+		default:
+			proto.WriteError(w, http.StatusBadRequest, proto.CodeBadRequest, "invalid chunk number")
+		}
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	client := NewHTTPClient(srv.URL)
+	client.SetRetryPolicy(RetryPolicy{MaxAttempts: 1})
+	if err := client.UploadChunk(proto.ChunkData{UploadID: "u1", ChunkID: 0}); !errors.Is(err, ErrChecksumMismatch) {
+		t.Errorf("UploadChunk(0) error = %v, want ErrChecksumMismatch", err)
+	}
+	if err := client.UploadChunk(proto.ChunkData{UploadID: "u1", ChunkID: 1}); !errors.Is(err, ErrUploadNotFound) {
+		t.Errorf("UploadChunk(1) error = %v, want ErrUploadNotFound", err)
+	}
+	var pe *proto.Error
+	if err := client.UploadChunk(proto.ChunkData{UploadID: "u1", ChunkID: 2}); !errors.As(err, &pe) || pe.Code != proto.CodeBadRequest {
+		t.Errorf("UploadChunk(2) error = %v, want code %s", err, proto.CodeBadRequest)
+	}
+}
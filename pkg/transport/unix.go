@@ -0,0 +1,32 @@
+package transport
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// NewUnixClient creates a client that speaks the goflux protocol over the
+// unix socket at baseURL, given as unix:///path/to/socket. Access is
+// controlled by the socket's file permissions as well as the server's
+// authentication.
+func NewUnixClient(baseURL string) *HTTPClient {
+	socket := strings.TrimPrefix(baseURL, "unix://")
+
+	httpTransport := &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", socket)
+		},
+		MaxIdleConnsPerHost: 32,
+	}
+	return &HTTPClient{
+		// The host is never resolved; every request goes to the socket
+		BaseURL:   "http://goflux",
+		url:       baseURL,
+		client:    &http.Client{Transport: httpTransport},
+		transport: httpTransport,
+		retry:     DefaultRetryPolicy(),
+	}
+}
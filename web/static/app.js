@@ -67,28 +67,37 @@ async function uploadFile(file) {
         const chunks = await splitFileIntoChunks(file);
         const remotePath = currentPath + (currentPath.endsWith('/') ? '' : '/') + file.name;
 
+        // Start the upload to get an upload ID
+        const created = await fetch('/upload/create', {
+            method: 'POST',
+            headers: { 'Content-Type': 'application/json' },
+            body: JSON.stringify({
+                path: remotePath,
+                total_chunks: chunks.length,
+                chunk_size: CHUNK_SIZE,
+                checksum: 'sha256'
+            })
+        });
+        if (!created.ok) {
+            throw new Error(`Upload failed: ${await errorMessage(created)}`);
+        }
+        const { upload_id: uploadId } = await created.json();
+
         // Upload each chunk
         for (let i = 0; i < chunks.length; i++) {
             const chunk = chunks[i];
             
-            const chunkData = {
-                path: remotePath,
-                chunk_id: i,
-                data: Array.from(new Uint8Array(chunk.data)),
-                checksum: chunk.checksum,
-                total: chunks.length
-            };
-
-            const response = await fetch('/upload', {
-                method: 'POST',
+            const response = await fetch(`/upload/${encodeURIComponent(uploadId)}/chunks/${i}`, {
+                method: 'PUT',
                 headers: {
-                    'Content-Type': 'application/json',
+                    'Content-Type': 'application/octet-stream',
+                    'X-Goflux-Checksum': chunk.checksum
                 },
-                body: JSON.stringify(chunkData)
+                body: chunk.data
             });
 
             if (!response.ok) {
-                throw new Error(`Upload failed: ${response.statusText}`);
+                throw new Error(`Upload failed: ${await errorMessage(response)}`);
             }
 
             // Update progress
@@ -132,23 +141,76 @@ async function splitFileIntoChunks(file) {
 }
 
 async function calculateSHA256(buffer) {
+    let hash;
     // Check if crypto.subtle is available (HTTPS or localhost)
     if (window.crypto && window.crypto.subtle) {
-        const hashBuffer = await crypto.subtle.digest('SHA-256', buffer);
-        const hashArray = Array.from(new Uint8Array(hashBuffer));
-        return hashArray.map(b => b.toString(16).padStart(2, '0')).join('');
+        hash = new Uint8Array(await crypto.subtle.digest('SHA-256', buffer));
+    } else {
+        // crypto.subtle is unavailable on plain HTTP, but the server refuses
+        // chunks without a checksum; hash them here instead
+        hash = sha256(new Uint8Array(buffer));
     }
-    
-    // Fallback: simple checksum for non-HTTPS contexts
-    // This is less secure but allows functionality to work
-    const bytes = new Uint8Array(buffer);
-    let hash = 0;
-    for (let i = 0; i < bytes.length; i++) {
-        hash = ((hash << 5) - hash) + bytes[i];
-        hash = hash & hash; // Convert to 32-bit integer
+    return 'sha256:' + Array.from(hash).map(b => b.toString(16).padStart(2, '0')).join('');
+}
+
+const SHA256_K = new Uint32Array([
+    0x428a2f98, 0x71374491, 0xb5c0fbcf, 0xe9b5dba5, 0x3956c25b, 0x59f111f1, 0x923f82a4, 0xab1c5ed5,
+    0xd807aa98, 0x12835b01, 0x243185be, 0x550c7dc3, 0x72be5d74, 0x80deb1fe, 0x9bdc06a7, 0xc19bf174,
+    0xe49b69c1, 0xefbe4786, 0x0fc19dc6, 0x240ca1cc, 0x2de92c6f, 0x4a7484aa, 0x5cb0a9dc, 0x76f988da,
+    0x983e5152, 0xa831c66d, 0xb00327c8, 0xbf597fc7, 0xc6e00bf3, 0xd5a79147, 0x06ca6351, 0x14292967,
+    0x27b70a85, 0x2e1b2138, 0x4d2c6dfc, 0x53380d13, 0x650a7354, 0x766a0abb, 0x81c2c92e, 0x92722c85,
+    0xa2bfe8a1, 0xa81a664b, 0xc24b8b70, 0xc76c51a3, 0xd192e819, 0xd6990624, 0xf40e3585, 0x106aa070,
+    0x19a4c116, 0x1e376c08, 0x2748774c, 0x34b0bcb5, 0x391c0cb3, 0x4ed8aa4a, 0x5b9cca4f, 0x682e6ff3,
+    0x748f82ee, 0x78a5636f, 0x84c87814, 0x8cc70208, 0x90befffa, 0xa4506ceb, 0xbef9a3f7, 0xc67178f2
+]);
+
+// sha256 hashes bytes without crypto.subtle (FIPS 180-4)
+function sha256(bytes) {
+    // Pad with 0x80, zeros and the bit length to a multiple of 64 bytes
+    const padded = new Uint8Array(Math.ceil((bytes.length + 9) / 64) * 64);
+    padded.set(bytes);
+    padded[bytes.length] = 0x80;
+    const view = new DataView(padded.buffer);
+    view.setUint32(padded.length - 8, Math.floor(bytes.length / 0x20000000));
+    view.setUint32(padded.length - 4, bytes.length * 8);
+
+    const h = new Uint32Array([
+        0x6a09e667, 0xbb67ae85, 0x3c6ef372, 0xa54ff53a, 0x510e527f, 0x9b05688c, 0x1f83d9ab, 0x5be0cd19
+    ]);
+    const w = new Uint32Array(64);
+    const rotr = (x, n) => (x >>> n) | (x << (32 - n));
+
+    for (let off = 0; off < padded.length; off += 64) {
+        for (let i = 0; i < 16; i++) {
+            w[i] = view.getUint32(off + i * 4);
+        }
+        for (let i = 16; i < 64; i++) {
+            const s0 = rotr(w[i - 15], 7) ^ rotr(w[i - 15], 18) ^ (w[i - 15] >>> 3);
+            const s1 = rotr(w[i - 2], 17) ^ rotr(w[i - 2], 19) ^ (w[i - 2] >>> 10);
+            w[i] = w[i - 16] + s0 + w[i - 7] + s1;
+        }
+
+        let [a, b, c, d, e, f, g, hh] = h;
+        for (let i = 0; i < 64; i++) {
+            const t1 = hh + (rotr(e, 6) ^ rotr(e, 11) ^ rotr(e, 25)) + ((e & f) ^ (~e & g)) + SHA256_K[i] + w[i];
+            const t2 = (rotr(a, 2) ^ rotr(a, 13) ^ rotr(a, 22)) + ((a & b) ^ (a & c) ^ (b & c));
+            hh = g;
+            g = f;
+            f = e;
+            e = (d + t1) | 0;
+            d = c;
+            c = b;
+            b = a;
+            a = (t1 + t2) | 0;
+        }
+        h[0] += a; h[1] += b; h[2] += c; h[3] += d;
+        h[4] += e; h[5] += f; h[6] += g; h[7] += hh;
     }
-    // Pad to look like a hex string
-    return Math.abs(hash).toString(16).padStart(64, '0');
+
+    const out = new Uint8Array(32);
+    const outView = new DataView(out.buffer);
+    h.forEach((v, i) => outView.setUint32(i * 4, v));
+    return out;
 }
 
 // File browsing
@@ -168,14 +230,20 @@ async function loadFiles(path) {
     fileList.innerHTML = '<div class="loading">Loading files...</div>';
 
     try {
-        const response = await fetch(`/list?path=${encodeURIComponent(path)}`);
-        
-        if (!response.ok) {
-            throw new Error('Failed to load files');
-        }
+        // Detailed listings come in pages; fetch them all
+        const entries = [];
+        let offset = 0;
+        do {
+            const response = await fetch(`/list?path=${encodeURIComponent(path)}&detail=true&offset=${offset}`);
+            if (!response.ok) {
+                throw new Error(await errorMessage(response));
+            }
+            const listing = await response.json();
+            entries.push(...listing.entries);
+            offset = listing.next_offset;
+        } while (offset > 0);
 
-        const files = await response.json();
-        displayFiles(files || []);
+        displayFiles(entries);
 
     } catch (error) {
         fileList.innerHTML = '<div class="error">Failed to load files: ' + error.message + '</div>';
@@ -192,29 +260,49 @@ function displayFiles(files) {
 
     fileList.innerHTML = '';
 
-    files.forEach(fileName => {
+    files.forEach(entry => {
         const fileItem = document.createElement('div');
         fileItem.className = 'file-item';
 
-        const isDirectory = false; // We don't have directory info yet
-        const icon = isDirectory ? '▶' : '■';
+        const icon = entry.is_dir ? '▶' : '■';
+        const modified = entry.mod_time.startsWith('0001-') ? '' : new Date(entry.mod_time).toLocaleString();
+        const meta = entry.is_dir ? modified : [formatBytes(entry.size), modified].filter(Boolean).join(' · ');
 
         fileItem.innerHTML = `
             <div class="file-icon">${icon}</div>
             <div class="file-info">
-                <div class="file-name-text">${escapeHtml(fileName)}</div>
-            </div>
-            <div class="file-actions">
-                <button class="btn btn-primary btn-small" onclick="downloadFile('${escapeHtml(fileName)}')">
-                    Download
-                </button>
+                <div class="file-name-text">${escapeHtml(entry.name)}</div>
+                <div class="file-meta">${escapeHtml(meta)}</div>
             </div>
+            <div class="file-actions"></div>
         `;
 
+        const button = document.createElement('button');
+        button.className = 'btn btn-primary btn-small';
+        if (entry.is_dir) {
+            const dirPath = currentPath + (currentPath.endsWith('/') ? '' : '/') + entry.name;
+            button.textContent = 'Open';
+            button.addEventListener('click', () => navigateTo(dirPath));
+        } else {
+            button.textContent = 'Download';
+            button.addEventListener('click', () => downloadFile(entry.name));
+        }
+        fileItem.querySelector('.file-actions').appendChild(button);
+
         fileList.appendChild(fileItem);
     });
 }
 
+// errorMessage returns the message of a failed response's error body
+async function errorMessage(response) {
+    try {
+        const { message } = await response.json();
+        return message || response.statusText;
+    } catch {
+        return response.statusText;
+    }
+}
+
 async function downloadFile(fileName) {
     const filePath = currentPath + (currentPath.endsWith('/') ? '' : '/') + fileName;
     